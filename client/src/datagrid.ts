@@ -6,6 +6,12 @@ interface EditableGridProps<T extends Record<string, unknown>> {
   onEdit?: (rowIndex: number, key: keyof T, value: string) => void;
   editing: { row: number; col: keyof any } | null;
   setEditing: (cell: { row: number; col: keyof any } | null) => void;
+  // other users on a cell, keyed by `${editHandle}|${column}`
+  peers?: Map<string, string[]>;
+  // comment thread sizes, keyed the same way
+  comments?: Record<string, number>;
+  // alt-click on a cell opens its comments
+  onComments?: (rowIndex: number, key: keyof T) => void;
 }
 
 interface EditableCell {
@@ -19,6 +25,9 @@ export function datagrid<T extends Record<string, EditableCell>>({
   onEdit,
   editing,
   setEditing,
+  peers,
+  comments,
+  onComments,
 }: EditableGridProps<T>): VNode {
   if (!data || data.length === 0) return h("div", "(empty)");
   if (loading) {
@@ -56,19 +65,25 @@ export function datagrid<T extends Record<string, EditableCell>>({
       ]);
     }
 
+    const cellKey = `${data[rowIdx][col].editHandle}|${String(col)}`;
+    const others = peers?.get(cellKey);
+    const threads = comments?.[cellKey] ?? 0;
     return h(
       "td",
       {
+        props: { title: others ? others.join(", ") : "" },
         style: {
           textAlign: numericCols.has(col) ? "right" : "left",
           padding: "4px 8px",
           cursor: "pointer",
+          outline: others ? "2px solid orange" : "",
         },
         on: {
-          click: () => setEditing({ row: rowIdx, col }),
+          click: (e: MouseEvent) =>
+            e.altKey ? onComments?.(rowIdx, col) : setEditing({ row: rowIdx, col }),
         },
       },
-      String(value ?? "")
+      [String(value ?? ""), threads ? h("sup", ` 💬${threads}`) : ""]
     );
   };
 
@@ -11,7 +11,19 @@ import { Store } from "./store";
 // import { triggerModal } from "./triggerModal";
 import { fetchApi } from "./util/fetchApi";
 import Swal from "sweetalert2";
-import { connectWS, subscribeWS } from "./socket";
+import {
+  commentsWS,
+  commentWS,
+  connectWS,
+  editWS,
+  lockWS,
+  presenceWS,
+  subscribeWS,
+  unlockWS,
+  type Comment,
+  type Lock,
+  type Presence,
+} from "./socket";
 
 export const patch = init([
   // Init patch function with chosen modules
@@ -24,21 +36,48 @@ export const patch = init([
 type State = {
   query: string;
   results: any;
+  keys: string[] | null; // row identities, parallel to results (from the socket)
   editing: any;
   loading: number;
   pendingEdits: number;
+  peers: Record<string, Presence>; // other subscribers of the grid's query, by peer
+  locks: Record<string, Lock>; // leased cells, by `${editHandle}|${column}`
+  comments: Record<string, number>; // thread sizes, by `${editHandle}|${column}`
 };
 
 const initialState: State = {
   query: "SELECT * FROM actor ORDER BY actor_id LIMIT 5;",
   results: null,
+  keys: null,
   editing: null,
   loading: 0,
   pendingEdits: 0,
+  peers: {},
+  locks: {},
+  comments: {},
 };
 
 const store = new Store(initialState);
 
+// subscription ID of the results grid
+const GRID_SUB = "grid";
+
+// how often the lease on the cell being edited is renewed; the server lets it
+// lapse after 30s without one
+const LOCK_RENEW_MS = 10_000;
+
+// the cell we hold a lease on while its editor is open
+let held: { editHandle: string; column: string; renew: ReturnType<typeof setInterval> } | null = null;
+
+const cellKey = (editHandle: string, column: string) => `${editHandle}|${column}`;
+
+function releaseHeld() {
+  if (!held) return;
+  clearInterval(held.renew);
+  unlockWS(held.editHandle, held.column).catch(() => {}); // may have lapsed or been spent on a hard-locked edit
+  held = null;
+}
+
 store.on("EVENT/#query/change", (state, action) => {
   return { ...state, query: action.payload };
 });
@@ -55,7 +94,8 @@ store.on(
         body: state.query,
         useJson: false,
       });
-      subscribeWS(state.query);
+      // one grid, one subscription: re-running a query replaces it
+      subscribeWS(state.query, undefined, GRID_SUB);
 
       store.dispatch({ type: "DATA/results", payload: data });
     } catch (err: any) {
@@ -65,27 +105,44 @@ store.on(
 );
 
 store.on(
-  "HTTP/api/edit",
-  (state, _action) => {
-    return { ...state, pendingEdits: state.pendingEdits + 1 };
+  "WS/edit",
+  (state, action) => {
+    // optimistic: show the new value until the server acks it or answers with an error
+    const { row, column, value } = action.payload;
+    const results = state.results.slice();
+    results[row] = { ...results[row], [column]: { ...results[row][column], value } };
+    return { ...state, results, pendingEdits: state.pendingEdits + 1 };
   },
   async (action, _state) => {
+    const { editHandle, column, value, previous } = action.payload;
     try {
-      const data = await fetchApi("/api/edit", {
-        method: "POST",
-        body: action.payload,
-      });
-      store.dispatch({ type: "HTTP/api/edit/SUCCESS", payload: data });
+      const ack = await editWS({ editHandle, column, value });
+      store.dispatch({ type: "WS/edit/SUCCESS", payload: ack });
     } catch (err: any) {
-      store.dispatch({ type: "HTTP/api/edit/FAILURE", payload: err.message });
+      // put the old value back unless something else has replaced it since
+      store.dispatch({ type: "WS/edit/REVERT", payload: { editHandle, column, value, previous } });
+      store.dispatch({ type: "WS/edit/FAILURE", payload: err.message });
+    } finally {
+      if (held && cellKey(held.editHandle, held.column) === cellKey(editHandle, column)) releaseHeld();
     }
   }
 );
 
-store.on(/HTTP\/api\/edit\/(SUCCESS|FAILURE)/, (state, _action) => {
+store.on(/WS\/edit\/(SUCCESS|FAILURE)/, (state, _action) => {
   return { ...state, pendingEdits: state.pendingEdits - 1 };
 });
 
+store.on("WS/edit/REVERT", (state, action) => {
+  const { editHandle, column, value, previous } = action.payload;
+  if (!state.results) return state;
+  const results = state.results.map((r: Row) =>
+    r[column]?.editHandle === editHandle && r[column].value === value
+      ? { ...r, [column]: { ...r[column], value: previous } }
+      : r
+  );
+  return { ...state, results };
+});
+
 store.on(/FAILURE/, null, (action, _state) => {
   Swal.fire("Failure", `<pre>${action.payload}</pre>`, "error");
   // triggerModal(action.payload);
@@ -95,69 +152,159 @@ store.on("HTTP/api/query/FAILURE", (state, _action) => {
   return { ...state, loading: state.loading - 1 };
 });
 
-// assume: const handleIndex = new Map<string, { row: number; col: string }[]>();
+store.on(
+  "UI/edit",
+  (state, action) => {
+    return { ...state, editing: action.payload };
+  },
+  async (action, state) => {
+    const cell = action.payload as { row: number; col: string } | null;
+    const editHandle = cell ? state.results?.[cell.row]?.[cell.col]?.editHandle : undefined;
+    presenceWS(
+      GRID_SUB,
+      cell && editHandle ? { editHandle, column: cell.col, state: "editing" } : { state: "viewing" }
+    );
+    // closing the editor submits the edit, which releases the lease when done
+    if (!cell || !editHandle) return;
 
-store.on("UI/edit", (state, action) => {
-  return { ...state, editing: action.payload };
+    releaseHeld();
+    const column = cell.col;
+    try {
+      await lockWS(editHandle, column);
+    } catch (err: any) {
+      store.dispatch({ type: "UI/edit", payload: null });
+      store.dispatch({ type: "WS/lock/FAILURE", payload: err.message });
+      return;
+    }
+    const now = store.state.editing;
+    if (now?.row !== cell.row || now?.col !== cell.col) {
+      unlockWS(editHandle, column).catch(() => {}); // the editor closed before we got it
+      return;
+    }
+    held = {
+      editHandle,
+      column,
+      renew: setInterval(() => lockWS(editHandle, column).catch(() => {}), LOCK_RENEW_MS),
+    };
+  }
+);
+
+store.on("SOCKET/LOCK", (state, action) => {
+  const { lock, locked } = action.payload as { lock: Lock; locked: boolean };
+  const locks = { ...state.locks };
+  if (locked) locks[cellKey(lock.editHandle, lock.column)] = lock;
+  else delete locks[cellKey(lock.editHandle, lock.column)];
+  return { ...state, locks };
 });
 
-// identity: (editHandle, resultCol)
-const key = (handle: string, col: string) => `${handle}::${col}`;
+/** Teammates' presence; null clears it before the server resends everyone's */
+store.on("SOCKET/PRESENCE", (state, action) => {
+  const p = action.payload as Presence | null;
+  if (!p) return { ...state, peers: {} };
+  const peers = { ...state.peers };
+  if (p.state === "left") delete peers[p.peer];
+  else peers[p.peer] = p;
+  return { ...state, peers };
+});
 
-type Cell = { editHandle?: string; value: any };
+type Cell = { editHandle?: string; value: any; comments?: number };
 type Row = Record<string, Cell>;
-type Ref = { row: number; col: string };
-
-const handleIndex = new Map<string, Ref[]>(); // key = key(handle,col)
 
 /** Build/replace results */
 store.on("DATA/results", (state, action) => {
   const results = action.payload as Row[];
+  const comments: Record<string, number> = {};
+  for (const row of results)
+    for (const [col, cell] of Object.entries(row))
+      if (cell.editHandle && cell.comments) comments[cellKey(cell.editHandle, col)] = cell.comments;
+  return { ...state, results, keys: null, comments, loading: state.loading - 1 };
+});
+
+/** Server's materialized rows and their keys (on subscribe, or after a schema change) */
+store.on("SOCKET/RELOAD", (state, action) => {
+  const { rows, keys, comments } = action.payload as {
+    rows: Row[];
+    keys: string[];
+    comments?: Record<string, number>;
+  };
+  return { ...state, results: rows, keys, comments: comments ?? state.comments };
+});
+
+store.on("SOCKET/COMMENT", (state, action) => {
+  const c = action.payload as Comment;
+  const k = cellKey(c.editHandle, c.column ?? "");
+  return { ...state, comments: { ...state.comments, [k]: (state.comments[k] ?? 0) + 1 } };
+});
 
-  handleIndex.clear();
-  for (let i = 0; i < results.length; i++) {
-    const row = results[i];
-    for (const col of Object.keys(row)) {
-      const h = row[col]?.editHandle;
-      if (!h) continue;
-
-      const k = key(h, col);
-      let refs = handleIndex.get(k);
-      if (!refs) handleIndex.set(k, (refs = []));
-      refs.push({ row: i, col });
+store.on(
+  "UI/comments",
+  null,
+  async (action, _state) => {
+    const { editHandle, column } = action.payload as { editHandle: string; column: string };
+    try {
+      const { comments } = await commentsWS(editHandle, column);
+      const res = await Swal.fire({
+        title: `Comments on ${column}`,
+        html:
+          comments.map((c) => `<p><b>${escapeHTML(c.user)}</b>: ${escapeHTML(c.body)}</p>`).join("") ||
+          "<p>(no comments yet)</p>",
+        input: "textarea",
+        inputPlaceholder: "Add a comment",
+        showCancelButton: true,
+        confirmButtonText: "Post",
+      });
+      // the count goes up when the server pushes the comment back
+      if (res.isConfirmed && res.value?.trim()) await commentWS(editHandle, column, res.value);
+    } catch (err: any) {
+      store.dispatch({ type: "WS/comment/FAILURE", payload: err.message });
     }
   }
+);
 
-  return { ...state, results, loading: state.loading - 1 };
-});
+function escapeHTML(s: string) {
+  return s.replace(/[&<>"']/g, (ch) => `&#${ch.charCodeAt(0)};`);
+}
+
+type RowOp = {
+  op: "insert" | "update" | "delete" | "move";
+  key: string;
+  index?: number;
+  row?: Row;
+};
 
-/** Apply socket updates without cross-column bleed */
+/**
+ * Apply socket row ops. Deletes and updates address rows by key; updates carry
+ * only the changed cells. Inserts and moves give the row's final position, so
+ * moved rows are detached first and everything is placed in index order.
+ */
 store.on("SOCKET/UPDATE", (state, action) => {
-  const old = state.results;
-  if (!old) return state;
-
-  const results = old.slice();
-  const touched = new Set<number>();
-
-  for (const upd of action.payload as Array<Record<string, Cell>>) {
-    for (const [col, cell] of Object.entries(upd)) {
-      const h = cell?.editHandle;
-      if (!h) continue;
-
-      const refs = handleIndex.get(key(h, col));
-      if (!refs) continue;
-
-      for (const { row, col: refCol } of refs) {
-        if (!touched.has(row)) {
-          results[row] = { ...results[row] }; // clone row once
-          touched.add(row);
-        }
-        results[row][refCol] = cell; // replace only that column’s cells
-      }
+  if (!state.results || !state.keys) return state;
+
+  // one message per committed transaction (or run of them): { lsn, xid, ops }
+  const ops = (action.payload.ops ?? []) as RowOp[];
+  const byKey = new Map<string, Row>();
+  state.keys.forEach((k: string, i: number) => byKey.set(k, state.results[i]));
+
+  const detached = new Set<string>();
+  for (const { op, key, row } of ops) {
+    if (op === "delete" || op === "move") detached.add(key);
+    if (op === "delete") byKey.delete(key);
+    if (op === "update" && row) {
+      const cur = byKey.get(key);
+      if (cur) byKey.set(key, { ...cur, ...row }); // replace only changed cells
     }
+    if (op === "insert" && row) byKey.set(key, row);
   }
 
-  return { ...state, results };
+  const keys: string[] = state.keys.filter((k: string) => !detached.has(k));
+  for (const { op, key, index } of ops) {
+    if ((op === "insert" || op === "move") && index !== undefined) {
+      keys.splice(index, 0, key);
+    }
+  }
+
+  const results = keys.map((k) => byKey.get(k)!);
+  return { ...state, results, keys };
 });
 
 store.subscribe((state: typeof initialState, _action: any, _prev: any) => {
@@ -200,15 +347,23 @@ const view = (state: any) =>
         loading: state.loading !== 0,
         onEdit: async (i, key, val) => {
           store.dispatch({
-            type: "HTTP/api/edit",
+            type: "WS/edit",
             payload: {
+              row: i,
               editHandle: state.results[i][key].editHandle,
               column: key,
               value: val,
+              previous: state.results[i][key].value,
             },
           });
         },
         editing: state.editing,
+        peers: cellPeers(state.peers, state.locks),
+        comments: state.comments,
+        onComments: (i, key) => {
+          const editHandle = state.results[i][key].editHandle;
+          if (editHandle) store.dispatch({ type: "UI/comments", payload: { editHandle, column: key } });
+        },
         setEditing: (cell: { row: number; col: keyof any } | null) => {
           store.dispatch({ type: "UI/edit", payload: cell });
         },
@@ -216,12 +371,45 @@ const view = (state: any) =>
     ),
   ]);
 
+/** Other users on each cell and who has it locked, keyed by editHandle and column */
+function cellPeers(peers: Record<string, Presence>, locks: Record<string, Lock>) {
+  const byCell = new Map<string, string[]>();
+  const add = (k: string, label: string) => byCell.set(k, [...(byCell.get(k) ?? []), label]);
+  for (const p of Object.values(peers)) {
+    if (p.editHandle && p.column) add(cellKey(p.editHandle, p.column), `${p.user} (${p.state})`);
+  }
+  for (const [k, l] of Object.entries(locks)) {
+    if (held && k === cellKey(held.editHandle, held.column)) continue; // ours
+    add(k, `🔒 ${l.user}`);
+  }
+  return byCell;
+}
+
 const container = document.getElementById("app")!;
 let vnode = patch(container, view(store.state));
 
-connectWS(`ws://localhost:8080/api/ws`, (update: any) => {
-  store.dispatch({ type: "SOCKET/UPDATE", payload: update });
-});
+connectWS(
+  `ws://localhost:8080/api/ws`,
+  (update: any, sub?: string) => {
+    if (sub !== GRID_SUB) return;
+    store.dispatch({ type: "SOCKET/UPDATE", payload: update });
+  },
+  (reload: any, sub?: string) => {
+    if (sub !== GRID_SUB) return;
+    store.dispatch({ type: "SOCKET/RELOAD", payload: reload });
+  },
+  (presence: Presence | null, sub?: string) => {
+    if (sub !== GRID_SUB) return;
+    store.dispatch({ type: "SOCKET/PRESENCE", payload: presence });
+  },
+  (lock: Lock, locked: boolean) => {
+    store.dispatch({ type: "SOCKET/LOCK", payload: { lock, locked } });
+  },
+  (comment: Comment, sub?: string) => {
+    if (sub !== GRID_SUB) return;
+    store.dispatch({ type: "SOCKET/COMMENT", payload: comment });
+  }
+);
 
 // function rerender() {
 //   vnode = patch(vnode, view(store.state));
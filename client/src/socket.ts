@@ -1,22 +1,110 @@
 let socket: WebSocket | null = null;
 let heartbeat: ReturnType<typeof setInterval> | null = null;
 
+// the protocol version we speak and the capabilities we ask for; the server's
+// "hello" answers with the ones it agreed to
+const PROTOCOL_VERSION = 2;
+const CAPABILITIES = ["resume", "edit", "presence", "locks", "comments"];
+
+// set once the server answered our hello; nothing else is accepted before
+let ready = false;
+
+// active subscriptions by ID, with the live query and last position applied
+// from each, so a reconnect can resume them instead of starting over
+const subs = new Map<
+  string,
+  { sql: string; strategy?: string; id?: string; seq?: number; presence?: PresenceReport }
+>();
+
+// requests sent and not yet answered, by request ID
+const pending = new Map<string, { resolve: (data: any) => void; reject: (err: Error) => void }>();
+
+// what this client reports about itself in one subscription
+export interface PresenceReport {
+  editHandle?: string;
+  column?: string;
+  state: "viewing" | "editing" | "idle";
+}
+
+// another subscriber's presence; state "left" when it is gone
+export interface Presence extends Omit<PresenceReport, "state"> {
+  peer: string;
+  user: string;
+  state: PresenceReport["state"] | "left";
+}
+
+export interface EditAck {
+  xid: number; // the edit's transaction; updates it causes list its requestId in origin
+  lsn: string;
+  rows: number;
+}
+
+// a lease on a cell while someone's editor is open
+export interface Lock {
+  editHandle: string;
+  column: string;
+  user: string;
+  hard?: boolean;
+  expires?: string;
+}
+
+export interface Comment {
+  id: number;
+  editHandle: string;
+  column?: string; // none for a comment on the row
+  user: string;
+  body: string;
+  createdAt: string;
+}
+
+// error codes the server answers a failed request with
+export type ErrorCode =
+  | "bad_request"
+  | "handshake_required"
+  | "unsupported_version"
+  | "unsupported"
+  | "unknown_type"
+  | "parse_error"
+  | "query_failed"
+  | "not_found"
+  | "not_editable"
+  | "invalid_value"
+  | "permission_denied"
+  | "conflict"
+  | "internal";
+
+/** A request the server refused; lock is who holds the cell, for conflicts over one. */
+export class ProtocolError extends Error {
+  constructor(public code: ErrorCode, message: string, public lock?: Lock) {
+    super(message);
+  }
+}
+
 export interface WSMessage {
   type: string;
+  requestId?: string; // of the request this answers
+  sub?: string; // subscription ID, for messages about one subscription
   data?: any;
 }
 
 export function connectWS(
   uri: string, // ws://localhost:8080/api/ws  (or wss:// in prod)
-  onUpdate?: (payload: any) => void
+  onUpdate?: (payload: any, sub?: string) => void,
+  // comments counts threads by `${editHandle}|${column}`, only on subscribe
+  onReload?: (reload: { rows: any[]; keys: string[]; comments?: Record<string, number> }, sub?: string) => void,
+  // null means forget every peer of sub: the presence that follows is complete
+  onPresence?: (presence: Presence | null, sub?: string) => void,
+  // every lock change, ours included; locked is false once it ends
+  onLock?: (lock: Lock, locked: boolean) => void,
+  // a new comment on a row the subscription shows
+  onComment?: (comment: Comment, sub?: string) => void
 ) {
   socket = new WebSocket(uri);
+  ready = false;
 
   socket.onopen = () => {
     console.log("✅ Connected to WebSocket");
-
-    // Example: auto-subscribe on connect
-    // subscribeWS("SELECT * FROM actor");
+    send({ type: "hello", data: { version: PROTOCOL_VERSION, capabilities: CAPABILITIES } });
 
     // Heartbeat (keep connection alive)
     // heartbeat = setInterval(() => {
@@ -33,26 +121,89 @@ export function connectWS(
       return;
     }
 
-    const type = msg.type?.toLowerCase?.();
+    const current = msg.sub ? subs.get(msg.sub) : undefined;
+
+    switch (msg.type) {
+      case "hello":
+        ready = true;
+        console.log("🤝 Protocol", msg.data?.version, "capabilities:", msg.data?.capabilities);
+        // (re)subscribe; after a reconnect the server replays what we missed
+        // or sends a fresh snapshot
+        for (const [sub, s] of subs) sendSubscribe(sub, s);
+        break;
 
-    switch (type) {
       case "subscribed":
-        console.log("🔗 Subscribed:", msg.data?.id);
+        if (current) {
+          current.id = msg.data?.id;
+          current.seq = msg.data?.seq;
+        }
+        // the initial result comes with the subscription; a resumed one is
+        // followed by the updates we missed instead
+        if (!msg.data?.resumed && onReload)
+          onReload(
+            { rows: msg.data?.rows ?? [], keys: msg.data?.keys ?? [], comments: msg.data?.comments ?? {} },
+            msg.sub
+          );
+        // the server follows up with everyone else's presence; ours is
+        // re-announced after a reconnect
+        onPresence?.(null, msg.sub);
+        if (msg.sub && current?.presence) sendWS({ type: "presence", sub: msg.sub, data: current.presence });
+        console.log("🔗 Subscribed:", msg.sub, msg.data?.id, "strategy:", msg.data?.strategy, "lsn:", msg.data?.lsn);
         console.debug("tables:", msg.data?.tables);
         break;
 
       case "unsubscribed":
-        console.log("🔌 Unsubscribed");
+        if (msg.sub) subs.delete(msg.sub);
+        else subs.clear();
+        console.log("🔌 Unsubscribed", msg.sub ?? "all");
         break;
 
       case "update":
-        if (onUpdate) onUpdate(msg.data);
+        if (current && msg.data?.id === current.id) current.seq = msg.data?.seq;
+        if (onUpdate) onUpdate(msg.data, msg.sub);
         else console.log("Update:", msg.data);
         break;
 
-      case "error":
-        console.error("WS Error:", msg.data?.error || msg.data);
+      case "schema_changed":
+        console.log("🧬 Schema changed under query:", msg.data?.changes);
+        break;
+
+      case "reload":
+        if (current && msg.data?.id === current.id) current.seq = msg.data?.seq;
+        if (onReload)
+          onReload({ rows: msg.data?.rows ?? [], keys: msg.data?.keys ?? [] }, msg.sub);
+        else console.log("Reload:", msg.data);
+        break;
+
+      case "presence":
+        if (onPresence) onPresence(msg.data, msg.sub);
+        break;
+
+      case "lock":
+      case "unlock":
+        if (onLock) onLock(msg.data, msg.type === "lock");
+        break;
+
+      case "comment":
+        if (onComment) onComment(msg.data, msg.sub);
+        break;
+
+      case "ack":
+        if (msg.requestId) {
+          pending.get(msg.requestId)?.resolve(msg.data);
+          pending.delete(msg.requestId);
+        }
+        break;
+
+      case "error": {
+        const err = new ProtocolError(msg.data?.code ?? "internal", msg.data?.message ?? "request failed", msg.data?.lock);
+        const p = msg.requestId ? pending.get(msg.requestId) : undefined;
+        if (p) {
+          p.reject(err);
+          pending.delete(msg.requestId!);
+        } else console.error("WS Error:", msg.sub ?? "", err.code, err.message);
         break;
+      }
 
       case "pong":
         console.debug("PONG");
@@ -65,9 +216,14 @@ export function connectWS(
 
   socket.onclose = (event) => {
     console.warn("❌ Socket closed:", event.reason || "no reason");
+    ready = false;
     if (heartbeat) clearInterval(heartbeat);
+    // an unanswered edit may or may not have committed; the resubscribe shows
+    // which. Locks end with the connection.
+    for (const [, p] of pending) p.reject(new Error("connection lost before the request was acknowledged"));
+    pending.clear();
     // Auto-reconnect
-    setTimeout(() => connectWS(uri, onUpdate), 2000);
+    setTimeout(() => connectWS(uri, onUpdate, onReload, onPresence, onLock, onComment), 2000);
   };
 
   socket.onerror = (err) => {
@@ -78,21 +234,101 @@ export function connectWS(
 
 // --- protocol helpers ---
 
-export function sendWS(payload: Record<string, any>) {
+/** Sends one request: { type, requestId?, sub?, data? }. Dropped before the handshake. */
+export function sendWS(msg: WSMessage) {
+  if (ready) send(msg);
+  else console.warn("⏳ Socket not ready, dropping message:", msg);
+}
+
+function send(msg: WSMessage) {
   if (socket?.readyState === WebSocket.OPEN) {
-    socket.send(JSON.stringify(payload));
+    socket.send(JSON.stringify(msg));
   } else {
-    console.warn("⏳ Socket not ready, dropping message:", payload);
+    console.warn("⏳ Socket not open, dropping message:", msg);
   }
 }
 
-export function subscribeWS(sql: string) {
+function sendSubscribe(sub: string, s: { sql: string; strategy?: string; id?: string; seq?: number }) {
   sendWS({
     type: "subscribe",
-    sql,
+    requestId: crypto.randomUUID(),
+    sub,
+    data: {
+      sql: s.sql,
+      ...(s.strategy ? { strategy: s.strategy } : {}),
+      ...(s.id && s.seq !== undefined ? { since: { id: s.id, seq: s.seq } } : {}),
+    },
   });
 }
 
-export function unsubscribeWS() {
-  sendWS({ type: "unsubscribe" });
+/**
+ * Subscribes sql under id, replacing any subscription already using it. Sent
+ * once the socket is ready if it isn't yet.
+ * strategy overrides the server's pick: pk_pushdown | full_requery | incremental_aggregate | poll;
+ * only the pick itself or a more conservative full_requery/poll is accepted
+ */
+export function subscribeWS(sql: string, strategy?: string, id: string = crypto.randomUUID()) {
+  const s = { sql, strategy };
+  subs.set(id, s);
+  if (ready) sendSubscribe(id, s);
+  return id;
+}
+
+/** Ends one subscription, or all of them without an id. */
+export function unsubscribeWS(id?: string) {
+  sendWS({ type: "unsubscribe", ...(id ? { sub: id } : {}) });
+}
+
+/** Tells the other subscribers of sub's live query which cell we are on. */
+export function presenceWS(sub: string, presence: PresenceReport) {
+  const s = subs.get(sub);
+  if (!s) return;
+  s.presence = presence;
+  sendWS({ type: "presence", sub, data: presence });
+}
+
+/**
+ * Writes one cell. Resolves with the server's ack once the edit commits, or
+ * rejects with a ProtocolError. Updates caused by the edit carry the
+ * requestId in their origin.
+ */
+export function editWS(
+  edit: { editHandle: string; column: string; value: any },
+  requestId: string = crypto.randomUUID()
+): Promise<EditAck> {
+  return request("edit", edit, requestId);
+}
+
+/**
+ * Leases a cell while its editor is open, or renews our lease on it; others'
+ * edits to it are rejected until we unlock it or stop renewing. hard also
+ * locks the row in the database. Rejects with a conflict if someone else
+ * holds it.
+ */
+export function lockWS(editHandle: string, column: string, hard = false): Promise<Lock> {
+  return request("lock", { editHandle, column, hard });
+}
+
+/** Ends our lease on a cell. */
+export function unlockWS(editHandle: string, column: string): Promise<unknown> {
+  return request("unlock", { editHandle, column });
+}
+
+/** Adds to a cell's comment thread, or the row's without a column. */
+export function commentWS(editHandle: string, column: string | undefined, body: string): Promise<Comment> {
+  return request("comment", { editHandle, column, body });
+}
+
+/** Lists a cell's comment thread, or the row's without a column, oldest first. */
+export function commentsWS(editHandle: string, column?: string): Promise<{ comments: Comment[] }> {
+  return request("comments", { editHandle, column });
+}
+
+// sends a request answered by an "ack" or "error" under its requestId
+function request<T>(type: string, data: any, requestId: string = crypto.randomUUID()): Promise<T> {
+  if (!ready) return Promise.reject(new Error("socket not connected"));
+  return new Promise((resolve, reject) => {
+    pending.set(requestId, { resolve, reject });
+    sendWS({ type, requestId, data });
+  });
 }
@@ -90,7 +90,15 @@ func connectAndReadReplication(b *Broadcaster) error {
 	log.Printf("PostgreSQL System ID: %s, Timeline: %d, XLogPos: %s, DBNAME: %s", sys.SystemID, sys.Timeline, sys.XLogPos, sys.DBName)
 
 	slotName := "delta_slot"
-	pluginArguments := []string{"\"pretty-print\" 'true'"}
+	// format-version 2 emits one message per row change, bracketed by
+	// {"action":"B"} / {"action":"C"} so consumers can group by transaction.
+	pluginArguments := []string{
+		"\"format-version\" '2'",
+		"\"include-transaction\" 'true'",
+		"\"include-xids\" 'true'",
+		"\"include-lsn\" 'true'",
+		"\"include-pk\" 'true'",
+	}
 
 	err = pglogrepl.StartReplication(context.Background(), conn, slotName, sys.XLogPos,
 		pglogrepl.StartReplicationOptions{PluginArgs: pluginArguments})
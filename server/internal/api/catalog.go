@@ -0,0 +1,107 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/zoravur/postgres-spreadsheet-view/server/pkg/richcatalog"
+)
+
+// GET /api/catalog?schema=public&table=film,actor
+//
+// Returns the richcatalog snapshot (tables, columns, PKs, indexes, FKs, types).
+// The ETag is the snapshot checksum, so clients can revalidate with If-None-Match.
+// schema and table accept comma-separated lists and may be repeated.
+func handleCatalog(w http.ResponseWriter, r *http.Request, cat *richcatalog.DBCatalog) {
+	if cat == nil {
+		http.Error(w, "catalog unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	// Load lazily if the background refresh hasn't completed yet.
+	pinned, err := cat.Load(r.Context())
+	if err != nil {
+		http.Error(w, "catalog load failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	etag := `"` + pinned.Checksum + `"`
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache")
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	qs := r.URL.Query()
+	snap := pinned.Filter(splitListParam(qs["schema"]), splitListParam(qs["table"]))
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(snap)
+}
+
+func etagMatches(ifNoneMatch, etag string) bool {
+	if ifNoneMatch == "" {
+		return false
+	}
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// splitListParam flattens ?x=a,b&x=c into [a b c].
+func splitListParam(vals []string) []string {
+	var out []string
+	for _, v := range vals {
+		for _, p := range strings.Split(v, ",") {
+			if p = strings.TrimSpace(p); p != "" {
+				out = append(out, p)
+			}
+		}
+	}
+	return out
+}
+
+// GET /api/catalog/diff?since=<checksum>
+//
+// Returns the structural changes between the snapshot a client last saw and the
+// current one. 410 Gone means the old snapshot is no longer retained; the client
+// should reload /api/catalog instead.
+func handleCatalogDiff(w http.ResponseWriter, r *http.Request, cat *richcatalog.DBCatalog) {
+	if cat == nil {
+		http.Error(w, "catalog unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	since := r.URL.Query().Get("since")
+	if since == "" {
+		http.Error(w, "missing since", http.StatusBadRequest)
+		return
+	}
+
+	curSnap := cat.Pin()
+	if curSnap.Checksum == "" {
+		http.Error(w, "catalog not loaded", http.StatusServiceUnavailable)
+		return
+	}
+	old, ok := cat.SnapshotAt(since)
+	if !ok {
+		http.Error(w, "unknown or expired checksum "+since, http.StatusGone)
+		return
+	}
+
+	changes := richcatalog.Diff(old, *curSnap)
+	if changes == nil {
+		changes = []richcatalog.Change{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]any{
+		"from":    since,
+		"to":      curSnap.Checksum,
+		"changes": changes,
+	})
+}
@@ -0,0 +1,159 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"go.uber.org/zap"
+
+	"github.com/zoravur/postgres-spreadsheet-view/server/internal/comments"
+	"github.com/zoravur/postgres-spreadsheet-view/server/internal/common"
+	"github.com/zoravur/postgres-spreadsheet-view/server/internal/protocol"
+	"github.com/zoravur/postgres-spreadsheet-view/server/internal/reactive"
+)
+
+// GET /api/comments?handle=...&column=...
+//
+// Lists the thread on a cell, oldest first; without column, the row's own.
+func handleListComments(w http.ResponseWriter, r *http.Request, store *comments.Store) {
+	if store == nil {
+		http.Error(w, "comments disabled", http.StatusServiceUnavailable)
+		return
+	}
+	qs := r.URL.Query()
+	list, err := store.List(r.Context(), qs.Get("handle"), qs.Get("column"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(list)
+}
+
+// POST /api/comments  {"editHandle", "column", "body"}
+//
+// Adds a comment as the caller and pushes it to live subscribers of the row.
+// The handle's table and key and the column must be in the catalog.
+func handleAddComment(w http.ResponseWriter, r *http.Request, deps Deps) {
+	if deps.Comments == nil {
+		http.Error(w, "comments disabled", http.StatusServiceUnavailable)
+		return
+	}
+	var c comments.Comment
+	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	if deps.Catalog == nil {
+		http.Error(w, "catalog unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	c.User = userFromRequest(r)
+	c, err := deps.Comments.Add(r.Context(), deps.Catalog, c)
+	var pe *protocol.Error
+	switch {
+	case errors.As(err, &pe):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	case err != nil:
+		http.Error(w, "comment failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	pushComment(deps.Registry, c)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(c)
+}
+
+// pushComment sends a new comment as "comment" to every subscriber of a live
+// query whose current result includes the comment's row.
+func pushComment(reg *reactive.Registry, c comments.Comment) {
+	if reg == nil {
+		return
+	}
+	schema, table, _, err := common.DecodeHandle(c.EditHandle)
+	if err != nil {
+		return
+	}
+	fq := schema + "." + table
+	// HasHandle waits out a running refresh, so don't hold the registry meanwhile
+	for _, q := range reg.Snapshot() {
+		if !q.DependsOn(fq) || !q.HasHandle(c.EditHandle) {
+			continue
+		}
+		q.Mu.RLock()
+		for cl := range q.Clients {
+			if err := cl.Send(protocol.TypeComment, c); err != nil {
+				zap.L().Warn("comment_push_failed", zap.String("live_query_id", q.ID), zap.Error(err))
+			}
+		}
+		q.Mu.RUnlock()
+	}
+}
+
+// annotateComments sets each cell's comment count. Threads are kept under
+// table columns, so each result column is looked up by the column it comes
+// from (prov is the query's provenance); aliased and joined columns count
+// too, and expressions never have a thread. The counts are a second query
+// after the rows are read: if it fails, the rows go out without them.
+func annotateComments(ctx context.Context, store *comments.Store, rows []reactive.EditableRow, prov map[string][]string) {
+	if store == nil || len(rows) == 0 {
+		return
+	}
+	counts, err := store.Counts(ctx, rowHandles(rows))
+	if err != nil {
+		zap.L().Warn("comment_counts_failed", zap.Error(err))
+		return
+	}
+	base := map[string]string{}
+	for col := range rows[0] {
+		base[col] = reactive.BaseColumn(col, prov)
+	}
+	for _, row := range rows {
+		for col, cell := range row {
+			if base[col] == "" {
+				continue
+			}
+			if n := counts[cell.EditHandle][base[col]]; n > 0 {
+				cell.Comments = n
+				row[col] = cell
+			}
+		}
+	}
+}
+
+// commentCounts is the per-cell comment counts of a live query's rows, keyed
+// "<editHandle>|<column>" ("<editHandle>|" for a row's own thread), for a
+// new subscriber.
+func commentCounts(ctx context.Context, store *comments.Store, q *reactive.LiveQuery) map[string]int {
+	out := map[string]int{}
+	if store == nil {
+		return out
+	}
+	counts, err := store.Counts(ctx, q.Handles())
+	if err != nil {
+		zap.L().Warn("comment_counts_failed", zap.String("live_query_id", q.ID), zap.Error(err))
+		return out
+	}
+	for h, cols := range counts {
+		for col, n := range cols {
+			out[h+"|"+col] = n
+		}
+	}
+	return out
+}
+
+func rowHandles(rows []reactive.EditableRow) []string {
+	var out []string
+	for _, row := range rows {
+		for _, cell := range row {
+			if cell.EditHandle != "" {
+				out = append(out, cell.EditHandle)
+			}
+		}
+	}
+	return out
+}
@@ -0,0 +1,117 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/zoravur/postgres-spreadsheet-view/server/internal/comments"
+	"github.com/zoravur/postgres-spreadsheet-view/server/internal/common"
+	"github.com/zoravur/postgres-spreadsheet-view/server/internal/reactive"
+	"github.com/zoravur/postgres-spreadsheet-view/server/internal/sqlfake"
+)
+
+// countsStore answers Counts with threads on film 7: two on title, one on
+// the row itself.
+func countsStore(fail bool) *comments.Store {
+	db, _ := sqlfake.Open(func(_ context.Context, c sqlfake.Call) (sqlfake.Result, error) {
+		if fail {
+			return sqlfake.Result{}, errors.New("connection refused")
+		}
+		return sqlfake.Result{Columns: []string{"k", "column_name", "count"}, Rows: [][]any{
+			{"public.film|film_id=7", "title", int64(2)},
+			{"public.film|film_id=7", "", int64(1)},
+		}}, nil
+	})
+	return comments.NewStore(db)
+}
+
+func TestAnnotateComments(t *testing.T) {
+	film := common.EncodeHandle("public", "film", []string{"film_id"}, []any{7})
+	rows := func() []reactive.EditableRow {
+		return []reactive.EditableRow{{
+			"film_title": {EditHandle: film, Value: "Alien"}, // SELECT f.title AS film_title
+			"title":      {EditHandle: film, Value: "Alien"},
+			"film_id":    {EditHandle: film, Value: 7},
+			"upper":      {Value: "ALIEN"}, // an expression
+		}}
+	}
+	prov := map[string][]string{
+		"film_title": {"film.title"},
+		"title":      {"film.title"},
+		"film_id":    {"film.film_id"},
+	}
+
+	got := rows()
+	annotateComments(context.Background(), countsStore(false), got, prov)
+	want := map[string]int{"film_title": 2, "title": 2, "film_id": 0, "upper": 0}
+	for col, n := range want {
+		if got[0][col].Comments != n {
+			t.Errorf("%s: %d comments, want %d", col, got[0][col].Comments, n)
+		}
+	}
+
+	// a failed lookup leaves the rows as they were
+	got = rows()
+	annotateComments(context.Background(), countsStore(true), got, prov)
+	for col, cell := range got[0] {
+		if cell.Comments != 0 {
+			t.Errorf("%s: %d comments after a failed lookup", col, cell.Comments)
+		}
+	}
+}
+
+func TestCommentHandlers(t *testing.T) {
+	film := common.EncodeHandle("public", "film", []string{"film_id"}, []any{7})
+	tests := []struct {
+		name   string
+		handle func(w http.ResponseWriter, r *http.Request)
+		req    *http.Request
+		want   int
+	}{
+		{
+			"list, disabled",
+			func(w http.ResponseWriter, r *http.Request) { handleListComments(w, r, nil) },
+			httptest.NewRequest(http.MethodGet, "/api/comments?handle="+film, nil),
+			http.StatusServiceUnavailable,
+		},
+		{
+			"list, bad handle",
+			func(w http.ResponseWriter, r *http.Request) { handleListComments(w, r, countsStore(false)) },
+			httptest.NewRequest(http.MethodGet, "/api/comments?handle=%25%25", nil),
+			http.StatusBadRequest,
+		},
+		{
+			"add, disabled",
+			func(w http.ResponseWriter, r *http.Request) { handleAddComment(w, r, Deps{}) },
+			httptest.NewRequest(http.MethodPost, "/api/comments", strings.NewReader(`{"editHandle":"`+film+`","body":"x"}`)),
+			http.StatusServiceUnavailable,
+		},
+		{
+			"add, invalid JSON",
+			func(w http.ResponseWriter, r *http.Request) {
+				handleAddComment(w, r, Deps{Comments: countsStore(false)})
+			},
+			httptest.NewRequest(http.MethodPost, "/api/comments", strings.NewReader(`{`)),
+			http.StatusBadRequest,
+		},
+		{
+			"add, no catalog to check it against",
+			func(w http.ResponseWriter, r *http.Request) {
+				handleAddComment(w, r, Deps{Comments: countsStore(false)})
+			},
+			httptest.NewRequest(http.MethodPost, "/api/comments", strings.NewReader(`{"editHandle":"`+film+`","body":"x"}`)),
+			http.StatusServiceUnavailable,
+		},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		tt.handle(rec, tt.req)
+		if rec.Code != tt.want {
+			t.Errorf("%s: status %d, want %d (%s)", tt.name, rec.Code, tt.want, rec.Body)
+		}
+	}
+}
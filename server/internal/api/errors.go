@@ -0,0 +1,54 @@
+package api
+
+import (
+	"errors"
+
+	"github.com/lib/pq"
+
+	"github.com/zoravur/postgres-spreadsheet-view/server/internal/locks"
+	"github.com/zoravur/postgres-spreadsheet-view/server/internal/protocol"
+)
+
+// classifyError gives err a protocol error code: its own if it is a
+// *protocol.Error, one for lock conflicts and the Postgres errors a client can
+// act on, fallback otherwise.
+func classifyError(err error, fallback protocol.ErrorCode) *protocol.Error {
+	var pe *protocol.Error
+	if errors.As(err, &pe) {
+		return pe
+	}
+	out := &protocol.Error{Code: fallback, Message: err.Error()}
+
+	var le *locks.LockedError
+	var pqErr *pq.Error
+	switch {
+	case errors.As(err, &le):
+		out.Code = protocol.CodeConflict
+		out.Lock = &le.Lock
+	case errors.Is(err, locks.ErrRowLocked), errors.Is(err, locks.ErrTooManyHard):
+		out.Code = protocol.CodeConflict
+	case errors.As(err, &pqErr):
+		if code := pqErrorCode(pqErr); code != "" {
+			out.Code = code
+		}
+	}
+	return out
+}
+
+// pqErrorCode maps the SQLSTATEs a client can act on; "" for the rest.
+func pqErrorCode(e *pq.Error) protocol.ErrorCode {
+	switch e.Code {
+	case "42501": // insufficient_privilege
+		return protocol.CodePermissionDenied
+	case "23505", "40001", "40P01", "55P03": // unique, serialization, deadlock, lock_not_available
+		return protocol.CodeConflict
+	case "23502", "23503", "23514": // not null, foreign key, check
+		return protocol.CodeInvalidValue
+	case "42601": // syntax_error
+		return protocol.CodeParseError
+	}
+	if e.Code.Class() == "22" { // data exception: bad input syntax, out of range...
+		return protocol.CodeInvalidValue
+	}
+	return ""
+}
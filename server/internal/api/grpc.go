@@ -0,0 +1,348 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/zoravur/postgres-spreadsheet-view/server/internal/history"
+	"github.com/zoravur/postgres-spreadsheet-view/server/internal/metrics"
+	"github.com/zoravur/postgres-spreadsheet-view/server/internal/protocol"
+	"github.com/zoravur/postgres-spreadsheet-view/server/internal/reactive"
+	pb "github.com/zoravur/postgres-spreadsheet-view/server/pkg/livequerypb"
+	"github.com/zoravur/postgres-spreadsheet-view/server/pkg/pg_lineage"
+)
+
+var (
+	mGRPCStreams        = metrics.NewGauge("grpc_streams")
+	mGRPCSlowDisconnect = metrics.NewCounter("grpc_slow_client_disconnects_total")
+)
+
+// NewGRPCServer returns a gRPC server with the LiveQuery service
+// (pkg/livequerypb) registered. It shares live queries with the WebSocket and
+// SSE handlers built from the same deps' registry.
+func NewGRPCServer(deps Deps, opts ...grpc.ServerOption) *grpc.Server {
+	s := grpc.NewServer(opts...)
+	pb.RegisterLiveQueryServer(s, &liveQueryServer{deps: deps, live: newWSHandler(deps)})
+	return s
+}
+
+// liveQueryServer implements pb.LiveQueryServer on top of the same pipeline
+// as the HTTP and WebSocket API. Callers hold no cell locks, so locked cells
+// reject their edits. Errors carry the protocol error code as an ErrorInfo
+// reason.
+type liveQueryServer struct {
+	pb.UnimplementedLiveQueryServer
+	deps Deps
+	live *WSHandler // for acquireLiveQuery
+}
+
+func (s *liveQueryServer) Query(ctx context.Context, req *pb.QueryRequest) (*pb.QueryResponse, error) {
+	start := time.Now()
+	rows, httpStatus, err := runEditableQuery(ctx, s.deps, req.GetSql())
+	recordQueryAs(ctx, s.deps.History, grpcUser(ctx), history.SourceGRPC, req.GetSql(), start, len(rows), err)
+	if err != nil {
+		fallback := protocol.CodeInternal
+		switch httpStatus {
+		case http.StatusBadRequest:
+			fallback = protocol.CodeQueryFailed
+		case http.StatusServiceUnavailable:
+			fallback = protocol.CodeUnsupported
+		}
+		return nil, grpcError(classifyError(err, fallback))
+	}
+	resp := &pb.QueryResponse{Rows: make([]*pb.Row, len(rows))}
+	for i, row := range rows {
+		if resp.Rows[i], err = toPBRow(row); err != nil {
+			return nil, grpcError(classifyError(err, protocol.CodeInternal))
+		}
+	}
+	return resp, nil
+}
+
+// Subscribe streams one live query until the caller cancels. A caller that
+// falls behind gets ResourceExhausted and resubscribes with since; an event
+// that can't be converted ends the stream with Internal.
+func (s *liveQueryServer) Subscribe(req *pb.SubscribeRequest, stream pb.LiveQuery_SubscribeServer) error {
+	ctx := stream.Context()
+	if req.GetSql() == "" {
+		return grpcError(protocol.Errorf(protocol.CodeBadRequest, "missing sql"))
+	}
+	override, err := pg_lineage.ParseStrategy(req.GetStrategy())
+	if err != nil {
+		return grpcError(protocol.AsError(err, protocol.CodeBadRequest))
+	}
+
+	start := time.Now()
+	lq, err := s.live.acquireLiveQuery(ctx, req.GetSql(), override)
+	if err != nil {
+		recordQueryAs(ctx, s.deps.History, grpcUser(ctx), history.SourceGRPC, req.GetSql(), start, -1, err)
+		return grpcError(classifyError(err, protocol.CodeQueryFailed))
+	}
+
+	q := newLiveStream(s.deps.Outbound.QueueSize, mGRPCStreams, mGRPCSlowDisconnect)
+	defer q.close()
+	cl := &reactive.Client{Send: q.send}
+	defer s.deps.Registry.Release(lq, cl)
+
+	// as in HandleWS: the initial result (or the missed updates) is queued
+	// under the query's lock, before any later update
+	rowCount := -1
+	info := subscribedInfo(lq)
+	if since := req.GetSince(); since != nil && since.GetId() == lq.ID {
+		_, err = lq.Resume(cl, since.GetSeq(), info)
+	} else {
+		rowCount, err = lq.Attach(cl, info)
+	}
+	recordQueryAs(ctx, s.deps.History, grpcUser(ctx), history.SourceGRPC, req.GetSql(), start, rowCount, err)
+	if err != nil {
+		return grpcError(classifyError(err, protocol.CodeInternal))
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-q.done:
+			return status.Error(codes.ResourceExhausted, "subscriber fell behind; resubscribe with since")
+		case m := <-q.queue:
+			// skipping an event would leave the caller's copy silently
+			// out of date, so end the stream and let them resubscribe
+			ev, err := toPBEvent(m)
+			if err != nil {
+				zap.L().Warn("grpc_event_convert_failed", zap.String("type", string(m.Type)), zap.Error(err))
+				return grpcError(protocol.Errorf(protocol.CodeInternal, "converting %s event: %v", m.Type, err))
+			}
+			if err := stream.Send(ev); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (s *liveQueryServer) Edit(ctx context.Context, req *pb.EditRequest) (*pb.EditResponse, error) {
+	res, httpStatus, err := applyEdit(ctx, s.deps.DB, s.deps.Catalog, s.deps.Locks, "", fromPBEdit(req), nil)
+	if err == nil && res.Rows == 0 {
+		err = protocol.Errorf(protocol.CodeNotEditable, "no row matches the edit handle")
+	}
+	if err != nil {
+		return nil, grpcError(classifyError(err, editFallback(httpStatus)))
+	}
+	return &pb.EditResponse{Xid: res.XID, Lsn: res.LSN, Rows: res.Rows}, nil
+}
+
+func (s *liveQueryServer) BatchEdit(ctx context.Context, req *pb.BatchEditRequest) (*pb.BatchEditResponse, error) {
+	edits := make([]EditRequest, len(req.GetEdits()))
+	for i, e := range req.GetEdits() {
+		edits[i] = fromPBEdit(e)
+	}
+	res, rows, httpStatus, err := applyEdits(ctx, s.deps.DB, s.deps.Catalog, s.deps.Locks, edits)
+	if err != nil {
+		return nil, grpcError(classifyError(err, editFallback(httpStatus)))
+	}
+	return &pb.BatchEditResponse{Xid: res.XID, Lsn: res.LSN, Rows: rows}, nil
+}
+
+func (s *liveQueryServer) GetCatalog(ctx context.Context, req *pb.GetCatalogRequest) (*pb.GetCatalogResponse, error) {
+	if s.deps.Catalog == nil {
+		return nil, status.Error(codes.Unavailable, "catalog unavailable")
+	}
+	pinned, err := s.deps.Catalog.Load(ctx)
+	if err != nil {
+		return nil, grpcError(protocol.Errorf(protocol.CodeInternal, "catalog load failed: %v", err))
+	}
+	resp := &pb.GetCatalogResponse{Checksum: pinned.Checksum}
+	if req.GetIfNoneMatch() != "" && req.GetIfNoneMatch() == pinned.Checksum {
+		resp.NotModified = true
+		return resp, nil
+	}
+	if resp.Catalog, err = toStruct(pinned.Filter(req.GetSchemas(), req.GetTables())); err != nil {
+		return nil, grpcError(protocol.AsError(err, protocol.CodeInternal))
+	}
+	return resp, nil
+}
+
+// editFallback is the error code for an applyEdit failure the classifier
+// doesn't recognize, from the HTTP status it came with.
+func editFallback(httpStatus int) protocol.ErrorCode {
+	switch httpStatus {
+	case http.StatusBadRequest, http.StatusNotFound:
+		return protocol.CodeNotEditable
+	case http.StatusConflict:
+		return protocol.CodeConflict
+	default:
+		return protocol.CodeInternal
+	}
+}
+
+// grpcUser identifies the caller, like userFromRequest: the x-user metadata.
+func grpcUser(ctx context.Context) string {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if u := md.Get("x-user"); len(u) > 0 && u[0] != "" {
+			return u[0]
+		}
+	}
+	return "anonymous"
+}
+
+// grpcError turns a protocol error into a gRPC status with the matching code
+// and the protocol code as an ErrorInfo reason.
+func grpcError(e *protocol.Error) error {
+	code := codes.Internal
+	switch e.Code {
+	case protocol.CodeBadRequest, protocol.CodeParseError, protocol.CodeQueryFailed, protocol.CodeInvalidValue:
+		code = codes.InvalidArgument
+	case protocol.CodeNotFound:
+		code = codes.NotFound
+	case protocol.CodeNotEditable:
+		code = codes.FailedPrecondition
+	case protocol.CodePermissionDenied:
+		code = codes.PermissionDenied
+	case protocol.CodeConflict:
+		code = codes.Aborted
+	case protocol.CodeUnsupported:
+		code = codes.Unimplemented
+	}
+	st := status.New(code, e.Message)
+	info := &errdetails.ErrorInfo{Reason: string(e.Code), Domain: "postgres-spreadsheet-view"}
+	if e.Lock != nil {
+		info.Metadata = map[string]string{"lockUser": e.Lock.User, "lockExpires": e.Lock.Expires.Format(time.RFC3339)}
+	}
+	if withInfo, err := st.WithDetails(info); err == nil {
+		st = withInfo
+	}
+	return st.Err()
+}
+
+// --- conversions ---
+
+func fromPBEdit(e *pb.EditRequest) EditRequest {
+	return EditRequest{EditHandle: e.GetEditHandle(), Column: e.GetColumn(), Value: e.GetValue().AsInterface()}
+}
+
+// toValue converts a cell value to a protobuf Value, through its JSON form
+// for types Value doesn't take directly (times, numerics, byte strings), so
+// gRPC callers see what JSON clients see.
+func toValue(v any) (*structpb.Value, error) {
+	if pv, err := structpb.NewValue(v); err == nil {
+		return pv, nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	pv := &structpb.Value{}
+	return pv, protojson.Unmarshal(b, pv)
+}
+
+// toStruct converts v to a Struct through its JSON form.
+func toStruct(v any) (*structpb.Struct, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	st := &structpb.Struct{}
+	return st, protojson.Unmarshal(b, st)
+}
+
+func toPBRow(row protocol.Row) (*pb.Row, error) {
+	out := &pb.Row{Cells: make(map[string]*pb.Cell, len(row))}
+	for col, c := range row {
+		v, err := toValue(c.Value)
+		if err != nil {
+			return nil, fmt.Errorf("column %s: %w", col, err)
+		}
+		out.Cells[col] = &pb.Cell{EditHandle: c.EditHandle, Value: v, Comments: int32(c.Comments)}
+	}
+	return out, nil
+}
+
+func toPBSnapshot(s protocol.Snapshot) (*pb.Snapshot, error) {
+	out := &pb.Snapshot{Id: s.ID, Seq: s.Seq, Lsn: s.LSN, Keys: s.Keys, Rows: make([]*pb.Row, len(s.Rows))}
+	for i, row := range s.Rows {
+		var err error
+		if out.Rows[i], err = toPBRow(row); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+func toPBColumns(pk map[string][]string) map[string]*pb.Columns {
+	out := make(map[string]*pb.Columns, len(pk))
+	for t, cols := range pk {
+		out[t] = &pb.Columns{Names: cols}
+	}
+	return out
+}
+
+// toPBEvent converts a message a live query sends its subscribers.
+func toPBEvent(m protocol.Message) (*pb.SubscribeEvent, error) {
+	switch d := m.Data.(type) {
+	case protocol.Subscribed:
+		snap, err := toPBSnapshot(d.Snapshot)
+		if err != nil {
+			return nil, err
+		}
+		return &pb.SubscribeEvent{Event: &pb.SubscribeEvent_Subscribed{Subscribed: &pb.Subscribed{
+			Snapshot: snap,
+			Resumed:  d.Resumed,
+			Tables:   d.Tables,
+			PkCols:   toPBColumns(d.PKCols),
+			Strategy: d.Strategy,
+			Rewrote:  d.Rewrote,
+		}}}, nil
+
+	case protocol.Snapshot:
+		snap, err := toPBSnapshot(d)
+		if err != nil {
+			return nil, err
+		}
+		return &pb.SubscribeEvent{Event: &pb.SubscribeEvent_Reload{Reload: snap}}, nil
+
+	case protocol.Update:
+		u := &pb.Update{Id: d.ID, Seq: d.Seq, Lsn: d.LSN, Xid: d.XID, Xids: d.XIDs, Ops: make([]*pb.RowOp, len(d.Ops))}
+		for i, op := range d.Ops {
+			pop := &pb.RowOp{Op: op.Op, Key: op.Key}
+			if op.Index != nil {
+				idx := int32(*op.Index)
+				pop.Index = &idx
+			}
+			if op.Row != nil {
+				var err error
+				if pop.Row, err = toPBRow(op.Row); err != nil {
+					return nil, err
+				}
+			}
+			u.Ops[i] = pop
+		}
+		return &pb.SubscribeEvent{Event: &pb.SubscribeEvent_Update{Update: u}}, nil
+
+	case protocol.SchemaChanged:
+		sc := &pb.SchemaChanged{Id: d.ID, Tables: d.Tables, PkCols: toPBColumns(d.PKCols), Changes: make([]*structpb.Struct, len(d.Changes))}
+		for i, c := range d.Changes {
+			var err error
+			if sc.Changes[i], err = toStruct(c); err != nil {
+				return nil, err
+			}
+		}
+		return &pb.SubscribeEvent{Event: &pb.SubscribeEvent_SchemaChanged{SchemaChanged: sc}}, nil
+
+	case *protocol.Error:
+		return &pb.SubscribeEvent{Event: &pb.SubscribeEvent_Error{Error: &pb.Error{Code: string(d.Code), Message: d.Message}}}, nil
+
+	default:
+		return nil, fmt.Errorf("unexpected %s payload %T", m.Type, m.Data)
+	}
+}
@@ -0,0 +1,171 @@
+package api
+
+import (
+	"testing"
+	"time"
+
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/zoravur/postgres-spreadsheet-view/server/internal/protocol"
+	pb "github.com/zoravur/postgres-spreadsheet-view/server/pkg/livequerypb"
+)
+
+func TestGRPCError(t *testing.T) {
+	tests := []struct {
+		code protocol.ErrorCode
+		want codes.Code
+	}{
+		{protocol.CodeBadRequest, codes.InvalidArgument},
+		{protocol.CodeParseError, codes.InvalidArgument},
+		{protocol.CodeQueryFailed, codes.InvalidArgument},
+		{protocol.CodeInvalidValue, codes.InvalidArgument},
+		{protocol.CodeNotFound, codes.NotFound},
+		{protocol.CodeNotEditable, codes.FailedPrecondition},
+		{protocol.CodePermissionDenied, codes.PermissionDenied},
+		{protocol.CodeConflict, codes.Aborted},
+		{protocol.CodeUnsupported, codes.Unimplemented},
+		{protocol.CodeInternal, codes.Internal},
+		{protocol.CodeUnknownType, codes.Internal},
+	}
+	for _, tt := range tests {
+		st := status.Convert(grpcError(protocol.Errorf(tt.code, "boom")))
+		if st.Code() != tt.want {
+			t.Errorf("%s: code = %s, want %s", tt.code, st.Code(), tt.want)
+		}
+		if st.Message() != "boom" {
+			t.Errorf("%s: message = %q", tt.code, st.Message())
+		}
+		info := errorInfo(t, st)
+		if info.GetReason() != string(tt.code) {
+			t.Errorf("%s: reason = %q", tt.code, info.GetReason())
+		}
+	}
+}
+
+func TestGRPCErrorLock(t *testing.T) {
+	expires := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	e := protocol.Errorf(protocol.CodeConflict, "locked")
+	e.Lock = &protocol.Lock{User: "ana", Expires: expires}
+
+	info := errorInfo(t, status.Convert(grpcError(e)))
+	if got := info.GetMetadata()["lockUser"]; got != "ana" {
+		t.Errorf("lockUser = %q", got)
+	}
+	if got := info.GetMetadata()["lockExpires"]; got != "2026-01-02T03:04:05Z" {
+		t.Errorf("lockExpires = %q", got)
+	}
+}
+
+func errorInfo(t *testing.T, st *status.Status) *errdetails.ErrorInfo {
+	t.Helper()
+	for _, d := range st.Details() {
+		if info, ok := d.(*errdetails.ErrorInfo); ok {
+			return info
+		}
+	}
+	t.Fatalf("no ErrorInfo in %v", st.Details())
+	return nil
+}
+
+func TestToValue(t *testing.T) {
+	tests := []struct {
+		name string
+		in   any
+		want *structpb.Value
+	}{
+		{"nil", nil, structpb.NewNullValue()},
+		{"string", "x", structpb.NewStringValue("x")},
+		{"int", int64(7), structpb.NewNumberValue(7)},
+		{"bool", true, structpb.NewBoolValue(true)},
+		// through JSON, as JSON clients see them
+		{"time", time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC), structpb.NewStringValue("2026-01-02T03:04:05Z")},
+		{"bytes", []byte("hi"), structpb.NewStringValue("aGk=")},
+	}
+	for _, tt := range tests {
+		got, err := toValue(tt.in)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !proto.Equal(got, tt.want) {
+			t.Errorf("%s: toValue = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+	if _, err := toValue(make(chan int)); err == nil {
+		t.Error("toValue(chan): want error")
+	}
+}
+
+func TestToPBEvent(t *testing.T) {
+	idx := 2
+	row := protocol.Row{"title": {EditHandle: "h1", Value: "Alien"}}
+	snap := protocol.Snapshot{ID: "q1", Seq: 3, LSN: "0/1", Rows: []protocol.Row{row}, Keys: []string{"k1"}}
+	pbRow := &pb.Row{Cells: map[string]*pb.Cell{"title": {EditHandle: "h1", Value: structpb.NewStringValue("Alien")}}}
+	pbSnap := &pb.Snapshot{Id: "q1", Seq: 3, Lsn: "0/1", Rows: []*pb.Row{pbRow}, Keys: []string{"k1"}}
+
+	tests := []struct {
+		name string
+		m    protocol.Message
+		want *pb.SubscribeEvent
+	}{
+		{
+			"subscribed",
+			protocol.Message{Type: protocol.TypeSubscribed, Data: protocol.Subscribed{
+				Snapshot: snap, Tables: []string{"public.film"},
+				PKCols: map[string][]string{"public.film": {"id"}}, Strategy: "pk_pushdown",
+			}},
+			&pb.SubscribeEvent{Event: &pb.SubscribeEvent_Subscribed{Subscribed: &pb.Subscribed{
+				Snapshot: pbSnap, Tables: []string{"public.film"},
+				PkCols: map[string]*pb.Columns{"public.film": {Names: []string{"id"}}}, Strategy: "pk_pushdown",
+			}}},
+		},
+		{
+			"reload",
+			protocol.Message{Type: protocol.TypeReload, Data: snap},
+			&pb.SubscribeEvent{Event: &pb.SubscribeEvent_Reload{Reload: pbSnap}},
+		},
+		{
+			"update",
+			protocol.Message{Type: protocol.TypeUpdate, Data: protocol.Update{
+				ID: "q1", Seq: 4, XID: 9, XIDs: []int64{8, 9},
+				Ops: []protocol.RowOp{{Op: "delete", Key: "k0"}, {Op: "insert", Key: "k1", Index: &idx, Row: row}},
+			}},
+			&pb.SubscribeEvent{Event: &pb.SubscribeEvent_Update{Update: &pb.Update{
+				Id: "q1", Seq: 4, Xid: 9, Xids: []int64{8, 9},
+				Ops: []*pb.RowOp{{Op: "delete", Key: "k0"}, {Op: "insert", Key: "k1", Index: proto.Int32(2), Row: pbRow}},
+			}}},
+		},
+		{
+			"error",
+			protocol.Message{Type: protocol.TypeError, Data: protocol.Errorf(protocol.CodeQueryFailed, "gone")},
+			&pb.SubscribeEvent{Event: &pb.SubscribeEvent_Error{Error: &pb.Error{Code: "query_failed", Message: "gone"}}},
+		},
+	}
+	for _, tt := range tests {
+		got, err := toPBEvent(tt.m)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !proto.Equal(got, tt.want) {
+			t.Errorf("%s: toPBEvent = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestToPBEventErrors(t *testing.T) {
+	bad := protocol.Row{"x": {Value: make(chan int)}}
+	for _, m := range []protocol.Message{
+		{Type: protocol.TypeReload, Data: protocol.Snapshot{Rows: []protocol.Row{bad}}},
+		{Type: protocol.TypeUpdate, Data: protocol.Update{Ops: []protocol.RowOp{{Op: "update", Key: "k", Row: bad}}}},
+		{Type: protocol.TypeUpdate, Data: "not an update"},
+	} {
+		if ev, err := toPBEvent(m); err == nil {
+			t.Errorf("%s %T: got %v, want error", m.Type, m.Data, ev)
+		}
+	}
+}
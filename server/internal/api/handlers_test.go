@@ -0,0 +1,86 @@
+package api
+
+import (
+	"encoding/base64"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/zoravur/postgres-spreadsheet-view/server/internal/common"
+)
+
+// stubCatalog is a richcatalog.Catalog over fixed tables.
+type stubCatalog struct {
+	cols map[string][]string
+	pks  map[string][]string
+}
+
+func (c stubCatalog) Columns(q string) ([]string, bool)     { v, ok := c.cols[q]; return v, ok }
+func (c stubCatalog) PrimaryKeys(q string) ([]string, bool) { v, ok := c.pks[q]; return v, ok }
+
+func TestBuildUpdate(t *testing.T) {
+	cat := stubCatalog{
+		cols: map[string][]string{"public.film": {"film_id", "title", "Rating"}},
+		pks:  map[string][]string{"public.film": {"film_id"}},
+	}
+	handle := common.EncodeHandle("public", "film", []string{"film_id"}, []any{7})
+	raw := func(s string) string { return base64.RawURLEncoding.EncodeToString([]byte(s)) }
+
+	tests := []struct {
+		name     string
+		req      EditRequest
+		wantStmt string
+		wantErr  string
+	}{
+		{
+			name:     "quoted",
+			req:      EditRequest{EditHandle: handle, Column: "title", Value: "x"},
+			wantStmt: `UPDATE "public"."film" SET "title" = $2 WHERE "film_id" = $1`,
+		},
+		{
+			name:     "catalog spelling",
+			req:      EditRequest{EditHandle: handle, Column: "rating", Value: "G"},
+			wantStmt: `UPDATE "public"."film" SET "Rating" = $2 WHERE "film_id" = $1`,
+		},
+		{
+			name:    "injected column",
+			req:     EditRequest{EditHandle: handle, Column: "title = 'x', film_id", Value: "x"},
+			wantErr: "unknown column",
+		},
+		{
+			name:    "injected table",
+			req:     EditRequest{EditHandle: raw("public.film; DROP TABLE film|film_id=7"), Column: "title"},
+			wantErr: "unknown table",
+		},
+		{
+			name:    "injected key column",
+			req:     EditRequest{EditHandle: raw("public.film|film_id=7,1=1 OR title=7"), Column: "title"},
+			wantErr: "not a primary key column",
+		},
+		{
+			name:    "non-key column in handle",
+			req:     EditRequest{EditHandle: raw("public.film|title=x"), Column: "title"},
+			wantErr: "not a primary key column",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stmt, args, err := buildUpdate(cat, tt.req)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("expected error containing %q, got %v (%s)", tt.wantErr, err, stmt)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if stmt != tt.wantStmt {
+				t.Errorf("stmt = %s, want %s", stmt, tt.wantStmt)
+			}
+			if want := []any{"7", tt.req.Value}; !reflect.DeepEqual(args, want) {
+				t.Errorf("args = %v, want %v", args, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,138 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/zoravur/postgres-spreadsheet-view/server/internal/history"
+)
+
+// recordQuery stores one execution in the user's query history. Failures are
+// logged, never surfaced: history must not break the query path.
+func recordQuery(r *http.Request, store *history.Store, source, sqlText string, start time.Time, rowCount int, qerr error) {
+	recordQueryAs(r.Context(), store, userFromRequest(r), source, sqlText, start, rowCount, qerr)
+}
+
+// recordQueryAs is recordQuery for callers without an http.Request.
+func recordQueryAs(ctx context.Context, store *history.Store, user, source, sqlText string, start time.Time, rowCount int, qerr error) {
+	if store == nil {
+		return
+	}
+	e := history.Entry{
+		User:       user,
+		Source:     source,
+		SQL:        sqlText,
+		StartedAt:  start,
+		DurationMS: float64(time.Since(start)) / float64(time.Millisecond),
+	}
+	if qerr != nil {
+		msg := qerr.Error()
+		e.Error = &msg
+	} else if rowCount >= 0 {
+		n := int64(rowCount)
+		e.RowCount = &n
+	}
+
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 2*time.Second)
+	defer cancel()
+	if err := store.Record(ctx, e); err != nil {
+		zap.L().Warn("history_record_failed", zap.String("user", e.User), zap.Error(err))
+	}
+}
+
+// GET /api/history/queries lists the caller's own queries, newest first.
+//
+// Query params:
+//
+//	q              substring search over the SQL text
+//	source         http | ws | sse | grpc
+//	status         ok | error
+//	minDurationMs  only queries at least this slow
+//	since, until   RFC3339 timestamps
+//	limit, offset  paging (limit defaults to 50, max 500)
+func handleQueryHistory(w http.ResponseWriter, r *http.Request, store *history.Store) {
+	if store == nil {
+		http.Error(w, "query history disabled", http.StatusServiceUnavailable)
+		return
+	}
+	qs := r.URL.Query()
+
+	// always the caller's own history: there are no admins to list anyone
+	// else's, and an empty User would list everyone's
+	f := history.Filter{
+		User:   userFromRequest(r),
+		Search: qs.Get("q"),
+		Source: qs.Get("source"),
+	}
+
+	switch qs.Get("status") {
+	case "":
+	case "ok":
+		v := false
+		f.OnlyErrors = &v
+	case "error":
+		v := true
+		f.OnlyErrors = &v
+	default:
+		http.Error(w, "status must be ok or error", http.StatusBadRequest)
+		return
+	}
+
+	var err error
+	if v := qs.Get("minDurationMs"); v != "" {
+		ms, perr := strconv.ParseFloat(v, 64)
+		if perr != nil {
+			http.Error(w, "invalid minDurationMs", http.StatusBadRequest)
+			return
+		}
+		f.MinDuration = time.Duration(ms * float64(time.Millisecond))
+	}
+	if f.Since, err = parseTimeParam(qs.Get("since")); err != nil {
+		http.Error(w, "invalid since: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if f.Until, err = parseTimeParam(qs.Get("until")); err != nil {
+		http.Error(w, "invalid until: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if f.Limit, err = parseIntParam(qs.Get("limit")); err != nil {
+		http.Error(w, "invalid limit", http.StatusBadRequest)
+		return
+	}
+	if f.Offset, err = parseIntParam(qs.Get("offset")); err != nil {
+		http.Error(w, "invalid offset", http.StatusBadRequest)
+		return
+	}
+
+	entries, err := store.List(r.Context(), f)
+	if err != nil {
+		http.Error(w, "history lookup failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(entries)
+}
+
+func parseTimeParam(v string) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, v)
+}
+
+func parseIntParam(v string) (int, error) {
+	if v == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err == nil && n < 0 {
+		return 0, strconv.ErrRange
+	}
+	return n, err
+}
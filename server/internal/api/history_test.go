@@ -0,0 +1,46 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/zoravur/postgres-spreadsheet-view/server/internal/history"
+	"github.com/zoravur/postgres-spreadsheet-view/server/internal/sqlfake"
+)
+
+// The history listing is the caller's own, whatever user it asks for.
+func TestQueryHistoryIsTheCallers(t *testing.T) {
+	var users []any
+	db, _ := sqlfake.Open(func(_ context.Context, c sqlfake.Call) (sqlfake.Result, error) {
+		if len(c.Args) > 0 {
+			users = append(users, c.Args[0])
+		}
+		return sqlfake.Result{
+			Columns: []string{"id", "user_name", "source", "sql", "started_at", "duration_ms", "row_count", "error"},
+			Rows:    [][]any{{int64(1), "ana", history.SourceHTTP, "SELECT 1", time.Now(), 1.0, nil, nil}},
+		}, nil
+	})
+	store := history.NewStore(db)
+
+	for _, q := range []string{"", "?user=*", "?user=bo"} {
+		users = nil
+		req := httptest.NewRequest(http.MethodGet, "/api/history/queries"+q, nil)
+		req.Header.Set("X-User", "ana")
+		rec := httptest.NewRecorder()
+		handleQueryHistory(rec, req, store)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%q: status %d: %s", q, rec.Code, rec.Body)
+		}
+		if len(users) == 0 || users[0] != "ana" {
+			t.Errorf("%q: listed for %v, want ana", q, users)
+		}
+		var got []history.Entry
+		if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil || len(got) != 1 {
+			t.Errorf("%q: body %s (%v)", q, rec.Body, err)
+		}
+	}
+}
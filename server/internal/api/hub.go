@@ -0,0 +1,44 @@
+package api
+
+import (
+	"sync"
+
+	"go.uber.org/zap"
+
+	"github.com/zoravur/postgres-spreadsheet-view/server/internal/protocol"
+	"github.com/zoravur/postgres-spreadsheet-view/server/internal/reactive"
+)
+
+// Hub tracks every open WebSocket so server-wide events (e.g. catalog changes)
+// reach clients regardless of which live queries they are subscribed to.
+type Hub struct {
+	mu      sync.RWMutex
+	clients map[*reactive.Client]struct{}
+}
+
+func NewHub() *Hub {
+	return &Hub{clients: make(map[*reactive.Client]struct{})}
+}
+
+func (h *Hub) Add(cl *reactive.Client) {
+	h.mu.Lock()
+	h.clients[cl] = struct{}{}
+	h.mu.Unlock()
+}
+
+func (h *Hub) Remove(cl *reactive.Client) {
+	h.mu.Lock()
+	delete(h.clients, cl)
+	h.mu.Unlock()
+}
+
+// Broadcast sends msgType/payload to every connected client.
+func (h *Hub) Broadcast(msgType protocol.Type, payload any) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for cl := range h.clients {
+		if err := cl.Send(msgType, payload); err != nil {
+			zap.L().Warn("hub_send_failed", zap.String("type", string(msgType)), zap.Error(err))
+		}
+	}
+}
@@ -23,6 +23,7 @@ func LoggingMiddleware(next http.Handler) http.Handler {
 
 		logger := zap.L().With(
 			zap.String("trace_id", traceID),
+			zap.String("user", userFromRequest(r)),
 			zap.String("method", r.Method),
 			zap.String("path", r.URL.Path),
 		)
@@ -50,3 +51,15 @@ func (w *statusWriter) WriteHeader(code int) {
 	w.status = code
 	w.ResponseWriter.WriteHeader(code)
 }
+
+// userFromRequest identifies the caller. There is no auth yet, so we trust the
+// X-User header, or ?user= for WebSocket upgrades (browsers can't set headers there).
+func userFromRequest(r *http.Request) string {
+	if u := r.Header.Get("X-User"); u != "" {
+		return u
+	}
+	if u := r.URL.Query().Get("user"); u != "" {
+		return u
+	}
+	return "anonymous"
+}
@@ -0,0 +1,327 @@
+package api
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	"go.uber.org/zap"
+
+	"github.com/zoravur/postgres-spreadsheet-view/server/internal/metrics"
+	"github.com/zoravur/postgres-spreadsheet-view/server/internal/protocol"
+	"github.com/zoravur/postgres-spreadsheet-view/server/internal/reactive"
+)
+
+var (
+	mWSConnections    = metrics.NewGauge("ws_connections")
+	mWSQueued         = metrics.NewGauge("ws_outbound_queued")
+	mWSSent           = metrics.NewCounter("ws_outbound_sent_total")
+	mWSDropped        = metrics.NewCounter("ws_outbound_dropped_total")
+	mWSOverflows      = metrics.NewCounter("ws_outbound_overflows_total")
+	mWSResyncs        = metrics.NewCounter("ws_resyncs_total")
+	mWSSlowDisconnect = metrics.NewCounter("ws_slow_client_disconnects_total")
+)
+
+// OverflowPolicy is what happens when a client's send queue is full.
+type OverflowPolicy string
+
+const (
+	// OverflowResync drops the message and every later one for the same live
+	// query, then sends that query a fresh "reload" once the queue drains.
+	// Messages not tied to a live query can't be resynced and disconnect.
+	OverflowResync OverflowPolicy = "resync"
+	// OverflowDisconnect closes the connection; the client reconnects and
+	// resubscribes.
+	OverflowDisconnect OverflowPolicy = "disconnect"
+)
+
+type OutboundOptions struct {
+	QueueSize    int            // messages waiting for the writer
+	Overflow     OverflowPolicy // default OverflowResync
+	WriteTimeout time.Duration  // per message; 0 means none
+}
+
+var errSlowClient = errors.New("client send queue full")
+var errConnClosed = errors.New("connection closed")
+
+// subscription is one live query subscription on a connection. Each has its
+// own reactive.Client, so a connection can hold several, even two of the same
+// query, and every message a subscription gets is tagged with its ID.
+type subscription struct {
+	id     string
+	reqID  string              // of the subscribe, repeated in "subscribed"
+	peer   string              // identifies it in presence messages to others
+	q      *reactive.LiveQuery // set once acquired
+	client *reactive.Client
+}
+
+// wsConn is the part of a *websocket.Conn the writer uses.
+type wsConn interface {
+	WriteJSON(v any) error
+	SetWriteDeadline(t time.Time) error
+	Close() error
+}
+
+// outbound is a websocket's single writer. gorilla/websocket allows one
+// concurrent writer, and refreshes broadcast from many goroutines while
+// holding the live query's lock, so sends only enqueue and never block.
+type outbound struct {
+	conn   wsConn
+	opt    OutboundOptions
+	client *reactive.Client
+	queue  chan protocol.Message
+	done   chan struct{}
+	once   sync.Once
+
+	mu    sync.Mutex
+	hello protocol.Hello                        // negotiated; no capabilities until the handshake
+	stale map[*subscription]*reactive.LiveQuery // dropped an update; waiting for a "reload"
+	// edits maps the xids of this connection's recent edits to their request
+	// IDs, oldest first in editOrder, so updates they cause can be tagged.
+	edits     map[int64]string
+	editOrder []int64
+
+	resyncing atomic.Bool
+}
+
+func newOutbound(conn wsConn, opt OutboundOptions) *outbound {
+	if opt.QueueSize <= 0 {
+		opt.QueueSize = 256
+	}
+	if opt.Overflow == "" {
+		opt.Overflow = OverflowResync
+	}
+	o := &outbound{
+		conn:  conn,
+		opt:   opt,
+		queue: make(chan protocol.Message, opt.QueueSize),
+		done:  make(chan struct{}),
+		stale: map[*subscription]*reactive.LiveQuery{},
+		edits: map[int64]string{},
+	}
+	o.client = &reactive.Client{
+		Send: func(msgType protocol.Type, payload any) error {
+			return o.enqueue(nil, nil, "", msgType, payload)
+		},
+	}
+	mWSConnections.Add(1)
+	go o.run()
+	return o
+}
+
+// setHello records the capabilities negotiated in the handshake.
+func (o *outbound) setHello(h protocol.Hello) {
+	o.mu.Lock()
+	o.hello = h
+	o.mu.Unlock()
+}
+
+// subscribe returns a new subscription whose client sends through o. Its
+// "subscribed" carries reqID.
+func (o *outbound) subscribe(id, reqID string) *subscription {
+	s := &subscription{id: id, reqID: reqID, peer: uuid.NewString()}
+	s.client = &reactive.Client{
+		Send: func(msgType protocol.Type, payload any) error {
+			return o.enqueue(s, nil, "", msgType, payload)
+		},
+		SendQuery: func(q *reactive.LiveQuery, msgType protocol.Type, payload any) error {
+			return o.enqueue(s, q, "", msgType, payload)
+		},
+	}
+	return s
+}
+
+// reply answers request reqID, tagged with subscription s if it has one.
+func (o *outbound) reply(s *subscription, reqID string, msgType protocol.Type, payload any) {
+	_ = o.enqueue(s, nil, reqID, msgType, payload)
+}
+
+// fail answers request reqID with an "error".
+func (o *outbound) fail(s *subscription, reqID string, err error) {
+	o.reply(s, reqID, protocol.TypeError, classifyError(err, protocol.CodeInternal))
+}
+
+// enqueue queues one message for subscription s (nil for connection-wide
+// messages) without blocking; see OverflowPolicy for a full queue. Messages
+// about live query q can be dropped and resynced. Messages of a capability the
+// connection didn't negotiate are dropped.
+func (o *outbound) enqueue(s *subscription, q *reactive.LiveQuery, reqID string, msgType protocol.Type, payload any) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	select {
+	case <-o.done:
+		return errConnClosed
+	default:
+	}
+	if c := protocol.RequiredCapability(msgType); c != "" && !o.hello.Has(c) {
+		return nil
+	}
+	resyncable := s != nil && q != nil
+	if resyncable && o.stale[s] != nil && msgType != protocol.TypeReload {
+		// the client is missing earlier updates to q; this one can't apply
+		mWSDropped.Inc()
+		return nil
+	}
+
+	if u, ok := payload.(reactive.Update); ok {
+		payload = o.tagOrigin(u)
+	}
+	m := protocol.Message{Type: msgType, RequestID: reqID, Data: payload}
+	if s != nil {
+		m.Sub = s.id
+		if msgType == protocol.TypeSubscribed {
+			m.RequestID = s.reqID
+		}
+	}
+	select {
+	case o.queue <- m:
+		mWSQueued.Add(1)
+		if resyncable && msgType == protocol.TypeReload {
+			delete(o.stale, s) // a reload replaces whatever the client missed
+		}
+		return nil
+	default:
+	}
+
+	mWSOverflows.Inc()
+	if !resyncable || o.opt.Overflow == OverflowDisconnect {
+		zap.L().Warn("ws_slow_client_disconnect", zap.String("type", string(msgType)), zap.Int("queue", o.opt.QueueSize))
+		mWSSlowDisconnect.Inc()
+		o.close()
+		return errSlowClient
+	}
+	if o.stale[s] == nil {
+		zap.L().Warn("ws_slow_client_resync", zap.String("sub", s.id),
+			zap.String("live_query_id", q.ID), zap.Int("queue", o.opt.QueueSize))
+	}
+	o.stale[s] = q
+	mWSDropped.Inc()
+	return nil
+}
+
+// maxEdits is how many recent edits per connection are remembered for
+// tagging; an edit's update normally arrives within a few commits of its ack.
+const maxEdits = 256
+
+// markEdit records that transaction xid is the edit with requestID, before it
+// commits, so the update it causes can't arrive untagged.
+func (o *outbound) markEdit(xid int64, requestID string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if _, ok := o.edits[xid]; !ok {
+		o.editOrder = append(o.editOrder, xid)
+	}
+	o.edits[xid] = requestID
+	for len(o.editOrder) > maxEdits {
+		delete(o.edits, o.editOrder[0])
+		o.editOrder = o.editOrder[1:]
+	}
+}
+
+// unmarkEdit forgets an edit whose transaction didn't commit.
+func (o *outbound) unmarkEdit(xid int64) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	delete(o.edits, xid)
+	for i, x := range o.editOrder {
+		if x == xid {
+			o.editOrder = append(o.editOrder[:i], o.editOrder[i+1:]...)
+			break
+		}
+	}
+}
+
+// tagOrigin sets u.Origin to the request IDs of this connection's edits among
+// the transactions u covers. u is shared by every subscriber, so it is copied
+// rather than modified. Called with mu held.
+func (o *outbound) tagOrigin(u reactive.Update) reactive.Update {
+	var origin []string
+	for _, xid := range u.XIDs {
+		if id, ok := o.edits[xid]; ok {
+			origin = append(origin, id)
+		}
+	}
+	if len(origin) > 0 {
+		u.Origin = origin
+	}
+	return u
+}
+
+// forget drops a pending resync for a subscription that ended.
+func (o *outbound) forget(s *subscription) {
+	o.mu.Lock()
+	delete(o.stale, s)
+	o.mu.Unlock()
+}
+
+// run writes queued messages until the connection fails or is closed.
+func (o *outbound) run() {
+	defer func() {
+		o.close()
+		// nothing is enqueued once done is closed (checked under mu)
+		o.mu.Lock()
+		for len(o.queue) > 0 {
+			<-o.queue
+			mWSQueued.Add(-1)
+		}
+		o.mu.Unlock()
+		mWSConnections.Add(-1)
+	}()
+
+	for {
+		select {
+		case <-o.done:
+			return
+		case m := <-o.queue:
+			mWSQueued.Add(-1)
+			if o.opt.WriteTimeout > 0 {
+				_ = o.conn.SetWriteDeadline(time.Now().Add(o.opt.WriteTimeout))
+			}
+			if err := o.conn.WriteJSON(m); err != nil {
+				zap.L().Warn("ws_write_failed", zap.String("type", string(m.Type)), zap.Error(err))
+				return
+			}
+			mWSSent.Inc()
+		}
+		if len(o.queue) == 0 {
+			o.resyncStale()
+		}
+	}
+}
+
+// resyncStale asks every subscription the client fell behind on for a
+// "reload". It runs off the writer goroutine: Resync waits for any refresh in
+// progress.
+func (o *outbound) resyncStale() {
+	o.mu.Lock()
+	stale := make(map[*subscription]*reactive.LiveQuery, len(o.stale))
+	for s, q := range o.stale {
+		stale[s] = q
+	}
+	o.mu.Unlock()
+	if len(stale) == 0 || !o.resyncing.CompareAndSwap(false, true) {
+		return
+	}
+
+	go func() {
+		defer o.resyncing.Store(false)
+		for s, q := range stale {
+			mWSResyncs.Inc()
+			if err := q.Resync(s.client); err != nil {
+				zap.L().Warn("ws_resync_failed", zap.String("sub", s.id), zap.String("live_query_id", q.ID), zap.Error(err))
+				return
+			}
+		}
+	}()
+}
+
+// close stops the writer and closes the socket, which also ends the read loop.
+func (o *outbound) close() {
+	o.once.Do(func() {
+		close(o.done)
+		_ = o.conn.Close()
+	})
+}
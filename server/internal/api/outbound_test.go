@@ -0,0 +1,343 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zoravur/postgres-spreadsheet-view/server/internal/protocol"
+	"github.com/zoravur/postgres-spreadsheet-view/server/internal/reactive"
+	"github.com/zoravur/postgres-spreadsheet-view/server/internal/sqlfake"
+)
+
+// slowConn is a websocket that takes as long to write as the test wants:
+// each write waits for gate to be closed (when set), honouring the write
+// deadline the way a socket does.
+type slowConn struct {
+	gate    chan struct{}
+	writing chan struct{} // one send per write started
+	closed  chan struct{}
+
+	mu        sync.Mutex
+	deadline  time.Time
+	deadlines int
+	inFlight  int
+	maxIn     int
+	written   []protocol.Message
+	closeOnce sync.Once
+}
+
+func newSlowConn(gated bool) *slowConn {
+	c := &slowConn{writing: make(chan struct{}, 1024), closed: make(chan struct{})}
+	if gated {
+		c.gate = make(chan struct{})
+	}
+	return c
+}
+
+var errTimeout = errors.New("i/o timeout")
+
+func (c *slowConn) WriteJSON(v any) error {
+	c.mu.Lock()
+	c.inFlight++
+	c.maxIn = max(c.maxIn, c.inFlight)
+	deadline := c.deadline
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		c.inFlight--
+		c.mu.Unlock()
+	}()
+	c.writing <- struct{}{}
+
+	var expired <-chan time.Time
+	if !deadline.IsZero() {
+		t := time.NewTimer(time.Until(deadline))
+		defer t.Stop()
+		expired = t.C
+	}
+	if c.gate != nil {
+		select {
+		case <-c.gate:
+		case <-expired:
+			return errTimeout
+		case <-c.closed:
+			return errConnClosed
+		}
+	}
+	time.Sleep(time.Millisecond) // long enough for a second writer to overlap
+	c.mu.Lock()
+	c.written = append(c.written, v.(protocol.Message))
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *slowConn) SetWriteDeadline(t time.Time) error {
+	c.mu.Lock()
+	c.deadline = t
+	c.deadlines++
+	c.mu.Unlock()
+	return nil
+}
+
+func (c *slowConn) Close() error {
+	c.closeOnce.Do(func() { close(c.closed) })
+	return nil
+}
+
+func (c *slowConn) messages() []protocol.Message {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]protocol.Message(nil), c.written...)
+}
+
+// waitWriting waits for the writer to start a write.
+func (c *slowConn) waitWriting(t *testing.T) {
+	t.Helper()
+	select {
+	case <-c.writing:
+	case <-time.After(2 * time.Second):
+		t.Fatal("writer never wrote")
+	}
+}
+
+func (c *slowConn) waitClosed(t *testing.T) {
+	t.Helper()
+	select {
+	case <-c.closed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("connection not closed")
+	}
+}
+
+// waitFor waits until the written messages satisfy ok.
+func (c *slowConn) waitFor(t *testing.T, ok func([]protocol.Message) bool) []protocol.Message {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		got := c.messages()
+		if ok(got) {
+			return got
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("wrote %s", describe(got))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// describe renders messages as "update 1, reload": the type and, for
+// updates, the seq.
+func describe(ms []protocol.Message) string {
+	parts := make([]string, len(ms))
+	for i, m := range ms {
+		parts[i] = string(m.Type)
+		if u, ok := m.Data.(reactive.Update); ok {
+			parts[i] += fmt.Sprintf(" %d", u.Seq)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// loadedQuery is a live query with an empty materialized result, so it can
+// answer Resync, subscribed to by s.
+func loadedQuery(t *testing.T, s *subscription) *reactive.LiveQuery {
+	t.Helper()
+	db, _ := sqlfake.Open(func(_ context.Context, c sqlfake.Call) (sqlfake.Result, error) {
+		if strings.Contains(c.Query, "pg_current_snapshot") {
+			return sqlfake.Result{Columns: []string{"snap", "lsn"}, Rows: [][]any{{"1:1:", "0/1"}}}, nil
+		}
+		return sqlfake.Result{}, nil
+	})
+	q := &reactive.LiveQuery{ID: "q1", Analysis: reactive.Analysis{Rewritten: "SELECT 1"}}
+	if err := q.Load(db); err != nil {
+		t.Fatal(err)
+	}
+	q.Clients = map[*reactive.Client]struct{}{s.client: {}}
+	return q
+}
+
+// Sends from many goroutines reach the socket one write at a time, each
+// sender's in order.
+func TestOutboundSingleWriter(t *testing.T) {
+	conn := newSlowConn(false)
+	o := newOutbound(conn, OutboundOptions{QueueSize: 1024})
+	defer o.close()
+
+	const senders, each = 8, 20
+	var wg sync.WaitGroup
+	for i := 0; i < senders; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for n := 0; n < each; n++ {
+				o.reply(nil, fmt.Sprintf("%d/%d", i, n), protocol.TypePong, nil)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	got := conn.waitFor(t, func(ms []protocol.Message) bool { return len(ms) == senders*each })
+	next := make([]int, senders)
+	for _, m := range got {
+		var i, n int
+		if _, err := fmt.Sscanf(m.RequestID, "%d/%d", &i, &n); err != nil {
+			t.Fatal(err)
+		}
+		if n != next[i] {
+			t.Fatalf("sender %d: got %d, want %d", i, n, next[i])
+		}
+		next[i]++
+	}
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	if conn.maxIn != 1 {
+		t.Errorf("%d concurrent writes, want 1", conn.maxIn)
+	}
+}
+
+// A full queue drops the subscription's updates until the writer catches
+// up, then sends it a "reload" instead of them.
+func TestOutboundOverflowResync(t *testing.T) {
+	conn := newSlowConn(true)
+	o := newOutbound(conn, OutboundOptions{QueueSize: 2, Overflow: OverflowResync})
+	defer o.close()
+	s := o.subscribe("s1", "r1")
+	q := loadedQuery(t, s)
+
+	update := func(seq uint64) {
+		if err := s.client.SendFor(q, protocol.TypeUpdate, reactive.Update{ID: q.ID, Seq: seq}); err != nil {
+			t.Fatalf("update %d: %v", seq, err)
+		}
+	}
+	update(1)
+	conn.waitWriting(t) // the writer holds 1
+	update(2)
+	update(3) // the queue is full
+	update(4) // overflows: s is stale
+	update(5) // dropped: it can't apply without 4
+
+	close(conn.gate)
+	got := conn.waitFor(t, func(ms []protocol.Message) bool {
+		return len(ms) > 0 && ms[len(ms)-1].Type == protocol.TypeReload
+	})
+	if d := describe(got); d != "update 1, update 2, update 3, reload" {
+		t.Errorf("wrote %s", d)
+	}
+	if got[3].Sub != "s1" {
+		t.Errorf("reload for sub %q, want s1", got[3].Sub)
+	}
+
+	// caught up: updates flow again
+	update(6)
+	got = conn.waitFor(t, func(ms []protocol.Message) bool { return len(ms) == 5 })
+	if d := describe(got[4:]); d != "update 6" {
+		t.Errorf("after the reload wrote %s", d)
+	}
+	select {
+	case <-conn.closed:
+		t.Error("resync closed the connection")
+	default:
+	}
+}
+
+func TestOutboundOverflowDisconnect(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy OverflowPolicy
+		send   func(o *outbound, s *subscription, q *reactive.LiveQuery) error
+	}{
+		{
+			name:   "disconnect policy",
+			policy: OverflowDisconnect,
+			send: func(o *outbound, s *subscription, q *reactive.LiveQuery) error {
+				return s.client.SendFor(q, protocol.TypeUpdate, reactive.Update{ID: q.ID})
+			},
+		},
+		{
+			// not about a live query, so there's nothing to resync it with
+			name:   "connection-wide message",
+			policy: OverflowResync,
+			send: func(o *outbound, s *subscription, q *reactive.LiveQuery) error {
+				return o.client.Send(protocol.TypePong, nil)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := newSlowConn(true)
+			o := newOutbound(conn, OutboundOptions{QueueSize: 1, Overflow: tt.policy})
+			defer o.close()
+			s := o.subscribe("s1", "r1")
+			q := loadedQuery(t, s)
+
+			if err := tt.send(o, s, q); err != nil {
+				t.Fatal(err)
+			}
+			conn.waitWriting(t)
+			if err := tt.send(o, s, q); err != nil {
+				t.Fatal(err) // queued
+			}
+			if err := tt.send(o, s, q); !errors.Is(err, errSlowClient) {
+				t.Fatalf("overflow: err = %v, want errSlowClient", err)
+			}
+			conn.waitClosed(t)
+			if err := tt.send(o, s, q); !errors.Is(err, errConnClosed) {
+				t.Errorf("after close: err = %v, want errConnClosed", err)
+			}
+		})
+	}
+}
+
+// A write that outlasts WriteTimeout fails and closes the connection.
+func TestOutboundWriteTimeout(t *testing.T) {
+	conn := newSlowConn(true) // never opened
+	o := newOutbound(conn, OutboundOptions{WriteTimeout: 20 * time.Millisecond})
+	defer o.close()
+
+	start := time.Now()
+	o.reply(nil, "r1", protocol.TypePong, nil)
+	conn.waitClosed(t)
+	if waited := time.Since(start); waited < 20*time.Millisecond {
+		t.Errorf("closed after %v, before the timeout", waited)
+	}
+	conn.mu.Lock()
+	deadlines, written := conn.deadlines, len(conn.written)
+	conn.mu.Unlock()
+	if deadlines != 1 || written != 0 {
+		t.Errorf("%d deadlines set, %d messages written; want 1, 0", deadlines, written)
+	}
+	if err := o.client.Send(protocol.TypePong, nil); !errors.Is(err, errConnClosed) {
+		t.Errorf("after the timeout: err = %v, want errConnClosed", err)
+	}
+}
+
+// Without WriteTimeout no deadline is set, so a slow write just waits.
+func TestOutboundNoWriteTimeout(t *testing.T) {
+	conn := newSlowConn(true)
+	o := newOutbound(conn, OutboundOptions{})
+	defer o.close()
+
+	o.reply(nil, "r1", protocol.TypePong, nil)
+	conn.waitWriting(t)
+	time.Sleep(30 * time.Millisecond)
+	select {
+	case <-conn.closed:
+		t.Fatal("closed without a write timeout")
+	default:
+	}
+	close(conn.gate)
+	got := conn.waitFor(t, func(ms []protocol.Message) bool { return len(ms) == 1 })
+	if got[0].RequestID != "r1" {
+		t.Errorf("wrote %+v", got[0])
+	}
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	if conn.deadlines != 0 {
+		t.Errorf("%d deadlines set", conn.deadlines)
+	}
+}
@@ -5,26 +5,69 @@ import (
 	"net/http"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/zoravur/postgres-spreadsheet-view/server/internal/comments"
+	"github.com/zoravur/postgres-spreadsheet-view/server/internal/history"
+	"github.com/zoravur/postgres-spreadsheet-view/server/internal/locks"
+	"github.com/zoravur/postgres-spreadsheet-view/server/internal/metrics"
 	"github.com/zoravur/postgres-spreadsheet-view/server/internal/reactive"
+	"github.com/zoravur/postgres-spreadsheet-view/server/pkg/richcatalog"
 )
 
-func SetupRoutes(reg *reactive.Registry, db *sql.DB) http.Handler {
+// Deps bundles the shared resources injected from app.Server.
+type Deps struct {
+	DB       *sql.DB
+	Registry *reactive.Registry
+	History  *history.Store
+	Catalog  *richcatalog.DBCatalog // long-lived, auto-refreshed by app.Server
+	Hub      *Hub
+	Outbound OutboundOptions // per-websocket send queue
+	Locks    *locks.Service  // cell leases; nil disables locking
+	Comments *comments.Store // cell and row threads; nil disables them
+}
+
+func SetupRoutes(deps Deps) http.Handler {
 	r := chi.NewRouter()
 
 	// --- WebSocket routes: NO middleware allowed ---
-	wsHandler := &WSHandler{DB: db, Registry: reg}
+	wsHandler := newWSHandler(deps)
 	r.Get("/api/ws", wsHandler.HandleWS)
+	// Server-Sent Events share the WebSocket handler's live queries, and need
+	// the unwrapped ResponseWriter to flush
+	r.Get("/api/live/stream", wsHandler.HandleSSE)
 
 	// --- All other routes grouped with middleware ---
 	r.Group(func(r chi.Router) {
 		r.Use(LoggingMiddleware)
 
 		r.Route("/api", func(r chi.Router) {
-			r.Post("/query", handleEditableQuery)
-			r.Post("/edit", handleEdit)
+			r.Post("/query", func(w http.ResponseWriter, req *http.Request) {
+				handleEditableQuery(w, req, deps)
+			})
+			r.Post("/edit", func(w http.ResponseWriter, req *http.Request) {
+				handleEdit(w, req, deps)
+			})
 			r.Get("/live", func(w http.ResponseWriter, req *http.Request) {
-				handleLiveQueries(w, req, reg)
+				handleLiveQueries(w, req, deps.Registry)
+			})
+			r.Get("/catalog", func(w http.ResponseWriter, req *http.Request) {
+				handleCatalog(w, req, deps.Catalog)
+			})
+			r.Get("/catalog/diff", func(w http.ResponseWriter, req *http.Request) {
+				handleCatalogDiff(w, req, deps.Catalog)
 			})
+			r.Post("/schema/changes", func(w http.ResponseWriter, req *http.Request) {
+				handleSchemaChanges(w, req, deps)
+			})
+			r.Get("/history/queries", func(w http.ResponseWriter, req *http.Request) {
+				handleQueryHistory(w, req, deps.History)
+			})
+			r.Get("/comments", func(w http.ResponseWriter, req *http.Request) {
+				handleListComments(w, req, deps.Comments)
+			})
+			r.Post("/comments", func(w http.ResponseWriter, req *http.Request) {
+				handleAddComment(w, req, deps)
+			})
+			r.Get("/metrics", metrics.Handler)
 		})
 	})
 
@@ -34,3 +77,7 @@ func SetupRoutes(reg *reactive.Registry, db *sql.DB) http.Handler {
 
 	return r
 }
+
+func newWSHandler(deps Deps) *WSHandler {
+	return &WSHandler{DB: deps.DB, Registry: deps.Registry, History: deps.History, Hub: deps.Hub, Catalog: deps.Catalog, Outbound: deps.Outbound, Locks: deps.Locks, Comments: deps.Comments}
+}
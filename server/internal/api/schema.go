@@ -0,0 +1,140 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"go.uber.org/zap"
+
+	"github.com/zoravur/postgres-spreadsheet-view/server/pkg/ddl"
+)
+
+type SchemaChangeRequest struct {
+	Changes []ddl.Change `json:"changes"`
+	DryRun  bool         `json:"dryRun"`
+	// Checksum, when set, must match the current catalog checksum; protects
+	// against applying changes planned against a stale schema view.
+	Checksum string `json:"checksum,omitempty"`
+	// AllowDestructive must be set to apply changes that drop tables or
+	// columns or cascade (see ddl.Change.Destructive). Dry runs don't need it.
+	AllowDestructive bool `json:"allowDestructive,omitempty"`
+}
+
+type SchemaChangeResponse struct {
+	Statements []string `json:"statements"`
+	Applied    bool     `json:"applied"`
+	Checksum   string   `json:"checksum"` // catalog checksum after the change (before, for dry runs)
+}
+
+// schemaChangeLock serializes schema changes made through the API, so two
+// requests planned against the same checksum can't both apply.
+const schemaChangeLock = `SELECT pg_advisory_xact_lock(hashtext('psv.schema_changes'))`
+
+// POST /api/schema/changes
+//
+// Validates the structured changes against the catalog and returns the generated
+// DDL. Unless dryRun is set, the DDL runs in a single transaction and the catalog
+// is refreshed afterwards (which also pushes catalog_changed to clients).
+//
+// The schema is read, checked against checksum and changed in that one
+// transaction, under schemaChangeLock, so nothing the API applies meanwhile
+// can slip in between the check and the DDL.
+func handleSchemaChanges(w http.ResponseWriter, r *http.Request, deps Deps) {
+	if deps.Catalog == nil {
+		http.Error(w, "catalog unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	var req SchemaChangeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON body", http.StatusBadRequest)
+		return
+	}
+	if len(req.Changes) == 0 {
+		http.Error(w, "no changes", http.StatusBadRequest)
+		return
+	}
+	if !req.DryRun && !req.AllowDestructive {
+		for i, ch := range req.Changes {
+			if ch.Destructive() {
+				http.Error(w, fmt.Sprintf("change %d (%s) can lose data; set allowDestructive to apply it", i, ch.Op), http.StatusUnprocessableEntity)
+				return
+			}
+		}
+	}
+
+	ctx := r.Context()
+	tx, err := deps.DB.BeginTx(ctx, nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, schemaChangeLock); err != nil {
+		http.Error(w, "schema lock failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	// Validate against the schema as this transaction sees it.
+	snap, err := deps.Catalog.Introspect(ctx, tx)
+	if err != nil {
+		http.Error(w, "catalog load failed: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if req.Checksum != "" && req.Checksum != snap.Checksum {
+		http.Error(w, "schema changed since checksum "+req.Checksum, http.StatusConflict)
+		return
+	}
+
+	stmts, err := ddl.Plan(snap, req.Changes)
+	if err != nil {
+		var ve *ddl.ValidationError
+		if errors.As(err, &ve) {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	resp := SchemaChangeResponse{Statements: stmts, Checksum: snap.Checksum}
+	if !req.DryRun {
+		if err := applyDDL(r, deps, tx, stmts); err != nil {
+			http.Error(w, "apply failed: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		resp.Applied = true
+		resp.Checksum = deps.Catalog.Checksum()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// applyDDL runs stmts in tx and commits it.
+func applyDDL(r *http.Request, deps Deps, tx *sql.Tx, stmts []string) error {
+	ctx := r.Context()
+	for _, stmt := range stmts {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	zap.L().Info("schema_changed_via_api",
+		zap.String("user", userFromRequest(r)),
+		zap.Strings("statements", stmts),
+	)
+
+	// DDL is committed; a failed refresh only delays the new snapshot until the
+	// next background poll, so don't report the change as failed.
+	if err := deps.Catalog.Refresh(ctx); err != nil {
+		zap.L().Warn("catalog_refresh_after_ddl_failed", zap.Error(err))
+	}
+	return nil
+}
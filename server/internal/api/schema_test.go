@@ -0,0 +1,175 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/zoravur/postgres-spreadsheet-view/server/internal/sqlfake"
+	"github.com/zoravur/postgres-spreadsheet-view/server/pkg/richcatalog"
+)
+
+// fakeSchema is a database holding public.film, answering the catalog's
+// introspection query with its columns and primary key. Other statements
+// succeed without effect; the test reads them from the log.
+type fakeSchema struct {
+	mu   sync.Mutex
+	cols []string
+}
+
+func (s *fakeSchema) addColumn(name string) {
+	s.mu.Lock()
+	s.cols = append(s.cols, name)
+	s.mu.Unlock()
+}
+
+func (s *fakeSchema) open(t *testing.T) (Deps, *sqlfake.DB) {
+	t.Helper()
+	db, fake := sqlfake.Open(func(_ context.Context, c sqlfake.Call) (sqlfake.Result, error) {
+		if !strings.Contains(c.Query, "WITH schemas AS") {
+			return sqlfake.Result{}, nil
+		}
+		res := sqlfake.Result{Columns: make([]string, 17)}
+		s.mu.Lock()
+		for i, col := range s.cols {
+			row := make([]any, 17)
+			row[0], row[1], row[2] = "COL", "public", "film"
+			row[3], row[4], row[5], row[6] = int64(i+1), col, "text", false
+			res.Rows = append(res.Rows, row)
+		}
+		s.mu.Unlock()
+		pk := make([]any, 17)
+		pk[0], pk[1], pk[2] = "IDX", "public", "film"
+		pk[8], pk[9], pk[10], pk[11] = "film_pkey", true, true, "{film_id}"
+		res.Rows = append(res.Rows, pk)
+		return res, nil
+	})
+	cat, err := richcatalog.New(db, richcatalog.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cat.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return Deps{DB: db, Catalog: cat}, fake
+}
+
+func postSchemaChanges(deps Deps, body string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/api/schema/changes", strings.NewReader(body))
+	handleSchemaChanges(w, r, deps)
+	return w
+}
+
+// ddlLog is the log without the introspection query's text.
+func ddlLog(fake *sqlfake.DB) []string {
+	var out []string
+	for _, l := range fake.Log() {
+		if i := strings.Index(l, "WITH schemas AS"); i >= 0 {
+			l = l[:i] + "<introspect>"
+		}
+		out = append(out, l)
+	}
+	return out
+}
+
+// The checksum is checked against the schema read inside the DDL
+// transaction, after taking the lock, not against the cached catalog.
+func TestSchemaChangesChecksumInTx(t *testing.T) {
+	s := &fakeSchema{cols: []string{"film_id", "title"}}
+	deps, fake := s.open(t)
+	checksum := deps.Catalog.Checksum()
+
+	w := postSchemaChanges(deps, `{"checksum":"`+checksum+`","changes":[{"op":"add_column","table":"film","column":"notes","type":"text"}]}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	var resp SchemaChangeResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if !resp.Applied {
+		t.Error("not applied")
+	}
+	want := []string{
+		"<introspect>", // the initial Refresh
+		"tx1 begin",
+		"tx1 " + schemaChangeLock,
+		"tx1 <introspect>",
+		`tx1 ALTER TABLE "public"."film" ADD COLUMN "notes" text`,
+		"tx1 commit",
+		"<introspect>", // the refresh after it
+	}
+	if got := ddlLog(fake); !reflect.DeepEqual(got, want) {
+		t.Errorf("ran\n%q\nwant\n%q", got, want)
+	}
+
+	// the schema changes behind the cached catalog's back
+	s.addColumn("rating")
+	before := len(fake.Log())
+	w = postSchemaChanges(deps, `{"checksum":"`+deps.Catalog.Checksum()+`","changes":[{"op":"add_column","table":"film","column":"notes2","type":"text"}]}`)
+	if w.Code != http.StatusConflict {
+		t.Fatalf("stale checksum: status %d, want 409: %s", w.Code, w.Body)
+	}
+	want = []string{"tx2 begin", "tx2 " + schemaChangeLock, "tx2 <introspect>", "tx2 rollback"}
+	if got := ddlLog(fake)[before:]; !reflect.DeepEqual(got, want) {
+		t.Errorf("stale checksum ran\n%q\nwant\n%q", got, want)
+	}
+}
+
+func TestSchemaChangesDestructive(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantDDL    string // "" when nothing may be applied
+	}{
+		{
+			name:       "drop column",
+			body:       `{"changes":[{"op":"drop_column","table":"film","column":"title"}]}`,
+			wantStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name:       "drop table",
+			body:       `{"changes":[{"op":"add_column","table":"film","column":"notes","type":"text"},{"op":"drop_table","table":"film"}]}`,
+			wantStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name:       "allowed",
+			body:       `{"allowDestructive":true,"changes":[{"op":"drop_column","table":"film","column":"title"}]}`,
+			wantStatus: http.StatusOK,
+			wantDDL:    `tx1 ALTER TABLE "public"."film" DROP COLUMN "title"`,
+		},
+		{
+			name:       "dry run",
+			body:       `{"dryRun":true,"changes":[{"op":"drop_table","table":"film"}]}`,
+			wantStatus: http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deps, fake := (&fakeSchema{cols: []string{"film_id", "title"}}).open(t)
+			w := postSchemaChanges(deps, tt.body)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.wantStatus, w.Body)
+			}
+			var ddl []string
+			for _, l := range fake.Log() {
+				if strings.Contains(l, "ALTER") || strings.Contains(l, "DROP") {
+					ddl = append(ddl, l)
+				}
+			}
+			if tt.wantDDL == "" && len(ddl) > 0 {
+				t.Errorf("applied %q", ddl)
+			}
+			if tt.wantDDL != "" && (len(ddl) != 1 || ddl[0] != tt.wantDDL) {
+				t.Errorf("applied %q, want %q", ddl, tt.wantDDL)
+			}
+		})
+	}
+}
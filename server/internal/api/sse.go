@@ -0,0 +1,209 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/zoravur/postgres-spreadsheet-view/server/internal/history"
+	"github.com/zoravur/postgres-spreadsheet-view/server/internal/metrics"
+	"github.com/zoravur/postgres-spreadsheet-view/server/internal/protocol"
+	"github.com/zoravur/postgres-spreadsheet-view/server/internal/reactive"
+	"github.com/zoravur/postgres-spreadsheet-view/server/pkg/pg_lineage"
+)
+
+var (
+	mSSEStreams        = metrics.NewGauge("sse_streams")
+	mSSESlowDisconnect = metrics.NewCounter("sse_slow_client_disconnects_total")
+)
+
+const (
+	// sseHeartbeat is how often an idle stream gets a comment line, so
+	// proxies don't time it out.
+	sseHeartbeat = 15 * time.Second
+	// sseRetry is the reconnect delay suggested to EventSource clients.
+	sseRetry = 2 * time.Second
+)
+
+// GET /api/live/stream
+//
+// Query params:
+//
+//	sql       the query to stream
+//	saved     instead of sql, the ID of one of the caller's query history entries to rerun
+//	strategy  refresh strategy override, as for a WebSocket subscribe
+//
+// HandleSSE streams a read-only live query as Server-Sent Events, for
+// consumers that can't use WebSockets. The query is shared through the same
+// registry as HandleWS. Events are named after protocol message types and
+// carry the same JSON payloads: "subscribed" with the initial result, then
+// "update", "reload", "schema_changed" and "error". Events that advance the
+// stream have an ID of "<live query id>:<seq>"; a client reconnecting with it
+// as Last-Event-ID gets only the updates it missed, like a resuming subscribe.
+func (h *WSHandler) HandleSSE(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	qs := r.URL.Query()
+	sqlText := qs.Get("sql")
+	if saved := qs.Get("saved"); saved != "" {
+		if sqlText != "" {
+			http.Error(w, "pass sql or saved, not both", http.StatusBadRequest)
+			return
+		}
+		if h.History == nil {
+			http.Error(w, "query history disabled", http.StatusServiceUnavailable)
+			return
+		}
+		id, err := strconv.ParseInt(saved, 10, 64)
+		if err != nil {
+			http.Error(w, "invalid saved", http.StatusBadRequest)
+			return
+		}
+		e, err := h.History.Get(r.Context(), id, userFromRequest(r))
+		if errors.Is(err, history.ErrNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, "history lookup failed: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		sqlText = e.SQL
+	}
+	if sqlText == "" {
+		http.Error(w, "missing sql or saved", http.StatusBadRequest)
+		return
+	}
+	override, err := pg_lineage.ParseStrategy(qs.Get("strategy"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	since, err := parseEventID(r.Header.Get("Last-Event-ID"))
+	if err != nil {
+		http.Error(w, "invalid Last-Event-ID", http.StatusBadRequest)
+		return
+	}
+
+	start := time.Now()
+	lq, err := h.acquireLiveQuery(r.Context(), sqlText, override)
+	if err != nil {
+		recordQuery(r, h.History, history.SourceSSE, sqlText, start, -1, err)
+		status := http.StatusInternalServerError
+		switch classifyError(err, protocol.CodeQueryFailed).Code {
+		case protocol.CodeParseError, protocol.CodeQueryFailed:
+			status = http.StatusBadRequest
+		case protocol.CodePermissionDenied:
+			status = http.StatusForbidden
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	stream := newLiveStream(h.Outbound.QueueSize, mSSEStreams, mSSESlowDisconnect)
+	defer stream.close()
+	cl := &reactive.Client{Send: stream.send}
+	defer h.Registry.Release(lq, cl)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no") // nginx: don't buffer the stream
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", sseRetry.Milliseconds())
+	flusher.Flush()
+
+	// as in HandleWS: the initial result (or the missed updates) is queued
+	// under the query's lock, before any later update
+	rowCount := -1
+	info := subscribedInfo(lq)
+	if since != nil && since.ID == lq.ID {
+		replayed, rerr := lq.Resume(cl, since.Seq, info)
+		err = rerr
+		zap.L().Info("sse resume", zap.String("live_query_id", lq.ID),
+			zap.Uint64("since", since.Seq), zap.Bool("replayed", replayed), zap.Error(err))
+	} else {
+		rowCount, err = lq.Attach(cl, info)
+	}
+	recordQuery(r, h.History, history.SourceSSE, sqlText, start, rowCount, err)
+	if err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(sseHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-stream.done:
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+		case m := <-stream.queue:
+			if err := writeEvent(w, m); err != nil {
+				zap.L().Warn("sse_write_failed", zap.String("type", string(m.Type)), zap.Error(err))
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+// writeEvent writes m as one event, with an ID if it has a position.
+func writeEvent(w http.ResponseWriter, m protocol.Message) error {
+	data, err := json.Marshal(m.Data)
+	if err != nil {
+		return err
+	}
+	if pos, ok := eventPosition(m.Data); ok {
+		if _, err := fmt.Fprintf(w, "id: %s:%d\n", pos.ID, pos.Seq); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", m.Type, data)
+	return err
+}
+
+// eventPosition is where a payload leaves the client in the query's stream.
+func eventPosition(data any) (protocol.Position, bool) {
+	switch d := data.(type) {
+	case protocol.Subscribed:
+		return protocol.Position{ID: d.ID, Seq: d.Seq}, true
+	case protocol.Snapshot:
+		return protocol.Position{ID: d.ID, Seq: d.Seq}, true
+	case protocol.Update:
+		return protocol.Position{ID: d.ID, Seq: d.Seq}, true
+	default:
+		return protocol.Position{}, false
+	}
+}
+
+// parseEventID parses a Last-Event-ID of "<live query id>:<seq>"; nil when
+// there is none.
+func parseEventID(v string) (*protocol.Position, error) {
+	if v == "" {
+		return nil, nil
+	}
+	i := strings.LastIndexByte(v, ':')
+	if i <= 0 {
+		return nil, fmt.Errorf("malformed event id %q", v)
+	}
+	seq, err := strconv.ParseUint(v[i+1:], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("malformed event id %q", v)
+	}
+	return &protocol.Position{ID: v[:i], Seq: seq}, nil
+}
@@ -0,0 +1,38 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/zoravur/postgres-spreadsheet-view/server/internal/history"
+	"github.com/zoravur/postgres-spreadsheet-view/server/internal/sqlfake"
+)
+
+// A saved query is only rerun for the user whose history it is in; anyone
+// else gets the same 404 as for an ID that doesn't exist.
+func TestHandleSSESavedIsTheCallers(t *testing.T) {
+	db, _ := sqlfake.Open(func(_ context.Context, c sqlfake.Call) (sqlfake.Result, error) {
+		res := sqlfake.Result{Columns: []string{"id", "user_name", "source", "sql", "started_at", "duration_ms", "row_count", "error"}}
+		// entry 1 is ana's; a lookup that doesn't pass the user finds it for anyone
+		if c.Args[0] == int64(1) && (len(c.Args) < 2 || c.Args[1] == "ana") {
+			res.Rows = [][]any{{int64(1), "ana", history.SourceHTTP, "SELECT secret FROM payroll", time.Now(), 1.0, nil, nil}}
+		}
+		return res, nil
+	})
+	h := &WSHandler{History: history.NewStore(db)}
+
+	for _, user := range []string{"bo", "anonymous"} {
+		req := httptest.NewRequest(http.MethodGet, "/api/live/stream?saved=1", nil)
+		if user != "anonymous" {
+			req.Header.Set("X-User", user)
+		}
+		rec := httptest.NewRecorder()
+		h.HandleSSE(rec, req)
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("%s rerunning ana's entry: status %d, want 404 (%s)", user, rec.Code, rec.Body)
+		}
+	}
+}
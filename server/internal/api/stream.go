@@ -0,0 +1,59 @@
+package api
+
+import (
+	"sync"
+
+	"go.uber.org/zap"
+
+	"github.com/zoravur/postgres-spreadsheet-view/server/internal/metrics"
+	"github.com/zoravur/postgres-spreadsheet-view/server/internal/protocol"
+)
+
+// liveStream queues the messages of one read-only streaming subscriber (an
+// SSE response, a gRPC Subscribe). Like outbound, sends only enqueue, as they
+// happen under the live query's lock. There is no resync: a full queue ends
+// the stream, and the client resumes from the last position it saw.
+type liveStream struct {
+	queue chan protocol.Message
+	done  chan struct{}
+	once  sync.Once
+	open  *metrics.Gauge   // streams open
+	slow  *metrics.Counter // streams ended by a full queue
+}
+
+func newLiveStream(size int, open *metrics.Gauge, slow *metrics.Counter) *liveStream {
+	if size <= 0 {
+		size = 256
+	}
+	open.Add(1)
+	return &liveStream{queue: make(chan protocol.Message, size), done: make(chan struct{}), open: open, slow: slow}
+}
+
+// send queues a message without blocking. Streams are read-only, so presence,
+// locks and comments are left out.
+func (s *liveStream) send(msgType protocol.Type, payload any) error {
+	if protocol.RequiredCapability(msgType) != "" {
+		return nil
+	}
+	select {
+	case <-s.done:
+		return errConnClosed
+	default:
+	}
+	select {
+	case s.queue <- protocol.Message{Type: msgType, Data: payload}:
+		return nil
+	default:
+		zap.L().Warn("live_stream_slow_client_disconnect", zap.String("type", string(msgType)), zap.Int("queue", cap(s.queue)))
+		s.slow.Inc()
+		s.close()
+		return errSlowClient
+	}
+}
+
+func (s *liveStream) close() {
+	s.once.Do(func() {
+		close(s.done)
+		s.open.Add(-1)
+	})
+}
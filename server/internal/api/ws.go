@@ -8,13 +8,18 @@ import (
 	"fmt"
 	"log"
 	"net/http"
-	"strings"
+	"sync"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 
 	"go.uber.org/zap"
 
+	"github.com/zoravur/postgres-spreadsheet-view/server/internal/comments"
+	"github.com/zoravur/postgres-spreadsheet-view/server/internal/history"
+	"github.com/zoravur/postgres-spreadsheet-view/server/internal/locks"
+	"github.com/zoravur/postgres-spreadsheet-view/server/internal/protocol"
 	"github.com/zoravur/postgres-spreadsheet-view/server/internal/reactive"
 	"github.com/zoravur/postgres-spreadsheet-view/server/pkg/pg_lineage"
 	"github.com/zoravur/postgres-spreadsheet-view/server/pkg/richcatalog"
@@ -28,11 +33,31 @@ var upgrader = websocket.Upgrader{
 type WSHandler struct {
 	DB       *sql.DB
 	Registry *reactive.Registry
-	Catalog  *richcatalog.Catalog
+	History  *history.Store
+	Hub      *Hub
+	Catalog  *richcatalog.DBCatalog
+	Outbound OutboundOptions
+	Locks    *locks.Service
+	Comments *comments.Store
 	Log      *zap.Logger
+
+	roleOnce sync.Once
+	dbRole   string
 }
 
-// HandleWS upgrades the connection and handles subscribe/unsubscribe messages
+// HandleWS upgrades the connection and speaks the protocol in package
+// protocol on it. The client opens with a "hello"; the server answers with the
+// negotiated version and capabilities, then sends the current cell locks.
+// After that it subscribes, edits, reports presence, leases cells and
+// comments; each request is answered under its requestId with its result
+// ("subscribed", "unsubscribed", "ack") or an "error" with a code.
+//
+// A connection holds any number of subscriptions, each under a client-chosen
+// sub; messages for a subscription carry it. Updates caused by the
+// connection's own edits list their requestIds as origin. Presence goes to the
+// other subscribers of the same live query, and a "left" when a subscription
+// ends. A "lock" leases a cell while its editor is open (see locks.Service);
+// edits to a cell leased by another connection fail with a conflict.
 func (h *WSHandler) HandleWS(w http.ResponseWriter, r *http.Request) {
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
@@ -41,14 +66,29 @@ func (h *WSHandler) HandleWS(w http.ResponseWriter, r *http.Request) {
 	}
 	defer conn.Close()
 
-	// small helper for sending messages to this connection
-	wsSend := func(msgType string, payload any) error {
-		out := map[string]any{"type": msgType, "data": payload}
-		return conn.WriteJSON(out)
-	}
+	// every write goes through the connection's queue and writer goroutine
+	out := newOutbound(conn, h.Outbound)
+	defer out.close()
 
-	cl := &reactive.Client{Send: wsSend}
-	activeQueries := []*reactive.LiveQuery{} // track what this client subscribed to
+	user := userFromRequest(r)
+	// owns the connection's cell locks
+	connID := uuid.NewString()
+	if h.Locks != nil {
+		defer h.Locks.ReleaseAll(connID)
+	}
+	var hello protocol.Hello // set by the handshake
+	subs := map[string]*subscription{}
+	release := func(s *subscription) {
+		h.Registry.Release(s.q, s.client)
+		out.forget(s)
+		delete(subs, s.id)
+	}
+	// cleanup on disconnect
+	defer func() {
+		for _, s := range subs {
+			release(s)
+		}
+	}()
 
 	for {
 		_, msg, err := conn.ReadMessage()
@@ -66,155 +106,330 @@ func (h *WSHandler) HandleWS(w http.ResponseWriter, r *http.Request) {
 			break
 		}
 
-		var req struct {
-			Type string `json:"type"`
-			SQL  string `json:"sql"`
-		}
+		var req protocol.Request
 		if err := json.Unmarshal(msg, &req); err != nil {
-			wsSend("error", map[string]string{"error": "invalid JSON"})
+			out.fail(nil, "", protocol.Errorf(protocol.CodeBadRequest, "invalid JSON: %v", err))
 			continue
 		}
 
-		switch strings.ToLower(req.Type) {
-		case "subscribe":
-			if req.SQL == "" {
-				wsSend("error", map[string]string{"error": "missing SQL"})
+		if hello.Version == 0 {
+			if req.Type != protocol.TypeHello {
+				out.fail(nil, req.RequestID, protocol.Errorf(protocol.CodeHandshakeRequired, "expected hello, got %q", req.Type))
+				continue
+			}
+			var want protocol.Hello
+			if err := req.Decode(&want); err != nil {
+				out.fail(nil, req.RequestID, err)
+				continue
+			}
+			if hello, err = protocol.Negotiate(want); err != nil {
+				// the client may try again with another version
+				out.fail(nil, req.RequestID, err)
+				continue
+			}
+			out.setHello(hello)
+			out.reply(nil, req.RequestID, protocol.TypeHello, hello)
+			if h.Hub != nil {
+				h.Hub.Add(out.client)
+				defer h.Hub.Remove(out.client)
+			}
+			if h.Locks != nil {
+				for _, l := range h.Locks.Locks() {
+					out.client.Send(protocol.TypeLock, l)
+				}
+			}
+			continue
+		}
+
+		if c := protocol.RequiredCapability(req.Type); c != "" && !hello.Has(c) {
+			out.fail(nil, req.RequestID, protocol.Errorf(protocol.CodeUnsupported, "%s needs the %q capability", req.Type, c))
+			continue
+		}
+
+		switch req.Type {
+		case protocol.TypeHello:
+			out.fail(nil, req.RequestID, protocol.Errorf(protocol.CodeBadRequest, "already said hello"))
+
+		case protocol.TypePing:
+			out.reply(nil, req.RequestID, protocol.TypePong, nil)
+
+		case protocol.TypeSubscribe:
+			var body protocol.Subscribe
+			if err := req.Decode(&body); err != nil {
+				out.fail(nil, req.RequestID, err)
+				continue
+			}
+			if req.Sub == "" {
+				req.Sub = uuid.NewString()
+			}
+			sub := out.subscribe(req.Sub, req.RequestID)
+			if body.SQL == "" {
+				out.fail(sub, req.RequestID, protocol.Errorf(protocol.CodeBadRequest, "missing sql"))
+				continue
+			}
+			if body.Since != nil && !hello.Has(protocol.CapResume) {
+				out.fail(sub, req.RequestID, protocol.Errorf(protocol.CodeUnsupported, "since needs the %q capability", protocol.CapResume))
+				continue
+			}
+
+			override, err := pg_lineage.ParseStrategy(body.Strategy)
+			if err != nil {
+				out.fail(sub, req.RequestID, protocol.AsError(err, protocol.CodeBadRequest))
 				continue
 			}
 
-			lq, err := h.registerLiveQuery(req.SQL, cl)
+			start := time.Now()
+			lq, err := h.acquireLiveQuery(r.Context(), body.SQL, override)
 			if err != nil {
-				wsSend("error", map[string]string{"error": err.Error()})
+				recordQuery(r, h.History, history.SourceWS, body.SQL, start, -1, err)
+				out.fail(sub, req.RequestID, classifyError(err, protocol.CodeQueryFailed))
 				continue
 			}
+			// acquire before releasing the old one, so re-subscribing to the
+			// same query keeps it alive
+			if old, ok := subs[req.Sub]; ok {
+				release(old)
+			}
+			sub.q = lq
+			subs[req.Sub] = sub
+
+			info := subscribedInfo(lq)
+			if hello.Has(protocol.CapComments) {
+				// new comments arrive as "comment"
+				info.Comments = commentCounts(r.Context(), h.Comments, lq)
+			}
 
-			activeQueries = append(activeQueries, lq)
-			wsSend("subscribed", map[string]any{
-				"id":      lq.ID,
-				"tables":  lq.Tables,
-				"pkCols":  lq.PKCols,
-				"rewrote": lq.Rewritten,
+			// "subscribed" carries the initial result itself: rows and keys
+			// read under one snapshot (its LSN included), sent under the
+			// query's lock, so no change between load and listen is lost or
+			// applied twice. Later "update" ops address rows by key and
+			// position. A resuming client instead gets the updates it missed,
+			// if the query is still the one it saw.
+			rowCount := -1
+			if body.Since != nil && body.Since.ID == lq.ID {
+				replayed, err := lq.Resume(sub.client, body.Since.Seq, info)
+				zap.L().Info("ws resume", zap.String("sub", req.Sub), zap.String("live_query_id", lq.ID),
+					zap.Uint64("since", body.Since.Seq), zap.Bool("replayed", replayed), zap.Error(err))
+			} else {
+				rowCount, err = lq.Attach(sub.client, info)
+			}
+			if err == nil {
+				err = lq.SendPresence(sub.client)
+			}
+			recordQuery(r, h.History, history.SourceWS, body.SQL, start, rowCount, err)
+
+		case protocol.TypeUnsubscribe:
+			// without a sub, every subscription on the connection ends
+			if req.Sub == "" {
+				for _, s := range subs {
+					release(s)
+				}
+				out.reply(nil, req.RequestID, protocol.TypeUnsubscribed, nil)
+				continue
+			}
+			s, ok := subs[req.Sub]
+			if !ok {
+				out.fail(nil, req.RequestID, errUnknownSub(req.Sub))
+				continue
+			}
+			release(s)
+			out.reply(s, req.RequestID, protocol.TypeUnsubscribed, nil)
+
+		case protocol.TypePresence:
+			// what the user is looking at in one subscription, relayed to
+			// everyone else subscribed to the same live query
+			var body protocol.PresenceReport
+			if err := req.Decode(&body); err != nil {
+				out.fail(nil, req.RequestID, err)
+				continue
+			}
+			s, ok := subs[req.Sub]
+			if !ok {
+				out.fail(nil, req.RequestID, errUnknownSub(req.Sub))
+				continue
+			}
+			if err := reactive.ValidPresenceState(body.State); err != nil {
+				out.fail(s, req.RequestID, protocol.AsError(err, protocol.CodeBadRequest))
+				continue
+			}
+			s.q.SetPresence(s.client, reactive.Presence{
+				Peer:       s.peer,
+				User:       user,
+				EditHandle: body.EditHandle,
+				Column:     body.Column,
+				State:      body.State,
 			})
 
-		case "unsubscribe":
-			if len(activeQueries) == 0 {
+		case protocol.TypeEdit:
+			// edits run in order on the read loop, so a client's edits commit
+			// in the order they were sent
+			var body protocol.Edit
+			if err := req.Decode(&body); err != nil {
+				out.fail(nil, req.RequestID, err)
+				continue
+			}
+			if req.RequestID == "" {
+				out.fail(nil, "", protocol.Errorf(protocol.CodeBadRequest, "edit without requestId"))
 				continue
 			}
-			for _, q := range activeQueries {
-				h.Registry.Unregister(q.ID)
+			edit := EditRequest{EditHandle: body.EditHandle, Column: body.Column, Value: body.Value}
+			res, status, err := applyEdit(r.Context(), h.DB, h.Catalog, h.Locks, connID, edit, func(xid int64) {
+				out.markEdit(xid, req.RequestID)
+			})
+			if err == nil && res.Rows == 0 {
+				err = protocol.Errorf(protocol.CodeNotEditable, "no row matches the edit handle")
 			}
-			activeQueries = nil
-			wsSend("unsubscribed", "ok")
+			if err != nil {
+				if res.XID != 0 {
+					out.unmarkEdit(res.XID)
+				}
+				zap.L().Info("ws edit failed", zap.String("request_id", req.RequestID), zap.Error(err))
+				fallback := protocol.CodeInternal
+				if status == http.StatusBadRequest {
+					fallback = protocol.CodeNotEditable // bad handle or column
+				}
+				out.fail(nil, req.RequestID, classifyError(err, fallback))
+				continue
+			}
+			out.reply(nil, req.RequestID, protocol.TypeAck, protocol.EditAck{XID: res.XID, LSN: res.LSN, Rows: res.Rows})
 
-		default:
-			wsSend("error", map[string]string{"error": "unknown message type"})
-		}
-	}
+		case protocol.TypeComment, protocol.TypeComments:
+			// "comment" adds to a cell's thread (the row's, without a
+			// column) and pushes it to every subscriber showing the row;
+			// "comments" lists the thread
+			if h.Comments == nil {
+				out.fail(nil, req.RequestID, protocol.Errorf(protocol.CodeUnsupported, "comments are disabled"))
+				continue
+			}
+			var body protocol.CommentRequest
+			if err := req.Decode(&body); err != nil {
+				out.fail(nil, req.RequestID, err)
+				continue
+			}
+			if req.Type == protocol.TypeComments {
+				list, err := h.Comments.List(r.Context(), body.EditHandle, body.Column)
+				if err != nil {
+					out.fail(nil, req.RequestID, classifyError(err, protocol.CodeBadRequest))
+					continue
+				}
+				out.reply(nil, req.RequestID, protocol.TypeAck, protocol.CommentList{Comments: list})
+				continue
+			}
+			if h.Catalog == nil {
+				out.fail(nil, req.RequestID, protocol.Errorf(protocol.CodeUnsupported, "catalog unavailable"))
+				continue
+			}
+			c, err := h.Comments.Add(r.Context(), h.Catalog, comments.Comment{
+				EditHandle: body.EditHandle,
+				Column:     body.Column,
+				User:       user,
+				Body:       body.Body,
+			})
+			if err != nil {
+				out.fail(nil, req.RequestID, classifyError(err, protocol.CodeBadRequest))
+				continue
+			}
+			out.reply(nil, req.RequestID, protocol.TypeAck, c)
+			pushComment(h.Registry, c)
 
-	// cleanup on disconnect
-	for _, q := range activeQueries {
-		q.Mu.Lock()
-		delete(q.Clients, cl)
-		empty := len(q.Clients) == 0
-		q.Mu.Unlock()
-
-		if empty {
-			h.Registry.Unregister(q.ID)
+		case protocol.TypeLock, protocol.TypeUnlock:
+			// a lease on the cell being edited; everyone is told about it
+			// through the hub, the requester also gets an ack or error
+			if h.Locks == nil {
+				out.fail(nil, req.RequestID, protocol.Errorf(protocol.CodeUnsupported, "cell locking is disabled"))
+				continue
+			}
+			var body protocol.LockRequest
+			if err := req.Decode(&body); err != nil {
+				out.fail(nil, req.RequestID, err)
+				continue
+			}
+			if req.Type == protocol.TypeUnlock {
+				if err := h.Locks.Release(connID, body.EditHandle, body.Column); err != nil {
+					out.fail(nil, req.RequestID, classifyError(err, protocol.CodeBadRequest))
+					continue
+				}
+				out.reply(nil, req.RequestID, protocol.TypeAck, nil)
+				continue
+			}
+			l, err := h.Locks.Claim(r.Context(), connID, user, body.EditHandle, body.Column, body.Hard)
+			if err != nil {
+				out.fail(nil, req.RequestID, classifyError(err, protocol.CodeNotEditable))
+				continue
+			}
+			out.reply(nil, req.RequestID, protocol.TypeAck, l)
+
+		default:
+			out.fail(nil, req.RequestID, protocol.Errorf(protocol.CodeUnknownType, "unknown request type %q", req.Type))
 		}
 	}
 }
 
-// registerLiveQuery parses, rewrites, and registers a new live query in the registry
-func (h *WSHandler) registerLiveQuery(sql string, cl *reactive.Client) (*reactive.LiveQuery, error) {
-	cat, err := richcatalog.New(h.DB, richcatalog.Options{
-		Schemas:        []string{"public"},
-		IncludeIndexes: true,
-		IncludeFKs:     true,
-	})
-	if err != nil {
-		return nil, err
-	}
-
-	// Critical: populate the catalog
-	if err := cat.Refresh(context.TODO()); err != nil {
-		return nil, fmt.Errorf("catalog refresh: %w", err)
+// subscribedInfo describes lq for a "subscribed"; Attach or Resume fill in
+// the rows.
+func subscribedInfo(lq *reactive.LiveQuery) protocol.Subscribed {
+	a := lq.Current()
+	return protocol.Subscribed{
+		Tables:   a.Tables,
+		PKCols:   a.PKCols,
+		Strategy: string(a.Strategy),
+		Rewrote:  a.Rewritten,
 	}
+}
 
-	// Run rewrite + provenance analysis
-	rew, pkByAlias, err := pg_lineage.RewriteSelectInjectPKs(sql, cat)
-	if err != nil {
-		return nil, fmt.Errorf("rewrite: %w", err)
-	}
+func errUnknownSub(id string) *protocol.Error {
+	return protocol.Errorf(protocol.CodeNotFound, "unknown subscription %q", id)
+}
 
-	prov, err := pg_lineage.ResolveProvenance(rew, cat)
+// acquireLiveQuery returns the shared live query for sql, creating it on first
+// use, and takes a reference on it for one subscription.
+func (h *WSHandler) acquireLiveQuery(ctx context.Context, sql string, override pg_lineage.Strategy) (*reactive.LiveQuery, error) {
+	// fingerprinting parses sql, so this is where syntax errors surface
+	key, err := reactive.Fingerprint(sql, h.role(ctx), override)
 	if err != nil {
-		zap.L().Warn("provenance_failed", zap.String("rew", rew), zap.Error(err))
-		// Optional: fallback to FROM-clause extraction here
+		return nil, protocol.Errorf(protocol.CodeParseError, "%v", err)
 	}
+	return h.Registry.Acquire(key, func() (*reactive.LiveQuery, error) {
+		return h.newLiveQuery(ctx, sql, override)
+	})
+}
 
-	// Map alias -> table (for dependency tracking)
-	// aliasToTable := make(map[string]string)
-	tablesSet := map[string]struct{}{}
-
-	for _, srcs := range prov {
-		if len(srcs) == 0 {
-			continue
-		}
-		for _, src := range srcs {
-			parts := strings.SplitN(src, ".", 2)
-			if len(parts) != 2 {
-				continue
-			}
-			base := parts[0]
-			tablesSet["public."+strings.ToLower(base)] = struct{}{}
-		}
-
-		if len(tablesSet) == 0 {
-			zap.L().Error("No base tables in query")
-			// bases, err := pg_lineage.ResolveBaseTables(rew, cat) // or walk AST
-			// if err == nil {
-			// 	for _, b := range bases {
-			// 		tablesSet["public."+strings.ToLower(b)] = struct{}{}
-			// 	}
-			// }
+// role is the database role live queries run as; part of their fingerprint.
+func (h *WSHandler) role(ctx context.Context) string {
+	h.roleOnce.Do(func() {
+		if err := h.DB.QueryRowContext(ctx, "SELECT current_user").Scan(&h.dbRole); err != nil {
+			zap.L().Warn("current_user lookup failed", zap.Error(err))
 		}
+	})
+	return h.dbRole
+}
 
-		// parts := strings.SplitN(src, ".", 2)
-		// if len(parts) != 2 {
-		// 	continue
-		// }
-		// table := parts[0]
-		// tablesSet["public."+table] = struct{}{}
-		// aliasToTable[outCol] = table
+// newLiveQuery parses, rewrites, and loads a live query; the registry takes it from there
+func (h *WSHandler) newLiveQuery(ctx context.Context, sql string, override pg_lineage.Strategy) (*reactive.LiveQuery, error) {
+	if h.Catalog == nil {
+		return nil, errors.New("catalog unavailable")
 	}
-
-	var tables []string
-	for t := range tablesSet {
-		tables = append(tables, t)
+	cat, err := h.Catalog.Load(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("catalog load: %w", err)
 	}
 
-	// Preserve injected PK aliases directly for incremental WHERE filters
-	pkAliasCols := make(map[string][]string)
-	for alias, injectedCols := range pkByAlias {
-		// Keep the injected columns exactly as the rewrite created them
-		pkAliasCols[alias] = append([]string(nil), injectedCols...)
+	a, err := reactive.Analyze(sql, cat, override)
+	if err != nil {
+		return nil, err
 	}
 
-	provOrig, _ := pg_lineage.ResolveProvenance(sql, cat)
-	provRewritten, _ := pg_lineage.ResolveProvenance(rew, cat)
-
 	lq := &reactive.LiveQuery{
-		ID:            uuid.NewString(),
-		SQL:           sql,
-		Rewritten:     rew,
-		Tables:        tables,
-		PKCols:        pkAliasCols,
-		Clients:       map[*reactive.Client]struct{}{cl: {}},
-		ProvOrig:      provOrig,
-		ProvRewritten: provRewritten,
-		PKMapByAlias:  pkByAlias,
+		ID:       uuid.NewString(),
+		SQL:      sql,
+		Override: override,
+		Analysis: a,
+		Clients:  map[*reactive.Client]struct{}{},
 	}
 
-	h.Registry.Register(lq)
+	// materialize the result so refreshes can be diffed against it
+	if err := lq.Load(h.DB); err != nil {
+		return nil, fmt.Errorf("initial load: %w", err)
+	}
 	return lq, nil
 }
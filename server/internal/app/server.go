@@ -103,6 +103,7 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 
@@ -110,16 +111,29 @@ import (
 
 	_ "github.com/lib/pq"
 	"go.uber.org/zap"
+	"google.golang.org/grpc"
 
 	"github.com/zoravur/postgres-spreadsheet-view/server/internal/api"
+	"github.com/zoravur/postgres-spreadsheet-view/server/internal/comments"
+	"github.com/zoravur/postgres-spreadsheet-view/server/internal/history"
+	"github.com/zoravur/postgres-spreadsheet-view/server/internal/locks"
+	"github.com/zoravur/postgres-spreadsheet-view/server/internal/protocol"
 	"github.com/zoravur/postgres-spreadsheet-view/server/internal/reactive"
 	"github.com/zoravur/postgres-spreadsheet-view/server/internal/wal"
+	"github.com/zoravur/postgres-spreadsheet-view/server/pkg/richcatalog"
 )
 
 type Server struct {
 	httpServer *http.Server
+	grpcServer *grpc.Server
+	grpcAddr   string
 	Registry   *reactive.Registry
 	DB         *sql.DB
+	History    *history.Store
+	Catalog    *richcatalog.DBCatalog
+	Hub        *api.Hub
+	Refresher  *reactive.Refresher
+	deps       reactive.Deps
 }
 
 func NewServer() *Server {
@@ -129,20 +143,80 @@ func NewServer() *Server {
 		log.Fatalf("DB open failed: %v", err)
 	}
 
-	// create reactive registry
+	// create reactive registry; queries outlive their last subscriber briefly
+	// so reconnecting clients can resume from the replay buffer
 	reg := reactive.NewRegistry()
+	reg.Linger = 30 * time.Second
+	reg.ReplaySize = reactive.DefaultReplaySize
+
+	// query history lives in a server-managed table; a missing table only
+	// disables recording, it shouldn't keep the server from starting
+	hist := history.NewStore(db)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := hist.EnsureSchema(ctx); err != nil {
+		log.Printf("query history schema setup failed: %v", err)
+	}
+
+	// comment threads share the psv schema; same failure policy as history
+	cmts := comments.NewStore(db)
+	if err := cmts.EnsureSchema(ctx); err != nil {
+		log.Printf("comments schema setup failed: %v", err)
+	}
+
+	// shared schema catalog; loaded and kept fresh in Run()
+	cat, err := richcatalog.New(db, richcatalog.Options{
+		Schemas:        []string{"public"},
+		IncludeIndexes: true,
+		IncludeFKs:     true,
+		KeepHistory:    32, // for /api/catalog/diff?since=
+	})
+	if err != nil {
+		log.Fatalf("catalog init failed: %v", err)
+	}
+
+	// every open websocket, for server-wide events
+	hub := api.NewHub()
+
+	// cell leases for open editors; every client hears about changes
+	lk := locks.NewService(db)
+	lk.Notify = hub.Broadcast
 
 	// set up API routes (inject registry for /api/live)
-	mux := api.SetupRoutes(reg, db)
+	deps := api.Deps{
+		DB: db, Registry: reg, History: hist, Catalog: cat, Hub: hub, Locks: lk, Comments: cmts,
+		// each websocket gets one writer; a client more than QueueSize
+		// messages behind drops updates and is resynced with a "reload"
+		Outbound: api.OutboundOptions{
+			QueueSize:    256,
+			Overflow:     api.OverflowResync,
+			WriteTimeout: 10 * time.Second,
+		},
+	}
+	mux := api.SetupRoutes(deps)
 
-	return &Server{
+	s := &Server{
 		httpServer: &http.Server{
 			Addr:    ":8080",
 			Handler: mux,
 		},
-		Registry: reg,
-		DB:       db,
+		// backend services: the same live queries and edits over gRPC
+		grpcServer: api.NewGRPCServer(deps),
+		grpcAddr:   ":9090",
+		Registry:   reg,
+		DB:         db,
+		History:    hist,
+		Catalog:    cat,
+		Hub:        hub,
 	}
+	s.deps = reactive.Deps{DB: db, Broadcast: s.broadcast}
+	// WAL changes are coalesced per live query and refreshed on a small pool
+	s.Refresher = reactive.NewRefresher(s.deps, reactive.RefresherOptions{
+		Window:    20 * time.Millisecond,
+		Workers:   8,
+		QueueSize: 256,
+	})
+	return s
 }
 
 func (s *Server) Run() error {
@@ -159,9 +233,29 @@ func (s *Server) Run() error {
 		}
 	}()
 
-	// --- WAL listener goroutine ---
+	// --- gRPC server ---
+	go func() {
+		lis, err := net.Listen("tcp", s.grpcAddr)
+		if err != nil {
+			zap.L().Fatal("gRPC listen failed", zap.Error(err))
+		}
+		zap.L().Info("Listening (gRPC)", zap.String("addr", s.grpcAddr))
+		if err := s.grpcServer.Serve(lis); err != nil {
+			zap.L().Fatal("gRPC server error", zap.Error(err))
+		}
+	}()
+
+	// --- WAL listener goroutine + refresh workers ---
+	refreshCtx, stopRefresh := context.WithCancel(context.Background())
+	defer stopRefresh()
+	s.Refresher.Start(refreshCtx)
+	go s.Refresher.Poll(refreshCtx, s.Registry, 5*time.Second)
 	go s.listenWAL()
 
+	// --- schema catalog: initial load + background refresh ---
+	stopCatalog := s.watchCatalog()
+	defer stopCatalog()
+
 	// --- graceful shutdown ---
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
@@ -169,9 +263,78 @@ func (s *Server) Run() error {
 	zap.L().Info("Shutting down...")
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
+	// Subscribe streams never finish on their own, so GracefulStop would wait
+	// on them forever
+	s.grpcServer.Stop()
 	return s.httpServer.Shutdown(ctx)
 }
 
+// watchCatalog loads the catalog, keeps it fresh, and tells every connected
+// client when the schema checksum changes. Returns a stop func.
+func (s *Server) watchCatalog() func() {
+	// Reanalyze runs a full refresh per affected query, which must not hold up
+	// the catalog refresh that reported the change. One goroutine at a time
+	// works through changes; ones that arrive meanwhile are folded into its
+	// next pass, against the newest snapshot.
+	var (
+		mu        sync.Mutex
+		pending   []richcatalog.Change
+		latest    richcatalog.Snapshot
+		analyzing bool
+	)
+	reanalyze := func(next richcatalog.Snapshot, changes []richcatalog.Change) {
+		mu.Lock()
+		defer mu.Unlock()
+		pending = append(pending, changes...)
+		latest = next
+		if analyzing {
+			return
+		}
+		analyzing = true
+		go func() {
+			for {
+				mu.Lock()
+				if len(pending) == 0 {
+					analyzing = false
+					mu.Unlock()
+					return
+				}
+				snap, changes := latest, pending
+				pending = nil
+				mu.Unlock()
+				reactive.Reanalyze(s.deps, s.Registry, snap, changes)
+			}
+		}()
+	}
+
+	s.Catalog.OnChange(func(prev, next richcatalog.Snapshot) {
+		if prev.Checksum == "" {
+			return // initial load, nothing changed from a client's point of view
+		}
+		zap.L().Info("catalog_changed",
+			zap.String("previous", prev.Checksum),
+			zap.String("checksum", next.Checksum),
+		)
+		changes := richcatalog.Diff(prev, next)
+		s.Hub.Broadcast(protocol.TypeCatalogChanged, protocol.CatalogChanged{
+			Checksum:    next.Checksum,
+			Previous:    prev.Checksum,
+			GeneratedAt: next.GeneratedAt,
+			Changes:     changes,
+		})
+		// live queries over changed tables need new _pk_* injection / * expansion;
+		// analyze against next itself so they all see the same schema version
+		reanalyze(next, changes)
+	})
+
+	if err := s.Catalog.Refresh(context.Background()); err != nil {
+		zap.L().Warn("catalog_initial_load_failed", zap.Error(err))
+	}
+	return s.Catalog.StartAutoRefresh(context.Background(), richcatalog.AutoRefresh{
+		Interval: 30 * time.Second,
+	})
+}
+
 // WAL listener: consumes JSON events from sidecar and triggers partial refreshes
 func (s *Server) listenWAL() {
 	conn, err := net.Dial("tcp", "localhost:9000")
@@ -183,32 +346,15 @@ func (s *Server) listenWAL() {
 	dec := json.NewDecoder(conn)
 
 	consumer := &wal.Consumer{
-		Reg: s.Registry,
-		Deps: reactive.Deps{
-			DB: s.DB,
-			Broadcast: func(lq *reactive.LiveQuery, msgType string, payload any) {
-				// Broadcast to all clients currently subscribed to this LiveQuery
-				lq.Mu.RLock()
-				defer lq.Mu.RUnlock()
-
-				// out := map[string]any{
-				// 	"type": msgType,
-				// 	"data": payload,
-				// }
-
-				for cl := range lq.Clients {
-					if err := cl.Send(msgType, payload); err != nil {
-						log.Printf("⚠️ failed to send to client for query %s: %v", lq.ID, err)
-					}
-				}
-
-				log.Printf("📡 Broadcasted %s to %d clients (query %s)", msgType, len(lq.Clients), lq.ID)
-			},
-		},
+		Reg:       s.Registry,
+		Deps:      s.deps,
+		Refresher: s.Refresher,
 	}
 
 	for {
-		var msg map[string]any
+		// pass messages through untouched; decoding into map[string]any would
+		// round numbers through float64
+		var msg json.RawMessage
 		if err := dec.Decode(&msg); err != nil {
 			if err == io.EOF {
 				break
@@ -217,7 +363,20 @@ func (s *Server) listenWAL() {
 			continue
 		}
 
-		b, _ := json.Marshal(msg)
-		consumer.OnMessage(b)
+		consumer.OnMessage(msg)
 	}
 }
+
+// broadcast sends to all clients currently subscribed to a LiveQuery.
+func (s *Server) broadcast(lq *reactive.LiveQuery, msgType protocol.Type, payload any) {
+	lq.Mu.RLock()
+	defer lq.Mu.RUnlock()
+
+	for cl := range lq.Clients {
+		if err := cl.SendFor(lq, msgType, payload); err != nil {
+			log.Printf("⚠️ failed to send to client for query %s: %v", lq.ID, err)
+		}
+	}
+
+	log.Printf("📡 Broadcasted %s to %d clients (query %s)", msgType, len(lq.Clients), lq.ID)
+}
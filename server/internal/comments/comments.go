@@ -0,0 +1,227 @@
+// Package comments stores discussion threads on cells and rows. A thread is
+// keyed by the row an edit handle decodes to (schema, table, primary key) and
+// a column; the empty column is the row's own thread.
+package comments
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/lib/pq"
+
+	"github.com/zoravur/postgres-spreadsheet-view/server/internal/common"
+	"github.com/zoravur/postgres-spreadsheet-view/server/internal/protocol"
+	"github.com/zoravur/postgres-spreadsheet-view/server/pkg/richcatalog"
+)
+
+// Comment is one message in a thread; see protocol.Comment.
+type Comment = protocol.Comment
+
+// maxBody bounds a comment's text.
+const maxBody = 10_000
+
+const schemaSQL = `
+CREATE SCHEMA IF NOT EXISTS psv;
+CREATE TABLE IF NOT EXISTS psv.comments (
+  id          bigserial PRIMARY KEY,
+  schema_name text NOT NULL,
+  table_name  text NOT NULL,
+  row_key     text NOT NULL,
+  pk          jsonb NOT NULL,
+  column_name text NOT NULL DEFAULT '',
+  user_name   text NOT NULL,
+  body        text NOT NULL,
+  created_at  timestamptz NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS comments_row_idx
+  ON psv.comments (schema_name, table_name, row_key, column_name);`
+
+// Store persists comments in a server-managed table (psv.comments).
+type Store struct {
+	db *sql.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+// EnsureSchema creates the comments table if it does not exist yet.
+func (s *Store) EnsureSchema(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, schemaSQL)
+	return err
+}
+
+// Add stores a comment on c.EditHandle's row (and c.Column, if set) and
+// returns it with its ID and time. The handle's table and key columns and
+// c.Column must be in cat; c.Column is stored as cat spells it. A comment
+// that fails these checks, or has an empty or overlong body, gets a
+// *protocol.Error with CodeBadRequest.
+func (s *Store) Add(ctx context.Context, cat richcatalog.Catalog, c Comment) (Comment, error) {
+	r, err := decode(c.EditHandle)
+	if err != nil {
+		return Comment{}, protocol.AsError(err, protocol.CodeBadRequest)
+	}
+	if c.Column, err = r.check(cat, c.Column); err != nil {
+		return Comment{}, err
+	}
+	c.Body = strings.TrimSpace(c.Body)
+	if c.Body == "" {
+		return Comment{}, protocol.Errorf(protocol.CodeBadRequest, "empty comment")
+	}
+	if len(c.Body) > maxBody {
+		return Comment{}, protocol.Errorf(protocol.CodeBadRequest, "comment longer than %d bytes", maxBody)
+	}
+	pk, err := json.Marshal(r.pk)
+	if err != nil {
+		return Comment{}, err
+	}
+	err = s.db.QueryRowContext(ctx, `
+INSERT INTO psv.comments (schema_name, table_name, row_key, pk, column_name, user_name, body)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+RETURNING id, created_at`,
+		r.schema, r.table, r.key, pk, c.Column, c.User, c.Body,
+	).Scan(&c.ID, &c.CreatedAt)
+	return c, err
+}
+
+// List returns the thread on handle's row and column, oldest first.
+func (s *Store) List(ctx context.Context, handle, column string) ([]Comment, error) {
+	r, err := decode(handle)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.db.QueryContext(ctx, `
+SELECT id, user_name, body, created_at FROM psv.comments
+WHERE schema_name = $1 AND table_name = $2 AND row_key = $3 AND column_name = $4
+ORDER BY created_at, id`,
+		r.schema, r.table, r.key, column,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []Comment{}
+	for rows.Next() {
+		c := Comment{EditHandle: handle, Column: column}
+		if err := rows.Scan(&c.ID, &c.User, &c.Body, &c.CreatedAt); err != nil {
+			return nil, err
+		}
+		out = append(out, c)
+	}
+	return out, rows.Err()
+}
+
+// Counts returns how many comments each handle's row has per column ("" for
+// the row's own thread). Handles without comments are left out; ones that
+// don't decode are ignored.
+func (s *Store) Counts(ctx context.Context, handles []string) (map[string]map[string]int, error) {
+	byRow := map[string][]string{} // "schema.table|row_key" -> handles
+	refs := map[string]rowRef{}
+	seen := map[string]bool{}
+	for _, h := range handles {
+		if seen[h] {
+			continue
+		}
+		seen[h] = true
+		r, err := decode(h)
+		if err != nil {
+			continue
+		}
+		k := r.schema + "." + r.table + "|" + r.key
+		byRow[k] = append(byRow[k], h)
+		refs[k] = r
+	}
+	out := map[string]map[string]int{}
+	if len(byRow) == 0 {
+		return out, nil
+	}
+	var schemas, tables, keys []string
+	for _, r := range refs {
+		schemas = append(schemas, r.schema)
+		tables = append(tables, r.table)
+		keys = append(keys, r.key)
+	}
+
+	rows, err := s.db.QueryContext(ctx, `
+SELECT schema_name || '.' || table_name || '|' || row_key, column_name, count(*)
+FROM psv.comments
+WHERE (schema_name, table_name, row_key) IN (
+  SELECT * FROM unnest($1::text[], $2::text[], $3::text[]))
+GROUP BY schema_name, table_name, row_key, column_name`,
+		pq.Array(schemas), pq.Array(tables), pq.Array(keys))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var k, col string
+		var n int
+		if err := rows.Scan(&k, &col, &n); err != nil {
+			return nil, err
+		}
+		for _, h := range byRow[k] {
+			if out[h] == nil {
+				out[h] = map[string]int{}
+			}
+			out[h][col] = n
+		}
+	}
+	return out, rows.Err()
+}
+
+type rowRef struct {
+	schema, table string
+	pk            map[string]any
+	key           string // pk as sorted "col=value" pairs
+}
+
+// check looks r's table, key columns and column up in cat, and returns the
+// column as cat spells it ("" stays "", the row's own thread).
+func (r rowRef) check(cat richcatalog.Catalog, column string) (string, error) {
+	rel := r.schema + "." + r.table
+	cols, ok := cat.Columns(rel)
+	if !ok {
+		return "", protocol.Errorf(protocol.CodeBadRequest, "unknown table %s", rel)
+	}
+	pkCols, _ := cat.PrimaryKeys(rel)
+	for col := range r.pk {
+		if _, ok := common.CatalogName(pkCols, col); !ok {
+			return "", protocol.Errorf(protocol.CodeBadRequest, "%q is not a primary key column of %s", col, rel)
+		}
+	}
+	if column == "" {
+		return "", nil
+	}
+	name, ok := common.CatalogName(cols, column)
+	if !ok {
+		return "", protocol.Errorf(protocol.CodeBadRequest, "unknown column %q on %s", column, rel)
+	}
+	return name, nil
+}
+
+// decode resolves a handle to its row. The key lists primary key columns in
+// sorted order, so handles that order them differently share threads.
+func decode(handle string) (rowRef, error) {
+	schema, table, pk, err := common.DecodeHandle(handle)
+	if err != nil {
+		return rowRef{}, fmt.Errorf("invalid handle: %w", err)
+	}
+	if len(pk) == 0 {
+		return rowRef{}, fmt.Errorf("no primary key info in handle")
+	}
+	cols := make([]string, 0, len(pk))
+	for col := range pk {
+		cols = append(cols, col)
+	}
+	sort.Strings(cols)
+	kv := make([]string, len(cols))
+	for i, col := range cols {
+		kv[i] = col + "=" + common.FormatKeyValue(pk[col])
+	}
+	return rowRef{schema: schema, table: table, pk: pk, key: strings.Join(kv, ",")}, nil
+}
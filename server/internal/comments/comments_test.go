@@ -0,0 +1,135 @@
+package comments
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zoravur/postgres-spreadsheet-view/server/internal/common"
+	"github.com/zoravur/postgres-spreadsheet-view/server/internal/protocol"
+	"github.com/zoravur/postgres-spreadsheet-view/server/internal/sqlfake"
+)
+
+// stubCatalog is a richcatalog.Catalog over fixed tables.
+type stubCatalog struct {
+	cols map[string][]string
+	pks  map[string][]string
+}
+
+func (c stubCatalog) Columns(q string) ([]string, bool)     { v, ok := c.cols[q]; return v, ok }
+func (c stubCatalog) PrimaryKeys(q string) ([]string, bool) { v, ok := c.pks[q]; return v, ok }
+
+var catalog = stubCatalog{
+	cols: map[string][]string{"public.film": {"film_id", "title", "Rating"}},
+	pks:  map[string][]string{"public.film": {"film_id"}},
+}
+
+func TestAdd(t *testing.T) {
+	handle := common.EncodeHandle("public", "film", []string{"film_id"}, []any{7})
+	created := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		in      Comment
+		wantCol string // stored column_name
+		wantErr string
+	}{
+		{name: "cell", in: Comment{EditHandle: handle, Column: "title", Body: " nice "}, wantCol: "title"},
+		{name: "row", in: Comment{EditHandle: handle, Body: "row note"}, wantCol: ""},
+		{name: "catalog spelling", in: Comment{EditHandle: handle, Column: "rating", Body: "x"}, wantCol: "Rating"},
+		{name: "unknown column", in: Comment{EditHandle: handle, Column: "film_title", Body: "x"}, wantErr: "unknown column"},
+		{name: "unknown table", in: Comment{EditHandle: common.EncodeHandle("public", "nope", []string{"id"}, []any{1}), Column: "title", Body: "x"}, wantErr: "unknown table"},
+		{name: "unknown schema", in: Comment{EditHandle: common.EncodeHandle("secret", "film", []string{"film_id"}, []any{1}), Body: "x"}, wantErr: "unknown table"},
+		{name: "non-key column in handle", in: Comment{EditHandle: common.EncodeHandle("public", "film", []string{"title"}, []any{"x"}), Body: "x"}, wantErr: "not a primary key column"},
+		{name: "bad handle", in: Comment{EditHandle: "%%%", Body: "x"}, wantErr: "invalid handle"},
+		{name: "empty", in: Comment{EditHandle: handle, Column: "title", Body: "  "}, wantErr: "empty comment"},
+		{name: "too long", in: Comment{EditHandle: handle, Column: "title", Body: strings.Repeat("x", maxBody+1)}, wantErr: "longer than"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var inserted []any
+			db, _ := sqlfake.Open(func(_ context.Context, c sqlfake.Call) (sqlfake.Result, error) {
+				inserted = c.Args
+				return sqlfake.Result{Columns: []string{"id", "created_at"}, Rows: [][]any{{int64(42), created}}}, nil
+			})
+			got, err := NewStore(db).Add(context.Background(), catalog, tt.in)
+			if tt.wantErr != "" {
+				var pe *protocol.Error
+				if !errors.As(err, &pe) || pe.Code != protocol.CodeBadRequest || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want a bad_request containing %q", err, tt.wantErr)
+				}
+				if inserted != nil {
+					t.Errorf("stored a rejected comment: %v", inserted)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.ID != 42 || !got.CreatedAt.Equal(created) || got.Column != tt.wantCol {
+				t.Errorf("Add = %+v", got)
+			}
+			// schema, table, row_key, pk, column_name, user_name, body
+			if inserted[0] != "public" || inserted[1] != "film" || inserted[2] != "film_id=7" || inserted[4] != tt.wantCol {
+				t.Errorf("inserted %v", inserted)
+			}
+			if inserted[6] != strings.TrimSpace(tt.in.Body) {
+				t.Errorf("body %q not trimmed", inserted[6])
+			}
+		})
+	}
+}
+
+// Handles listing a row's key columns in any order share its threads.
+func TestDecodeSortsKey(t *testing.T) {
+	a, err := decode(common.EncodeHandle("public", "film_actor", []string{"film_id", "actor_id"}, []any{1, 2}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := decode(common.EncodeHandle("public", "film_actor", []string{"actor_id", "film_id"}, []any{2, 1}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.key != "actor_id=2,film_id=1" || a.key != b.key {
+		t.Errorf("keys %q and %q", a.key, b.key)
+	}
+	if _, err := decode(common.EncodeHandle("public", "film", nil, nil)); err == nil {
+		t.Error("handle without a key: want error")
+	}
+}
+
+func TestCounts(t *testing.T) {
+	h1 := common.EncodeHandle("public", "film_actor", []string{"film_id", "actor_id"}, []any{1, 2})
+	h1b := common.EncodeHandle("public", "film_actor", []string{"actor_id", "film_id"}, []any{2, 1})
+	h2 := common.EncodeHandle("public", "film_actor", []string{"film_id", "actor_id"}, []any{3, 4})
+
+	queries := 0
+	db, _ := sqlfake.Open(func(_ context.Context, c sqlfake.Call) (sqlfake.Result, error) {
+		queries++
+		return sqlfake.Result{Columns: []string{"k", "column_name", "count"}, Rows: [][]any{
+			{"public.film_actor|actor_id=2,film_id=1", "", int64(1)},
+			{"public.film_actor|actor_id=2,film_id=1", "film_id", int64(3)},
+		}}, nil
+	})
+	s := NewStore(db)
+
+	got, err := s.Counts(context.Background(), []string{h1, h1b, h2, h1, "not a handle"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]map[string]int{
+		h1:  {"": 1, "film_id": 3},
+		h1b: {"": 1, "film_id": 3},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Counts = %v, want %v", got, want)
+	}
+
+	queries = 0
+	if got, err := s.Counts(context.Background(), []string{"not a handle"}); err != nil || len(got) != 0 || queries != 0 {
+		t.Errorf("no decodable handles: %v, %v after %d queries", got, err, queries)
+	}
+}
@@ -3,6 +3,8 @@ package common
 import (
 	"encoding/base64"
 	"fmt"
+	"sort"
+	"strconv"
 	"strings"
 )
 
@@ -52,3 +54,54 @@ func DecodeHandle(h string) (schema, table string, pk map[string]any, err error)
 	}
 	return schema, table, pk, nil
 }
+
+// EncodeRowKey returns a stable identity for a result row from its injected
+// _pk_* columns, e.g. base64("_pk_a_actor_id=5,_pk_f_film_id=7"). Columns are
+// sorted so the key doesn't depend on projection order.
+func EncodeRowKey(pkCols []string, pkVals []any) string {
+	idx := make([]int, len(pkCols))
+	for i := range idx {
+		idx[i] = i
+	}
+	sort.Slice(idx, func(a, b int) bool { return pkCols[idx[a]] < pkCols[idx[b]] })
+
+	kvPairs := make([]string, 0, len(pkCols))
+	for _, i := range idx {
+		kvPairs = append(kvPairs, fmt.Sprintf("%s=%s", pkCols[i], FormatKeyValue(pkVals[i])))
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(strings.Join(kvPairs, ",")))
+}
+
+// FormatKeyValue renders a PK value so that the same key compares equal whether it
+// came from the database (int64) or from wal2json (json.Number, or float64 via
+// plain encoding/json).
+func FormatKeyValue(v any) string {
+	switch t := v.(type) {
+	case nil:
+		return "NULL"
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64)
+	case float32:
+		return strconv.FormatFloat(float64(t), 'f', -1, 32)
+	case []byte:
+		return string(t)
+	default:
+		return fmt.Sprintf("%v", t)
+	}
+}
+
+// CatalogName finds name among the catalog's names, falling back to a
+// case-insensitive match as Postgres does for unquoted identifiers.
+func CatalogName(names []string, name string) (string, bool) {
+	for _, n := range names {
+		if n == name {
+			return n, true
+		}
+	}
+	for _, n := range names {
+		if strings.EqualFold(n, name) {
+			return n, true
+		}
+	}
+	return "", false
+}
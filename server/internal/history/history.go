@@ -0,0 +1,207 @@
+// Package history records every query a user runs (HTTP /api/query, a
+// WebSocket subscribe, an SSE stream or a gRPC call) so it can be searched and
+// rerun later.
+package history
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	SourceHTTP = "http"
+	SourceWS   = "ws"
+	SourceSSE  = "sse"
+	SourceGRPC = "grpc"
+)
+
+// ErrNotFound is returned by Get for an ID with no entry of the user's.
+var ErrNotFound = errors.New("history entry not found")
+
+// Entry is a single recorded query execution.
+type Entry struct {
+	ID         int64     `json:"id"`
+	User       string    `json:"user"`
+	Source     string    `json:"source"` // http | ws | sse | grpc
+	SQL        string    `json:"sql"`
+	StartedAt  time.Time `json:"startedAt"`
+	DurationMS float64   `json:"durationMs"`
+	RowCount   *int64    `json:"rowCount,omitempty"` // nil when no rows were fetched (e.g. failed, or subscribe only)
+	Error      *string   `json:"error,omitempty"`
+}
+
+// Filter narrows List results. Zero values mean "no filter".
+type Filter struct {
+	User        string // exact match; empty = all users
+	Search      string // case-insensitive substring of the SQL text
+	Source      string
+	OnlyErrors  *bool // true = failed only, false = succeeded only
+	MinDuration time.Duration
+	Since       time.Time
+	Until       time.Time
+	Limit       int
+	Offset      int
+}
+
+const (
+	defaultLimit = 50
+	maxLimit     = 500
+)
+
+const schemaSQL = `
+CREATE SCHEMA IF NOT EXISTS psv;
+CREATE TABLE IF NOT EXISTS psv.query_history (
+  id          bigserial PRIMARY KEY,
+  user_name   text NOT NULL,
+  source      text NOT NULL,
+  sql         text NOT NULL,
+  started_at  timestamptz NOT NULL,
+  duration_ms double precision NOT NULL,
+  row_count   bigint,
+  error       text
+);
+CREATE INDEX IF NOT EXISTS query_history_user_started_idx
+  ON psv.query_history (user_name, started_at DESC);`
+
+// Store persists history entries in a server-managed table (psv.query_history).
+// The psv schema is kept out of the introspected catalog on purpose.
+type Store struct {
+	db *sql.DB
+}
+
+func NewStore(db *sql.DB) *Store {
+	return &Store{db: db}
+}
+
+// EnsureSchema creates the history table if it does not exist yet.
+func (s *Store) EnsureSchema(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, schemaSQL)
+	return err
+}
+
+// Record inserts an entry. A nil Store is a no-op so callers don't need to guard.
+func (s *Store) Record(ctx context.Context, e Entry) error {
+	if s == nil {
+		return nil
+	}
+	_, err := s.db.ExecContext(ctx, `
+INSERT INTO psv.query_history (user_name, source, sql, started_at, duration_ms, row_count, error)
+VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		e.User, e.Source, e.SQL, e.StartedAt, e.DurationMS, e.RowCount, e.Error,
+	)
+	return err
+}
+
+// Get returns user's entry with the given ID. Another user's entry is
+// ErrNotFound, the same as a missing one, so IDs can't be probed.
+func (s *Store) Get(ctx context.Context, id int64, user string) (Entry, error) {
+	var e Entry
+	var rowCount sql.NullInt64
+	var errText sql.NullString
+	err := s.db.QueryRowContext(ctx, `
+SELECT id, user_name, source, sql, started_at, duration_ms, row_count, error
+FROM psv.query_history WHERE id = $1 AND user_name = $2`, id, user,
+	).Scan(&e.ID, &e.User, &e.Source, &e.SQL, &e.StartedAt, &e.DurationMS, &rowCount, &errText)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Entry{}, ErrNotFound
+	}
+	if err != nil {
+		return Entry{}, err
+	}
+	if rowCount.Valid {
+		n := rowCount.Int64
+		e.RowCount = &n
+	}
+	if errText.Valid {
+		s := errText.String
+		e.Error = &s
+	}
+	return e, nil
+}
+
+// List returns entries matching f, newest first.
+func (s *Store) List(ctx context.Context, f Filter) ([]Entry, error) {
+	var where []string
+	var args []any
+	add := func(cond string, arg any) {
+		args = append(args, arg)
+		where = append(where, fmt.Sprintf(cond, len(args)))
+	}
+
+	if f.User != "" {
+		add("user_name = $%d", f.User)
+	}
+	if f.Search != "" {
+		add("sql ILIKE '%%' || $%d || '%%'", escapeLike(f.Search))
+	}
+	if f.Source != "" {
+		add("source = $%d", f.Source)
+	}
+	if f.OnlyErrors != nil {
+		if *f.OnlyErrors {
+			where = append(where, "error IS NOT NULL")
+		} else {
+			where = append(where, "error IS NULL")
+		}
+	}
+	if f.MinDuration > 0 {
+		add("duration_ms >= $%d", float64(f.MinDuration)/float64(time.Millisecond))
+	}
+	if !f.Since.IsZero() {
+		add("started_at >= $%d", f.Since)
+	}
+	if !f.Until.IsZero() {
+		add("started_at < $%d", f.Until)
+	}
+
+	limit := f.Limit
+	if limit <= 0 {
+		limit = defaultLimit
+	}
+	if limit > maxLimit {
+		limit = maxLimit
+	}
+
+	q := "SELECT id, user_name, source, sql, started_at, duration_ms, row_count, error FROM psv.query_history"
+	if len(where) > 0 {
+		q += " WHERE " + strings.Join(where, " AND ")
+	}
+	args = append(args, limit, f.Offset)
+	q += fmt.Sprintf(" ORDER BY started_at DESC, id DESC LIMIT $%d OFFSET $%d", len(args)-1, len(args))
+
+	rows, err := s.db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := []Entry{}
+	for rows.Next() {
+		var e Entry
+		var rowCount sql.NullInt64
+		var errText sql.NullString
+		if err := rows.Scan(&e.ID, &e.User, &e.Source, &e.SQL, &e.StartedAt, &e.DurationMS, &rowCount, &errText); err != nil {
+			return nil, err
+		}
+		if rowCount.Valid {
+			n := rowCount.Int64
+			e.RowCount = &n
+		}
+		if errText.Valid {
+			s := errText.String
+			e.Error = &s
+		}
+		out = append(out, e)
+	}
+	return out, rows.Err()
+}
+
+// escapeLike escapes LIKE wildcards so Search is matched literally.
+func escapeLike(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+	return r.Replace(s)
+}
@@ -0,0 +1,224 @@
+package history
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zoravur/postgres-spreadsheet-view/server/internal/sqlfake"
+)
+
+// fakeTable answers Store's SELECTs from entries, newest first, applying the
+// conditions it finds in the query the way Postgres would: user_name,
+// source and the ILIKE search filter, then LIMIT and OFFSET. Conditions the
+// query leaves out don't filter, so a missing one shows up as extra rows.
+func fakeTable(entries []Entry) *Store {
+	var (
+		userRe   = regexp.MustCompile(`user_name = \$(\d+)`)
+		idRe     = regexp.MustCompile(`id = \$(\d+)`)
+		sourceRe = regexp.MustCompile(`source = \$(\d+)`)
+		searchRe = regexp.MustCompile(`sql ILIKE '%' \|\| \$(\d+) \|\| '%'`)
+		pageRe   = regexp.MustCompile(`LIMIT \$(\d+) OFFSET \$(\d+)`)
+	)
+	arg := func(c sqlfake.Call, re *regexp.Regexp) (any, bool) {
+		m := re.FindStringSubmatch(c.Query)
+		if m == nil {
+			return nil, false
+		}
+		n, _ := strconv.Atoi(m[1])
+		return c.Args[n-1], true
+	}
+	db, _ := sqlfake.Open(func(_ context.Context, c sqlfake.Call) (sqlfake.Result, error) {
+		res := sqlfake.Result{Columns: []string{"id", "user_name", "source", "sql", "started_at", "duration_ms", "row_count", "error"}}
+		for i := len(entries) - 1; i >= 0; i-- {
+			e := entries[i]
+			if v, ok := arg(c, userRe); ok && v != e.User {
+				continue
+			}
+			if v, ok := arg(c, idRe); ok && v != e.ID {
+				continue
+			}
+			if v, ok := arg(c, sourceRe); ok && v != e.Source {
+				continue
+			}
+			if v, ok := arg(c, searchRe); ok && !ilike(e.SQL, v.(string)) {
+				continue
+			}
+			res.Rows = append(res.Rows, []any{e.ID, e.User, e.Source, e.SQL, e.StartedAt, e.DurationMS, nil, nil})
+		}
+		if m := pageRe.FindStringSubmatch(c.Query); m != nil {
+			li, _ := strconv.Atoi(m[1])
+			oi, _ := strconv.Atoi(m[2])
+			limit, offset := c.Args[li-1].(int), c.Args[oi-1].(int)
+			res.Rows = res.Rows[min(offset, len(res.Rows)):]
+			res.Rows = res.Rows[:min(limit, len(res.Rows))]
+		}
+		return res, nil
+	})
+	return NewStore(db)
+}
+
+// ilike matches s against '%' || pattern || '%' case-insensitively, with
+// backslash escapes as in Postgres' default LIKE escape.
+func ilike(s, pattern string) bool {
+	var re strings.Builder
+	re.WriteString("(?is)^.*")
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+				re.WriteString(regexp.QuoteMeta(string(pattern[i])))
+			}
+		case '%':
+			re.WriteString(".*")
+		case '_':
+			re.WriteString(".")
+		default:
+			re.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	re.WriteString(".*$")
+	return regexp.MustCompile(re.String()).MatchString(s)
+}
+
+func entries(n int) []Entry {
+	start := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	out := make([]Entry, n)
+	for i := range out {
+		user := "ana"
+		if i%2 == 1 {
+			user = "bo"
+		}
+		out[i] = Entry{ID: int64(i + 1), User: user, Source: SourceHTTP, SQL: "SELECT " + strconv.Itoa(i+1), StartedAt: start.Add(time.Duration(i) * time.Minute)}
+	}
+	return out
+}
+
+func ids(es []Entry) []int64 {
+	out := []int64{}
+	for _, e := range es {
+		out = append(out, e.ID)
+	}
+	return out
+}
+
+func TestGetScopedToUser(t *testing.T) {
+	s := fakeTable(entries(4)) // ana: 1, 3; bo: 2, 4
+	ctx := context.Background()
+
+	e, err := s.Get(ctx, 3, "ana")
+	if err != nil || e.ID != 3 || e.User != "ana" {
+		t.Fatalf("Get(3, ana) = %+v, %v", e, err)
+	}
+	if _, err := s.Get(ctx, 2, "ana"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get(2, ana) of bo's entry: err = %v, want ErrNotFound", err)
+	}
+	if _, err := s.Get(ctx, 99, "ana"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get(99, ana): err = %v, want ErrNotFound", err)
+	}
+}
+func TestListScopedToUser(t *testing.T) {
+	s := fakeTable(entries(6))
+	got, err := s.List(context.Background(), Filter{User: "bo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []int64{6, 4, 2}; !reflect.DeepEqual(ids(got), want) {
+		t.Errorf("List(bo) = %v, want %v", ids(got), want)
+	}
+	for _, e := range got {
+		if e.User != "bo" {
+			t.Errorf("List(bo) returned %s's entry %d", e.User, e.ID)
+		}
+	}
+}
+
+func TestListSearchIsLiteral(t *testing.T) {
+	es := []Entry{
+		{ID: 1, User: "ana", SQL: "SELECT * FROM film WHERE rate > 50"},
+		{ID: 2, User: "ana", SQL: "SELECT '50%' AS pct"},
+		{ID: 3, User: "ana", SQL: "SELECT film_id FROM film"},
+		{ID: 4, User: "ana", SQL: "SELECT filmXid FROM film"},
+		{ID: 5, User: "ana", SQL: `SELECT 'a\b'`},
+	}
+	s := fakeTable(es)
+	tests := []struct {
+		search string
+		want   []int64
+	}{
+		{"50%", []int64{2}},
+		{"film_id", []int64{3}},
+		{`a\b`, []int64{5}},
+		{"FILM_ID", []int64{3}}, // still case-insensitive
+	}
+	for _, tt := range tests {
+		got, err := s.List(context.Background(), Filter{User: "ana", Search: tt.search})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(ids(got), tt.want) {
+			t.Errorf("search %q = %v, want %v", tt.search, ids(got), tt.want)
+		}
+	}
+}
+
+func TestEscapeLike(t *testing.T) {
+	tests := map[string]string{
+		"plain":   "plain",
+		"50%":     `50\%`,
+		"film_id": `film\_id`,
+		`a\b`:     `a\\b`,
+		`\%_`:     `\\\%\_`,
+	}
+	for in, want := range tests {
+		if got := escapeLike(in); got != want {
+			t.Errorf("escapeLike(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestListPaging(t *testing.T) {
+	s := fakeTable(entries(600)) // 300 each
+	ctx := context.Background()
+	tests := []struct {
+		name          string
+		limit, offset int
+		wantLen       int
+		wantFirst     int64
+	}{
+		{"default limit", 0, 0, defaultLimit, 599},
+		{"negative limit", -5, 0, defaultLimit, 599},
+		{"explicit", 10, 0, 10, 599},
+		{"offset", 10, 10, 10, 579},
+		{"all of them", 1000, 0, 300, 599}, // ana has 300, under maxLimit
+		{"past the end", 10, 300, 0, 0},
+	}
+	for _, tt := range tests {
+		got, err := s.List(ctx, Filter{User: "ana", Limit: tt.limit, Offset: tt.offset})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != tt.wantLen {
+			t.Errorf("%s: %d entries, want %d", tt.name, len(got), tt.wantLen)
+			continue
+		}
+		if len(got) > 0 && got[0].ID != tt.wantFirst {
+			t.Errorf("%s: first = %d, want %d", tt.name, got[0].ID, tt.wantFirst)
+		}
+	}
+
+	all := fakeTable(entries(1200))
+	got, err := all.List(ctx, Filter{User: "ana", Limit: 1000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != maxLimit {
+		t.Errorf("limit 1000: %d entries, want maxLimit %d", len(got), maxLimit)
+	}
+}
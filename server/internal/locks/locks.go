@@ -0,0 +1,415 @@
+// Package locks leases cells to the user editing them. Leases are soft by
+// default: edits from anyone but the holder are rejected until it releases the
+// cell or stops renewing it. A hard lease also holds the row with
+// SELECT ... FOR UPDATE NOWAIT, so writes from outside this server wait too.
+// One owner's hard leases on the same row share that row lock, and all of the
+// owner's edits to the row go through its transaction.
+package locks
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+	"go.uber.org/zap"
+
+	"github.com/zoravur/postgres-spreadsheet-view/server/internal/common"
+	"github.com/zoravur/postgres-spreadsheet-view/server/internal/metrics"
+	"github.com/zoravur/postgres-spreadsheet-view/server/internal/protocol"
+)
+
+var (
+	mLocksHeld    = metrics.NewGauge("cell_locks_held")
+	mLockConflict = metrics.NewCounter("cell_lock_conflicts_total")
+	mLockExpired  = metrics.NewCounter("cell_locks_expired_total")
+)
+
+// DefaultTTL is how long a lease lasts without being renewed.
+const DefaultTTL = 30 * time.Second
+
+// DefaultMaxHard is how many hard leases may be held at once. Each pins a
+// database connection for as long as it lasts, so without a cap idle editors
+// could take every connection the queries need.
+const DefaultMaxHard = 16
+
+// Lock is a lease on one cell, as other clients are told about it.
+type Lock = protocol.Lock
+
+// LockedError is returned for a cell someone else holds.
+type LockedError struct{ Lock Lock }
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("cell locked by %s until %s", e.Lock.User, e.Lock.Expires.Format(time.RFC3339))
+}
+
+// ErrRowLocked is returned when a hard lease can't take the row because a
+// transaction outside this service holds it.
+var ErrRowLocked = errors.New("row is locked in the database")
+
+// ErrTooManyHard is returned for a hard claim while MaxHard hard leases are
+// already held.
+var ErrTooManyHard = errors.New("too many hard locks held; try again later or use a soft lock")
+
+var errNotHeld = errors.New("cell is not locked by you")
+
+type lease struct {
+	Lock
+	owner string
+	row   *rowLock // hard leases: the owner's lock on the row
+	timer *time.Timer
+}
+
+// rowLock is one owner's hold on a row: the transaction that took it FOR
+// UPDATE, shared by the owner's hard leases on the row's cells.
+type rowLock struct {
+	tx    *sql.Tx
+	cells int // hard leases using it
+}
+
+// rowOwner keys row locks: a row (rowKey) and the owner holding it.
+type rowOwner struct{ row, owner string }
+
+// Service hands out leases. Notify, if set, is told of every change: "lock"
+// with the Lock when a cell is claimed or renewed, "unlock" when it is
+// released, expires or is spent on an edit. MaxHard caps the hard leases held
+// at once (and so the connections they pin); 0 means DefaultMaxHard.
+type Service struct {
+	DB      *sql.DB
+	TTL     time.Duration
+	MaxHard int
+	Notify  func(msgType protocol.Type, payload any)
+
+	mu     sync.Mutex
+	leases map[string]*lease     // by cellKey
+	rows   map[rowOwner]*rowLock // hard row locks
+	hard   int                   // row transactions open or being opened
+}
+
+func NewService(db *sql.DB) *Service {
+	return &Service{DB: db, TTL: DefaultTTL, MaxHard: DefaultMaxHard, leases: map[string]*lease{}, rows: map[rowOwner]*rowLock{}}
+}
+
+// Claim leases a cell to owner (one connection; user is who it shows as), or
+// renews owner's lease. A hard claim also locks the row in the database, and
+// upgrades a soft lease owner already has; it reuses the row lock when owner
+// already holds one on another cell of the row.
+func (s *Service) Claim(ctx context.Context, owner, user, handle, column string, hard bool) (Lock, error) {
+	row, err := rowKey(handle)
+	if err != nil {
+		return Lock{}, err
+	}
+	key, err := cellKey(row, column)
+	if err != nil {
+		return Lock{}, err
+	}
+	ro := rowOwner{row, owner}
+
+	for {
+		s.mu.Lock()
+		l := s.leases[key]
+		if l != nil && l.owner != owner {
+			s.mu.Unlock()
+			mLockConflict.Inc()
+			return Lock{}, &LockedError{Lock: l.Lock}
+		}
+		needTx := hard && (l == nil || l.row == nil) && s.rows[ro] == nil
+		if needTx {
+			// the slot is taken before the round trip so concurrent claims
+			// can't overshoot the cap
+			if s.hard >= s.maxHard() {
+				s.mu.Unlock()
+				return Lock{}, ErrTooManyHard
+			}
+			s.hard++
+		}
+		s.mu.Unlock()
+
+		// NOWAIT fails at once rather than blocking, but it is still a round
+		// trip, so it runs outside mu; losing a race to another claimer rolls
+		// it back
+		var tx *sql.Tx
+		if needTx {
+			if tx, err = s.lockRow(ctx, handle); err != nil {
+				s.mu.Lock()
+				s.hard--
+				s.mu.Unlock()
+				return Lock{}, err
+			}
+		}
+
+		s.mu.Lock()
+		lk, retry, err := s.claimLocked(key, ro, owner, user, handle, column, hard, tx)
+		s.mu.Unlock()
+		if !retry {
+			return lk, err
+		}
+	}
+}
+
+// claimLocked finishes a Claim once any row transaction it needed is open.
+// It reports retry when owner's row lock went away while mu was released,
+// so the claim has to take a new one. Called with mu held.
+func (s *Service) claimLocked(key string, ro rowOwner, owner, user, handle, column string, hard bool, tx *sql.Tx) (Lock, bool, error) {
+	l := s.leases[key]
+	if l != nil && l.owner != owner {
+		if tx != nil {
+			_ = tx.Rollback()
+			s.hard--
+		}
+		mLockConflict.Inc()
+		return Lock{}, false, &LockedError{Lock: l.Lock}
+	}
+	rl := s.rows[ro]
+	if tx != nil {
+		if rl != nil {
+			// owner's own concurrent claim locked the row first
+			_ = tx.Rollback()
+			s.hard--
+		} else {
+			rl = &rowLock{tx: tx}
+			s.rows[ro] = rl
+		}
+	}
+	if hard && (l == nil || l.row == nil) && rl == nil {
+		return Lock{}, true, nil
+	}
+
+	if l == nil {
+		l = &lease{Lock: Lock{EditHandle: handle, Column: column}, owner: owner}
+		s.leases[key] = l
+		mLocksHeld.Add(1)
+		l.timer = time.AfterFunc(s.TTL, func() { s.expire(key, l) })
+	} else {
+		l.timer.Reset(s.TTL)
+	}
+	if hard && l.row == nil {
+		l.row, l.Hard = rl, true
+		rl.cells++
+	}
+	l.User = user
+	l.Expires = time.Now().Add(s.TTL)
+	s.notify(protocol.TypeLock, l.Lock)
+	return l.Lock, false, nil
+}
+
+// Release ends owner's lease on a cell.
+func (s *Service) Release(owner, handle, column string) error {
+	row, err := rowKey(handle)
+	if err != nil {
+		return err
+	}
+	key, err := cellKey(row, column)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l := s.leases[key]
+	if l == nil || l.owner != owner {
+		return errNotHeld
+	}
+	s.dropLocked(key, l)
+	return nil
+}
+
+// ReleaseAll ends every lease owner holds, e.g. when its connection closes.
+func (s *Service) ReleaseAll(owner string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, l := range s.leases {
+		if l.owner == owner {
+			s.dropLocked(key, l)
+		}
+	}
+}
+
+// Locks returns every current lease, for a client that just connected.
+func (s *Service) Locks() []Lock {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	out := make([]Lock, 0, len(s.leases))
+	for _, l := range s.leases {
+		out = append(out, l.Lock)
+	}
+	return out
+}
+
+// ForEdit checks that owner may write a cell: it is unlocked or owner holds
+// it, and no one else holds its row under a hard lease. An empty owner holds
+// nothing. When owner holds the row under a hard lease (on this cell or
+// another of the row), its transaction is returned and the row lock ends:
+// the caller writes in it and commits or rolls it back, which releases the
+// row. A hard lease on this cell is spent on the edit; owner's hard leases
+// on the row's other cells carry on as soft ones.
+func (s *Service) ForEdit(owner, handle, column string) (*sql.Tx, error) {
+	row, err := rowKey(handle)
+	if err != nil {
+		return nil, err
+	}
+	key, err := cellKey(row, column)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	l := s.leases[key]
+	if l != nil && (l.owner != owner || owner == "") {
+		mLockConflict.Inc()
+		return nil, &LockedError{Lock: l.Lock}
+	}
+	// writing outside another owner's row lock would block until it ends
+	for ro, rl := range s.rows {
+		if ro.row == row && (ro.owner != owner || owner == "") {
+			mLockConflict.Inc()
+			return nil, &LockedError{Lock: s.holder(rl)}
+		}
+	}
+	rl := s.rows[rowOwner{row, owner}]
+	if rl == nil {
+		return nil, nil
+	}
+
+	delete(s.rows, rowOwner{row, owner})
+	s.hard--
+	for k, other := range s.leases {
+		if other.row != rl {
+			continue
+		}
+		other.row, other.Hard = nil, false
+		if k == key {
+			s.dropLocked(k, other)
+		} else {
+			s.notify(protocol.TypeLock, other.Lock)
+		}
+	}
+	return rl.tx, nil
+}
+
+// holder is a lease on rl's row, to report who holds it. Called with mu held.
+func (s *Service) holder(rl *rowLock) Lock {
+	for _, l := range s.leases {
+		if l.row == rl {
+			return l.Lock
+		}
+	}
+	return Lock{}
+}
+
+func (s *Service) maxHard() int {
+	if s.MaxHard <= 0 {
+		return DefaultMaxHard
+	}
+	return s.MaxHard
+}
+
+// expire ends a lease its owner stopped renewing.
+func (s *Service) expire(key string, l *lease) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.leases[key] != l || time.Now().Before(l.Expires) {
+		return // released, or renewed after the timer fired
+	}
+	mLockExpired.Inc()
+	zap.L().Info("cell_lock_expired", zap.String("user", l.User), zap.String("column", l.Column))
+	s.dropLocked(key, l)
+}
+
+// dropLocked removes a lease. The last hard lease on a row rolls back the
+// row lock. Called with mu held.
+func (s *Service) dropLocked(key string, l *lease) {
+	delete(s.leases, key)
+	mLocksHeld.Add(-1)
+	l.timer.Stop()
+	if rl := l.row; rl != nil {
+		l.row = nil
+		if rl.cells--; rl.cells == 0 {
+			for ro, x := range s.rows {
+				if x == rl {
+					delete(s.rows, ro)
+				}
+			}
+			_ = rl.tx.Rollback()
+			s.hard--
+		}
+	}
+	s.notify(protocol.TypeUnlock, Lock{EditHandle: l.EditHandle, Column: l.Column, User: l.User})
+}
+
+func (s *Service) notify(msgType protocol.Type, l Lock) {
+	if s.Notify != nil {
+		s.Notify(msgType, l)
+	}
+}
+
+// lockRow opens a transaction holding the handle's row FOR UPDATE NOWAIT.
+func (s *Service) lockRow(ctx context.Context, handle string) (*sql.Tx, error) {
+	schema, table, pk, err := common.DecodeHandle(handle)
+	if err != nil {
+		return nil, fmt.Errorf("invalid handle: %w", err)
+	}
+	if len(pk) == 0 {
+		return nil, fmt.Errorf("no primary key info in handle")
+	}
+	where := make([]string, 0, len(pk))
+	args := make([]any, 0, len(pk))
+	for col, val := range pk {
+		args = append(args, val)
+		where = append(where, fmt.Sprintf("%s = $%d", pq.QuoteIdentifier(col), len(args)))
+	}
+	stmt := fmt.Sprintf(`SELECT 1 FROM %s.%s WHERE %s FOR UPDATE NOWAIT`,
+		pq.QuoteIdentifier(schema), pq.QuoteIdentifier(table), strings.Join(where, " AND "))
+
+	// the lease outlives the request that claimed it
+	tx, err := s.DB.BeginTx(context.WithoutCancel(ctx), nil)
+	if err != nil {
+		return nil, err
+	}
+	var one int
+	err = tx.QueryRowContext(ctx, stmt, args...).Scan(&one)
+	if err == nil {
+		return tx, nil
+	}
+	_ = tx.Rollback()
+	var pqErr *pq.Error
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return nil, fmt.Errorf("no row matches the edit handle")
+	case errors.As(err, &pqErr) && pqErr.Code == "55P03": // lock_not_available
+		return nil, ErrRowLocked
+	default:
+		return nil, fmt.Errorf("row lock failed: %w", err)
+	}
+}
+
+// rowKey identifies a row by table and primary key, independent of the order
+// the handle lists key columns in.
+func rowKey(handle string) (string, error) {
+	schema, table, pk, err := common.DecodeHandle(handle)
+	if err != nil {
+		return "", fmt.Errorf("invalid handle: %w", err)
+	}
+	cols := make([]string, 0, len(pk))
+	for col := range pk {
+		cols = append(cols, col)
+	}
+	sort.Strings(cols)
+	kv := make([]string, len(cols))
+	for i, col := range cols {
+		kv[i] = col + "=" + common.FormatKeyValue(pk[col])
+	}
+	return schema + "." + table + "|" + strings.Join(kv, ","), nil
+}
+
+// cellKey identifies a cell of the row with rowKey row.
+func cellKey(row, column string) (string, error) {
+	if column == "" {
+		return "", fmt.Errorf("missing column")
+	}
+	return row + "|" + column, nil
+}
@@ -0,0 +1,338 @@
+package locks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+
+	"github.com/zoravur/postgres-spreadsheet-view/server/internal/common"
+	"github.com/zoravur/postgres-spreadsheet-view/server/internal/protocol"
+	"github.com/zoravur/postgres-spreadsheet-view/server/internal/sqlfake"
+)
+
+// Hard claims past MaxHard fail before touching the database; soft claims
+// are unaffected.
+func TestClaimHardCap(t *testing.T) {
+	s := NewService(nil)
+	s.MaxHard = 2
+	s.hard = 2 // as if two hard leases were held
+	h := common.EncodeHandle("public", "film", []string{"id"}, []any{1})
+
+	if _, err := s.Claim(context.Background(), "c1", "ana", h, "title", true); !errors.Is(err, ErrTooManyHard) {
+		t.Fatalf("hard claim at the cap: err = %v, want ErrTooManyHard", err)
+	}
+	if s.hard != 2 {
+		t.Errorf("hard = %d after a refused claim, want 2", s.hard)
+	}
+	if _, err := s.Claim(context.Background(), "c1", "ana", h, "title", false); err != nil {
+		t.Fatalf("soft claim at the cap: %v", err)
+	}
+	if err := s.Release("c1", h, "title"); err != nil {
+		t.Fatal(err)
+	}
+	if s.hard != 2 {
+		t.Errorf("hard = %d after releasing a soft lease, want 2", s.hard)
+	}
+}
+
+// fakeRows is a database whose FOR UPDATE NOWAIT finds the row, or fails
+// with lock_not_available while *busy is set.
+func fakeRows(busy *bool) (*Service, *sqlfake.DB) {
+	db, fake := sqlfake.Open(func(_ context.Context, c sqlfake.Call) (sqlfake.Result, error) {
+		if strings.Contains(c.Query, "FOR UPDATE NOWAIT") && busy != nil && *busy {
+			return sqlfake.Result{}, &pq.Error{Code: "55P03", Message: "could not obtain lock on row"}
+		}
+		return sqlfake.Result{Columns: []string{"?column?"}, Rows: [][]any{{int64(1)}}}, nil
+	})
+	return NewService(db), fake
+}
+
+// notifications records what a Service tells its Notify.
+type notifications struct {
+	mu  sync.Mutex
+	got []string
+}
+
+func (n *notifications) notify(msgType protocol.Type, payload any) {
+	l := payload.(Lock)
+	s := fmt.Sprintf("%s %s %s", msgType, l.Column, l.User)
+	if l.Hard {
+		s += " hard"
+	}
+	n.mu.Lock()
+	n.got = append(n.got, s)
+	n.mu.Unlock()
+}
+
+func (n *notifications) list() []string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return append([]string(nil), n.got...)
+}
+
+// count is how many entries of log end in suffix.
+func count(log []string, suffix string) int {
+	n := 0
+	for _, e := range log {
+		if strings.HasSuffix(e, suffix) {
+			n++
+		}
+	}
+	return n
+}
+
+var (
+	film1 = common.EncodeHandle("public", "film", []string{"id"}, []any{1})
+	film2 = common.EncodeHandle("public", "film", []string{"id"}, []any{2})
+)
+
+func TestClaimConflicts(t *testing.T) {
+	ctx := context.Background()
+	s, _ := fakeRows(nil)
+
+	if _, err := s.Claim(ctx, "c1", "ana", film1, "title", false); err != nil {
+		t.Fatal(err)
+	}
+	for _, hard := range []bool{false, true} {
+		_, err := s.Claim(ctx, "c2", "bo", film1, "title", hard)
+		var le *LockedError
+		if !errors.As(err, &le) || le.Lock.User != "ana" {
+			t.Errorf("claim by another owner (hard %v): err = %v, want LockedError by ana", hard, err)
+		}
+	}
+	// other cells and rows are free
+	if _, err := s.Claim(ctx, "c2", "bo", film1, "rating", false); err != nil {
+		t.Errorf("other column: %v", err)
+	}
+	if _, err := s.Claim(ctx, "c2", "bo", film2, "title", false); err != nil {
+		t.Errorf("other row: %v", err)
+	}
+	if _, err := s.Claim(ctx, "c1", "ana", film1, "", false); err == nil {
+		t.Error("claim without a column: want error")
+	}
+	if _, err := s.Claim(ctx, "c1", "ana", "not a handle", "title", false); err == nil {
+		t.Error("claim with a bad handle: want error")
+	}
+
+	// a row held in the database by someone outside this server
+	busy := true
+	s2, _ := fakeRows(&busy)
+	if _, err := s2.Claim(ctx, "c1", "ana", film1, "title", true); !errors.Is(err, ErrRowLocked) {
+		t.Errorf("hard claim on a locked row: err = %v, want ErrRowLocked", err)
+	}
+	if s2.hard != 0 || len(s2.Locks()) != 0 {
+		t.Errorf("after a failed hard claim: hard = %d, locks = %v", s2.hard, s2.Locks())
+	}
+}
+
+func TestClaimRenews(t *testing.T) {
+	ctx := context.Background()
+	s, _ := fakeRows(nil)
+	s.TTL = 60 * time.Millisecond
+
+	first, err := s.Claim(ctx, "c1", "ana", film1, "title", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// renewing every 30ms keeps the lease well past one TTL
+	for i := 0; i < 4; i++ {
+		time.Sleep(30 * time.Millisecond)
+		l, err := s.Claim(ctx, "c1", "ana", film1, "title", false)
+		if err != nil {
+			t.Fatalf("renewal %d: %v", i, err)
+		}
+		if !l.Expires.After(first.Expires) {
+			t.Errorf("renewal %d: expires %v, not after %v", i, l.Expires, first.Expires)
+		}
+	}
+	if got := len(s.Locks()); got != 1 {
+		t.Fatalf("renewed lease expired: %d locks", got)
+	}
+
+	// renewing as hard upgrades the lease in place
+	l, err := s.Claim(ctx, "c1", "ana", film1, "title", true)
+	if err != nil || !l.Hard {
+		t.Fatalf("upgrade: %+v, %v", l, err)
+	}
+	if len(s.Locks()) != 1 || s.hard != 1 {
+		t.Errorf("after upgrade: locks = %v, hard = %d", s.Locks(), s.hard)
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	ctx := context.Background()
+	s, fake := fakeRows(nil)
+	s.TTL = 20 * time.Millisecond
+	var n notifications
+	s.Notify = n.notify
+
+	if _, err := s.Claim(ctx, "c1", "ana", film1, "title", true); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for len(s.Locks()) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("lease never expired")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := count(fake.Log(), " rollback"); got != 1 {
+		t.Errorf("%d rollbacks of the row lock, want 1: %v", got, fake.Log())
+	}
+	if want := []string{"lock title ana hard", "unlock title ana"}; !reflect.DeepEqual(n.list(), want) {
+		t.Errorf("notifications = %v, want %v", n.list(), want)
+	}
+	// the cell is free again
+	if _, err := s.Claim(ctx, "c2", "bo", film1, "title", false); err != nil {
+		t.Errorf("claim after expiry: %v", err)
+	}
+}
+
+func TestRelease(t *testing.T) {
+	ctx := context.Background()
+	s, fake := fakeRows(nil)
+	for _, c := range []struct {
+		owner, handle, column string
+		hard                  bool
+	}{
+		{"c1", film1, "title", true},
+		{"c1", film1, "rating", true},
+		{"c1", film2, "title", false},
+		{"c2", film2, "rating", false},
+	} {
+		if _, err := s.Claim(ctx, c.owner, c.owner, c.handle, c.column, c.hard); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := s.Release("c2", film1, "title"); err == nil {
+		t.Error("release of another owner's lease: want error")
+	}
+	if err := s.Release("c1", film1, "title"); err != nil {
+		t.Fatal(err)
+	}
+	// rating still holds the row
+	if got := count(fake.Log(), " rollback"); got != 0 {
+		t.Errorf("row lock rolled back while a hard lease still uses it: %v", fake.Log())
+	}
+	if err := s.Release("c1", film1, "title"); err == nil {
+		t.Error("second release: want error")
+	}
+
+	s.ReleaseAll("c1")
+	if got := count(fake.Log(), " rollback"); got != 1 {
+		t.Errorf("%d rollbacks after ReleaseAll, want 1: %v", got, fake.Log())
+	}
+	locks := s.Locks()
+	if len(locks) != 1 || locks[0].User != "c2" {
+		t.Errorf("after ReleaseAll(c1): %v, want only c2's", locks)
+	}
+	if s.hard != 0 || len(s.rows) != 0 {
+		t.Errorf("after ReleaseAll: hard = %d, rows = %v", s.hard, s.rows)
+	}
+}
+
+func TestForEditSoft(t *testing.T) {
+	ctx := context.Background()
+	s, _ := fakeRows(nil)
+	if _, err := s.Claim(ctx, "c1", "ana", film1, "title", false); err != nil {
+		t.Fatal(err)
+	}
+
+	if tx, err := s.ForEdit("c1", film1, "title"); tx != nil || err != nil {
+		t.Errorf("holder: %v, %v; want no tx, no error", tx, err)
+	}
+	for _, owner := range []string{"c2", ""} {
+		var le *LockedError
+		if _, err := s.ForEdit(owner, film1, "title"); !errors.As(err, &le) {
+			t.Errorf("owner %q: err = %v, want LockedError", owner, err)
+		}
+	}
+	if tx, err := s.ForEdit("c2", film1, "rating"); tx != nil || err != nil {
+		t.Errorf("unlocked cell: %v, %v", tx, err)
+	}
+	// a soft lease survives its holder's edit
+	if len(s.Locks()) != 1 {
+		t.Errorf("locks after edits = %v", s.Locks())
+	}
+}
+
+func TestForEditHard(t *testing.T) {
+	ctx := context.Background()
+	s, fake := fakeRows(nil)
+	if _, err := s.Claim(ctx, "c1", "ana", film1, "title", true); err != nil {
+		t.Fatal(err)
+	}
+	// a second hard claim on the row shares its lock instead of failing
+	// NOWAIT against it
+	if _, err := s.Claim(ctx, "c1", "ana", film1, "rating", true); err != nil {
+		t.Fatalf("second hard claim on the row: %v", err)
+	}
+	if got := count(fake.Log(), " begin"); got != 1 || s.hard != 1 {
+		t.Fatalf("two hard leases on a row: %d transactions, hard = %d", got, s.hard)
+	}
+
+	// nobody else may write the row, even an unleased cell of it
+	for _, owner := range []string{"c2", ""} {
+		var le *LockedError
+		if _, err := s.ForEdit(owner, film1, "length"); !errors.As(err, &le) || le.Lock.User != "ana" {
+			t.Errorf("owner %q on the hard-locked row: err = %v, want LockedError by ana", owner, err)
+		}
+	}
+
+	// the holder's edit to an unleased cell of the row goes through the row
+	// lock's transaction instead of blocking on it
+	tx, err := s.ForEdit("c1", film1, "length")
+	if err != nil || tx == nil {
+		t.Fatalf("holder editing another cell of the row: %v, %v; want the row transaction", tx, err)
+	}
+	if _, err := tx.Exec("UPDATE film SET length = 1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	log := fake.Log()
+	if !strings.HasPrefix(log[len(log)-2], "tx1 UPDATE") || log[len(log)-1] != "tx1 commit" {
+		t.Errorf("edit did not run in the row lock's transaction: %v", log)
+	}
+
+	// the leases carry on, soft, and the row is free
+	locks := s.Locks()
+	if len(locks) != 2 {
+		t.Fatalf("locks after the edit = %v", locks)
+	}
+	for _, l := range locks {
+		if l.Hard {
+			t.Errorf("%s still hard after its row lock was spent", l.Column)
+		}
+	}
+	if s.hard != 0 || len(s.rows) != 0 {
+		t.Errorf("after the edit: hard = %d, rows = %v", s.hard, s.rows)
+	}
+	if tx, err := s.ForEdit("c1", film1, "length"); tx != nil || err != nil {
+		t.Errorf("second edit: %v, %v; want a fresh transaction", tx, err)
+	}
+
+	// a hard lease on the edited cell is spent on the edit
+	if _, err := s.Claim(ctx, "c1", "ana", film1, "title", true); err != nil {
+		t.Fatal(err)
+	}
+	tx, err = s.ForEdit("c1", film1, "title")
+	if err != nil || tx == nil {
+		t.Fatalf("holder editing its hard cell: %v, %v", tx, err)
+	}
+	_ = tx.Rollback()
+	for _, l := range s.Locks() {
+		if l.Column == "title" {
+			t.Errorf("hard lease on title outlived its edit: %+v", l)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+// Package metrics keeps process-wide counters and gauges and serves them as
+// JSON. It is deliberately tiny: named int64 values, no labels, no histograms.
+package metrics
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// Counter only goes up.
+type Counter struct{ v atomic.Int64 }
+
+func (c *Counter) Inc()         { c.v.Add(1) }
+func (c *Counter) Add(n int64)  { c.v.Add(n) }
+func (c *Counter) Value() int64 { return c.v.Load() }
+
+// Gauge goes up and down.
+type Gauge struct{ v atomic.Int64 }
+
+func (g *Gauge) Set(n int64)  { g.v.Store(n) }
+func (g *Gauge) Add(n int64)  { g.v.Add(n) }
+func (g *Gauge) Value() int64 { return g.v.Load() }
+
+type valuer interface{ Value() int64 }
+
+var (
+	mu      sync.RWMutex
+	entries = map[string]valuer{}
+)
+
+// NewCounter returns the counter registered under name, creating it if needed.
+func NewCounter(name string) *Counter {
+	mu.Lock()
+	defer mu.Unlock()
+	if c, ok := entries[name].(*Counter); ok {
+		return c
+	}
+	c := &Counter{}
+	entries[name] = c
+	return c
+}
+
+// NewGauge returns the gauge registered under name, creating it if needed.
+func NewGauge(name string) *Gauge {
+	mu.Lock()
+	defer mu.Unlock()
+	if g, ok := entries[name].(*Gauge); ok {
+		return g
+	}
+	g := &Gauge{}
+	entries[name] = g
+	return g
+}
+
+// Snapshot returns the current value of every metric.
+func Snapshot() map[string]int64 {
+	mu.RLock()
+	defer mu.RUnlock()
+	out := make(map[string]int64, len(entries))
+	for name, e := range entries {
+		out[name] = e.Value()
+	}
+	return out
+}
+
+// Handler serves Snapshot as JSON.
+func Handler(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	_ = json.NewEncoder(w).Encode(Snapshot())
+}
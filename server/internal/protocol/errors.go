@@ -0,0 +1,67 @@
+package protocol
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrorCode classifies an "error" message. Codes are stable; messages are for
+// people and may change.
+type ErrorCode string
+
+const (
+	// CodeBadRequest: malformed JSON, a missing or invalid field.
+	CodeBadRequest ErrorCode = "bad_request"
+	// CodeHandshakeRequired: a request before the "hello".
+	CodeHandshakeRequired ErrorCode = "handshake_required"
+	// CodeUnsupportedVersion: the hello's version isn't spoken here.
+	CodeUnsupportedVersion ErrorCode = "unsupported_version"
+	// CodeUnsupported: the request needs a capability the connection didn't
+	// negotiate, or the server has it disabled.
+	CodeUnsupported ErrorCode = "unsupported"
+	// CodeUnknownType: the request type doesn't exist.
+	CodeUnknownType ErrorCode = "unknown_type"
+	// CodeParseError: the SQL doesn't parse.
+	CodeParseError ErrorCode = "parse_error"
+	// CodeQueryFailed: the SQL parses but can't be analyzed or run, or a live
+	// query stopped working (e.g. after a schema change).
+	CodeQueryFailed ErrorCode = "query_failed"
+	// CodeNotFound: no such subscription.
+	CodeNotFound ErrorCode = "not_found"
+	// CodeNotEditable: the edit handle doesn't name a row that can be written.
+	CodeNotEditable ErrorCode = "not_editable"
+	// CodeInvalidValue: the database rejected the value (type, NOT NULL,
+	// CHECK, foreign key...).
+	CodeInvalidValue ErrorCode = "invalid_value"
+	// CodePermissionDenied: the database role may not do it.
+	CodePermissionDenied ErrorCode = "permission_denied"
+	// CodeConflict: someone else holds the cell or row, or the write lost a
+	// race (unique violation, serialization failure, deadlock).
+	CodeConflict ErrorCode = "conflict"
+	// CodeInternal: anything else.
+	CodeInternal ErrorCode = "internal"
+)
+
+// Error is the payload of an "error" message, and an error carrying its code.
+type Error struct {
+	Code    ErrorCode `json:"code"`
+	Message string    `json:"message"`
+	// Lock is who holds the cell, for conflicts over a cell lock.
+	Lock *Lock `json:"lock,omitempty"`
+}
+
+func (e *Error) Error() string { return e.Message }
+
+// Errorf returns an *Error with a formatted message.
+func Errorf(code ErrorCode, format string, args ...any) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// AsError returns err as an *Error, or wrapped in one with code fallback.
+func AsError(err error, fallback ErrorCode) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+	return &Error{Code: fallback, Message: err.Error()}
+}
@@ -0,0 +1,212 @@
+package protocol
+
+import (
+	"time"
+
+	"github.com/zoravur/postgres-spreadsheet-view/server/pkg/richcatalog"
+)
+
+// Hello opens a connection, both ways. The client's names the version it
+// speaks and the capabilities it wants (none means all); the server's the
+// negotiated ones.
+type Hello struct {
+	Version      int          `json:"version"`
+	Capabilities []Capability `json:"capabilities"`
+}
+
+// --- subscriptions ---
+
+// Subscribe starts a live query under the request's sub, which is generated
+// when empty. Subscribing under a sub in use replaces that subscription.
+type Subscribe struct {
+	SQL      string `json:"sql"`
+	Strategy string `json:"strategy,omitempty"` // pk_pushdown | full_requery | incremental_aggregate | poll
+	// Since resumes after a reconnect (needs CapResume): the live query and
+	// the seq of the last "update"/"reload" the client applied.
+	Since *Position `json:"since,omitempty"`
+}
+
+// Position is a point in a live query's stream of updates and reloads.
+type Position struct {
+	ID  string `json:"id"`
+	Seq uint64 `json:"seq"`
+}
+
+// Snapshot is a live query's whole result: the payload of "reload", and the
+// start of "subscribed". Rows were read under one database snapshot at LSN
+// and are current as of Seq.
+type Snapshot struct {
+	ID   string   `json:"id"` // live query
+	Seq  uint64   `json:"seq"`
+	LSN  string   `json:"lsn,omitempty"`
+	Rows []Row    `json:"rows"`
+	Keys []string `json:"keys"` // row identities, parallel to Rows
+}
+
+// Subscribed answers a subscribe with the initial result. A resumed
+// subscription instead has no rows and is followed by the updates after
+// Since, as if it had never been away.
+type Subscribed struct {
+	Snapshot
+	Resumed  bool                `json:"resumed,omitempty"`
+	Tables   []string            `json:"tables"`
+	PKCols   map[string][]string `json:"pkCols"`
+	Strategy string              `json:"strategy"`
+	Rewrote  string              `json:"rewrote"`
+	// Comments counts comment threads by "<editHandle>|<column>"
+	// ("<editHandle>|" for a row's own), with CapComments.
+	Comments map[string]int `json:"comments,omitempty"`
+}
+
+// Cell is one value of a result row and the handle that edits it.
+type Cell struct {
+	EditHandle string `json:"editHandle"`
+	Value      any    `json:"value"`
+	// Comments counts the cell's comment thread. Only set on results that
+	// were annotated (HTTP /api/query); live rows leave it out.
+	Comments int `json:"comments,omitempty"`
+}
+
+// Row is a result row: output column -> cell.
+type Row map[string]Cell
+
+// RowOp is one row-level change in an "update" message. Key is the row's
+// identity.
+//
+// Ops come in three groups and are meant to be applied in this order:
+// deletes; updates (Row holds only the changed cells); then inserts and moves
+// sorted by Index, where Index is the row's position in the new result. To
+// apply the last group, detach every moved row first, then place inserted and
+// moved rows at their Index in ascending order.
+type RowOp struct {
+	Op    string `json:"op"`
+	Key   string `json:"key"`
+	Index *int   `json:"index,omitempty"`
+	Row   Row    `json:"row,omitempty"`
+}
+
+// Update is the payload of an "update" message: every row op a refresh
+// produced, tagged with the newest commit it reflects (LSN, XID) and all
+// commits folded into it (XIDs). Seq counts the query's updates and reloads;
+// a client that saw seq n can resume from n.
+type Update struct {
+	ID   string  `json:"id"`
+	Seq  uint64  `json:"seq"`
+	LSN  string  `json:"lsn,omitempty"`
+	XID  int64   `json:"xid,omitempty"`
+	XIDs []int64 `json:"xids,omitempty"`
+	Ops  []RowOp `json:"ops"`
+	// Origin lists the request IDs of the receiving connection's own edits
+	// among XIDs; set per connection when the update is sent.
+	Origin []string `json:"origin,omitempty"`
+}
+
+// SchemaChanged tells subscribers their query was re-analyzed after a schema
+// change; a "reload" follows.
+type SchemaChanged struct {
+	ID      string               `json:"id"`
+	Tables  []string             `json:"tables"`
+	PKCols  map[string][]string  `json:"pkCols"`
+	Changes []richcatalog.Change `json:"changes"`
+}
+
+// CatalogChanged goes to every connection when the schema checksum changes.
+type CatalogChanged struct {
+	Checksum    string               `json:"checksum"`
+	Previous    string               `json:"previous"`
+	GeneratedAt time.Time            `json:"generatedAt"`
+	Changes     []richcatalog.Change `json:"changes"`
+}
+
+// --- cells ---
+
+// CellRef names a cell by its edit handle and column; without a column it
+// names the row.
+type CellRef struct {
+	EditHandle string `json:"editHandle"`
+	Column     string `json:"column,omitempty"`
+}
+
+// Edit writes one cell (CapEdit). Its "ack" carries an EditAck.
+type Edit struct {
+	CellRef
+	Value any `json:"value"`
+}
+
+// EditAck identifies the transaction an edit committed in. XID matches the
+// xid wal2json reports for it, so live updates can be traced back to the edit;
+// LSN is a WAL position at or after its commit.
+type EditAck struct {
+	XID  int64  `json:"xid"`
+	LSN  string `json:"lsn"`
+	Rows int64  `json:"rows"` // rows the UPDATE matched
+}
+
+// Presence states.
+const (
+	PresenceViewing = "viewing"
+	PresenceEditing = "editing"
+	PresenceIdle    = "idle"
+	// PresenceLeft is sent to the others when a subscriber goes away.
+	PresenceLeft = "left"
+)
+
+// PresenceReport is what a client says it is doing in the request's sub
+// (CapPresence): viewing|editing|idle, on the cell it has focused.
+type PresenceReport struct {
+	CellRef
+	State string `json:"state"`
+}
+
+// Presence is what one subscriber of a live query is doing, as the other
+// subscribers are told. Peer identifies the subscription to them; its own
+// subscription ID is meaningless outside its connection.
+type Presence struct {
+	Peer       string `json:"peer"`
+	User       string `json:"user"`
+	EditHandle string `json:"editHandle,omitempty"`
+	Column     string `json:"column,omitempty"`
+	State      string `json:"state"`
+}
+
+// LockRequest claims or renews a lease on a cell (CapLocks). Hard also locks
+// the row with SELECT ... FOR UPDATE NOWAIT. Its "ack" carries the Lock.
+// "unlock" takes a plain CellRef.
+type LockRequest struct {
+	CellRef
+	Hard bool `json:"hard,omitempty"`
+}
+
+// Lock is a lease on one cell, as clients are told about it in "lock" and
+// "unlock" messages.
+type Lock struct {
+	EditHandle string    `json:"editHandle"`
+	Column     string    `json:"column"`
+	User       string    `json:"user"`
+	Hard       bool      `json:"hard,omitempty"`
+	Expires    time.Time `json:"expires"`
+}
+
+// CommentRequest adds to a cell's thread, or the row's without a column
+// (CapComments). Its "ack" carries the Comment. "comments" takes a plain
+// CellRef and is answered with a CommentList.
+type CommentRequest struct {
+	CellRef
+	Body string `json:"body"`
+}
+
+// Comment is one message in a thread. EditHandle is the handle it was posted
+// or looked up under; any handle for the same row finds the same thread.
+type Comment struct {
+	ID         int64     `json:"id"`
+	EditHandle string    `json:"editHandle"`
+	Column     string    `json:"column,omitempty"`
+	User       string    `json:"user"`
+	Body       string    `json:"body"`
+	CreatedAt  time.Time `json:"createdAt"`
+}
+
+// CommentList answers "comments", oldest first.
+type CommentList struct {
+	Comments []Comment `json:"comments"`
+}
@@ -0,0 +1,152 @@
+// Package protocol defines the WebSocket protocol spoken on /api/ws: the
+// envelopes, the hello handshake, every request and message payload, and the
+// error codes. Besides pkg/richcatalog's change descriptions it depends on
+// nothing else in the server, so clients can share it.
+//
+// A connection starts with the client's "hello", naming the protocol version
+// it speaks and the capabilities it wants; the server answers "hello" with the
+// version and the capabilities both sides support. Nothing else is accepted
+// before that. Every request after it may carry a requestId, which the
+// server's answer ("subscribed", "unsubscribed", "ack" or "error") repeats.
+// Messages about a subscription carry its ID as "sub".
+package protocol
+
+import "encoding/json"
+
+// Version is the protocol version this server speaks; MinVersion the oldest
+// it still accepts.
+const (
+	Version    = 2
+	MinVersion = 2
+)
+
+// Capability is an optional part of the protocol. Requests and messages that
+// belong to one the connection didn't negotiate are refused and not sent.
+type Capability string
+
+const (
+	// CapResume: subscribe with "since" replays missed updates.
+	CapResume Capability = "resume"
+	// CapEdit: "edit" requests; updates carry the origin of the caller's edits.
+	CapEdit Capability = "edit"
+	// CapPresence: "presence" requests and messages.
+	CapPresence Capability = "presence"
+	// CapLocks: "lock"/"unlock" requests and messages.
+	CapLocks Capability = "locks"
+	// CapComments: "comment"/"comments" requests and "comment" messages.
+	CapComments Capability = "comments"
+)
+
+// Capabilities lists every capability this server supports.
+var Capabilities = []Capability{CapResume, CapEdit, CapPresence, CapLocks, CapComments}
+
+// Type is a request or message type.
+type Type string
+
+// Requests (client to server).
+const (
+	TypeHello       Type = "hello"
+	TypeSubscribe   Type = "subscribe"
+	TypeUnsubscribe Type = "unsubscribe"
+	TypeEdit        Type = "edit"
+	TypePresence    Type = "presence"
+	TypeLock        Type = "lock"
+	TypeUnlock      Type = "unlock"
+	TypeComment     Type = "comment"
+	TypeComments    Type = "comments"
+	TypePing        Type = "ping"
+)
+
+// Messages (server to client). "hello", "presence", "lock", "unlock" and
+// "comment" are used both ways.
+const (
+	TypeSubscribed     Type = "subscribed"
+	TypeUnsubscribed   Type = "unsubscribed"
+	TypeUpdate         Type = "update"
+	TypeReload         Type = "reload"
+	TypeSchemaChanged  Type = "schema_changed"
+	TypeCatalogChanged Type = "catalog_changed"
+	TypeAck            Type = "ack"
+	TypeError          Type = "error"
+	TypePong           Type = "pong"
+)
+
+// RequiredCapability is the capability a request or message type belongs to,
+// "" for the core protocol.
+func RequiredCapability(t Type) Capability {
+	switch t {
+	case TypeEdit:
+		return CapEdit
+	case TypePresence:
+		return CapPresence
+	case TypeLock, TypeUnlock:
+		return CapLocks
+	case TypeComment, TypeComments:
+		return CapComments
+	default:
+		return ""
+	}
+}
+
+// Request is the envelope of everything a client sends. Data holds the
+// type's payload (see the request types in messages.go).
+type Request struct {
+	Type      Type            `json:"type"`
+	RequestID string          `json:"requestId,omitempty"`
+	Sub       string          `json:"sub,omitempty"`
+	Data      json.RawMessage `json:"data,omitempty"`
+}
+
+// Decode unmarshals the request's data into v. A request without data
+// leaves v as it is.
+func (r Request) Decode(v any) error {
+	if len(r.Data) == 0 || string(r.Data) == "null" {
+		return nil
+	}
+	if err := json.Unmarshal(r.Data, v); err != nil {
+		return Errorf(CodeBadRequest, "invalid %s data: %v", r.Type, err)
+	}
+	return nil
+}
+
+// Message is the envelope of everything the server sends.
+type Message struct {
+	Type      Type   `json:"type"`
+	RequestID string `json:"requestId,omitempty"`
+	Sub       string `json:"sub,omitempty"`
+	Data      any    `json:"data,omitempty"`
+}
+
+// Negotiate picks the version and capabilities for a client's hello: the
+// client's version if it is one this server speaks, and the capabilities both
+// support (all of them when the client lists none).
+func Negotiate(h Hello) (Hello, error) {
+	if h.Version < MinVersion || h.Version > Version {
+		return Hello{}, Errorf(CodeUnsupportedVersion,
+			"protocol version %d not supported (server speaks %d to %d)", h.Version, MinVersion, Version)
+	}
+	out := Hello{Version: h.Version}
+	if len(h.Capabilities) == 0 {
+		out.Capabilities = append(out.Capabilities, Capabilities...)
+		return out, nil
+	}
+	for _, want := range h.Capabilities {
+		for _, have := range Capabilities {
+			if want == have {
+				out.Capabilities = append(out.Capabilities, want)
+				break
+			}
+		}
+	}
+	return out, nil
+}
+
+// Has reports whether c is among the hello's capabilities.
+func (h Hello) Has(c Capability) bool {
+	for _, x := range h.Capabilities {
+		if x == c {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,178 @@
+package protocol
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		name     string
+		in       Hello
+		want     Hello
+		wantCode ErrorCode
+	}{
+		{
+			name: "all capabilities by default",
+			in:   Hello{Version: Version},
+			want: Hello{Version: Version, Capabilities: Capabilities},
+		},
+		{
+			name: "requested subset, in the client's order",
+			in:   Hello{Version: Version, Capabilities: []Capability{CapLocks, CapEdit}},
+			want: Hello{Version: Version, Capabilities: []Capability{CapLocks, CapEdit}},
+		},
+		{
+			name: "unknown capabilities dropped",
+			in:   Hello{Version: Version, Capabilities: []Capability{"telepathy", CapResume}},
+			want: Hello{Version: Version, Capabilities: []Capability{CapResume}},
+		},
+		{
+			name: "nothing in common",
+			in:   Hello{Version: Version, Capabilities: []Capability{"telepathy"}},
+			want: Hello{Version: Version},
+		},
+		{name: "too old", in: Hello{Version: MinVersion - 1}, wantCode: CodeUnsupportedVersion},
+		{name: "too new", in: Hello{Version: Version + 1}, wantCode: CodeUnsupportedVersion},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Negotiate(tt.in)
+			if tt.wantCode != "" {
+				var pe *Error
+				if !errors.As(err, &pe) || pe.Code != tt.wantCode {
+					t.Fatalf("err = %v, want code %s", err, tt.wantCode)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Negotiate = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// The default capability list is a copy: a connection can't change what the
+// next one is offered.
+func TestNegotiateCopiesCapabilities(t *testing.T) {
+	h, _ := Negotiate(Hello{Version: Version})
+	h.Capabilities[0] = "changed"
+	if Capabilities[0] == "changed" {
+		t.Fatal("Negotiate shares the Capabilities slice")
+	}
+}
+
+func TestHelloHas(t *testing.T) {
+	h := Hello{Version: Version, Capabilities: []Capability{CapEdit}}
+	if !h.Has(CapEdit) || h.Has(CapLocks) {
+		t.Errorf("Has: got edit=%v locks=%v, want true false", h.Has(CapEdit), h.Has(CapLocks))
+	}
+}
+
+func TestRequiredCapability(t *testing.T) {
+	tests := map[Type]Capability{
+		TypeSubscribe: "",
+		TypePing:      "",
+		TypeEdit:      CapEdit,
+		TypePresence:  CapPresence,
+		TypeLock:      CapLocks,
+		TypeUnlock:    CapLocks,
+		TypeComment:   CapComments,
+		TypeComments:  CapComments,
+	}
+	for typ, want := range tests {
+		if got := RequiredCapability(typ); got != want {
+			t.Errorf("RequiredCapability(%s) = %q, want %q", typ, got, want)
+		}
+	}
+}
+
+// A Message the server sends decodes as a Request carrying the same payload,
+// the way the Go client reads it.
+func TestEnvelopeRoundTrip(t *testing.T) {
+	idx := 1
+	expires := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		typ  Type
+		data any
+	}{
+		{TypeHello, &Hello{Version: Version, Capabilities: []Capability{CapEdit}}},
+		{TypeSubscribe, &Subscribe{SQL: "SELECT 1", Strategy: "poll", Since: &Position{ID: "q1", Seq: 7}}},
+		{TypeEdit, &Edit{CellRef: CellRef{EditHandle: "h", Column: "title"}, Value: "x"}},
+		{TypeUpdate, &Update{ID: "q1", Seq: 2, XID: 9, XIDs: []int64{9}, Ops: []RowOp{
+			{Op: "insert", Key: "k", Index: &idx, Row: Row{"title": {EditHandle: "h", Value: "Alien"}}},
+		}}},
+		{TypeAck, &EditAck{XID: 9, LSN: "0/16B3748", Rows: 1}},
+		{TypeLock, &Lock{EditHandle: "h", Column: "title", User: "ana", Hard: true, Expires: expires}},
+		{TypeError, Errorf(CodeConflict, "locked")},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.typ), func(t *testing.T) {
+			b, err := json.Marshal(Message{Type: tt.typ, RequestID: "r1", Sub: "s1", Data: tt.data})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var req Request
+			if err := json.Unmarshal(b, &req); err != nil {
+				t.Fatal(err)
+			}
+			if req.Type != tt.typ || req.RequestID != "r1" || req.Sub != "s1" {
+				t.Errorf("envelope = %s %q %q", req.Type, req.RequestID, req.Sub)
+			}
+			got := reflect.New(reflect.TypeOf(tt.data).Elem()).Interface()
+			if err := req.Decode(got); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.data) {
+				t.Errorf("decoded %+v, want %+v", got, tt.data)
+			}
+		})
+	}
+}
+
+func TestEnvelopeOmitsEmpty(t *testing.T) {
+	b, err := json.Marshal(Message{Type: TypePong})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != `{"type":"pong"}` {
+		t.Errorf("Marshal = %s", b)
+	}
+}
+
+func TestRequestDecode(t *testing.T) {
+	// no data leaves v alone
+	for _, data := range []string{"", "null"} {
+		v := Subscribe{SQL: "kept"}
+		if err := (Request{Type: TypeSubscribe, Data: json.RawMessage(data)}).Decode(&v); err != nil || v.SQL != "kept" {
+			t.Errorf("Decode(%q) = %v, %+v", data, err, v)
+		}
+	}
+
+	var v Subscribe
+	err := Request{Type: TypeSubscribe, Data: json.RawMessage(`{"sql": 1}`)}.Decode(&v)
+	var pe *Error
+	if !errors.As(err, &pe) || pe.Code != CodeBadRequest {
+		t.Errorf("Decode(bad) = %v, want %s", err, CodeBadRequest)
+	}
+}
+
+func TestAsError(t *testing.T) {
+	pe := Errorf(CodeNotFound, "no such query %s", "q1")
+	if pe.Message != "no such query q1" {
+		t.Errorf("Errorf message = %q", pe.Message)
+	}
+	if got := AsError(fmt.Errorf("wrapped: %w", pe), CodeInternal); got != pe {
+		t.Errorf("AsError(wrapped) = %+v, want the wrapped error", got)
+	}
+	if got := AsError(errors.New("boom"), CodeInternal); got.Code != CodeInternal || got.Message != "boom" {
+		t.Errorf("AsError(plain) = %+v", got)
+	}
+}
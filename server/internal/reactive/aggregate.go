@@ -0,0 +1,690 @@
+package reactive
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+	pg_query "github.com/pganalyze/pg_query_go/v6"
+
+	"github.com/zoravur/postgres-spreadsheet-view/server/internal/common"
+	"github.com/zoravur/postgres-spreadsheet-view/server/internal/metrics"
+	"github.com/zoravur/postgres-spreadsheet-view/server/pkg/pg_lineage"
+)
+
+var (
+	mAggFolds   = metrics.NewCounter("aggregate_row_folds_total")
+	mAggRequery = metrics.NewCounter("aggregate_group_requeries_total")
+	mAggLoads   = metrics.NewCounter("aggregate_loads_total")
+)
+
+// colKind is how an aggregated column's values can be folded in Go.
+type colKind int
+
+const (
+	kindOther   colKind = iota // not comparable outside Postgres; changes re-query the group
+	kindInt                    // smallint, integer: sum is bigint
+	kindBigint                 // bigint: sum is numeric
+	kindFloat                  // double precision
+	kindDecimal                // numeric
+	kindText                   // text-like: can't be summed or ordered here, but keys match
+)
+
+// aggSlot is one aggregated base column with the running values it needs.
+type aggSlot struct {
+	col    string
+	kind   colKind
+	scale  int // numeric(p,s) scale, -1 when unconstrained
+	sum    bool
+	minmax bool
+}
+
+// aggGroup is one GROUP BY group's running state.
+type aggGroup struct {
+	vals []any      // group key values, as the database returned them
+	seen *ReadPoint // snapshot the group was read under; its commits are already counted
+	n    int64      // count(*)
+	cols []aggCol
+}
+
+type aggCol struct {
+	nonNull int64    // count(col)
+	sum     *big.Rat // exact, whatever the column type
+	scale   int      // numeric: display scale of sum (the max input scale)
+	lo, hi  aggBound
+}
+
+// aggBound is a min or max: the value as displayed plus its numeric value.
+type aggBound struct {
+	val any
+	r   *big.Rat // nil when the group has no non-null values
+}
+
+// aggState maintains an incremental_aggregate query's groups from WAL row
+// images. Inserts add the new image, deletes subtract the old one and updates
+// do both, so the old image needs every column (REPLICA IDENTITY FULL, see
+// db/seed). A group whose delta can't be applied locally (min/max losing its
+// extremum, a WHERE clause, values that can't be parsed) is re-queried alone.
+type aggState struct {
+	shape     *pg_lineage.AggregateShape
+	slots     []aggSlot
+	slotOf    map[string]int // column -> slot
+	exactKeys bool           // group key values from the WAL encode like the database's
+	stateSQL  string         // per-group running values, see stateQuery
+	groups    map[string]*aggGroup
+	order     []string // row keys in result order
+}
+
+var errReseed = errors.New("aggregate needs a reseed")
+
+// loadAggregate runs the query's state query in tx, whose snapshot is p, and
+// seeds its groups. Called with rowsMu held.
+func (q *LiveQuery) loadAggregate(tx *sql.Tx, p *ReadPoint) (*aggState, []keyedRow, error) {
+	a := q.Current()
+	shape := a.Plan.Aggregate
+	if shape == nil {
+		return nil, nil, fmt.Errorf("query %s has no aggregate shape", q.ID)
+	}
+	types, err := columnTypes(tx, shape.Table)
+	if err != nil {
+		return nil, nil, fmt.Errorf("column types: %w", err)
+	}
+
+	s := newAggState(shape, types)
+	if s.stateSQL, err = s.stateQuery(q.SQL); err != nil {
+		return nil, nil, err
+	}
+
+	rows, err := tx.Query(s.stateSQL)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		key, g, err := s.scanGroup(rows)
+		if err != nil {
+			return nil, nil, err
+		}
+		g.seen = p
+		s.groups[key] = g
+		s.order = append(s.order, key)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	mAggLoads.Inc()
+	return s, s.keyed(), nil
+}
+
+// newAggState sets up the slots for shape's aggregated columns, given the
+// table's column types (format_type() names). It has no groups yet.
+func newAggState(shape *pg_lineage.AggregateShape, types map[string]string) *aggState {
+	s := &aggState{shape: shape, slotOf: map[string]int{}, exactKeys: true, groups: map[string]*aggGroup{}}
+	for _, col := range shape.GroupBy {
+		if k, _ := typeKind(types[col]); k == kindOther || k == kindFloat {
+			s.exactKeys = false
+		}
+	}
+	for _, c := range shape.Columns {
+		if c.Func == "" || c.Arg == "" {
+			continue
+		}
+		i, ok := s.slotOf[c.Arg]
+		if !ok {
+			kind, scale := typeKind(types[c.Arg])
+			i = len(s.slots)
+			s.slotOf[c.Arg] = i
+			s.slots = append(s.slots, aggSlot{col: c.Arg, kind: kind, scale: scale})
+		}
+		switch c.Func {
+		case "sum", "avg":
+			s.slots[i].sum = true
+		case "min", "max":
+			s.slots[i].minmax = true
+		}
+	}
+	return s
+}
+
+// reseedAggregate reloads every group, for deltas that can't be placed, and
+// returns the next result; q.results is left for the caller to diff against.
+// Called with rowsMu held.
+func (q *LiveQuery) reseedAggregate(db *sql.DB) ([]keyedRow, error) {
+	var agg *aggState
+	var keyed []keyedRow
+	p, err := snapshotRead(db, func(tx *sql.Tx, p *ReadPoint) error {
+		var err error
+		agg, keyed, err = q.loadAggregate(tx, p)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	q.agg, q.loaded = agg, p
+	return keyed, nil
+}
+
+// refreshAggregate folds a batch's row images into q's groups and returns the
+// next result. Changes a group's snapshot already includes are skipped, so
+// nothing is counted twice. Called with rowsMu held.
+func (q *LiveQuery) refreshAggregate(db *sql.DB, changes []RowChange) ([]keyedRow, error) {
+	s := q.agg
+	if s == nil || s.shape != q.Current().Plan.Aggregate {
+		return q.reseedAggregate(db)
+	}
+
+	stale := map[string][]any{} // groups to re-query: row key -> group values
+	for _, rc := range changes {
+		if rc.Table != s.shape.Table {
+			continue
+		}
+		for _, img := range []struct {
+			row  map[string]any
+			sign int64
+		}{{rc.Old, -1}, {rc.New, 1}} {
+			if img.row == nil {
+				continue
+			}
+			key, vals, ok := s.groupOf(img.row)
+			if !ok {
+				// the image doesn't say which group it was in
+				return q.reseedAggregate(db)
+			}
+			if _, ok := stale[key]; ok {
+				continue
+			}
+			if g := s.groups[key]; g != nil && g.seen.Includes(rc.XID) {
+				continue
+			}
+			if s.shape.Filtered || !s.fold(key, img.row, img.sign) {
+				stale[key] = vals
+				continue
+			}
+			mAggFolds.Inc()
+		}
+	}
+
+	for key, vals := range stale {
+		if err := q.requeryGroup(db, key, vals); err != nil {
+			if errors.Is(err, errReseed) {
+				return q.reseedAggregate(db)
+			}
+			return nil, err
+		}
+	}
+	s.dropEmpty()
+	return s.keyed(), nil
+}
+
+// requeryGroup replaces one group with the database's current values for it.
+func (q *LiveQuery) requeryGroup(db *sql.DB, key string, vals []any) error {
+	s := q.agg
+	mAggRequery.Inc()
+
+	text := s.stateSQL
+	if len(s.shape.GroupBy) > 0 {
+		conds := make([]string, len(s.shape.GroupBy))
+		for i := range s.shape.GroupBy {
+			conds[i] = fmt.Sprintf("__g%d IS NOT DISTINCT FROM $%d", i, i+1)
+		}
+		text = fmt.Sprintf("SELECT * FROM (%s) __state WHERE %s", s.stateSQL, strings.Join(conds, " AND "))
+	}
+	st, err := q.prepared(db, text)
+	if err != nil {
+		return err
+	}
+
+	found := false
+	_, err = snapshotRead(db, func(tx *sql.Tx, p *ReadPoint) error {
+		rows, err := tx.Stmt(st).Query(vals...)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			dbKey, g, err := s.scanGroup(rows)
+			if err != nil {
+				return err
+			}
+			found = true
+			g.seen = p
+			if _, ok := s.groups[dbKey]; !ok {
+				s.order = append(s.order, dbKey)
+			}
+			s.groups[dbKey] = g
+		}
+		return rows.Err()
+	})
+	if err != nil {
+		return err
+	}
+	if found {
+		return nil
+	}
+	if g, ok := s.groups[key]; ok {
+		g.n = 0 // the group is gone; dropEmpty removes it
+		return nil
+	}
+	if !s.exactKeys {
+		// we may hold the group under the database's spelling of its key
+		return errReseed
+	}
+	return nil
+}
+
+// stateQuery rewrites the original query to select, per group, the key
+// columns (__g0, ...), count(*) (__n) and per slot count (__c0), sum (__s0,
+// for sum and avg) and min/max (__lo0, __hi0). FROM, WHERE and GROUP BY are
+// kept as written.
+func (s *aggState) stateQuery(origSQL string) (string, error) {
+	targets := make([]string, 0, len(s.shape.GroupBy)+1+4*len(s.slots))
+	for i, col := range s.shape.GroupBy {
+		targets = append(targets, fmt.Sprintf("%s AS __g%d", pq.QuoteIdentifier(col), i))
+	}
+	targets = append(targets, "count(*) AS __n")
+	for i, sl := range s.slots {
+		col := pq.QuoteIdentifier(sl.col)
+		targets = append(targets, fmt.Sprintf("count(%s) AS __c%d", col, i))
+		if sl.sum {
+			targets = append(targets, fmt.Sprintf("sum(%s) AS __s%d", col, i))
+		}
+		if sl.minmax {
+			targets = append(targets, fmt.Sprintf("min(%s) AS __lo%d, max(%s) AS __hi%d", col, i, col, i))
+		}
+	}
+
+	tree, err := pg_query.Parse(origSQL)
+	if err != nil {
+		return "", fmt.Errorf("state query: %w", err)
+	}
+	tl, err := pg_query.Parse("SELECT " + strings.Join(targets, ", "))
+	if err != nil {
+		return "", fmt.Errorf("state query: %w", err)
+	}
+	if len(tree.Stmts) == 0 || tree.Stmts[0].Stmt.GetSelectStmt() == nil {
+		return "", errors.New("state query: not a SELECT")
+	}
+	tree.Stmts[0].Stmt.GetSelectStmt().TargetList = tl.Stmts[0].Stmt.GetSelectStmt().TargetList
+	return pg_query.Deparse(tree)
+}
+
+// scanGroup reads one state query row.
+func (s *aggState) scanGroup(rows *sql.Rows) (string, *aggGroup, error) {
+	cols, err := rows.Columns()
+	if err != nil {
+		return "", nil, err
+	}
+	values := make([]any, len(cols))
+	ptrs := make([]any, len(cols))
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+	if err := rows.Scan(ptrs...); err != nil {
+		return "", nil, err
+	}
+	byName := make(map[string]any, len(cols))
+	for i, c := range cols {
+		byName[c] = deref(values[i])
+	}
+
+	g := &aggGroup{vals: make([]any, len(s.shape.GroupBy)), cols: make([]aggCol, len(s.slots))}
+	for i := range s.shape.GroupBy {
+		g.vals[i] = byName[fmt.Sprintf("__g%d", i)]
+	}
+	g.n, _ = byName["__n"].(int64)
+	for i, sl := range s.slots {
+		c := &g.cols[i]
+		c.nonNull, _ = byName[fmt.Sprintf("__c%d", i)].(int64)
+		c.sum = new(big.Rat)
+		if v := byName[fmt.Sprintf("__s%d", i)]; v != nil {
+			if r, ok := parseRat(v); ok {
+				c.sum = r
+			}
+			c.scale = scaleOf(v)
+		}
+		if !sl.minmax {
+			continue
+		}
+		for _, b := range []struct {
+			dst *aggBound
+			v   any
+		}{{&c.lo, byName[fmt.Sprintf("__lo%d", i)]}, {&c.hi, byName[fmt.Sprintf("__hi%d", i)]}} {
+			if b.v == nil {
+				continue
+			}
+			b.dst.val = b.v
+			if sl.kind != kindOther && sl.kind != kindText {
+				b.dst.r, _ = parseRat(b.v)
+			}
+		}
+	}
+	return common.EncodeRowKey(s.shape.GroupBy, g.vals), g, nil
+}
+
+// groupOf returns the row key and group values a row image belongs to.
+func (s *aggState) groupOf(row map[string]any) (string, []any, bool) {
+	vals := make([]any, len(s.shape.GroupBy))
+	for i, col := range s.shape.GroupBy {
+		v, ok := row[col]
+		if !ok {
+			return "", nil, false
+		}
+		vals[i] = v
+	}
+	return common.EncodeRowKey(s.shape.GroupBy, vals), vals, true
+}
+
+// fold adds (sign 1) or subtracts (sign -1) one row image from its group. It
+// reports false when the group has to be re-queried instead; the group may
+// then be half-updated, which the re-query overwrites.
+func (s *aggState) fold(key string, row map[string]any, sign int64) bool {
+	g, ok := s.groups[key]
+	if !ok {
+		return false
+	}
+	g.n += sign
+	if g.n < 0 {
+		return false
+	}
+	for i, sl := range s.slots {
+		v, ok := row[sl.col]
+		if !ok {
+			return false // e.g. an old image without REPLICA IDENTITY FULL
+		}
+		if v == nil {
+			continue
+		}
+		c := &g.cols[i]
+		c.nonNull += sign
+		if c.nonNull < 0 {
+			return false
+		}
+		if !sl.sum && !sl.minmax {
+			continue
+		}
+		if sl.kind == kindOther || sl.kind == kindText {
+			return false
+		}
+		r, ok := parseRat(v)
+		if !ok {
+			return false
+		}
+		disp := displayValue(sl.kind, v)
+
+		if sl.sum {
+			if sign > 0 {
+				c.sum.Add(c.sum, r)
+			} else {
+				c.sum.Sub(c.sum, r)
+			}
+			if sl.kind == kindDecimal {
+				vs := scaleOf(v)
+				switch {
+				case sl.scale >= 0:
+					c.scale = sl.scale
+				case sign > 0 && vs > c.scale:
+					c.scale = vs
+				case sign < 0 && c.nonNull == 0:
+					c.scale = 0
+				case sign < 0 && vs == c.scale:
+					return false // other values may or may not share the scale
+				}
+			}
+		}
+
+		if !sl.minmax {
+			continue
+		}
+		if c.nonNull == 0 {
+			c.lo, c.hi = aggBound{}, aggBound{}
+			continue
+		}
+		if sign > 0 {
+			if c.lo.r == nil || r.Cmp(c.lo.r) < 0 {
+				c.lo = aggBound{val: disp, r: r}
+			}
+			if c.hi.r == nil || r.Cmp(c.hi.r) > 0 {
+				c.hi = aggBound{val: disp, r: r}
+			}
+		} else if (c.lo.r != nil && r.Cmp(c.lo.r) == 0) || (c.hi.r != nil && r.Cmp(c.hi.r) == 0) {
+			return false // removed the extremum; the next one is only in the table
+		}
+	}
+	return true
+}
+
+// dropEmpty removes groups whose last row went away. An ungrouped aggregate
+// always has its one row, even over no rows.
+func (s *aggState) dropEmpty() {
+	if len(s.shape.GroupBy) == 0 {
+		return
+	}
+	order := s.order[:0]
+	for _, k := range s.order {
+		if g := s.groups[k]; g != nil && g.n > 0 {
+			order = append(order, k)
+			continue
+		}
+		delete(s.groups, k)
+	}
+	s.order = order
+}
+
+// keyed renders every group as a result row, valued as the database would
+// return the original query's outputs.
+func (s *aggState) keyed() []keyedRow {
+	out := make([]keyedRow, 0, len(s.order))
+	for _, k := range s.order {
+		g := s.groups[k]
+		row := make(EditableRow, len(s.shape.Columns))
+		for _, c := range s.shape.Columns {
+			row[c.Output] = EditableCell{Value: s.output(g, c)}
+		}
+		out = append(out, keyedRow{Key: k, Row: row})
+	}
+	return out
+}
+
+func (s *aggState) output(g *aggGroup, c pg_lineage.AggColumn) any {
+	if c.Func == "" {
+		for i, col := range s.shape.GroupBy {
+			if col == c.Arg {
+				return g.vals[i]
+			}
+		}
+		return nil
+	}
+	if c.Func == "count" && c.Arg == "" {
+		return g.n
+	}
+	sl := s.slots[s.slotOf[c.Arg]]
+	ac := g.cols[s.slotOf[c.Arg]]
+	if c.Func == "count" {
+		return ac.nonNull
+	}
+	if ac.nonNull == 0 {
+		return nil
+	}
+	switch c.Func {
+	case "min":
+		return ac.lo.val
+	case "max":
+		return ac.hi.val
+	case "sum":
+		switch sl.kind {
+		case kindInt:
+			return ac.sum.Num().Int64()
+		case kindFloat:
+			f, _ := ac.sum.Float64()
+			return f
+		case kindBigint:
+			return ac.sum.Num().String()
+		default:
+			return ac.sum.FloatString(ac.scale)
+		}
+	case "avg":
+		if sl.kind == kindFloat {
+			f, _ := new(big.Rat).Quo(ac.sum, new(big.Rat).SetInt64(ac.nonNull)).Float64()
+			return f
+		}
+		scale := 0
+		if sl.kind == kindDecimal {
+			scale = ac.scale
+		}
+		return numericDiv(ac.sum, scale, ac.nonNull)
+	}
+	return nil
+}
+
+// numericDiv renders num/den the way Postgres's numeric division does, which
+// is what avg() returns for integer and numeric columns: at least 16
+// significant digits, and at least num's display scale.
+func numericDiv(num *big.Rat, numScale int, den int64) string {
+	w1, f1 := nbaseLead(num)
+	w2, f2 := nbaseLead(new(big.Rat).SetInt64(den))
+	qweight := w1 - w2
+	if f1 <= f2 {
+		qweight--
+	}
+	rscale := max(16-qweight*4, numScale, 0)
+	rscale = min(rscale, 1000)
+	return new(big.Rat).Quo(num, new(big.Rat).SetInt64(den)).FloatString(rscale)
+}
+
+// nbaseLead returns the weight and value of the first non-zero base-10000
+// digit of |r|, as Postgres stores numerics; (0, 0) for zero.
+func nbaseLead(r *big.Rat) (weight, first int) {
+	if r.Sign() == 0 {
+		return 0, 0
+	}
+	abs := new(big.Rat).Abs(r)
+	intPart := new(big.Int).Quo(abs.Num(), abs.Denom())
+	if intPart.Sign() > 0 {
+		s := intPart.String()
+		lead := (len(s)-1)%4 + 1
+		first, _ = strconv.Atoi(s[:lead])
+		return (len(s) - 1) / 4, first
+	}
+	// fraction: walk base-10000 digits after the point
+	frac := new(big.Rat).Set(abs)
+	base := big.NewRat(10000, 1)
+	for w := -1; ; w-- {
+		frac.Mul(frac, base)
+		d := new(big.Int).Quo(frac.Num(), frac.Denom())
+		if d.Sign() > 0 {
+			return w, int(d.Int64())
+		}
+	}
+}
+
+// parseRat reads a numeric value from the database (int64, float64, text) or
+// from the WAL (json.Number). NaN and infinities don't parse.
+func parseRat(v any) (*big.Rat, bool) {
+	switch t := v.(type) {
+	case int64:
+		return new(big.Rat).SetInt64(t), true
+	case float64:
+		if math.IsNaN(t) || math.IsInf(t, 0) {
+			return nil, false
+		}
+		return new(big.Rat).SetFloat64(t), true
+	case json.Number:
+		return new(big.Rat).SetString(t.String())
+	case string:
+		return new(big.Rat).SetString(t)
+	}
+	return nil, false
+}
+
+// displayValue converts a WAL value to the Go type the database driver would
+// return for the column, so min/max cells diff cleanly against loaded ones.
+func displayValue(kind colKind, v any) any {
+	n, ok := v.(json.Number)
+	if !ok {
+		return v
+	}
+	switch kind {
+	case kindInt, kindBigint:
+		if i, err := n.Int64(); err == nil {
+			return i
+		}
+	case kindFloat:
+		if f, err := n.Float64(); err == nil {
+			return f
+		}
+	}
+	return n.String()
+}
+
+// scaleOf counts the digits after the decimal point of a numeric's text.
+func scaleOf(v any) int {
+	var s string
+	switch t := v.(type) {
+	case json.Number:
+		s = t.String()
+	case string:
+		s = t
+	default:
+		return 0
+	}
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		return len(s) - i - 1
+	}
+	return 0
+}
+
+var numericTypmod = regexp.MustCompile(`^numeric\(\d+,(\d+)\)$`)
+
+// typeKind maps a format_type() name to how its values fold; scale is the
+// numeric(p,s) scale, or -1.
+func typeKind(typ string) (colKind, int) {
+	switch {
+	case typ == "smallint" || typ == "integer":
+		return kindInt, -1
+	case typ == "bigint":
+		return kindBigint, -1
+	case typ == "double precision":
+		return kindFloat, -1
+	case typ == "numeric":
+		return kindDecimal, -1
+	case strings.HasPrefix(typ, "numeric("):
+		if m := numericTypmod.FindStringSubmatch(typ); m != nil {
+			s, _ := strconv.Atoi(m[1])
+			return kindDecimal, s
+		}
+		return kindDecimal, 0 // numeric(p)
+	case typ == "text" || typ == "boolean" || typ == "uuid" ||
+		strings.HasPrefix(typ, "character"):
+		return kindText, -1
+	}
+	return kindOther, -1
+}
+
+// columnTypes returns format_type() for each column of table ("public.film").
+func columnTypes(qr querier, table string) (map[string]string, error) {
+	rows, err := qr.Query(`
+SELECT a.attname, pg_catalog.format_type(a.atttypid, a.atttypmod)
+FROM pg_catalog.pg_attribute a
+WHERE a.attrelid = $1::regclass AND a.attnum > 0 AND NOT a.attisdropped`, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	types := map[string]string{}
+	for rows.Next() {
+		var name, typ string
+		if err := rows.Scan(&name, &typ); err != nil {
+			return nil, err
+		}
+		types[name] = typ
+	}
+	return types, rows.Err()
+}
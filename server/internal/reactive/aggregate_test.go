@@ -0,0 +1,373 @@
+package reactive
+
+import (
+	"encoding/json"
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/zoravur/postgres-spreadsheet-view/server/internal/common"
+	"github.com/zoravur/postgres-spreadsheet-view/server/pkg/pg_lineage"
+)
+
+// filmAggregate is SELECT actor_id, count(*) AS n, count(revenue) AS c,
+// sum(revenue) AS total, avg(revenue) AS mean, min(revenue) AS lo,
+// max(revenue) AS hi FROM film GROUP BY actor_id.
+var filmAggregate = &pg_lineage.AggregateShape{
+	Table:   "public.film",
+	GroupBy: []string{"actor_id"},
+	Columns: []pg_lineage.AggColumn{
+		{Output: "actor_id", Arg: "actor_id"},
+		{Output: "n", Func: "count"},
+		{Output: "c", Func: "count", Arg: "revenue"},
+		{Output: "total", Func: "sum", Arg: "revenue"},
+		{Output: "mean", Func: "avg", Arg: "revenue"},
+		{Output: "lo", Func: "min", Arg: "revenue"},
+		{Output: "hi", Func: "max", Arg: "revenue"},
+	},
+}
+
+// film is a WAL row image of actor 1's film; revenue nil is NULL.
+func film(revenue any) map[string]any {
+	if s, ok := revenue.(string); ok {
+		revenue = json.Number(s)
+	}
+	return map[string]any{"actor_id": json.Number("1"), "revenue": revenue}
+}
+
+// seedAggregate starts an aggregate over an empty group for actor 1, with
+// rows folded in as if loaded.
+func seedAggregate(t *testing.T, shape *pg_lineage.AggregateShape, revenueType string, rows ...map[string]any) (*aggState, string) {
+	t.Helper()
+	s := newAggState(shape, map[string]string{"actor_id": "integer", "revenue": revenueType})
+	vals := []any{int64(1)}
+	if len(shape.GroupBy) == 0 {
+		vals = nil
+	}
+	key := common.EncodeRowKey(shape.GroupBy, vals)
+	g := &aggGroup{vals: vals, cols: make([]aggCol, len(s.slots))}
+	for i := range g.cols {
+		g.cols[i].sum = new(big.Rat)
+	}
+	s.groups[key] = g
+	s.order = append(s.order, key)
+	for _, row := range rows {
+		if !s.fold(key, row, 1) {
+			t.Fatalf("seeding %v: fold refused", row)
+		}
+	}
+	return s, key
+}
+
+// outputs is the first result row's values.
+func outputs(s *aggState) map[string]any {
+	rows := s.keyed()
+	if len(rows) == 0 {
+		return nil
+	}
+	out := map[string]any{}
+	for col, c := range rows[0].Row {
+		out[col] = c.Value
+	}
+	return out
+}
+
+type fold struct {
+	sign int64
+	row  map[string]any
+}
+
+func TestAggregateFold(t *testing.T) {
+	tests := []struct {
+		name   string
+		typ    string
+		seed   []map[string]any
+		folds  []fold
+		wantOK bool           // every fold applied locally
+		want   map[string]any // outputs, when wantOK
+	}{
+		{
+			name:   "insert",
+			typ:    "integer",
+			seed:   []map[string]any{film("10"), film("20")},
+			folds:  []fold{{1, film("30")}},
+			wantOK: true,
+			want: map[string]any{"actor_id": int64(1), "n": int64(3), "c": int64(3), "total": int64(60),
+				"mean": "20.0000000000000000", "lo": int64(10), "hi": int64(30)},
+		},
+		{
+			name:   "delete inside the range",
+			typ:    "integer",
+			seed:   []map[string]any{film("10"), film("20"), film("30")},
+			folds:  []fold{{-1, film("20")}},
+			wantOK: true,
+			want: map[string]any{"actor_id": int64(1), "n": int64(2), "c": int64(2), "total": int64(40),
+				"mean": "20.0000000000000000", "lo": int64(10), "hi": int64(30)},
+		},
+		{
+			name:   "update as delete and insert",
+			typ:    "integer",
+			seed:   []map[string]any{film("10"), film("20"), film("30")},
+			folds:  []fold{{-1, film("20")}, {1, film("25")}},
+			wantOK: true,
+			want: map[string]any{"actor_id": int64(1), "n": int64(3), "c": int64(3), "total": int64(65),
+				"mean": "21.6666666666666667", "lo": int64(10), "hi": int64(30)},
+		},
+		{
+			name:   "new extremum",
+			typ:    "integer",
+			seed:   []map[string]any{film("10"), film("20")},
+			folds:  []fold{{1, film("-5")}, {1, film("99")}},
+			wantOK: true,
+			want: map[string]any{"actor_id": int64(1), "n": int64(4), "c": int64(4), "total": int64(124),
+				"mean": "31.0000000000000000", "lo": int64(-5), "hi": int64(99)},
+		},
+		{
+			name:   "deleting the minimum re-queries",
+			typ:    "integer",
+			seed:   []map[string]any{film("10"), film("20")},
+			folds:  []fold{{-1, film("10")}},
+			wantOK: false,
+		},
+		{
+			name:   "deleting the maximum re-queries",
+			typ:    "integer",
+			seed:   []map[string]any{film("10"), film("20")},
+			folds:  []fold{{-1, film("20")}},
+			wantOK: false,
+		},
+		{
+			name:   "NULL input counts the row only",
+			typ:    "integer",
+			seed:   []map[string]any{film("10")},
+			folds:  []fold{{1, film(nil)}},
+			wantOK: true,
+			want: map[string]any{"actor_id": int64(1), "n": int64(2), "c": int64(1), "total": int64(10),
+				"mean": "10.0000000000000000", "lo": int64(10), "hi": int64(10)},
+		},
+		{
+			name:   "only NULLs",
+			typ:    "integer",
+			seed:   []map[string]any{film(nil), film("10")},
+			folds:  []fold{{-1, film("10")}},
+			wantOK: true, // no values left, so no next extremum to look up
+			want: map[string]any{"actor_id": int64(1), "n": int64(1), "c": int64(0), "total": nil,
+				"mean": nil, "lo": nil, "hi": nil},
+		},
+		{
+			name:   "all NULL group",
+			typ:    "integer",
+			seed:   []map[string]any{film(nil)},
+			folds:  []fold{{1, film(nil)}},
+			wantOK: true,
+			want: map[string]any{"actor_id": int64(1), "n": int64(2), "c": int64(0), "total": nil,
+				"mean": nil, "lo": nil, "hi": nil},
+		},
+		{
+			name:   "numeric keeps the widest scale",
+			typ:    "numeric",
+			seed:   []map[string]any{film("1.5")},
+			folds:  []fold{{1, film("2.25")}},
+			wantOK: true,
+			want: map[string]any{"actor_id": int64(1), "n": int64(2), "c": int64(2), "total": "3.75",
+				"mean": "1.8750000000000000", "lo": "1.5", "hi": "2.25"},
+		},
+		{
+			name:   "numeric delete of the widest scale re-queries",
+			typ:    "numeric",
+			seed:   []map[string]any{film("1.5"), film("2.25"), film("3")},
+			folds:  []fold{{-1, film("2.25")}},
+			wantOK: false,
+		},
+		{
+			name:   "numeric(10,2) has a fixed scale",
+			typ:    "numeric(10,2)",
+			seed:   []map[string]any{film("1.5"), film("2.25"), film("3")},
+			folds:  []fold{{-1, film("2.25")}},
+			wantOK: true,
+			want: map[string]any{"actor_id": int64(1), "n": int64(2), "c": int64(2), "total": "4.50",
+				"mean": "2.2500000000000000", "lo": "1.5", "hi": "3"},
+		},
+		{
+			name:   "bigint sums as numeric",
+			typ:    "bigint",
+			seed:   []map[string]any{film("9223372036854775807")},
+			folds:  []fold{{1, film("1")}},
+			wantOK: true,
+			want: map[string]any{"actor_id": int64(1), "n": int64(2), "c": int64(2), "total": "9223372036854775808",
+				"mean": "4611686018427387904", "lo": int64(1), "hi": int64(9223372036854775807)},
+		},
+		{
+			name:   "double precision",
+			typ:    "double precision",
+			seed:   []map[string]any{film("0.5")},
+			folds:  []fold{{1, film("0.25")}},
+			wantOK: true,
+			want: map[string]any{"actor_id": int64(1), "n": int64(2), "c": int64(2), "total": 0.75,
+				"mean": 0.375, "lo": 0.25, "hi": 0.5},
+		},
+		{
+			name:   "unparseable value re-queries",
+			typ:    "numeric",
+			seed:   []map[string]any{film("1")},
+			folds:  []fold{{1, film("NaN")}},
+			wantOK: false,
+		},
+		{
+			name:   "text can't be summed",
+			typ:    "text",
+			seed:   nil,
+			folds:  []fold{{1, map[string]any{"actor_id": json.Number("1"), "revenue": "x"}}},
+			wantOK: false,
+		},
+		{
+			name:   "old image without the column re-queries",
+			typ:    "integer",
+			seed:   []map[string]any{film("10")},
+			folds:  []fold{{-1, map[string]any{"actor_id": json.Number("1")}}},
+			wantOK: false,
+		},
+		{
+			name:   "deleting from an empty group re-queries",
+			typ:    "integer",
+			seed:   nil,
+			folds:  []fold{{-1, film("10")}},
+			wantOK: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, key := seedAggregate(t, filmAggregate, tt.typ, tt.seed...)
+			ok := true
+			for _, f := range tt.folds {
+				ok = ok && s.fold(key, f.row, f.sign)
+			}
+			if ok != tt.wantOK {
+				t.Fatalf("fold ok = %v, want %v", ok, tt.wantOK)
+			}
+			if !ok {
+				return
+			}
+			if got := outputs(s); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("outputs = %#v\nwant      %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAggregateGroups(t *testing.T) {
+	s, key := seedAggregate(t, filmAggregate, "integer", film("10"))
+
+	// a row for another group: unknown here, so the group is re-queried
+	other := map[string]any{"actor_id": json.Number("2"), "revenue": json.Number("5")}
+	otherKey, vals, ok := s.groupOf(other)
+	if !ok || otherKey == key || !reflect.DeepEqual(vals, []any{json.Number("2")}) {
+		t.Fatalf("groupOf = %q, %v, %v", otherKey, vals, ok)
+	}
+	if s.fold(otherKey, other, 1) {
+		t.Error("fold into an unknown group applied")
+	}
+
+	// an image without the group column can't be placed: reseed
+	if _, _, ok := s.groupOf(map[string]any{"revenue": json.Number("5")}); ok {
+		t.Error("groupOf placed an image without actor_id")
+	}
+
+	// the WAL's json.Number and the database's int64 key the same group
+	if k, _, _ := s.groupOf(film("1")); k != key {
+		t.Errorf("WAL key %q, loaded key %q", k, key)
+	}
+
+	// deleting the group's last row drops it
+	if !s.fold(key, film("10"), -1) {
+		t.Fatal("fold refused")
+	}
+	s.dropEmpty()
+	if len(s.keyed()) != 0 || len(s.groups) != 0 {
+		t.Errorf("empty group kept: %v", s.keyed())
+	}
+}
+
+func TestAggregateUngrouped(t *testing.T) {
+	shape := &pg_lineage.AggregateShape{
+		Table: "public.film",
+		Columns: []pg_lineage.AggColumn{
+			{Output: "n", Func: "count"},
+			{Output: "total", Func: "sum", Arg: "revenue"},
+		},
+	}
+	s, key := seedAggregate(t, shape, "integer", film("10"))
+	if !s.fold(key, film("10"), -1) {
+		t.Fatal("fold refused")
+	}
+	// an ungrouped aggregate has its one row even over no rows
+	s.dropEmpty()
+	want := map[string]any{"n": int64(0), "total": nil}
+	if got := outputs(s); !reflect.DeepEqual(got, want) {
+		t.Errorf("outputs = %#v, want %#v", got, want)
+	}
+}
+
+func TestNumericDiv(t *testing.T) {
+	tests := []struct {
+		num   string
+		scale int
+		den   int64
+		want  string
+	}{
+		{"3", 0, 2, "1.5000000000000000"},
+		{"3", 0, 3, "1.00000000000000000000"},
+		{"2", 0, 3, "0.66666666666666666667"},
+		{"-2", 0, 3, "-0.66666666666666666667"},
+		{"1", 0, 30000, "0.000033333333333333333333"},
+		{"300", 0, 2, "150.0000000000000000"},
+		{"12345.678", 3, 2, "6172.8390000000000000"},
+		{"0", 2, 5, "0.00000000000000000000"},
+		{"1.23456789012345678901", 20, 1, "1.23456789012345678901"},
+	}
+	for _, tt := range tests {
+		num, _ := new(big.Rat).SetString(tt.num)
+		if got := numericDiv(num, tt.scale, tt.den); got != tt.want {
+			t.Errorf("numericDiv(%s, %d, %d) = %s, want %s", tt.num, tt.scale, tt.den, got, tt.want)
+		}
+	}
+}
+
+func TestTypeKind(t *testing.T) {
+	tests := []struct {
+		typ   string
+		kind  colKind
+		scale int
+	}{
+		{"integer", kindInt, -1},
+		{"smallint", kindInt, -1},
+		{"bigint", kindBigint, -1},
+		{"double precision", kindFloat, -1},
+		{"numeric", kindDecimal, -1},
+		{"numeric(10,2)", kindDecimal, 2},
+		{"numeric(10)", kindDecimal, 0},
+		{"text", kindText, -1},
+		{"character varying(40)", kindText, -1},
+		{"real", kindOther, -1},
+		{"timestamp with time zone", kindOther, -1},
+	}
+	for _, tt := range tests {
+		if kind, scale := typeKind(tt.typ); kind != tt.kind || scale != tt.scale {
+			t.Errorf("typeKind(%s) = %v, %d, want %v, %d", tt.typ, kind, scale, tt.kind, tt.scale)
+		}
+	}
+}
+
+func TestStateQuery(t *testing.T) {
+	s := newAggState(filmAggregate, map[string]string{"actor_id": "integer", "revenue": "integer"})
+	got, err := s.stateQuery("SELECT actor_id, count(*) AS n, count(revenue) AS c, sum(revenue) AS total, " +
+		"avg(revenue) AS mean, min(revenue) AS lo, max(revenue) AS hi FROM film WHERE title <> '' GROUP BY actor_id")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `SELECT actor_id AS __g0, count(*) AS __n, count(revenue) AS __c0, sum(revenue) AS __s0, ` +
+		`min(revenue) AS __lo0, max(revenue) AS __hi0 FROM film WHERE title <> '' GROUP BY actor_id`
+	if got != want {
+		t.Errorf("state query:\n got %s\nwant %s", got, want)
+	}
+}
@@ -0,0 +1,79 @@
+package reactive
+
+import (
+	"fmt"
+	"strings"
+
+	"go.uber.org/zap"
+
+	"github.com/zoravur/postgres-spreadsheet-view/server/pkg/pg_lineage"
+	"github.com/zoravur/postgres-spreadsheet-view/server/pkg/richcatalog"
+)
+
+// Analyze rewrites sql to inject PKs, resolves provenance for both versions and
+// picks a refresh strategy; override, if set, replaces the picked one when it
+// is at least as conservative (see Classification.Override).
+// It is run at subscribe time and again whenever the schema under the query changes.
+func Analyze(sql string, cat richcatalog.Catalog, override pg_lineage.Strategy) (Analysis, error) {
+	plan, err := pg_lineage.ClassifyQuery(sql, cat)
+	if err != nil {
+		return Analysis{}, fmt.Errorf("classify: %w", err)
+	}
+	strategy, err := plan.Override(override)
+	if err != nil {
+		return Analysis{}, err
+	}
+
+	// Run rewrite + provenance analysis
+	rew, pkByAlias, err := pg_lineage.RewriteSelectInjectPKs(sql, cat)
+	if err != nil {
+		return Analysis{}, fmt.Errorf("rewrite: %w", err)
+	}
+
+	prov, err := pg_lineage.ResolveProvenance(rew, cat)
+	if err != nil {
+		zap.L().Warn("provenance_failed", zap.String("rew", rew), zap.Error(err))
+		// Optional: fallback to FROM-clause extraction here
+	}
+
+	// Map alias -> table (for dependency tracking)
+	tablesSet := map[string]struct{}{}
+	for _, srcs := range prov {
+		for _, src := range srcs {
+			parts := strings.SplitN(src, ".", 2)
+			if len(parts) != 2 {
+				continue
+			}
+			base := parts[0]
+			tablesSet["public."+strings.ToLower(base)] = struct{}{}
+		}
+	}
+	if len(tablesSet) == 0 {
+		zap.L().Error("No base tables in query")
+	}
+
+	var tables []string
+	for t := range tablesSet {
+		tables = append(tables, t)
+	}
+
+	// Preserve injected PK aliases directly for incremental WHERE filters
+	pkAliasCols := make(map[string][]string)
+	for alias, injectedCols := range pkByAlias {
+		// Keep the injected columns exactly as the rewrite created them
+		pkAliasCols[alias] = append([]string(nil), injectedCols...)
+	}
+
+	provOrig, _ := pg_lineage.ResolveProvenance(sql, cat)
+
+	return Analysis{
+		Rewritten:     rew,
+		Tables:        tables,
+		PKCols:        pkAliasCols,
+		ProvOrig:      provOrig,
+		ProvRewritten: prov,
+		PKMapByAlias:  pkByAlias,
+		Plan:          plan,
+		Strategy:      strategy,
+	}, nil
+}
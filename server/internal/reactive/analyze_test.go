@@ -0,0 +1,61 @@
+package reactive
+
+import (
+	"testing"
+
+	"github.com/zoravur/postgres-spreadsheet-view/server/pkg/pg_lineage"
+)
+
+// stubCatalog is a richcatalog.Catalog over fixed tables.
+type stubCatalog struct {
+	cols map[string][]string
+	pks  map[string][]string
+}
+
+func (c stubCatalog) Columns(q string) ([]string, bool)     { v, ok := c.cols[q]; return v, ok }
+func (c stubCatalog) PrimaryKeys(q string) ([]string, bool) { v, ok := c.pks[q]; return v, ok }
+
+var filmCatalog = stubCatalog{
+	cols: map[string][]string{
+		"public.film":  {"id", "title", "revenue", "actor_id"},
+		"public.actor": {"id", "name"},
+	},
+	pks: map[string][]string{"public.film": {"id"}, "public.actor": {"id"}},
+}
+
+func TestAnalyzeOverride(t *testing.T) {
+	tests := []struct {
+		name     string
+		sql      string
+		override pg_lineage.Strategy
+		want     pg_lineage.Strategy // "" means refused
+	}{
+		{"classified", "SELECT title FROM film", "", pg_lineage.StrategyPKPushdown},
+		{"downgrade to full requery", "SELECT title FROM film", pg_lineage.StrategyFullRequery, pg_lineage.StrategyFullRequery},
+		{"downgrade to poll", "SELECT title FROM film ORDER BY title", pg_lineage.StrategyPoll, pg_lineage.StrategyPoll},
+		{"pushdown on ORDER BY/LIMIT", "SELECT title FROM film ORDER BY title LIMIT 5", pg_lineage.StrategyPKPushdown, ""},
+		{"pushdown on DISTINCT", "SELECT DISTINCT title FROM film", pg_lineage.StrategyPKPushdown, ""},
+		{"pushdown on window function", "SELECT title, rank() OVER (ORDER BY revenue) FROM film", pg_lineage.StrategyPKPushdown, ""},
+		{"pushdown on aggregate", "SELECT actor_id, count(*) FROM film GROUP BY actor_id", pg_lineage.StrategyPKPushdown, ""},
+		{"pushdown on outer join", "SELECT a.name, f.title FROM actor a LEFT JOIN film f ON f.actor_id = a.id", pg_lineage.StrategyPKPushdown, ""},
+		{"aggregate on plain select", "SELECT title FROM film", pg_lineage.StrategyIncrementalAggregate, ""},
+		{"full requery on poll query", "SELECT title, now() FROM film", pg_lineage.StrategyFullRequery, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := Analyze(tt.sql, filmCatalog, tt.override)
+			if tt.want == "" {
+				if err == nil {
+					t.Fatalf("override %s accepted as %s", tt.override, a.Strategy)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if a.Strategy != tt.want {
+				t.Errorf("strategy = %s, want %s", a.Strategy, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,183 @@
+package reactive
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/zoravur/postgres-spreadsheet-view/server/internal/metrics"
+	"github.com/zoravur/postgres-spreadsheet-view/server/pkg/pg_lineage"
+)
+
+var (
+	mPendingQueries = metrics.NewGauge("refresh_pending_queries")
+	mQueueDepth     = metrics.NewGauge("refresh_queue_depth")
+	mWorkersBusy    = metrics.NewGauge("refresh_workers_busy")
+	mChanges        = metrics.NewCounter("refresh_changes_total")
+	mBatches        = metrics.NewCounter("refresh_batches_total")
+	mBatchKeys      = metrics.NewCounter("refresh_batch_keys_total")
+)
+
+type RefresherOptions struct {
+	Window    time.Duration // how long changes for one live query are collected
+	Workers   int           // concurrent refresh queries
+	QueueSize int           // batches waiting for a worker before Enqueue blocks
+}
+
+// Refresher coalesces WAL commits per live query: commits arriving within
+// Window are merged into one PartialRefresh, and refreshes run on a fixed pool
+// of workers so a bulk UPDATE can't fan out into thousands of queries.
+type Refresher struct {
+	deps Deps
+	opt  RefresherOptions
+	jobs chan *LiveQuery
+
+	mu      sync.Mutex
+	pending map[*LiveQuery]*Batch
+	// running holds the queries a worker is refreshing, so each query has at
+	// most one refresh in flight and its updates go out in order. The value
+	// is set when q's next batch came due meanwhile and must be re-queued.
+	running map[*LiveQuery]bool
+	// stop is the Start context's Done channel: once it closes, batches that
+	// come due are dropped instead of waiting for a worker that's gone.
+	stop <-chan struct{}
+}
+
+func NewRefresher(deps Deps, opt RefresherOptions) *Refresher {
+	if opt.Window <= 0 {
+		opt.Window = 20 * time.Millisecond
+	}
+	if opt.Workers <= 0 {
+		opt.Workers = 4
+	}
+	if opt.QueueSize <= 0 {
+		opt.QueueSize = 256
+	}
+	return &Refresher{
+		deps:    deps,
+		opt:     opt,
+		jobs:    make(chan *LiveQuery, opt.QueueSize),
+		pending: map[*LiveQuery]*Batch{},
+		running: map[*LiveQuery]bool{},
+	}
+}
+
+// Start runs the worker pool until ctx is done.
+func (r *Refresher) Start(ctx context.Context) {
+	r.mu.Lock()
+	r.stop = ctx.Done()
+	r.mu.Unlock()
+	for i := 0; i < r.opt.Workers; i++ {
+		go r.work(ctx)
+	}
+}
+
+// Enqueue records the rows of q's tables changed by one commit. The first
+// commit for q opens a batch that is flushed to the workers after Window, or
+// once q's current refresh finishes if that takes longer.
+func (r *Refresher) Enqueue(q *LiveQuery, c Commit, aff Affected, rows []RowChange) {
+	mChanges.Add(int64(aff.Len()))
+
+	r.mu.Lock()
+	b, open := r.pending[q]
+	if !open {
+		b = &Batch{}
+		r.pending[q] = b
+		mPendingQueries.Set(int64(len(r.pending)))
+	}
+	b.Merge(c, aff, rows)
+	r.mu.Unlock()
+
+	if !open {
+		// blocks when the queue is full: backpressure on timers, not on the WAL reader
+		time.AfterFunc(r.opt.Window, func() { r.dispatch(q) })
+	}
+}
+
+// Poll re-runs every poll-strategy query each interval until ctx is done.
+// Their results can change without any WAL traffic (now(), random(), ...).
+func (r *Refresher) Poll(ctx context.Context, reg *Registry, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			for _, q := range reg.Snapshot() {
+				if q.Current().Strategy == pg_lineage.StrategyPoll {
+					r.Enqueue(q, Commit{}, Affected{}, nil)
+				}
+			}
+		}
+	}
+}
+
+func (r *Refresher) work(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case q := <-r.jobs:
+			mQueueDepth.Set(int64(len(r.jobs)))
+
+			r.mu.Lock()
+			if _, busy := r.running[q]; busy {
+				// leave the batch pending (later commits still merge into
+				// it) until the refresh in flight is done
+				r.running[q] = true
+				r.mu.Unlock()
+				continue
+			}
+			b := r.pending[q]
+			delete(r.pending, q)
+			mPendingQueries.Set(int64(len(r.pending)))
+			if b != nil {
+				r.running[q] = false
+			}
+			r.mu.Unlock()
+
+			if b == nil {
+				continue
+			}
+			mBatches.Inc()
+			mBatchKeys.Add(int64(b.Affected.Len()))
+			mWorkersBusy.Add(1)
+			zap.L().Debug("refresh_batch",
+				zap.String("live_query_id", q.ID),
+				zap.Int("keys", b.Affected.Len()),
+				zap.Int("commits", len(b.Commits)),
+			)
+			PartialRefresh(r.deps, q, *b)
+			mWorkersBusy.Add(-1)
+			r.done(q)
+		}
+	}
+}
+
+// done ends q's refresh and dispatches the batch that came due meanwhile.
+func (r *Refresher) done(q *LiveQuery) {
+	r.mu.Lock()
+	requeue := r.running[q]
+	delete(r.running, q)
+	r.mu.Unlock()
+	if requeue {
+		// not from the worker itself: a full queue would block every worker
+		go r.dispatch(q)
+	}
+}
+
+// dispatch hands q to the workers, waiting while the queue is full. It gives
+// up once the Start context is done, since nothing would take q any more.
+func (r *Refresher) dispatch(q *LiveQuery) {
+	r.mu.Lock()
+	stop := r.stop
+	r.mu.Unlock()
+	select {
+	case r.jobs <- q:
+		mQueueDepth.Set(int64(len(r.jobs)))
+	case <-stop:
+	}
+}
@@ -0,0 +1,181 @@
+package reactive
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zoravur/postgres-spreadsheet-view/server/internal/protocol"
+	"github.com/zoravur/postgres-spreadsheet-view/server/internal/sqlfake"
+)
+
+// refreshProbe is a database whose one row changes on every refresh, so each
+// refresh broadcasts an update carrying the xids of its batch. Refreshes
+// block until release is closed, if it is set.
+type refreshProbe struct {
+	release chan struct{}
+	started chan struct{} // one send per refresh
+
+	mu       sync.Mutex
+	calls    int
+	inFlight int
+	maxIn    int
+	updates  chan []int64
+}
+
+func newRefreshProbe() *refreshProbe {
+	return &refreshProbe{started: make(chan struct{}, 16), updates: make(chan []int64, 16)}
+}
+
+func (p *refreshProbe) refresher(opt RefresherOptions) (*Refresher, *LiveQuery) {
+	db, _ := sqlfake.Open(func(_ context.Context, c sqlfake.Call) (sqlfake.Result, error) {
+		p.mu.Lock()
+		p.calls++
+		n := p.calls
+		p.inFlight++
+		p.maxIn = max(p.maxIn, p.inFlight)
+		release := p.release
+		p.mu.Unlock()
+
+		p.started <- struct{}{}
+		if release != nil {
+			<-release
+		}
+
+		p.mu.Lock()
+		p.inFlight--
+		p.mu.Unlock()
+		return sqlfake.Result{Columns: []string{"n", "_pk_id"}, Rows: [][]any{{int64(n), int64(1)}}}, nil
+	})
+	deps := Deps{DB: db, Broadcast: func(_ *LiveQuery, typ protocol.Type, payload any) {
+		if typ == protocol.TypeUpdate {
+			p.updates <- payload.(Update).XIDs
+		}
+	}}
+	q := &LiveQuery{ID: "q", Analysis: Analysis{Tables: []string{"public.film"}, Rewritten: "SELECT n, id AS _pk_id FROM film"}}
+	q.results = newResultSet(nil)
+	return NewRefresher(deps, opt), q
+}
+
+func (p *refreshProbe) next(t *testing.T) []int64 {
+	t.Helper()
+	select {
+	case xids := <-p.updates:
+		return xids
+	case <-time.After(2 * time.Second):
+		t.Fatal("no update")
+		return nil
+	}
+}
+
+func (p *refreshProbe) none(t *testing.T, wait time.Duration) {
+	t.Helper()
+	select {
+	case xids := <-p.updates:
+		t.Errorf("unexpected update for xids %v", xids)
+	case <-time.After(wait):
+	}
+}
+
+func enqueue(r *Refresher, q *LiveQuery, xid int64) {
+	r.Enqueue(q, Commit{XID: xid}, Affected{"public.film": {{"id": 1}}}, nil)
+}
+
+// Commits arriving within the window refresh once, together.
+func TestRefresherCoalesces(t *testing.T) {
+	p := newRefreshProbe()
+	r, q := p.refresher(RefresherOptions{Window: 50 * time.Millisecond, Workers: 4})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r.Start(ctx)
+
+	for xid := int64(1); xid <= 3; xid++ {
+		enqueue(r, q, xid)
+	}
+	if got := p.next(t); !reflect.DeepEqual(got, []int64{1, 2, 3}) {
+		t.Errorf("update xids = %v, want [1 2 3]", got)
+	}
+	p.none(t, 100*time.Millisecond)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.calls != 1 {
+		t.Errorf("%d refreshes, want 1", p.calls)
+	}
+}
+
+// Commits that come due while q is refreshing wait for that refresh, then go
+// out as one batch; q never has two refreshes in flight.
+func TestRefresherOneInFlight(t *testing.T) {
+	p := newRefreshProbe()
+	p.release = make(chan struct{})
+	r, q := p.refresher(RefresherOptions{Window: 5 * time.Millisecond, Workers: 4})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r.Start(ctx)
+
+	enqueue(r, q, 1)
+	<-p.started
+
+	// xid 2 comes due mid-refresh and is parked; xid 3 merges into it
+	enqueue(r, q, 2)
+	time.Sleep(30 * time.Millisecond)
+	enqueue(r, q, 3)
+	time.Sleep(30 * time.Millisecond)
+
+	p.mu.Lock()
+	calls := p.calls
+	p.mu.Unlock()
+	if calls != 1 {
+		t.Fatalf("%d refreshes started while the first was in flight", calls)
+	}
+
+	close(p.release)
+	if got := p.next(t); !reflect.DeepEqual(got, []int64{1}) {
+		t.Errorf("first update xids = %v, want [1]", got)
+	}
+	if got := p.next(t); !reflect.DeepEqual(got, []int64{2, 3}) {
+		t.Errorf("requeued update xids = %v, want [2 3]", got)
+	}
+	p.none(t, 50*time.Millisecond)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.maxIn != 1 {
+		t.Errorf("%d refreshes in flight at once, want 1", p.maxIn)
+	}
+}
+
+// Once the Start context is done, a batch coming due with the queue full
+// is dropped rather than blocking its timer forever.
+func TestRefresherDispatchStops(t *testing.T) {
+	p := newRefreshProbe()
+	r, q := p.refresher(RefresherOptions{QueueSize: 1, Workers: 1})
+	ctx, cancel := context.WithCancel(context.Background())
+	r.Start(ctx)
+	cancel()
+
+	// a worker may still take a job or two before it sees ctx is done; keep
+	// the queue full until they have all stopped
+	filler := &LiveQuery{ID: "filler"}
+	for full := 0; full < 5; {
+		select {
+		case r.jobs <- filler:
+			full = 0
+		default:
+			full++
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	returned := make(chan struct{})
+	go func() {
+		r.dispatch(q)
+		close(returned)
+	}()
+	select {
+	case <-returned:
+	case <-time.After(time.Second):
+		t.Fatal("dispatch blocked after the context was done")
+	}
+}
@@ -0,0 +1,58 @@
+package reactive
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	pg_query "github.com/pganalyze/pg_query_go/v6"
+
+	"github.com/zoravur/postgres-spreadsheet-view/server/pkg/pg_lineage"
+)
+
+// Fingerprint identifies a live query so identical subscriptions can share one
+// LiveQuery. pg_query's fingerprint ignores formatting, comments and constant
+// values, so the constants are hashed back in as the query's parameters, along
+// with the database role the query runs as and any strategy override.
+//
+//	SELECT * FROM actor WHERE actor_id = 1   -- same key as
+//	select *  from actor where actor_id=1    -- but not as ... = 2
+func Fingerprint(sql, role string, strategy pg_lineage.Strategy) (string, error) {
+	fp, err := pg_query.Fingerprint(sql)
+	if err != nil {
+		return "", fmt.Errorf("fingerprint: %w", err)
+	}
+	params, err := literals(sql)
+	if err != nil {
+		return "", fmt.Errorf("fingerprint: %w", err)
+	}
+
+	h := sha256.New()
+	for _, p := range params {
+		h.Write([]byte(p))
+		h.Write([]byte{0})
+	}
+	h.Write([]byte{1})
+	h.Write([]byte(role))
+	h.Write([]byte{1})
+	h.Write([]byte(strategy))
+	return fp + "-" + hex.EncodeToString(h.Sum(nil))[:16], nil
+}
+
+// literals returns the source text of every constant in sql, in order.
+func literals(sql string) ([]string, error) {
+	res, err := pg_query.Scan(sql)
+	if err != nil {
+		return nil, err
+	}
+	var out []string
+	for _, t := range res.GetTokens() {
+		switch t.GetToken() {
+		case pg_query.Token_ICONST, pg_query.Token_FCONST, pg_query.Token_SCONST,
+			pg_query.Token_USCONST, pg_query.Token_BCONST, pg_query.Token_XCONST,
+			pg_query.Token_TRUE_P, pg_query.Token_FALSE_P, pg_query.Token_NULL_P:
+			out = append(out, sql[t.GetStart():t.GetEnd()])
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,85 @@
+package reactive
+
+import (
+	"fmt"
+
+	"github.com/zoravur/postgres-spreadsheet-view/server/internal/protocol"
+)
+
+// Presence states a subscriber can report, and PresenceLeft, which is sent to
+// the others when a subscriber goes away.
+const (
+	PresenceViewing = protocol.PresenceViewing
+	PresenceEditing = protocol.PresenceEditing
+	PresenceIdle    = protocol.PresenceIdle
+	PresenceLeft    = protocol.PresenceLeft
+)
+
+// Presence is what one subscriber of a live query is doing; see
+// protocol.Presence.
+type Presence = protocol.Presence
+
+// ValidPresenceState reports whether a subscriber may report state s.
+func ValidPresenceState(s string) error {
+	switch s {
+	case PresenceViewing, PresenceEditing, PresenceIdle:
+		return nil
+	default:
+		return fmt.Errorf("unknown presence state %q", s)
+	}
+}
+
+// SetPresence records cl's presence and sends it as "presence" to every other
+// subscriber of q. It is stored and sent under one lock, so the others see
+// changes in order.
+func (q *LiveQuery) SetPresence(cl *Client, p Presence) {
+	q.Mu.Lock()
+	defer q.Mu.Unlock()
+	if _, ok := q.Clients[cl]; !ok {
+		return // unsubscribed meanwhile
+	}
+	if q.presence == nil {
+		q.presence = map[*Client]Presence{}
+	}
+	q.presence[cl] = p
+	q.sendPresenceLocked(cl, p)
+}
+
+// SendPresence sends cl the presence of every other subscriber of q, one
+// "presence" message each, so a new subscriber sees who is already there.
+func (q *LiveQuery) SendPresence(cl *Client) error {
+	q.Mu.RLock()
+	defer q.Mu.RUnlock()
+	for other, p := range q.presence {
+		if other == cl {
+			continue
+		}
+		if err := cl.Send(protocol.TypePresence, p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// dropPresence forgets cl's presence and tells the others it left. Called
+// with Mu held.
+func (q *LiveQuery) dropPresence(cl *Client) {
+	p, ok := q.presence[cl]
+	if !ok {
+		return
+	}
+	delete(q.presence, cl)
+	q.sendPresenceLocked(cl, Presence{Peer: p.Peer, User: p.User, State: PresenceLeft})
+}
+
+// sendPresenceLocked sends p to every subscriber but from. Presence isn't
+// part of the result, so it goes through Send: a client resyncing its rows
+// still gets it.
+func (q *LiveQuery) sendPresenceLocked(from *Client, p Presence) {
+	for cl := range q.Clients {
+		if cl == from {
+			continue
+		}
+		_ = cl.Send(protocol.TypePresence, p)
+	}
+}
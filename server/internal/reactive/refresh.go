@@ -3,7 +3,14 @@ package reactive
 import (
 	"fmt"
 	"log"
+	"sort"
 	"strings"
+
+	"github.com/lib/pq"
+
+	"github.com/zoravur/postgres-spreadsheet-view/server/internal/common"
+	"github.com/zoravur/postgres-spreadsheet-view/server/internal/protocol"
+	"github.com/zoravur/postgres-spreadsheet-view/server/pkg/pg_lineage"
 )
 
 func AffectedKey(evt WALEvent) string { // "public.actor"
@@ -43,73 +50,211 @@ func AffectedKey(evt WALEvent) string { // "public.actor"
 //		return "WHERE " + strings.Join(parts, " OR "), args
 //	}
 //
-// buildPKPredicate constructs WHERE clauses for affected PKs
-// using the injected alias-prefixed _pk_* columns.
-func buildPKPredicate(q *LiveQuery, affected map[string]map[string]any) (string, []any) {
-	log.Printf("🔍 buildPKPredicate(q=%s)", q.ID)
-
-	var parts []string
-	var args []any
-	arg := 1
-
-	for alias, injectedPKCols := range q.PKCols {
-		log.Printf("   alias=%s injectedPKCols=%v", alias, injectedPKCols)
-
-		for fq, changedKeys := range affected {
-			log.Printf("   checking affected table=%s keys=%v", fq, changedKeys)
-
-			// match by suffix: "_<col>" (e.g. _pk_f_film_id ends with "_film_id")
-			for _, injected := range injectedPKCols {
-				for baseKey, val := range changedKeys {
-					if strings.HasSuffix(injected, "_"+baseKey) {
-						part := fmt.Sprintf("%s = $%d", injected, arg)
-						args = append(args, val)
-						parts = append(parts, part)
-						log.Printf("      ✅ matched %s -> %s (val=%v)", baseKey, injected, val)
-						arg++
+// Affected lists the primary keys of changed rows per table ("public.actor").
+type Affected map[string][]map[string]any
+
+// Len is the number of changed rows across all tables.
+func (a Affected) Len() int {
+	n := 0
+	for _, pks := range a {
+		n += len(pks)
+	}
+	return n
+}
+
+// Commit identifies a source transaction by its xid and commit LSN.
+type Commit struct {
+	LSN string `json:"lsn"`
+	XID int64  `json:"xid"`
+}
+
+// RowChange is one row's before/after image from the WAL. Old is nil for
+// inserts and New for deletes. Old only holds every column under REPLICA
+// IDENTITY FULL; otherwise it is just the primary key.
+type RowChange struct {
+	Table string // "public.film"
+	Kind  string // insert|update|delete
+	XID   int64  // committing transaction
+	Old   map[string]any
+	New   map[string]any
+}
+
+// Batch is the input to one refresh: the keys and row images changed by one
+// or more consecutive commits. Commits are only ever merged whole, so a
+// refresh never shows part of a transaction.
+type Batch struct {
+	Affected Affected
+	Rows     []RowChange // in commit order
+	Commits  []Commit
+}
+
+// Merge folds another commit's changes into b.
+func (b *Batch) Merge(c Commit, aff Affected, rows []RowChange) {
+	if b.Affected == nil {
+		b.Affected = Affected{}
+	}
+	for fq, pks := range aff {
+		b.Affected[fq] = append(b.Affected[fq], pks...)
+	}
+	b.Rows = append(b.Rows, rows...)
+	if c != (Commit{}) {
+		b.Commits = append(b.Commits, c) // polls carry no commit
+	}
+}
+
+// Update is the payload of an "update" message; see protocol.Update.
+type Update = protocol.Update
+
+// newUpdate stamps the next sequence number on ops and remembers the update
+// for replay. Called with rowsMu held.
+func newUpdate(q *LiveQuery, b Batch, ops []RowOp) Update {
+	q.seq++
+	u := Update{ID: q.ID, Seq: q.seq, Ops: ops}
+	if n := len(b.Commits); n > 0 {
+		u.LSN, u.XID = b.Commits[n-1].LSN, b.Commits[n-1].XID
+		for _, c := range b.Commits {
+			u.XIDs = append(u.XIDs, c.XID)
+		}
+	}
+	q.replay.add(u)
+	return u
+}
+
+// pkFilter selects a live query's rows by the values of its injected _pk_*
+// columns: a row matches if any filtered column holds one of its values.
+type pkFilter map[string]map[string]bool // injected column -> formatted values
+
+// buildPKFilter maps affected base-table keys onto the alias-prefixed _pk_*
+// columns, matching by suffix: _pk_f_film_id ends with "_film_id".
+func buildPKFilter(a Analysis, affected Affected) pkFilter {
+	f := pkFilter{}
+	for _, injectedPKCols := range a.PKCols {
+		for _, pks := range affected {
+			for _, changedKeys := range pks {
+				for _, injected := range injectedPKCols {
+					for baseKey, val := range changedKeys {
+						if val == nil || !strings.HasSuffix(injected, "_"+baseKey) {
+							continue
+						}
+						if f[injected] == nil {
+							f[injected] = map[string]bool{}
+						}
+						f[injected][common.FormatKeyValue(val)] = true
 					}
 				}
 			}
 		}
 	}
+	return f
+}
+
+// where renders the filter as one "col = ANY($n)" per column. Postgres infers
+// each array's element type from the column, and text elements cast cleanly,
+// so the statement text only depends on which columns are filtered.
+func (f pkFilter) where() (string, []any) {
+	cols := make([]string, 0, len(f))
+	for col := range f {
+		cols = append(cols, col)
+	}
+	sort.Strings(cols)
 
-	if len(parts) == 0 {
-		log.Printf("⚠️  buildPKPredicate: no PK matches for query %s", q.ID)
-		return "", nil
+	parts := make([]string, len(cols))
+	args := make([]any, len(cols))
+	for i, col := range cols {
+		vals := make([]string, 0, len(f[col]))
+		for v := range f[col] {
+			vals = append(vals, v)
+		}
+		sort.Strings(vals)
+		parts[i] = fmt.Sprintf("%s = ANY($%d)", col, i+1)
+		args[i] = pq.Array(vals)
 	}
+	return "WHERE " + strings.Join(parts, " OR "), args
+}
 
-	where := "WHERE " + strings.Join(parts, " OR ")
-	log.Printf("✅ buildPKPredicate WHERE: %s ARGS: %v", where, args)
-	return where, args
+// matches is where() evaluated in Go, for rows we hold but the query didn't return.
+func (f pkFilter) matches(pk map[string]any) bool {
+	for col, vals := range f {
+		if have, ok := pk[col]; ok && have != nil && vals[common.FormatKeyValue(have)] {
+			return true
+		}
+	}
+	return false
 }
 
-// Rerun only affected rows by wrapping the rewritten query and applying PK WHERE.
-func PartialRefresh(deps Deps, q *LiveQuery, affected map[string]map[string]any) {
-	log.Println("PartialRefresh")
-	where, args := buildPKPredicate(q, affected)
-	if where == "" {
-		return
+// PartialRefresh brings q up to date after a batch of changes. Under
+// pk_pushdown only the affected rows are re-run, by wrapping the rewritten
+// query in a PK WHERE; incremental aggregates fold the batch's row images into
+// their groups; every other strategy re-runs the whole query. Either way the
+// result is diffed against the materialized copy and sent as one "update" for
+// the whole batch.
+func PartialRefresh(deps Deps, q *LiveQuery, b Batch) {
+	a := q.Current()
+
+	q.rowsMu.Lock()
+	defer q.rowsMu.Unlock()
+
+	if q.loaded.includesAll(b) {
+		return // committed before the snapshot the rows were loaded from
 	}
 
-	sql := fmt.Sprintf("SELECT * FROM (%s) __src %s", q.Rewritten, where)
+	var next []keyedRow
+	switch a.Strategy {
+	case pg_lineage.StrategyIncrementalAggregate:
+		rows, err := q.refreshAggregate(deps.DB, b.Rows)
+		if err != nil {
+			deps.Broadcast(q, protocol.TypeError, protocol.AsError(err, protocol.CodeQueryFailed))
+			return
+		}
+		next = rows
+	case pg_lineage.StrategyPKPushdown:
+		filter := buildPKFilter(a, b.Affected)
+		if len(filter) == 0 {
+			log.Printf("⚠️  no PK matches for query %s", q.ID)
+			return
+		}
+		where, args := filter.where()
+		refreshed, err := q.queryWhere(deps.DB, where, args...)
+		if err != nil {
+			// broadcast an error to clients (optional)
+			deps.Broadcast(q, protocol.TypeError, protocol.AsError(err, protocol.CodeQueryFailed))
+			return
+		}
+		next = q.results.patched(refreshed, filter)
+	default:
+		all, err := q.query(deps.DB)
+		if err != nil {
+			deps.Broadcast(q, protocol.TypeError, protocol.AsError(err, protocol.CodeQueryFailed))
+			return
+		}
+		next = all
+	}
 
-	rows, err := deps.DB.Query(sql, args...)
-	if err != nil {
-		// broadcast an error to clients (optional)
-		deps.Broadcast(q, "error", map[string]any{"error": err.Error()})
+	ops, rs := diffResults(q.results, next)
+	q.results = rs
+	if len(ops) == 0 {
 		return
 	}
-	defer rows.Close()
+	deps.Broadcast(q, protocol.TypeUpdate, newUpdate(q, b, ops))
+}
+
+// FullRefresh re-runs the whole rewritten query and sends the complete result
+// as a "reload", for when incremental updates can't describe what changed.
+func FullRefresh(deps Deps, q *LiveQuery) {
+	q.rowsMu.Lock()
+	defer q.rowsMu.Unlock()
 
-	// serialize rows just like handleeditablequery
-	cols, _ := rows.Columns()
-	results, err := SerializeEditableRows(rows, cols, q.PKMapByAlias, q.ProvOrig, q.ProvRewritten)
-	if err != nil {
-		deps.Broadcast(q, "error", map[string]any{"error": err.Error()})
+	// full reloads follow schema changes; cached plans may no longer fit
+	q.closeStatements()
+	if err := q.load(deps.DB); err != nil {
+		deps.Broadcast(q, protocol.TypeError, protocol.AsError(err, protocol.CodeQueryFailed))
 		return
 	}
 
-	deps.Broadcast(q, "update", results)
+	// updates from before the reload can't be replayed on top of it
+	q.seq++
+	q.replay.reset()
+	deps.Broadcast(q, protocol.TypeReload, q.reload())
 }
 
 // small helper copied from your handler
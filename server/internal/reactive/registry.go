@@ -2,27 +2,118 @@ package reactive
 
 import (
 	"sync"
+	"time"
 )
 
 type Registry struct {
-	mu   sync.RWMutex
-	data map[string]*LiveQuery
+	mu    sync.RWMutex
+	data  map[string]*LiveQuery
+	byKey map[string]*LiveQuery // Fingerprint -> shared query
+
+	// Linger keeps a query registered (and refreshed) for a while after its
+	// last subscriber leaves, so a client that reconnects can resume it.
+	Linger time.Duration
+	// ReplaySize is how many updates each query keeps for resuming clients.
+	ReplaySize int
 }
 
 func NewRegistry() *Registry {
-	return &Registry{data: make(map[string]*LiveQuery)}
+	return &Registry{data: make(map[string]*LiveQuery), byKey: make(map[string]*LiveQuery)}
 }
 
 func (r *Registry) Register(q *LiveQuery) {
 	r.mu.Lock()
 	r.data[q.ID] = q
+	if q.Key != "" {
+		r.byKey[q.Key] = q
+	}
 	r.mu.Unlock()
 }
 
 func (r *Registry) Unregister(id string) {
 	r.mu.Lock()
+	r.remove(id)
+	r.mu.Unlock()
+}
+
+// remove drops a query from both indexes. Caller holds mu.
+func (r *Registry) remove(id string) {
+	q, ok := r.data[id]
+	if !ok {
+		return
+	}
 	delete(r.data, id)
+	if r.byKey[q.Key] == q {
+		delete(r.byKey, q.Key)
+	}
+	q.Close()
+}
+
+// Acquire returns the live query registered under key, creating it with create
+// if there is none, and takes a reference on it. Every Acquire must be paired
+// with a Release. create runs without the registry lock held; if another
+// subscriber registers the same key meanwhile, theirs wins and ours is closed.
+func (r *Registry) Acquire(key string, create func() (*LiveQuery, error)) (*LiveQuery, error) {
+	r.mu.Lock()
+	if q, ok := r.byKey[key]; ok {
+		q.refs++
+		r.mu.Unlock()
+		return q, nil
+	}
 	r.mu.Unlock()
+
+	q, err := create()
+	if err != nil {
+		return nil, err
+	}
+	q.Key = key
+	q.replay.max = r.ReplaySize
+
+	r.mu.Lock()
+	if existing, ok := r.byKey[key]; ok {
+		existing.refs++
+		r.mu.Unlock()
+		// ours was never registered, so nothing else will close it
+		q.Close()
+		return existing, nil
+	}
+	q.refs = 1
+	r.data[q.ID] = q
+	r.byKey[key] = q
+	r.mu.Unlock()
+	return q, nil
+}
+
+// Release detaches cl from q, telling the other subscribers it left if it
+// reported presence, and drops one reference; the query is
+// unregistered when the last reference goes, or Linger after that if no one
+// acquires it again. Reports whether it was unregistered right away.
+func (r *Registry) Release(q *LiveQuery, cl *Client) bool {
+	q.Mu.Lock()
+	delete(q.Clients, cl)
+	q.dropPresence(cl)
+	q.Mu.Unlock()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	q.refs--
+	if q.refs > 0 {
+		return false
+	}
+	if r.Linger <= 0 {
+		r.remove(q.ID)
+		return true
+	}
+	q.idleUntil = time.Now().Add(r.Linger)
+	time.AfterFunc(r.Linger, func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		// a later Release pushes idleUntil out and schedules its own check
+		if q.refs <= 0 && !time.Now().Before(q.idleUntil) && r.data[q.ID] == q {
+			r.remove(q.ID)
+		}
+	})
+	return false
 }
 
 func (r *Registry) Get(id string) (*LiveQuery, bool) {
@@ -59,12 +150,16 @@ func (r *Registry) SnapshotView() []map[string]any {
 	for _, q := range r.data {
 		q.Mu.RLock()
 		item := map[string]any{
-			"id":        q.ID,
-			"sql":       q.SQL,
-			"rewritten": q.Rewritten,
-			"tables":    append([]string(nil), q.Tables...), // copy slice
-			"pkCols":    clonePKMap(q.PKCols),
-			"clients":   len(q.Clients),
+			"id":          q.ID,
+			"fingerprint": q.Key,
+			"sql":         q.SQL,
+			"rewritten":   q.Rewritten,
+			"tables":      append([]string(nil), q.Tables...), // copy slice
+			"pkCols":      clonePKMap(q.PKCols),
+			"clients":     len(q.Clients),
+			"strategy":    q.Strategy,
+			"override":    q.Override,
+			"reasons":     append([]string(nil), q.Plan.Reasons...), // why the cheaper strategies don't apply
 		}
 		q.Mu.RUnlock()
 		out = append(out, item)
@@ -89,8 +184,9 @@ func (r *Registry) CleanupOrphans() int {
 		q.Mu.RLock()
 		noClients := len(q.Clients) == 0
 		q.Mu.RUnlock()
-		if noClients {
-			delete(r.data, id)
+		// refs also counts subscribers that acquired but haven't attached yet
+		if noClients && q.refs <= 0 && !time.Now().Before(q.idleUntil) {
+			r.remove(id)
 			count++
 		}
 	}
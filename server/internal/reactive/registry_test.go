@@ -0,0 +1,37 @@
+package reactive
+
+import (
+	"database/sql"
+	"testing"
+)
+
+// A create that loses the race to register its key is closed, and the
+// winner is shared.
+func TestAcquireClosesLoser(t *testing.T) {
+	r := NewRegistry()
+	winner := &LiveQuery{ID: "winner"}
+	loser := &LiveQuery{ID: "loser", stmts: map[string]*sql.Stmt{}}
+
+	got, err := r.Acquire("k", func() (*LiveQuery, error) {
+		// another subscriber registers k while this one is still creating
+		if _, err := r.Acquire("k", func() (*LiveQuery, error) { return winner, nil }); err != nil {
+			t.Fatal(err)
+		}
+		return loser, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != winner {
+		t.Fatalf("Acquire = %s, want the winner", got.ID)
+	}
+	if winner.refs != 2 {
+		t.Errorf("winner refs = %d, want 2", winner.refs)
+	}
+	if loser.stmts != nil {
+		t.Error("loser was not closed")
+	}
+	if _, ok := r.Get("loser"); ok {
+		t.Error("loser was registered")
+	}
+}
@@ -0,0 +1,49 @@
+package reactive
+
+// DefaultReplaySize is how many updates a live query keeps for resuming
+// subscribers when Registry.ReplaySize is unset.
+const DefaultReplaySize = 256
+
+// replayBuffer keeps a live query's most recent updates, so a client that
+// reconnects can be sent what it missed instead of a full reload.
+type replayBuffer struct {
+	max     int
+	updates []Update // ascending Seq
+}
+
+func (b *replayBuffer) add(u Update) {
+	if b.max <= 0 {
+		b.max = DefaultReplaySize
+	}
+	if len(b.updates) == b.max {
+		copy(b.updates, b.updates[1:])
+		b.updates = b.updates[:b.max-1]
+	}
+	b.updates = append(b.updates, u)
+}
+
+// reset forgets every update; after a reload they no longer apply.
+func (b *replayBuffer) reset() {
+	b.updates = nil
+}
+
+// since returns the updates after seq given the query is now at cur. It
+// reports false when seq is from the future (another incarnation of the query)
+// or older than the buffer reaches.
+func (b *replayBuffer) since(seq, cur uint64) ([]Update, bool) {
+	switch {
+	case seq == cur:
+		return nil, true
+	case seq > cur:
+		return nil, false
+	case len(b.updates) == 0 || b.updates[0].Seq > seq+1:
+		return nil, false
+	}
+	out := make([]Update, 0, cur-seq)
+	for _, u := range b.updates {
+		if u.Seq > seq {
+			out = append(out, u)
+		}
+	}
+	return out, true
+}
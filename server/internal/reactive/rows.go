@@ -0,0 +1,425 @@
+package reactive
+
+import (
+	"database/sql"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/zoravur/postgres-spreadsheet-view/server/internal/protocol"
+	"github.com/zoravur/postgres-spreadsheet-view/server/pkg/pg_lineage"
+)
+
+const (
+	OpInsert = "insert"
+	OpUpdate = "update"
+	OpDelete = "delete"
+	OpMove   = "move"
+)
+
+// RowOp is one row-level change in an "update" message; Key is the row's
+// identity (see common.EncodeRowKey).
+type RowOp = protocol.RowOp
+
+// knownRow is a row clients currently display, plus its injected PK values.
+type knownRow struct {
+	pk  map[string]any // injected _pk_* column -> value
+	row EditableRow
+}
+
+// resultSet is the server-side materialized copy of a live query's result:
+// rows keyed by identity, in result order.
+type resultSet struct {
+	order []string
+	rows  map[string]knownRow
+}
+
+// Load runs the full rewritten query and makes the result the new
+// materialized copy. Later refreshes diff against it.
+func (q *LiveQuery) Load(db *sql.DB) error {
+	q.rowsMu.Lock()
+	defer q.rowsMu.Unlock()
+	return q.load(db)
+}
+
+// load is Load with rowsMu already held. The result is read under one
+// snapshot, recorded in q.loaded so refreshes skip commits it already has.
+// Incremental aggregates are loaded from their per-group state instead of the
+// rewritten query.
+func (q *LiveQuery) load(db *sql.DB) error {
+	aggregate := q.Current().Strategy == pg_lineage.StrategyIncrementalAggregate
+	var keyed []keyedRow
+	var agg *aggState
+	p, err := snapshotRead(db, func(tx *sql.Tx, p *ReadPoint) error {
+		var err error
+		if aggregate {
+			agg, keyed, err = q.loadAggregate(tx, p)
+		} else {
+			keyed, err = q.query(tx)
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	q.agg = agg
+	q.results = newResultSet(keyed)
+	q.loaded = p
+	return nil
+}
+
+// Attach adds cl to the query's clients and sends it "subscribed": sub with
+// the materialized rows (see reload). Both happen under rowsMu, so the client
+// sees every later "update" after its snapshot and none before it. Returns
+// the number of rows sent.
+func (q *LiveQuery) Attach(cl *Client, sub protocol.Subscribed) (int, error) {
+	q.rowsMu.Lock()
+	defer q.rowsMu.Unlock()
+
+	q.Mu.Lock()
+	q.Clients[cl] = struct{}{}
+	q.Mu.Unlock()
+
+	sub.Snapshot = q.reload()
+	return len(q.results.order), cl.SendFor(q, protocol.TypeSubscribed, sub)
+}
+
+// Resume is Attach for a client that was subscribed before and has seen every
+// message up to seq: "subscribed" comes without rows and with Resumed set,
+// followed by the updates it missed from the replay buffer. If they aren't all
+// there anymore it falls back to Attach. Reports whether it replayed.
+func (q *LiveQuery) Resume(cl *Client, seq uint64, sub protocol.Subscribed) (bool, error) {
+	q.rowsMu.Lock()
+	missed, ok := q.replay.since(seq, q.seq)
+	if !ok {
+		q.rowsMu.Unlock()
+		_, err := q.Attach(cl, sub)
+		return false, err
+	}
+	defer q.rowsMu.Unlock()
+
+	q.Mu.Lock()
+	q.Clients[cl] = struct{}{}
+	q.Mu.Unlock()
+
+	sub.Snapshot = protocol.Snapshot{ID: q.ID, Seq: seq}
+	sub.Resumed = true
+	if err := cl.SendFor(q, protocol.TypeSubscribed, sub); err != nil {
+		return true, err
+	}
+	for _, u := range missed {
+		if err := cl.SendFor(q, protocol.TypeUpdate, u); err != nil {
+			return true, err
+		}
+	}
+	return true, nil
+}
+
+// reload is the payload of a "reload" message: the rows, the seq they are
+// current as of, and the WAL position of the snapshot they were loaded from.
+func (q *LiveQuery) reload() protocol.Snapshot {
+	rows, keys := q.results.snapshot()
+	return protocol.Snapshot{ID: q.ID, Seq: q.seq, LSN: q.loaded.LSN, Rows: rows, Keys: keys}
+}
+
+// Resync sends cl the materialized rows again, for a client that dropped some
+// of this query's updates. Like Attach it holds rowsMu, so the "reload" lands
+// between two updates. Clients that have unsubscribed are skipped.
+func (q *LiveQuery) Resync(cl *Client) error {
+	q.rowsMu.Lock()
+	defer q.rowsMu.Unlock()
+
+	q.Mu.RLock()
+	_, ok := q.Clients[cl]
+	q.Mu.RUnlock()
+	if !ok {
+		return nil
+	}
+
+	return cl.SendFor(q, protocol.TypeReload, q.reload())
+}
+
+// Rows returns the materialized result and its row keys, in order.
+func (q *LiveQuery) Rows() ([]EditableRow, []string) {
+	q.rowsMu.Lock()
+	defer q.rowsMu.Unlock()
+	return q.results.snapshot()
+}
+
+// Handles returns the distinct edit handles in the materialized result.
+func (q *LiveQuery) Handles() []string {
+	q.rowsMu.Lock()
+	defer q.rowsMu.Unlock()
+	var out []string
+	seen := map[string]bool{}
+	if q.results == nil {
+		return out
+	}
+	for _, kr := range q.results.rows {
+		for _, cell := range kr.row {
+			if cell.EditHandle != "" && !seen[cell.EditHandle] {
+				seen[cell.EditHandle] = true
+				out = append(out, cell.EditHandle)
+			}
+		}
+	}
+	return out
+}
+
+// HasHandle reports whether any cell of the materialized result carries
+// handle h, i.e. the row it names is part of the result.
+func (q *LiveQuery) HasHandle(h string) bool {
+	q.rowsMu.Lock()
+	defer q.rowsMu.Unlock()
+	if q.results == nil {
+		return false
+	}
+	for _, kr := range q.results.rows {
+		for _, cell := range kr.row {
+			if cell.EditHandle == h {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// querier is a *sql.DB or a *sql.Tx.
+type querier interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+}
+
+// query runs the full rewritten query and serializes it with row keys.
+func (q *LiveQuery) query(qr querier) ([]keyedRow, error) {
+	rows, err := qr.Query(q.Current().Rewritten)
+	if err != nil {
+		return nil, err
+	}
+	return q.serialize(rows)
+}
+
+// queryWhere runs the rewritten query wrapped in a filter on its projected
+// _pk_* columns (see pkFilter). Filtered refreshes go through prepared
+// statements, since the same few shapes run for every batch. Called with
+// rowsMu held.
+func (q *LiveQuery) queryWhere(db *sql.DB, where string, args ...any) ([]keyedRow, error) {
+	st, err := q.prepared(db, fmt.Sprintf("SELECT * FROM (%s) __src %s", q.Current().Rewritten, where))
+	if err != nil {
+		return nil, err
+	}
+	rows, err := st.Query(args...)
+	if err != nil {
+		return nil, err
+	}
+	return q.serialize(rows)
+}
+
+func (q *LiveQuery) serialize(rows *sql.Rows) ([]keyedRow, error) {
+	defer rows.Close()
+	a := q.Current()
+	cols, _ := rows.Columns()
+	return serializeKeyedRows(rows, cols, a.PKMapByAlias, a.ProvOrig, a.ProvRewritten)
+}
+
+// prepared returns the cached statement for sqlText, preparing it on first use.
+// Called with rowsMu held.
+func (q *LiveQuery) prepared(db *sql.DB, sqlText string) (*sql.Stmt, error) {
+	if st, ok := q.stmts[sqlText]; ok {
+		return st, nil
+	}
+	st, err := db.Prepare(sqlText)
+	if err != nil {
+		return nil, err
+	}
+	if q.stmts == nil {
+		q.stmts = map[string]*sql.Stmt{}
+	}
+	q.stmts[sqlText] = st
+	return st, nil
+}
+
+// closeStatements drops every prepared statement. Called with rowsMu held.
+func (q *LiveQuery) closeStatements() {
+	for _, st := range q.stmts {
+		_ = st.Close()
+	}
+	q.stmts = nil
+}
+
+// Close releases the query's prepared statements once it is unregistered.
+func (q *LiveQuery) Close() {
+	q.rowsMu.Lock()
+	defer q.rowsMu.Unlock()
+	q.closeStatements()
+}
+
+// newResultSet builds a result set, disambiguating repeated keys (rows without
+// a PK, or joins that repeat a PK combination) as key#2, key#3, ...
+func newResultSet(keyed []keyedRow) *resultSet {
+	rs := &resultSet{order: make([]string, 0, len(keyed)), rows: make(map[string]knownRow, len(keyed))}
+	counts := map[string]int{}
+	for _, kr := range keyed {
+		key := kr.Key
+		if n := counts[kr.Key]; n > 0 {
+			key = fmt.Sprintf("%s#%d", kr.Key, n+1)
+		}
+		counts[kr.Key]++
+		rs.order = append(rs.order, key)
+		rs.rows[key] = knownRow{pk: kr.PK, row: kr.Row}
+	}
+	return rs
+}
+
+// snapshot returns the rows and their keys in order.
+func (rs *resultSet) snapshot() ([]EditableRow, []string) {
+	if rs == nil {
+		return []EditableRow{}, []string{}
+	}
+	rows := make([]EditableRow, len(rs.order))
+	for i, k := range rs.order {
+		rows[i] = rs.rows[k].row
+	}
+	return rows, append([]string(nil), rs.order...)
+}
+
+// patched merges a PK-pushdown refresh into the current rows and returns the
+// full next result: refreshed rows replace their old selves, new identities are
+// appended, and known rows matching the refresh predicate that didn't come
+// back are dropped. Only valid for queries without ORDER BY/LIMIT.
+func (rs *resultSet) patched(refreshed []keyedRow, filter pkFilter) []keyedRow {
+	if rs == nil {
+		rs = newResultSet(nil)
+	}
+	fresh := newResultSet(refreshed)
+
+	next := make([]keyedRow, 0, len(rs.order)+len(fresh.order))
+	for _, k := range rs.order {
+		if kr, ok := fresh.rows[k]; ok {
+			next = append(next, keyedRow{Key: k, PK: kr.pk, Row: kr.row})
+			continue
+		}
+		old := rs.rows[k]
+		if filter.matches(old.pk) {
+			continue // gone: the predicate selected it and it didn't come back
+		}
+		next = append(next, keyedRow{Key: k, PK: old.pk, Row: old.row})
+	}
+	for _, k := range fresh.order {
+		if _, ok := rs.rows[k]; !ok {
+			kr := fresh.rows[k]
+			next = append(next, keyedRow{Key: k, PK: kr.pk, Row: kr.row})
+		}
+	}
+	return next
+}
+
+// diffResults computes the ops that turn old into next and returns the new set.
+// Rows that keep their relative order (a longest increasing subsequence of old
+// positions) stay put; every other surviving row becomes a move.
+func diffResults(old *resultSet, next []keyedRow) ([]RowOp, *resultSet) {
+	if old == nil {
+		old = newResultSet(nil)
+	}
+	nrs := newResultSet(next)
+	var ops []RowOp
+
+	// 1) deletes
+	for _, k := range old.order {
+		if _, ok := nrs.rows[k]; !ok {
+			ops = append(ops, RowOp{Op: OpDelete, Key: k})
+		}
+	}
+
+	// 2) cell-level updates
+	for _, k := range nrs.order {
+		if o, ok := old.rows[k]; ok {
+			if cells := changedCells(o.row, nrs.rows[k].row); len(cells) > 0 {
+				ops = append(ops, RowOp{Op: OpUpdate, Key: k, Row: cells})
+			}
+		}
+	}
+
+	// 3) inserts + moves, by new position
+	oldPos := make(map[string]int, len(old.order))
+	i := 0
+	for _, k := range old.order {
+		if _, ok := nrs.rows[k]; ok {
+			oldPos[k] = i
+			i++
+		}
+	}
+	var seq []int // old positions of surviving rows, in new order
+	var seqKeys []string
+	for _, k := range nrs.order {
+		if p, ok := oldPos[k]; ok {
+			seq = append(seq, p)
+			seqKeys = append(seqKeys, k)
+		}
+	}
+	stable := make(map[string]bool, len(seq))
+	for n, keep := range lisMembers(seq) {
+		if keep {
+			stable[seqKeys[n]] = true
+		}
+	}
+	for j, k := range nrs.order {
+		idx := j
+		if _, survived := oldPos[k]; !survived {
+			ops = append(ops, RowOp{Op: OpInsert, Key: k, Index: &idx, Row: nrs.rows[k].row})
+		} else if !stable[k] {
+			ops = append(ops, RowOp{Op: OpMove, Key: k, Index: &idx})
+		}
+	}
+	return ops, nrs
+}
+
+// lisMembers marks the elements of one longest strictly increasing subsequence.
+func lisMembers(seq []int) []bool {
+	keep := make([]bool, len(seq))
+	if len(seq) == 0 {
+		return keep
+	}
+	tails := []int{}              // indexes into seq: smallest tail of each length
+	prev := make([]int, len(seq)) // predecessor index in the subsequence
+	for i, v := range seq {
+		j := sort.Search(len(tails), func(j int) bool { return seq[tails[j]] >= v })
+		if j > 0 {
+			prev[i] = tails[j-1]
+		} else {
+			prev[i] = -1
+		}
+		if j == len(tails) {
+			tails = append(tails, i)
+		} else {
+			tails[j] = i
+		}
+	}
+	for i := tails[len(tails)-1]; i >= 0; i = prev[i] {
+		keep[i] = true
+	}
+	return keep
+}
+
+// changedCells returns the cells of next that differ from old.
+func changedCells(old, next EditableRow) EditableRow {
+	var out EditableRow
+	for col, cell := range next {
+		if oc, ok := old[col]; ok && oc.EditHandle == cell.EditHandle && sameValue(oc.Value, cell.Value) {
+			continue
+		}
+		if out == nil {
+			out = EditableRow{}
+		}
+		out[col] = cell
+	}
+	return out
+}
+
+func sameValue(a, b any) bool {
+	if ta, ok := a.(time.Time); ok {
+		tb, ok := b.(time.Time)
+		return ok && ta.Equal(tb)
+	}
+	return reflect.DeepEqual(a, b)
+}
@@ -0,0 +1,254 @@
+package reactive
+
+import (
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+
+	"github.com/zoravur/postgres-spreadsheet-view/server/pkg/pg_lineage"
+)
+
+// kr is a result row keyed by key with one title cell.
+func kr(key, title string) keyedRow {
+	return keyedRow{Key: key, PK: map[string]any{"_pk_film_id": key}, Row: EditableRow{"title": {EditHandle: "h" + key, Value: title}}}
+}
+
+func keyedRows(keys ...string) []keyedRow {
+	out := make([]keyedRow, len(keys))
+	for i, k := range keys {
+		out[i] = kr(k, k)
+	}
+	return out
+}
+
+// applyOps replays ops on order the way RowOp says clients must: deletes,
+// updates, then detach moved rows and place inserts and moves by Index.
+func applyOps(t *testing.T, order []string, ops []RowOp) []string {
+	t.Helper()
+	gone := map[string]bool{}
+	var placed []RowOp
+	for _, op := range ops {
+		switch op.Op {
+		case OpDelete:
+			gone[op.Key] = true
+		case OpMove:
+			gone[op.Key] = true
+			placed = append(placed, op)
+		case OpInsert:
+			placed = append(placed, op)
+		case OpUpdate:
+		default:
+			t.Fatalf("unknown op %q", op.Op)
+		}
+	}
+	var out []string
+	for _, k := range order {
+		if !gone[k] {
+			out = append(out, k)
+		}
+	}
+	sort.SliceStable(placed, func(i, j int) bool { return *placed[i].Index < *placed[j].Index })
+	for _, op := range placed {
+		i := *op.Index
+		if i > len(out) {
+			t.Fatalf("%s %s at %d past the end of %v", op.Op, op.Key, i, out)
+		}
+		out = append(out[:i], append([]string{op.Key}, out[i:]...)...)
+	}
+	return out
+}
+
+func opNames(ops []RowOp) []string {
+	out := []string{}
+	for _, op := range ops {
+		s := op.Op + " " + op.Key
+		if op.Index != nil {
+			s += " @" + strconv.Itoa(*op.Index)
+		}
+		out = append(out, s)
+	}
+	return out
+}
+
+func TestDiffResults(t *testing.T) {
+	tests := []struct {
+		name      string
+		old, next []keyedRow
+		want      []string // ops, as opNames
+	}{
+		{"empty to rows", nil, keyedRows("a", "b"), []string{"insert a @0", "insert b @1"}},
+		{"rows to empty", keyedRows("a", "b"), nil, []string{"delete a", "delete b"}},
+		{"unchanged", keyedRows("a", "b", "c"), keyedRows("a", "b", "c"), []string{}},
+		{"last to front", keyedRows("a", "b", "c", "d"), keyedRows("d", "a", "b", "c"), []string{"move d @0"}},
+		{"front to last", keyedRows("a", "b", "c", "d"), keyedRows("b", "c", "d", "a"), []string{"move a @3"}},
+		{"swap", keyedRows("a", "b", "c"), keyedRows("a", "c", "b"), []string{"move c @1"}},
+		{
+			"cell update",
+			keyedRows("a", "b"),
+			[]keyedRow{kr("a", "A"), kr("b", "b")},
+			[]string{"update a"},
+		},
+		{
+			// a top-3 window: z ranks in, c drops out
+			"limit boundary",
+			keyedRows("a", "b", "c"),
+			keyedRows("a", "z", "b"),
+			[]string{"delete c", "insert z @1"},
+		},
+		{
+			"limit boundary with reorder",
+			keyedRows("a", "b", "c"),
+			keyedRows("c", "z", "a"),
+			[]string{"delete b", "move c @0", "insert z @1"},
+		},
+		{
+			// repeated keys are told apart as x, x#2, ...
+			"duplicate keys",
+			keyedRows("x", "y", "x"),
+			keyedRows("x", "x", "y", "x"),
+			[]string{"move x#2 @1", "insert x#3 @3"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			old := newResultSet(tt.old)
+			ops, rs := diffResults(old, tt.next)
+			if got := opNames(ops); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ops = %q, want %q", got, tt.want)
+			}
+			if got := applyOps(t, old.order, ops); len(got)+len(rs.order) > 0 && !reflect.DeepEqual(got, rs.order) {
+				t.Errorf("applied order = %v, want %v", got, rs.order)
+			}
+			for _, op := range ops {
+				if op.Op == OpUpdate && len(op.Row) != 1 {
+					t.Errorf("update %s sends unchanged cells: %v", op.Key, op.Row)
+				}
+			}
+		})
+	}
+}
+
+// Every permutation of a small result reorders with the fewest moves: all rows
+// but one longest increasing run.
+func TestDiffResultsPermutations(t *testing.T) {
+	keys := []string{"a", "b", "c", "d", "e"}
+	var permute func(prefix, rest []string)
+	permute = func(prefix, rest []string) {
+		if len(rest) == 0 {
+			old := newResultSet(keyedRows(keys...))
+			ops, rs := diffResults(old, keyedRows(prefix...))
+			if got := applyOps(t, old.order, ops); !reflect.DeepEqual(got, prefix) {
+				t.Errorf("%v: applied order = %v", prefix, got)
+			}
+			if !reflect.DeepEqual(rs.order, prefix) {
+				t.Errorf("%v: result order = %v", prefix, rs.order)
+			}
+			seq := make([]int, len(prefix))
+			for i, k := range prefix {
+				seq[i] = int(k[0] - 'a')
+			}
+			if want := len(keys) - lisLen(seq); len(ops) != want {
+				t.Errorf("%v: %d moves, want %d: %q", prefix, len(ops), want, opNames(ops))
+			}
+			return
+		}
+		for i := range rest {
+			next := append(append([]string(nil), rest[:i]...), rest[i+1:]...)
+			permute(append(append([]string(nil), prefix...), rest[i]), next)
+		}
+	}
+	permute(nil, keys)
+}
+
+// lisLen is the length of a longest strictly increasing subsequence, by brute force.
+func lisLen(seq []int) int {
+	best := 0
+	for mask := 0; mask < 1<<len(seq); mask++ {
+		n, last, ok := 0, -1, true
+		for i, v := range seq {
+			if mask&(1<<i) == 0 {
+				continue
+			}
+			if v <= last {
+				ok = false
+				break
+			}
+			last = v
+			n++
+		}
+		if ok && n > best {
+			best = n
+		}
+	}
+	return best
+}
+
+func TestLISMembers(t *testing.T) {
+	tests := [][]int{
+		nil,
+		{0},
+		{0, 1, 2, 3},
+		{3, 2, 1, 0},
+		{2, 0, 1, 3},
+		{1, 3, 0, 2, 4},
+		{4, 0, 3, 1, 2},
+	}
+	for _, seq := range tests {
+		keep := lisMembers(seq)
+		n, last := 0, -1
+		for i, k := range keep {
+			if !k {
+				continue
+			}
+			if seq[i] <= last {
+				t.Errorf("lisMembers(%v) = %v: not increasing", seq, keep)
+			}
+			last = seq[i]
+			n++
+		}
+		if n != lisLen(seq) {
+			t.Errorf("lisMembers(%v) keeps %d, want %d", seq, n, lisLen(seq))
+		}
+	}
+}
+
+func TestPatched(t *testing.T) {
+	rs := newResultSet(keyedRows("a", "b", "c"))
+	// b and c changed: b came back edited, c no longer matches, d is new
+	filter := pkFilter{"_pk_film_id": {"b": true, "c": true, "d": true}}
+	next := rs.patched([]keyedRow{kr("d", "d"), kr("b", "B")}, filter)
+
+	var keys []string
+	for _, r := range next {
+		keys = append(keys, r.Key)
+	}
+	if want := []string{"a", "b", "d"}; !reflect.DeepEqual(keys, want) {
+		t.Fatalf("keys = %v, want %v", keys, want)
+	}
+	if v := next[1].Row["title"].Value; v != "B" {
+		t.Errorf("b = %v, want the refreshed row", v)
+	}
+}
+
+// patched keeps rows in place and appends new ones, which is only right when
+// the result has no order of its own. PartialRefresh patches only under
+// pk_pushdown, so ordered queries must never end up with it.
+func TestPatchedNotUsedForOrderedQueries(t *testing.T) {
+	queries := []string{
+		"SELECT title FROM film ORDER BY title",
+		"SELECT title FROM film LIMIT 10",
+		"SELECT title FROM film OFFSET 5",
+		"SELECT title FROM film ORDER BY revenue DESC LIMIT 3",
+	}
+	overrides := []pg_lineage.Strategy{"", pg_lineage.StrategyPKPushdown, pg_lineage.StrategyIncrementalAggregate,
+		pg_lineage.StrategyFullRequery, pg_lineage.StrategyPoll}
+	for _, sql := range queries {
+		for _, o := range overrides {
+			a, err := Analyze(sql, filmCatalog, o)
+			if err == nil && a.Strategy == pg_lineage.StrategyPKPushdown {
+				t.Errorf("%q with override %q: analyzed as %s", sql, o, a.Strategy)
+			}
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package reactive
+
+import (
+	"go.uber.org/zap"
+
+	"github.com/zoravur/postgres-spreadsheet-view/server/internal/protocol"
+	"github.com/zoravur/postgres-spreadsheet-view/server/pkg/richcatalog"
+)
+
+// Reanalyze re-runs Analyze for every live query touched by a schema change, so
+// injected _pk_* columns and SELECT * expansion match the new schema. Clients get
+// "schema_changed" followed by a full "reload", or an "error" if the query no
+// longer compiles (it then stays registered but idle until the next change).
+// It blocks for a full refresh of each affected query, so catalog listeners
+// should run it off their own goroutine.
+func Reanalyze(deps Deps, reg *Registry, cat richcatalog.Catalog, changes []richcatalog.Change) {
+	if len(changes) == 0 {
+		return
+	}
+	affected := map[string]bool{}
+	for _, t := range richcatalog.AffectedTables(changes) {
+		affected[t] = true
+	}
+
+	for _, q := range reg.Snapshot() {
+		q.Mu.RLock()
+		// queries we couldn't attribute to tables (or that are broken) get retried on any change
+		hit := len(q.Tables) == 0 || q.Broken != nil
+		for _, t := range q.Tables {
+			if affected[t] {
+				hit = true
+				break
+			}
+		}
+		q.Mu.RUnlock()
+		if !hit {
+			continue
+		}
+
+		qlog := zap.L().With(zap.String("live_query_id", q.ID))
+		a, err := Analyze(q.SQL, cat, q.Override)
+
+		q.Mu.Lock()
+		q.Broken = err
+		if err == nil {
+			q.Analysis = a
+		}
+		q.Mu.Unlock()
+
+		if err != nil {
+			qlog.Warn("live_query_broken_by_schema_change", zap.Error(err))
+			deps.Broadcast(q, protocol.TypeError, protocol.Errorf(protocol.CodeQueryFailed,
+				"query no longer compiles after schema change: %v", err))
+			continue
+		}
+
+		qlog.Info("live_query_reanalyzed", zap.String("rewritten", a.Rewritten))
+		deps.Broadcast(q, protocol.TypeSchemaChanged, protocol.SchemaChanged{
+			ID:      q.ID,
+			Tables:  a.Tables,
+			PKCols:  a.PKCols,
+			Changes: relevantChanges(changes, a.Tables),
+		})
+		FullRefresh(deps, q)
+	}
+}
+
+// relevantChanges keeps the changes that touch any of tables.
+func relevantChanges(changes []richcatalog.Change, tables []string) []richcatalog.Change {
+	want := map[string]bool{}
+	for _, t := range tables {
+		want[t] = true
+	}
+	out := []richcatalog.Change{}
+	for _, c := range changes {
+		if want[c.Qualified()] {
+			out = append(out, c)
+		}
+	}
+	return out
+}
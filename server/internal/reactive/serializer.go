@@ -5,15 +5,13 @@ import (
 	"strings"
 
 	"github.com/zoravur/postgres-spreadsheet-view/server/internal/common"
+	"github.com/zoravur/postgres-spreadsheet-view/server/internal/protocol"
 )
 
 // EditableRow is a row of { column: EditableCell }
-type EditableRow map[string]EditableCell
+type EditableRow = protocol.Row
 
-type EditableCell struct {
-	EditHandle string `json:"editHandle"`
-	Value      any    `json:"value"`
-}
+type EditableCell = protocol.Cell
 
 type pkAtom struct{ baseTable, pkCol string }
 
@@ -26,14 +24,41 @@ func SerializeEditableRows(
 	provOrig map[string][]string, // provenance for ORIGINAL sql
 	provRewritten map[string][]string, // provenance for REWRITTEN sql
 ) ([]EditableRow, error) {
-	results := []EditableRow{}
+	keyed, err := serializeKeyedRows(rows, cols, pkMapByAlias, provOrig, provRewritten)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]EditableRow, len(keyed))
+	for i, kr := range keyed {
+		results[i] = kr.Row
+	}
+	return results, nil
+}
+
+// keyedRow is a serialized row plus its identity: the injected _pk_* values.
+type keyedRow struct {
+	Key string
+	PK  map[string]any // injected _pk_* column -> value
+	Row EditableRow
+}
+
+func serializeKeyedRows(
+	rows *sql.Rows,
+	cols []string,
+	pkMapByAlias map[string][]string,
+	provOrig map[string][]string,
+	provRewritten map[string][]string,
+) ([]keyedRow, error) {
+	results := []keyedRow{}
 
 	// Precompute _pk_* column owner → (baseTable, pkCol)
 	pkOwner := map[string]pkAtom{}
-	for _, c := range cols {
+	var pkIdx []int
+	for i, c := range cols {
 		if !strings.HasPrefix(c, "_pk_") {
 			continue
 		}
+		pkIdx = append(pkIdx, i)
 		if srcs, ok := provRewritten[c]; ok && len(srcs) > 0 {
 			bt, bc := splitTableCol(srcs[0]) // e.g. "actor.actor_id"
 			if bt != "" && bc != "" {
@@ -65,7 +90,17 @@ func SerializeEditableRows(
 			handle := computeEditHandle(col, pkByBase, provOrig, pkMapByAlias, provRewritten)
 			row[col] = EditableCell{Value: val, EditHandle: handle}
 		}
-		results = append(results, row)
+
+		pk := make(map[string]any, len(pkIdx))
+		names := make([]string, 0, len(pkIdx))
+		vals := make([]any, 0, len(pkIdx))
+		for _, i := range pkIdx {
+			v := deref(values[i])
+			pk[cols[i]] = v
+			names = append(names, cols[i])
+			vals = append(vals, v)
+		}
+		results = append(results, keyedRow{Key: common.EncodeRowKey(names, vals), PK: pk, Row: row})
 	}
 	if err := rows.Err(); err != nil {
 		return nil, err
@@ -73,6 +108,18 @@ func SerializeEditableRows(
 	return results, nil
 }
 
+// BaseColumn is the table column an output column comes from, by the query's
+// provenance prov: the column its edit handle edits. "" when it has none (an
+// expression) or its origin is ambiguous.
+func BaseColumn(col string, prov map[string][]string) string {
+	srcs := originsForColumn(col, prov)
+	if len(srcs) == 0 {
+		return ""
+	}
+	_, c := splitTableCol(srcs[0])
+	return c
+}
+
 func originsForColumn(col string, prov map[string][]string) []string {
 	// 1) exact label match
 	if srcs, ok := prov[col]; ok && len(srcs) > 0 {
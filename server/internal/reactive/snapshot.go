@@ -0,0 +1,110 @@
+package reactive
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ReadPoint is the database snapshot a result was read under: the WAL
+// position at the time (LSN) and the snapshot's transaction bounds, which say
+// exactly which commits the result already includes. WAL changes from those
+// commits arrive after the read but must not be applied again.
+type ReadPoint struct {
+	LSN  string   `json:"lsn"`
+	Xmin uint64   `json:"-"` // from pg_current_snapshot(); xid8, epoch in the high bits
+	Xmax uint64   `json:"-"`
+	Xip  []uint64 `json:"-"`
+}
+
+// Includes reports whether the commit of xid (a 32-bit WAL xid) is visible in
+// the snapshot. The zero ReadPoint includes nothing.
+func (p *ReadPoint) Includes(xid int64) bool {
+	if p == nil || p.Xmax == 0 || xid == 0 {
+		return false
+	}
+	x := uint32(xid)
+	if xidBefore(x, uint32(p.Xmin)) {
+		return true
+	}
+	if !xidBefore(x, uint32(p.Xmax)) {
+		return false
+	}
+	for _, ip := range p.Xip {
+		if uint32(ip) == x {
+			return false // still running when the snapshot was taken
+		}
+	}
+	return true
+}
+
+// xidBefore compares 32-bit xids the way Postgres does, modulo wraparound.
+func xidBefore(a, b uint32) bool { return int32(a-b) < 0 }
+
+// parseSnapshot reads pg_current_snapshot()'s text form, "xmin:xmax:xip,...".
+func parseSnapshot(s string) (ReadPoint, error) {
+	parts := strings.SplitN(s, ":", 3)
+	if len(parts) != 3 {
+		return ReadPoint{}, fmt.Errorf("bad snapshot %q", s)
+	}
+	var p ReadPoint
+	var err error
+	if p.Xmin, err = strconv.ParseUint(parts[0], 10, 64); err != nil {
+		return ReadPoint{}, fmt.Errorf("bad snapshot %q: %w", s, err)
+	}
+	if p.Xmax, err = strconv.ParseUint(parts[1], 10, 64); err != nil {
+		return ReadPoint{}, fmt.Errorf("bad snapshot %q: %w", s, err)
+	}
+	if parts[2] != "" {
+		for _, x := range strings.Split(parts[2], ",") {
+			ip, err := strconv.ParseUint(x, 10, 64)
+			if err != nil {
+				return ReadPoint{}, fmt.Errorf("bad snapshot %q: %w", s, err)
+			}
+			p.Xip = append(p.Xip, ip)
+		}
+	}
+	return p, nil
+}
+
+// snapshotRead runs fn in a read-only REPEATABLE READ transaction, so every
+// query in it sees one snapshot, and returns where that snapshot stands.
+func snapshotRead(db *sql.DB, fn func(tx *sql.Tx, p *ReadPoint) error) (ReadPoint, error) {
+	tx, err := db.BeginTx(context.Background(), &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return ReadPoint{}, err
+	}
+	defer tx.Rollback()
+
+	// the first statement takes the snapshot every later one reads from
+	var snap, lsn string
+	if err := tx.QueryRow("SELECT pg_current_snapshot()::text, pg_current_wal_lsn()::text").Scan(&snap, &lsn); err != nil {
+		return ReadPoint{}, fmt.Errorf("snapshot: %w", err)
+	}
+	p, err := parseSnapshot(snap)
+	if err != nil {
+		return ReadPoint{}, err
+	}
+	p.LSN = lsn
+
+	if err := fn(tx, &p); err != nil {
+		return ReadPoint{}, err
+	}
+	return p, tx.Commit()
+}
+
+// includesAll reports whether p includes every commit of b, so refreshing
+// for b would change nothing. Batches without commits (polls) never are.
+func (p *ReadPoint) includesAll(b Batch) bool {
+	if len(b.Commits) == 0 {
+		return false
+	}
+	for _, c := range b.Commits {
+		if !p.Includes(c.XID) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,102 @@
+package reactive
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSnapshot(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    ReadPoint
+		wantErr bool
+	}{
+		{in: "100:105:", want: ReadPoint{Xmin: 100, Xmax: 105}},
+		{in: "100:105:101,103", want: ReadPoint{Xmin: 100, Xmax: 105, Xip: []uint64{101, 103}}},
+		// xid8: epoch 1 in the high 32 bits
+		{in: "4294967396:4294967401:4294967397", want: ReadPoint{Xmin: 1<<32 + 100, Xmax: 1<<32 + 105, Xip: []uint64{1<<32 + 101}}},
+		{in: "100:105", wantErr: true},
+		{in: "x:105:", wantErr: true},
+		{in: "100:y:", wantErr: true},
+		{in: "100:105:101,", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseSnapshot(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("parseSnapshot(%q) = %+v, want error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseSnapshot(%q): %v", tt.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseSnapshot(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestReadPointIncludes(t *testing.T) {
+	const epoch = 1 << 32
+	plain := &ReadPoint{Xmin: 100, Xmax: 105, Xip: []uint64{101, 103}}
+	// taken just after the 32-bit xid counter wrapped: xmin is in epoch 1,
+	// while commits from before the wrap are near 2^32 in epoch 0
+	wrapped := &ReadPoint{Xmin: epoch + 10, Xmax: epoch + 20, Xip: []uint64{epoch + 12}}
+	// xmin before the wrap, xmax after it
+	straddling := &ReadPoint{Xmin: epoch - 5, Xmax: epoch + 5, Xip: []uint64{epoch - 2, epoch + 1}}
+
+	tests := []struct {
+		name string
+		p    *ReadPoint
+		xid  int64
+		want bool
+	}{
+		{"nil snapshot", nil, 50, false},
+		{"zero snapshot", &ReadPoint{}, 50, false},
+		{"no xid", plain, 0, false},
+		{"before xmin", plain, 99, true},
+		{"at xmin", plain, 100, true},
+		{"running at the snapshot", plain, 101, false},
+		{"committed between", plain, 102, true},
+		{"also running", plain, 103, false},
+		{"at xmax", plain, 105, false},
+		{"after xmax", plain, 200, false},
+		{"epoch 0 commit before a wrapped xmin", wrapped, 4294967290, true},
+		{"wrapped commit before xmin", wrapped, 5, true},
+		{"wrapped commit between", wrapped, 11, true},
+		{"wrapped running", wrapped, 12, false},
+		{"wrapped after xmax", wrapped, 25, false},
+		{"straddling, before xmin", straddling, 4294967290, true},
+		{"straddling, running before the wrap", straddling, 4294967294, false},
+		{"straddling, committed before the wrap", straddling, 4294967295, true},
+		{"straddling, committed after the wrap", straddling, 3, true},
+		{"straddling, running after the wrap", straddling, 1, false},
+		{"straddling, after xmax", straddling, 6, false},
+	}
+	for _, tt := range tests {
+		if got := tt.p.Includes(tt.xid); got != tt.want {
+			t.Errorf("%s: Includes(%d) = %v, want %v", tt.name, tt.xid, got, tt.want)
+		}
+	}
+}
+
+func TestReadPointIncludesAll(t *testing.T) {
+	p := &ReadPoint{Xmin: 100, Xmax: 105, Xip: []uint64{103}}
+	tests := []struct {
+		name    string
+		commits []Commit
+		want    bool
+	}{
+		{"poll batch", nil, false},
+		{"all before the snapshot", []Commit{{XID: 90}, {XID: 102}}, true},
+		{"one still running", []Commit{{XID: 90}, {XID: 103}}, false},
+		{"one after", []Commit{{XID: 90}, {XID: 110}}, false},
+	}
+	for _, tt := range tests {
+		if got := p.includesAll(Batch{Commits: tt.commits}); got != tt.want {
+			t.Errorf("%s: includesAll = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -3,26 +3,101 @@ package reactive
 import (
 	"database/sql"
 	"sync"
+	"time"
+
+	"github.com/zoravur/postgres-spreadsheet-view/server/internal/protocol"
+	"github.com/zoravur/postgres-spreadsheet-view/server/pkg/pg_lineage"
 )
 
 type LiveQuery struct {
-	ID        string
-	SQL       string              // original
+	ID  string
+	Key string // Fingerprint; subscribers with the same key share this query
+	SQL string // original
+	// Override is the strategy the subscriber asked for, if any. It survives
+	// re-analysis and is part of Key.
+	Override pg_lineage.Strategy
+	Analysis
+	Clients map[*Client]struct{}
+	Mu      sync.RWMutex
+	// presence is what each subscriber last reported; guarded by Mu.
+	presence map[*Client]Presence
+
+	// Broken is set when the query stopped compiling after a schema change.
+	// Refreshes are skipped until a later change makes it analyzable again.
+	Broken error
+
+	// rowsMu serializes refreshes so row ops reach clients in the order they
+	// were computed; results is the materialized copy clients currently display.
+	rowsMu  sync.Mutex
+	results *resultSet
+	stmts   map[string]*sql.Stmt // prepared refresh statements, by SQL text
+	agg     *aggState            // incremental_aggregate groups
+	loaded  ReadPoint            // snapshot results were last loaded under
+	// seq numbers every "update" and "reload" this query sends; replay keeps
+	// the latest updates for subscribers resuming after a reconnect.
+	seq    uint64
+	replay replayBuffer
+
+	refs      int       // subscriptions holding this query; guarded by Registry.mu
+	idleUntil time.Time // unreferenced queries linger until then; guarded by Registry.mu
+}
+
+// Analysis holds everything derived from the SQL + catalog. It is replaced
+// wholesale (under Mu) when the schema changes; use Current() to read it.
+type Analysis struct {
 	Rewritten string              // with _pk_* injected
 	Tables    []string            // ["public.actor", "public.film", ...]
 	PKCols    map[string][]string // "public.actor" -> ["actor_id"]
-	Clients   map[*Client]struct{}
-	Mu        sync.RWMutex
 
-	// 🔧 add this:
 	ProvOrig      map[string][]string // from ResolveProvenance(origSQL)
 	ProvRewritten map[string][]string // from ResolveProvenance(rewrittenSQL)
 	PKMapByAlias  map[string][]string // direct from RewriteSelectInjectPKs
+
+	// Plan is what ClassifyQuery picked and why; Strategy is what actually
+	// runs, which differs when the subscriber overrode it.
+	Plan     pg_lineage.Classification
+	Strategy pg_lineage.Strategy
+}
+
+// Current returns the query's analysis, read under the lock so a concurrent
+// re-analysis can't hand out a half-updated mix.
+func (q *LiveQuery) Current() Analysis {
+	q.Mu.RLock()
+	defer q.Mu.RUnlock()
+	return q.Analysis
+}
+
+// DependsOn reports whether a change to table fq ("public.actor") can affect
+// this query. Broken queries depend on nothing until re-analyzed.
+func (q *LiveQuery) DependsOn(fq string) bool {
+	q.Mu.RLock()
+	defer q.Mu.RUnlock()
+	if q.Broken != nil {
+		return false
+	}
+	for _, t := range q.Tables {
+		if t == fq {
+			return true
+		}
+	}
+	return false
 }
 
 type Client struct {
 	// abstract over ws.Conn to avoid import cycles
-	Send func(msgType string, payload any) error
+	Send func(msgType protocol.Type, payload any) error
+	// SendQuery, when set, carries messages about one live query. A client
+	// that falls behind may drop them and catch up from a later "reload"
+	// (see LiveQuery.Resync).
+	SendQuery func(q *LiveQuery, msgType protocol.Type, payload any) error
+}
+
+// SendFor sends a message about q.
+func (c *Client) SendFor(q *LiveQuery, msgType protocol.Type, payload any) error {
+	if c.SendQuery != nil {
+		return c.SendQuery(q, msgType, payload)
+	}
+	return c.Send(msgType, payload)
 }
 
 type WALEvent struct {
@@ -35,5 +110,5 @@ type WALEvent struct {
 // lets you inject your DB + lineage deps without global singletons
 type Deps struct {
 	DB        *sql.DB
-	Broadcast func(lq *LiveQuery, msgType string, payload any)
+	Broadcast func(lq *LiveQuery, msgType protocol.Type, payload any)
 }
@@ -0,0 +1,200 @@
+// Package sqlfake is a database/sql driver for tests of code that talks to
+// Postgres without needing one. Every statement goes to a Handler, which
+// answers it from whatever in-memory state the test keeps; the fake only
+// records what was run on which connection and in which transaction.
+package sqlfake
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// Result answers one statement: the rows of a query, or the rows an Exec
+// affected.
+type Result struct {
+	Columns  []string
+	Rows     [][]any
+	Affected int64
+}
+
+// Call is a statement as the Handler sees it. Conn numbers the connection it
+// ran on, from 1; Tx numbers the transaction it ran in, 0 for none.
+type Call struct {
+	Conn  int
+	Tx    int
+	Query string
+	Args  []any
+}
+
+// Handler answers a statement.
+type Handler func(ctx context.Context, c Call) (Result, error)
+
+// DB is a fake database. Log records every statement and every begin,
+// commit and rollback as "tx<n> begin" and so on.
+type DB struct {
+	handle Handler
+
+	mu    sync.Mutex
+	conns int
+	txs   int
+	log   []string
+}
+
+// Open returns a *sql.DB whose statements are answered by h, and the fake
+// behind it.
+func Open(h Handler) (*sql.DB, *DB) {
+	f := &DB{handle: h}
+	return sql.OpenDB(f), f
+}
+
+// Log returns what has run so far.
+func (f *DB) Log() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.log...)
+}
+
+func (f *DB) record(format string, args ...any) {
+	f.mu.Lock()
+	f.log = append(f.log, fmt.Sprintf(format, args...))
+	f.mu.Unlock()
+}
+
+// Connect implements driver.Connector.
+func (f *DB) Connect(context.Context) (driver.Conn, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.conns++
+	return &conn{db: f, id: f.conns}, nil
+}
+
+// Driver implements driver.Connector.
+func (f *DB) Driver() driver.Driver { return fakeDriver{} }
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(string) (driver.Conn, error) {
+	return nil, fmt.Errorf("sqlfake: use sqlfake.Open")
+}
+
+type conn struct {
+	db *DB
+	id int
+	tx int // open transaction, 0 for none
+}
+
+func (c *conn) Prepare(query string) (driver.Stmt, error) {
+	return &stmt{c: c, query: query}, nil
+}
+
+func (c *conn) Close() error { return nil }
+
+func (c *conn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *conn) BeginTx(context.Context, driver.TxOptions) (driver.Tx, error) {
+	c.db.mu.Lock()
+	c.db.txs++
+	c.tx = c.db.txs
+	c.db.mu.Unlock()
+	c.db.record("tx%d begin", c.tx)
+	return &tx{c: c}, nil
+}
+
+func (c *conn) run(ctx context.Context, query string, args []driver.NamedValue) (Result, error) {
+	call := Call{Conn: c.id, Tx: c.tx, Query: query, Args: make([]any, len(args))}
+	for i, a := range args {
+		call.Args[i] = a.Value
+	}
+	if c.tx != 0 {
+		c.db.record("tx%d %s", c.tx, oneLine(query))
+	} else {
+		c.db.record("%s", oneLine(query))
+	}
+	return c.db.handle(ctx, call)
+}
+
+func (c *conn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	res, err := c.run(ctx, query, args)
+	if err != nil {
+		return nil, err
+	}
+	return &rows{res: res}, nil
+}
+
+func (c *conn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	res, err := c.run(ctx, query, args)
+	if err != nil {
+		return nil, err
+	}
+	return driver.RowsAffected(res.Affected), nil
+}
+
+// CheckNamedValue passes every argument through as is, so handlers see the
+// values the code under test passed.
+func (c *conn) CheckNamedValue(*driver.NamedValue) error { return nil }
+
+type tx struct{ c *conn }
+
+func (t *tx) Commit() error   { return t.end("commit") }
+func (t *tx) Rollback() error { return t.end("rollback") }
+
+func (t *tx) end(how string) error {
+	t.c.db.record("tx%d %s", t.c.tx, how)
+	t.c.tx = 0
+	return nil
+}
+
+type stmt struct {
+	c     *conn
+	query string
+}
+
+func (s *stmt) Close() error  { return nil }
+func (s *stmt) NumInput() int { return -1 }
+
+func (s *stmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.c.ExecContext(context.Background(), s.query, named(args))
+}
+
+func (s *stmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.c.QueryContext(context.Background(), s.query, named(args))
+}
+
+func named(args []driver.Value) []driver.NamedValue {
+	out := make([]driver.NamedValue, len(args))
+	for i, v := range args {
+		out[i] = driver.NamedValue{Ordinal: i + 1, Value: v}
+	}
+	return out
+}
+
+type rows struct {
+	res Result
+	i   int
+}
+
+func (r *rows) Columns() []string { return r.res.Columns }
+func (r *rows) Close() error      { return nil }
+
+func (r *rows) Next(dest []driver.Value) error {
+	if r.i >= len(r.res.Rows) {
+		return io.EOF
+	}
+	for i, v := range r.res.Rows[r.i] {
+		dest[i] = v
+	}
+	r.i++
+	return nil
+}
+
+// oneLine collapses whitespace so logged statements stay on one line.
+func oneLine(q string) string {
+	return strings.Join(strings.Fields(q), " ")
+}
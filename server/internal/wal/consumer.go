@@ -1,6 +1,7 @@
 package wal
 
 import (
+	"bytes"
 	"encoding/json"
 	"log"
 
@@ -8,111 +9,324 @@ import (
 	"go.uber.org/zap"
 )
 
+// Change is one row change in wal2json format-version 1 terms. Version 2
+// messages are converted into this shape (see v2Message.change).
 type Change struct {
-	Schema  string `json:"schema"`
-	Table   string `json:"table"`
-	Kind    string `json:"kind"`
-	OldKeys Keys   `json:"oldkeys"`
-	NewKeys Keys   `json:"newkeys"`
+	Schema       string        `json:"schema"`
+	Table        string        `json:"table"`
+	Kind         string        `json:"kind"`
+	ColumnNames  []string      `json:"columnnames"`
+	ColumnValues []interface{} `json:"columnvalues"`
+	OldKeys      Keys          `json:"oldkeys"`
+	NewKeys      Keys          `json:"newkeys"`
+	PK           PK            `json:"pk"` // requires wal2json include-pk
+}
+type PK struct {
+	PKNames []string `json:"pknames"`
 }
 type Keys struct {
 	KeyNames  []string      `json:"keynames"`
 	KeyValues []interface{} `json:"keyvalues"`
 }
+
+// Envelope is a format-version 1 message: a whole transaction at once.
+// xid and nextlsn are present with include-xids / include-lsn.
 type Envelope struct {
-	Change []Change `json:"change"`
+	XID     int64    `json:"xid"`
+	NextLSN string   `json:"nextlsn"`
+	Change  []Change `json:"change"`
+}
+
+// v2Message is a format-version 2 message: one per row change, bracketed by
+// action "B" (begin) and "C" (commit).
+type v2Message struct {
+	Action   string     `json:"action"` // B, C, I, U, D, T, M
+	XID      int64      `json:"xid"`
+	LSN      string     `json:"lsn"`
+	Schema   string     `json:"schema"`
+	Table    string     `json:"table"`
+	Columns  []v2Column `json:"columns"`
+	Identity []v2Column `json:"identity"`
+	PK       []v2Column `json:"pk"`
+}
+type v2Column struct {
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	Value any    `json:"value"`
 }
 
+// Consumer turns the wal2json stream into live query refreshes, one per
+// committed transaction. OnMessage must be called from a single goroutine.
 type Consumer struct {
 	Reg  *reactive.Registry
 	Deps reactive.Deps
+	// Refresher batches refreshes per live query. When nil, every commit is
+	// refreshed on its own goroutine.
+	Refresher *reactive.Refresher
+
+	tx *txn // open format-version 2 transaction, between "B" and "C"
+}
+
+type txn struct {
+	xid       int64
+	changes   []Change
+	truncated []string // "public.actor"
 }
 
 func (c *Consumer) OnMessage(line []byte) {
-	var env Envelope
-	if err := json.Unmarshal(line, &env); err != nil {
+	var probe struct {
+		Action string `json:"action"`
+	}
+	if err := json.Unmarshal(line, &probe); err != nil {
 		log.Printf("❌ WAL decode error: %v", err)
 		return
 	}
+	if probe.Action != "" {
+		c.onV2(line)
+		return
+	}
 
+	var env Envelope
+	if err := decode(line, &env); err != nil {
+		log.Printf("❌ WAL decode error: %v", err)
+		return
+	}
 	if len(env.Change) == 0 {
 		log.Println("⚠️  No 'change' entries in WAL message")
 		return
 	}
+	c.commit(reactive.Commit{LSN: env.NextLSN, XID: env.XID}, &txn{xid: env.XID, changes: env.Change})
+}
 
-	for idx, ch := range env.Change {
-		chlog := zap.L().With(
-			zap.Int("idx", idx),
-			zap.String("schema", ch.Schema),
-			zap.String("table", ch.Table),
-			zap.String("kind", ch.Kind),
-		)
+func (c *Consumer) onV2(line []byte) {
+	var m v2Message
+	if err := decode(line, &m); err != nil {
+		log.Printf("❌ WAL decode error: %v", err)
+		return
+	}
 
-		keys := ch.OldKeys
-		if ch.Kind == "insert" {
-			keys = ch.NewKeys
+	switch m.Action {
+	case "B":
+		if c.tx != nil {
+			zap.L().Warn("wal_begin_without_commit", zap.Int64("open_xid", c.tx.xid), zap.Int64("xid", m.XID))
+			c.resync(c.tx)
 		}
+		c.tx = &txn{xid: m.XID}
 
-		kv := make(map[string]any, len(keys.KeyNames))
-		for i, name := range keys.KeyNames {
-			var val any
-			if i < len(keys.KeyValues) {
-				val = keys.KeyValues[i]
-			}
-			kv[name] = val
+	case "C":
+		tx := c.tx
+		c.tx = nil
+		if tx == nil {
+			return
 		}
+		c.commit(reactive.Commit{LSN: m.LSN, XID: m.XID}, tx)
 
-		fq := ch.Schema + "." + ch.Table
-		affected := map[string]map[string]any{fq: kv}
+	case "I", "U", "D":
+		ch := m.change()
+		if c.tx == nil {
+			// no transaction markers (include-transaction off): each change commits alone
+			c.commit(reactive.Commit{LSN: m.LSN, XID: m.XID}, &txn{xid: m.XID, changes: []Change{ch}})
+			return
+		}
+		c.tx.changes = append(c.tx.changes, ch)
+
+	case "T":
+		fq := m.Schema + "." + m.Table
+		if c.tx == nil {
+			c.commit(reactive.Commit{LSN: m.LSN, XID: m.XID}, &txn{xid: m.XID, truncated: []string{fq}})
+			return
+		}
+		c.tx.truncated = append(c.tx.truncated, fq)
 
-		// Single correlated record for the change
-		chlog.Debug("wal_change",
+	default:
+		// "M" (logical decoding messages) carry no row data
+	}
+}
+
+// decode keeps numbers as json.Number, so numeric values keep their exact text
+// (4.99 stays "4.99") for incremental aggregates and key matching.
+func decode(line []byte, v any) error {
+	dec := json.NewDecoder(bytes.NewReader(line))
+	dec.UseNumber()
+	return dec.Decode(v)
+}
+
+// change converts a format-version 2 row message to the version 1 shape.
+func (m v2Message) change() Change {
+	ch := Change{Schema: m.Schema, Table: m.Table}
+	switch m.Action {
+	case "I":
+		ch.Kind = "insert"
+	case "U":
+		ch.Kind = "update"
+	case "D":
+		ch.Kind = "delete"
+	}
+	for _, col := range m.Columns {
+		ch.ColumnNames = append(ch.ColumnNames, col.Name)
+		ch.ColumnValues = append(ch.ColumnValues, col.Value)
+	}
+	for _, col := range m.Identity {
+		ch.OldKeys.KeyNames = append(ch.OldKeys.KeyNames, col.Name)
+		ch.OldKeys.KeyValues = append(ch.OldKeys.KeyValues, col.Value)
+	}
+	for _, col := range m.PK {
+		ch.PK.PKNames = append(ch.PK.PKNames, col.Name)
+	}
+	return ch
+}
+
+// commit hands every live query the keys one transaction changed in its
+// tables, so each query refreshes once for the whole commit.
+func (c *Consumer) commit(cm reactive.Commit, tx *txn) {
+	txlog := zap.L().With(zap.Int64("xid", cm.XID), zap.String("lsn", cm.LSN))
+
+	byTable := map[string][]map[string]any{}
+	rowsByTable := map[string][]reactive.RowChange{}
+	for idx, ch := range tx.changes {
+		fq := ch.Schema + "." + ch.Table
+		rc := ch.rowChange(fq)
+		rc.XID = cm.XID
+		rowsByTable[fq] = append(rowsByTable[fq], rc)
+		for _, keys := range ch.keySets() {
+			kv := make(map[string]any, len(keys.KeyNames))
+			for i, name := range keys.KeyNames {
+				var val any
+				if i < len(keys.KeyValues) {
+					val = keys.KeyValues[i]
+				}
+				kv[name] = val
+			}
+			byTable[fq] = append(byTable[fq], kv)
+		}
+		txlog.Debug("wal_change",
+			zap.Int("idx", idx),
 			zap.String("fq", fq),
-			zap.Strings("pk_names", keys.KeyNames),
-			zap.Any("pk_values", keys.KeyValues),
-			zap.Any("affected", affected),
+			zap.String("kind", ch.Kind),
 		)
+	}
 
-		matched := 0
-		c.Reg.ForEach(func(q *reactive.LiveQuery) bool {
-			if !contains(q.Tables, fq) {
-				return true // skip noiselessly
+	matched := 0
+	c.Reg.ForEach(func(q *reactive.LiveQuery) bool {
+		for _, fq := range tx.truncated {
+			if q.DependsOn(fq) {
+				// no keys to push down; reload the whole result
+				matched++
+				go reactive.FullRefresh(c.Deps, q)
+				return true
 			}
-			matched++
-			qlog := chlog.With(
-				zap.String("live_query_id", q.ID),
-				zap.String("rewritten", q.Rewritten),
-			)
-			// Trace PK columns for sanity, still correlated
-			qlog.Debug("dispatch_partial_refresh", zap.Any("pk_cols", q.PKCols))
-			go func(qp *reactive.LiveQuery) {
-				reactive.PartialRefresh(c.Deps, qp, affected)
-			}(q)
-			return true
-		})
+		}
 
-		c.Reg.ForEach(func(q *reactive.LiveQuery) bool {
-			chlog.Debug("registered_live_query",
-				zap.String("id", q.ID),
-				zap.Strings("tables", q.Tables),
-			)
+		aff := reactive.Affected{}
+		var rows []reactive.RowChange
+		for fq, pks := range byTable {
+			if q.DependsOn(fq) {
+				aff[fq] = pks
+				rows = append(rows, rowsByTable[fq]...)
+			}
+		}
+		if len(aff) == 0 {
+			return true // skip noiselessly
+		}
+		matched++
+		txlog.Debug("dispatch_partial_refresh",
+			zap.String("live_query_id", q.ID),
+			zap.Int("keys", aff.Len()),
+		)
+		if c.Refresher != nil {
+			c.Refresher.Enqueue(q, cm, aff, rows)
 			return true
-		})
+		}
+		go reactive.PartialRefresh(c.Deps, q, reactive.Batch{Affected: aff, Rows: rows, Commits: []reactive.Commit{cm}})
+		return true
+	})
+
+	if matched == 0 {
+		txlog.Debug("No matched queries in fanout; fanout complete", zap.Int("changes", len(tx.changes)))
+	} else {
+		txlog.Debug("fanout_complete", zap.Int("matched_queries", matched), zap.Int("changes", len(tx.changes)))
+	}
+}
 
-		if matched == 0 {
-			chlog.Warn("No matched queries in fanout; fanout complete", zap.Int("matched_queries", matched))
-		} else {
-			chlog.Debug("fanout_complete", zap.Int("matched_queries", matched))
+// resync reloads every live query over the tables tx touched. It is for a
+// transaction whose commit never arrived: its changes may be incomplete and
+// have no commit LSN, so they can't be applied as a batch, but dropping them
+// would leave those queries stale until their rows next change.
+func (c *Consumer) resync(tx *txn) {
+	seen := map[string]bool{}
+	var tables []string
+	for _, ch := range tx.changes {
+		fq := ch.Schema + "." + ch.Table
+		if !seen[fq] {
+			seen[fq] = true
+			tables = append(tables, fq)
+		}
+	}
+	for _, fq := range tx.truncated {
+		if !seen[fq] {
+			seen[fq] = true
+			tables = append(tables, fq)
+		}
+	}
+	// a truncate of every table forces a full reload of the queries over them
+	c.commit(reactive.Commit{XID: tx.xid}, &txn{xid: tx.xid, truncated: tables})
+}
+
+// rowChange returns the change's row images: New from the column values,
+// Old from oldkeys/identity (every column under REPLICA IDENTITY FULL).
+func (ch Change) rowChange(fq string) reactive.RowChange {
+	rc := reactive.RowChange{Table: fq, Kind: ch.Kind}
+	if ch.Kind != "delete" {
+		rc.New = make(map[string]any, len(ch.ColumnNames))
+		for i, name := range ch.ColumnNames {
+			if i < len(ch.ColumnValues) {
+				rc.New[name] = ch.ColumnValues[i]
+			}
 		}
+	}
+	if ch.Kind != "insert" && len(ch.OldKeys.KeyNames) > 0 {
+		rc.Old = make(map[string]any, len(ch.OldKeys.KeyNames))
+		for i, name := range ch.OldKeys.KeyNames {
+			if i < len(ch.OldKeys.KeyValues) {
+				rc.Old[name] = ch.OldKeys.KeyValues[i]
+			}
+		}
+	}
+	return rc
+}
 
+// keySets returns the primary keys a change touches: the new row's for
+// inserts, the old row's for deletes, and both for updates, since an update
+// that rewrites the PK removes one identity and creates another.
+func (ch Change) keySets() []Keys {
+	switch ch.Kind {
+	case "insert":
+		return []Keys{ch.insertKeys()}
+	case "delete":
+		return []Keys{ch.OldKeys}
+	default:
+		newKeys := ch.insertKeys()
+		if len(ch.OldKeys.KeyNames) == 0 {
+			return []Keys{newKeys}
+		}
+		return []Keys{ch.OldKeys, newKeys}
 	}
 }
 
-func contains(xs []string, s string) bool {
-	for _, x := range xs {
-		if x == s {
-			return true
+// insertKeys extracts the new row's PK from its column values; wal2json only
+// emits oldkeys (for update/delete), so inserts need the pk names from include-pk.
+func (ch Change) insertKeys() Keys {
+	if len(ch.NewKeys.KeyNames) > 0 {
+		return ch.NewKeys
+	}
+	var k Keys
+	for _, name := range ch.PK.PKNames {
+		for i, col := range ch.ColumnNames {
+			if col == name && i < len(ch.ColumnValues) {
+				k.KeyNames = append(k.KeyNames, name)
+				k.KeyValues = append(k.KeyValues, ch.ColumnValues[i])
+			}
 		}
 	}
-	return false
+	return k
 }
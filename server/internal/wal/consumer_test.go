@@ -0,0 +1,127 @@
+package wal
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zoravur/postgres-spreadsheet-view/server/internal/protocol"
+	"github.com/zoravur/postgres-spreadsheet-view/server/internal/reactive"
+	"github.com/zoravur/postgres-spreadsheet-view/server/internal/sqlfake"
+)
+
+type broadcast struct {
+	id  string
+	typ protocol.Type
+}
+
+// fakeDeps answers every live query with no rows, read from a snapshot that
+// includes no transaction yet, and reports what is broadcast.
+func fakeDeps(t *testing.T) (reactive.Deps, *sqlfake.DB, chan broadcast) {
+	t.Helper()
+	db, fake := sqlfake.Open(func(_ context.Context, c sqlfake.Call) (sqlfake.Result, error) {
+		if strings.Contains(c.Query, "pg_current_snapshot") {
+			return sqlfake.Result{Columns: []string{"snap", "lsn"}, Rows: [][]any{{"1:1:", "0/1"}}}, nil
+		}
+		return sqlfake.Result{}, nil
+	})
+	out := make(chan broadcast, 16)
+	return reactive.Deps{
+		DB: db,
+		Broadcast: func(q *reactive.LiveQuery, typ protocol.Type, _ any) {
+			out <- broadcast{q.ID, typ}
+		},
+	}, fake, out
+}
+
+func liveQuery(t *testing.T, deps reactive.Deps, id, table, sql string) *reactive.LiveQuery {
+	t.Helper()
+	q := &reactive.LiveQuery{ID: id, Analysis: reactive.Analysis{Tables: []string{table}, Rewritten: sql}}
+	if err := q.Load(deps.DB); err != nil {
+		t.Fatal(err)
+	}
+	return q
+}
+
+// ran waits up to wait for sql to run outside a transaction, the way a
+// partial refresh runs it.
+func ran(fake *sqlfake.DB, sql string, wait time.Duration) bool {
+	deadline := time.Now().Add(wait)
+	for time.Now().Before(deadline) {
+		for _, l := range fake.Log() {
+			if l == sql {
+				return true
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return false
+}
+
+// A begin while a transaction is still open reloads the queries over what
+// the open one changed, and the new transaction commits as usual.
+func TestBeginWithoutCommit(t *testing.T) {
+	deps, fake, out := fakeDeps(t)
+	films := liveQuery(t, deps, "films", "public.film", "SELECT film_id FROM film")
+	actors := liveQuery(t, deps, "actors", "public.actor", "SELECT actor_id FROM actor")
+	reg := reactive.NewRegistry()
+	reg.Register(films)
+	reg.Register(actors)
+	c := &Consumer{Reg: reg, Deps: deps}
+
+	for _, line := range []string{
+		`{"action":"B","xid":1}`,
+		`{"action":"I","xid":1,"schema":"public","table":"film","columns":[{"name":"film_id","value":1}],"pk":[{"name":"film_id"}]}`,
+		// xid 1's commit never arrives
+		`{"action":"B","xid":2}`,
+		`{"action":"I","xid":2,"schema":"public","table":"actor","columns":[{"name":"actor_id","value":7}],"pk":[{"name":"actor_id"}]}`,
+		`{"action":"C","xid":2,"lsn":"0/20"}`,
+	} {
+		c.OnMessage([]byte(line))
+	}
+
+	select {
+	case b := <-out:
+		if b != (broadcast{"films", protocol.TypeReload}) {
+			t.Fatalf("broadcast %+v, want a reload of films", b)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("films was not reloaded")
+	}
+	if !ran(fake, "SELECT actor_id FROM actor", 2*time.Second) {
+		t.Errorf("xid 2 did not refresh actors: %q", fake.Log())
+	}
+	select {
+	case b := <-out:
+		t.Errorf("unexpected broadcast %+v", b)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if c.tx != nil {
+		t.Errorf("transaction %d left open", c.tx.xid)
+	}
+}
+
+// Without a second begin nothing is refreshed before the commit.
+func TestCommitDispatchesOnce(t *testing.T) {
+	deps, fake, out := fakeDeps(t)
+	films := liveQuery(t, deps, "films", "public.film", "SELECT film_id FROM film")
+	reg := reactive.NewRegistry()
+	reg.Register(films)
+	c := &Consumer{Reg: reg, Deps: deps}
+
+	c.OnMessage([]byte(`{"action":"B","xid":3}`))
+	c.OnMessage([]byte(`{"action":"I","xid":3,"schema":"public","table":"film","columns":[{"name":"film_id","value":1}],"pk":[{"name":"film_id"}]}`))
+	if ran(fake, "SELECT film_id FROM film", 50*time.Millisecond) {
+		t.Fatal("refreshed before the commit")
+	}
+	c.OnMessage([]byte(`{"action":"C","xid":3,"lsn":"0/30"}`))
+	if !ran(fake, "SELECT film_id FROM film", 2*time.Second) {
+		t.Errorf("commit did not refresh films: %q", fake.Log())
+	}
+	select {
+	case b := <-out:
+		t.Errorf("unexpected broadcast %+v", b)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
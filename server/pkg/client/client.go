@@ -0,0 +1,429 @@
+// Package client is a Go SDK for the server's WebSocket API (/api/ws). It
+// keeps a materialized copy of every live query it subscribes to, applies the
+// server's row diffs to it, reports changes through callbacks or channels,
+// writes edits, and reconnects and resumes its subscriptions on its own.
+//
+// Usage
+//
+//	c, err := client.Dial(ctx, "ws://localhost:8080/api/ws", client.Options{User: "etl"})
+//	if err != nil { ... }
+//	defer c.Close()
+//
+//	sub, err := c.Subscribe(ctx, "SELECT * FROM film", client.SubscribeOptions{})
+//	for ch := range sub.Changes() {
+//		rows := sub.Rows() // current as of ch
+//	}
+//
+//	res, err := c.Edit(ctx, cell.EditHandle, "title", "New title")
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/zoravur/postgres-spreadsheet-view/server/internal/protocol"
+)
+
+// Protocol types, re-exported so callers outside this module can name them.
+type (
+	Row       = protocol.Row
+	Cell      = protocol.Cell
+	RowOp     = protocol.RowOp
+	Update    = protocol.Update
+	EditAck   = protocol.EditAck
+	Error     = protocol.Error
+	ErrorCode = protocol.ErrorCode
+)
+
+// Error codes the server answers failed requests with; see Error.
+const (
+	CodeBadRequest         = protocol.CodeBadRequest
+	CodeUnsupportedVersion = protocol.CodeUnsupportedVersion
+	CodeUnsupported        = protocol.CodeUnsupported
+	CodeParseError         = protocol.CodeParseError
+	CodeQueryFailed        = protocol.CodeQueryFailed
+	CodeNotFound           = protocol.CodeNotFound
+	CodeNotEditable        = protocol.CodeNotEditable
+	CodeInvalidValue       = protocol.CodeInvalidValue
+	CodePermissionDenied   = protocol.CodePermissionDenied
+	CodeConflict           = protocol.CodeConflict
+	CodeInternal           = protocol.CodeInternal
+)
+
+var (
+	// ErrClosed is returned by calls on a closed Client or Subscription.
+	ErrClosed = errors.New("client: closed")
+	// ErrDisconnected fails requests whose connection dropped before they
+	// were answered. An edit may or may not have committed; the
+	// subscription's rows show which once it has resumed.
+	ErrDisconnected = errors.New("client: disconnected")
+)
+
+// capabilities are the protocol capabilities this package uses.
+var capabilities = []protocol.Capability{protocol.CapResume, protocol.CapEdit}
+
+type Options struct {
+	// User is who the connection's edits show as (sent as ?user=).
+	User string
+	// Header is sent with every dial.
+	Header http.Header
+	// Dialer defaults to websocket.DefaultDialer.
+	Dialer *websocket.Dialer
+	// MinBackoff and MaxBackoff bound the delay between reconnect attempts;
+	// default 500ms and 30s. The delay doubles after every failed attempt.
+	MinBackoff, MaxBackoff time.Duration
+	// OnDisconnect and OnReconnect, if set, are told when the connection
+	// drops and when it is back with every subscription resubscribed.
+	OnDisconnect func(err error)
+	OnReconnect  func()
+}
+
+// Client is one connection to the server, redialed whenever it drops. It is
+// safe for concurrent use.
+type Client struct {
+	url  string
+	opt  Options
+	done chan struct{} // closed by Close
+	exit chan struct{} // closed when run returns
+
+	writeMu sync.Mutex // gorilla/websocket allows one concurrent writer
+
+	mu      sync.Mutex
+	conn    *websocket.Conn // nil while reconnecting
+	subs    map[string]*Subscription
+	pending map[string]chan reply
+	closed  bool
+
+	nextID atomic.Uint64
+}
+
+// reply answers one request: an "ack" (or "subscribed", "unsubscribed",
+// "pong") with its data, or an "error".
+type reply struct {
+	data json.RawMessage
+	err  error
+}
+
+// inbound is a server message with its data left raw until its type is known.
+type inbound struct {
+	Type      protocol.Type   `json:"type"`
+	RequestID string          `json:"requestId"`
+	Sub       string          `json:"sub"`
+	Data      json.RawMessage `json:"data"`
+}
+
+// Dial connects to the server's /api/ws URL and completes the handshake. The
+// first connection must succeed; later ones are retried with backoff.
+func Dial(ctx context.Context, rawURL string, opt Options) (*Client, error) {
+	if opt.Dialer == nil {
+		opt.Dialer = websocket.DefaultDialer
+	}
+	if opt.MinBackoff <= 0 {
+		opt.MinBackoff = 500 * time.Millisecond
+	}
+	if opt.MaxBackoff < opt.MinBackoff {
+		opt.MaxBackoff = max(30*time.Second, opt.MinBackoff)
+	}
+	if opt.User != "" {
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			return nil, err
+		}
+		q := u.Query()
+		q.Set("user", opt.User)
+		u.RawQuery = q.Encode()
+		rawURL = u.String()
+	}
+
+	c := &Client{
+		url:     rawURL,
+		opt:     opt,
+		done:    make(chan struct{}),
+		exit:    make(chan struct{}),
+		subs:    map[string]*Subscription{},
+		pending: map[string]chan reply{},
+	}
+	conn, err := c.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.conn = conn
+	go c.run(conn)
+	return c, nil
+}
+
+// Close ends every subscription and the connection.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	close(c.done)
+	conn := c.conn
+	c.mu.Unlock()
+
+	if conn != nil {
+		c.writeMu.Lock()
+		_ = conn.WriteControl(websocket.CloseMessage,
+			websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+		c.writeMu.Unlock()
+		_ = conn.Close()
+	}
+	<-c.exit
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, s := range c.subs {
+		s.end()
+	}
+	c.subs = map[string]*Subscription{}
+	return nil
+}
+
+// connect dials and says hello.
+func (c *Client) connect(ctx context.Context) (*websocket.Conn, error) {
+	conn, _, err := c.opt.Dialer.DialContext(ctx, c.url, c.opt.Header)
+	if err != nil {
+		return nil, err
+	}
+	if dl, ok := ctx.Deadline(); ok {
+		_ = conn.SetReadDeadline(dl)
+	}
+	err = conn.WriteJSON(protocol.Request{
+		Type: protocol.TypeHello,
+		Data: mustJSON(protocol.Hello{Version: protocol.Version, Capabilities: capabilities}),
+	})
+	for err == nil {
+		var m inbound
+		if err = conn.ReadJSON(&m); err != nil {
+			break
+		}
+		switch m.Type {
+		case protocol.TypeHello:
+			_ = conn.SetReadDeadline(time.Time{})
+			return conn, nil
+		case protocol.TypeError:
+			err = decodeError(m.Data)
+		}
+	}
+	_ = conn.Close()
+	return nil, err
+}
+
+// run reads from conn until it fails, then reconnects, until Close.
+func (c *Client) run(conn *websocket.Conn) {
+	defer close(c.exit)
+	for {
+		err := c.readLoop(conn)
+
+		c.mu.Lock()
+		c.conn = nil
+		for id, ch := range c.pending {
+			ch <- reply{err: ErrDisconnected}
+			delete(c.pending, id)
+		}
+		closed := c.closed
+		c.mu.Unlock()
+		if closed {
+			return
+		}
+		if c.opt.OnDisconnect != nil {
+			c.opt.OnDisconnect(err)
+		}
+
+		if conn = c.reconnect(); conn == nil {
+			return // closed
+		}
+		if c.opt.OnReconnect != nil {
+			c.opt.OnReconnect()
+		}
+	}
+}
+
+// reconnect redials with backoff and resubscribes; nil once the client is
+// closed.
+func (c *Client) reconnect() *websocket.Conn {
+	delay := c.opt.MinBackoff
+	for {
+		select {
+		case <-c.done:
+			return nil
+		case <-time.After(delay):
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		conn, err := c.connect(ctx)
+		cancel()
+		if err != nil {
+			delay = min(delay*2, c.opt.MaxBackoff)
+			continue
+		}
+
+		c.mu.Lock()
+		if c.closed {
+			c.mu.Unlock()
+			_ = conn.Close()
+			return nil
+		}
+		c.conn = conn
+		subs := make([]*Subscription, 0, len(c.subs))
+		for _, s := range c.subs {
+			subs = append(subs, s)
+		}
+		c.mu.Unlock()
+
+		// the server replays what each subscription missed, or starts it over
+		for _, s := range subs {
+			if err := c.write(s.subscribeRequest(c.newRequestID())); err != nil {
+				break // the read loop sees the failure too
+			}
+		}
+		return conn
+	}
+}
+
+func (c *Client) readLoop(conn *websocket.Conn) error {
+	for {
+		var m inbound
+		if err := conn.ReadJSON(&m); err != nil {
+			return err
+		}
+		c.dispatch(m)
+	}
+}
+
+// dispatch routes one message to its subscription and its request. It runs
+// on the read goroutine only, so subscriptions apply messages in order.
+func (c *Client) dispatch(m inbound) {
+	c.mu.Lock()
+	s := c.subs[m.Sub]
+	ch := c.pending[m.RequestID]
+	delete(c.pending, m.RequestID)
+	c.mu.Unlock()
+
+	if s != nil {
+		if err := s.handle(m); err != nil {
+			// a resubscribe failed: the subscription is over
+			c.mu.Lock()
+			delete(c.subs, s.ID)
+			c.mu.Unlock()
+			s.end()
+		}
+	}
+	if ch != nil {
+		if m.Type == protocol.TypeError {
+			ch <- reply{err: decodeError(m.Data)}
+		} else {
+			ch <- reply{data: m.Data}
+		}
+	}
+}
+
+// request sends req and waits for its answer.
+func (c *Client) request(ctx context.Context, req protocol.Request) (json.RawMessage, error) {
+	ch := make(chan reply, 1)
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil, ErrClosed
+	}
+	c.pending[req.RequestID] = ch
+	c.mu.Unlock()
+
+	if err := c.write(req); err != nil {
+		c.forget(req.RequestID)
+		return nil, err
+	}
+	select {
+	case r := <-ch:
+		return r.data, r.err
+	case <-ctx.Done():
+		c.forget(req.RequestID)
+		return nil, ctx.Err()
+	case <-c.done:
+		return nil, ErrClosed
+	}
+}
+
+func (c *Client) forget(requestID string) {
+	c.mu.Lock()
+	delete(c.pending, requestID)
+	c.mu.Unlock()
+}
+
+// write sends one request on the current connection.
+func (c *Client) write(req protocol.Request) error {
+	c.mu.Lock()
+	conn, closed := c.conn, c.closed
+	c.mu.Unlock()
+	switch {
+	case closed:
+		return ErrClosed
+	case conn == nil:
+		return ErrDisconnected
+	}
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if err := conn.WriteJSON(req); err != nil {
+		return fmt.Errorf("%w: %v", ErrDisconnected, err)
+	}
+	return nil
+}
+
+func (c *Client) newRequestID() string {
+	return "c" + strconv.FormatUint(c.nextID.Add(1), 10)
+}
+
+// EditResult is an edit's acknowledgement. RequestID appears in the Origin of
+// the updates the edit causes.
+type EditResult struct {
+	RequestID string
+	EditAck
+}
+
+// Edit writes one cell and waits for the transaction to commit. Failures the
+// server reports are *Error with a code, e.g. CodeConflict for a cell someone
+// else has locked.
+func (c *Client) Edit(ctx context.Context, editHandle, column string, value any) (EditResult, error) {
+	res := EditResult{RequestID: c.newRequestID()}
+	data, err := c.request(ctx, protocol.Request{
+		Type:      protocol.TypeEdit,
+		RequestID: res.RequestID,
+		Data: mustJSON(protocol.Edit{
+			CellRef: protocol.CellRef{EditHandle: editHandle, Column: column},
+			Value:   value,
+		}),
+	})
+	if err != nil {
+		return res, err
+	}
+	return res, json.Unmarshal(data, &res.EditAck)
+}
+
+// decodeError reads an "error" message's data.
+func decodeError(data json.RawMessage) error {
+	e := &Error{}
+	if err := json.Unmarshal(data, e); err != nil || e.Code == "" {
+		return &Error{Code: CodeInternal, Message: "malformed error message: " + string(data)}
+	}
+	return e
+}
+
+func mustJSON(v any) json.RawMessage {
+	b, err := json.Marshal(v)
+	if err != nil {
+		panic(err) // only called with protocol types
+	}
+	return b
+}
@@ -0,0 +1,282 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/zoravur/postgres-spreadsheet-view/server/internal/protocol"
+)
+
+// fakeServer speaks the server side of the protocol. Each connection is
+// handed to serve after the handshake; the connection closes when it returns.
+func fakeServer(t *testing.T, serve func(n int, conn *websocket.Conn)) string {
+	t.Helper()
+	n := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		var req protocol.Request
+		if err := conn.ReadJSON(&req); err != nil || req.Type != protocol.TypeHello {
+			return
+		}
+		var h protocol.Hello
+		_ = json.Unmarshal(req.Data, &h)
+		agreed, _ := protocol.Negotiate(h)
+		_ = conn.WriteJSON(protocol.Message{Type: protocol.TypeHello, Data: agreed})
+		n++
+		serve(n, conn)
+	}))
+	t.Cleanup(srv.Close)
+	return "ws" + strings.TrimPrefix(srv.URL, "http")
+}
+
+func expect(t *testing.T, conn *websocket.Conn, typ protocol.Type) protocol.Request {
+	t.Helper()
+	var req protocol.Request
+	if err := conn.ReadJSON(&req); err != nil {
+		t.Errorf("read %s: %v", typ, err)
+		return req
+	}
+	if req.Type != typ {
+		t.Errorf("got %s request, want %s", req.Type, typ)
+	}
+	return req
+}
+
+func row(handle string, vals ...any) Row {
+	r := Row{}
+	for i := 0; i+1 < len(vals); i += 2 {
+		r[vals[i].(string)] = Cell{EditHandle: handle, Value: vals[i+1]}
+	}
+	return r
+}
+
+func idx(i int) *int { return &i }
+
+func titles(rows []Row) []any {
+	out := []any{}
+	for _, r := range rows {
+		out = append(out, r["title"].Value)
+	}
+	return out
+}
+
+func nextChange(t *testing.T, s *Subscription) Change {
+	t.Helper()
+	select {
+	case ch := <-s.Changes():
+		return ch
+	case <-time.After(5 * time.Second):
+		t.Fatal("no change")
+		return Change{}
+	}
+}
+
+func TestApply(t *testing.T) {
+	snap := &protocol.Snapshot{
+		ID:   "lq",
+		Keys: []string{"1", "2", "3"},
+		Rows: []Row{row("h1", "title", "a"), row("h2", "title", "b"), row("h3", "title", "c")},
+	}
+	tests := []struct {
+		name string
+		ops  []RowOp
+		want []any
+	}{
+		{"delete", []RowOp{{Op: OpDelete, Key: "2"}}, []any{"a", "c"}},
+		{"update merges", []RowOp{{Op: OpUpdate, Key: "1", Row: row("h1", "title", "A")}}, []any{"A", "b", "c"}},
+		{"insert", []RowOp{{Op: OpInsert, Key: "4", Index: idx(1), Row: row("h4", "title", "d")}}, []any{"a", "d", "b", "c"}},
+		{"move", []RowOp{{Op: OpMove, Key: "3", Index: idx(0)}}, []any{"c", "a", "b"}},
+		{"mixed", []RowOp{
+			{Op: OpInsert, Key: "5", Index: idx(2), Row: row("h5", "title", "e")},
+			{Op: OpMove, Key: "1", Index: idx(1)},
+			{Op: OpDelete, Key: "2"},
+			{Op: OpInsert, Key: "4", Index: idx(0), Row: row("h4", "title", "d")},
+		}, []any{"d", "a", "e", "c"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Subscription{}
+			s.reset(snap)
+			s.apply(tt.ops)
+			if got := titles(s.Rows()); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("rows = %v, want %v", got, tt.want)
+			}
+			for _, k := range s.Keys() {
+				r, _ := s.Row(k)
+				h := r["title"].EditHandle
+				if got := s.RowsByHandle(h); len(got) != 1 || !reflect.DeepEqual(got[0], r) {
+					t.Errorf("RowsByHandle(%s) = %v, want [%v]", h, got, r)
+				}
+			}
+			if got := s.RowsByHandle("h2"); tt.name == "delete" && got != nil {
+				t.Errorf("deleted row still indexed: %v", got)
+			}
+		})
+	}
+}
+
+func TestSubscribeAndUpdate(t *testing.T) {
+	url := fakeServer(t, func(_ int, conn *websocket.Conn) {
+		req := expect(t, conn, protocol.TypeSubscribe)
+		var sub protocol.Subscribe
+		_ = json.Unmarshal(req.Data, &sub)
+		if sub.SQL != "SELECT title FROM film" || sub.Since != nil {
+			t.Errorf("subscribe = %+v", sub)
+		}
+		_ = conn.WriteJSON(protocol.Message{Type: protocol.TypeSubscribed, RequestID: req.RequestID, Sub: req.Sub,
+			Data: protocol.Subscribed{Snapshot: protocol.Snapshot{ID: "lq", Seq: 0,
+				Keys: []string{"1"}, Rows: []Row{row("h1", "title", "a")}}}})
+		_ = conn.WriteJSON(protocol.Message{Type: protocol.TypeUpdate, Sub: req.Sub,
+			Data: protocol.Update{ID: "lq", Seq: 1, Ops: []RowOp{{Op: OpUpdate, Key: "1", Row: row("h1", "title", "b")}}}})
+		expect(t, conn, protocol.TypeUnsubscribe)
+	})
+
+	ctx := context.Background()
+	c, err := Dial(ctx, url, Options{User: "etl"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	s, err := c.Subscribe(ctx, "SELECT title FROM film", SubscribeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ch := nextChange(t, s); ch.Kind != ChangeReset {
+		t.Errorf("first change = %v, want reset", ch.Kind)
+	}
+	if ch := nextChange(t, s); ch.Kind != ChangeUpdate || ch.Update.Seq != 1 {
+		t.Errorf("second change = %+v, want update 1", ch)
+	}
+	if got := titles(s.Rows()); !reflect.DeepEqual(got, []any{"b"}) {
+		t.Errorf("rows = %v", got)
+	}
+	if cell, ok := s.Cell("h1", "title"); !ok || cell.Value != "b" {
+		t.Errorf("Cell = %v, %v", cell, ok)
+	}
+	if id, seq := s.Position(); id != "lq" || seq != 1 {
+		t.Errorf("position = %s:%d", id, seq)
+	}
+	_ = s.Close(ctx)
+}
+
+func TestSubscribeRefused(t *testing.T) {
+	url := fakeServer(t, func(_ int, conn *websocket.Conn) {
+		req := expect(t, conn, protocol.TypeSubscribe)
+		_ = conn.WriteJSON(protocol.Message{Type: protocol.TypeError, RequestID: req.RequestID, Sub: req.Sub,
+			Data: protocol.Errorf(protocol.CodeParseError, "syntax error")})
+		_ = conn.ReadJSON(&req) // until the client closes
+	})
+	c, err := Dial(context.Background(), url, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	_, err = c.Subscribe(context.Background(), "SELEC", SubscribeOptions{})
+	var perr *Error
+	if !errors.As(err, &perr) || perr.Code != CodeParseError {
+		t.Errorf("err = %v, want parse_error", err)
+	}
+}
+
+func TestEdit(t *testing.T) {
+	url := fakeServer(t, func(_ int, conn *websocket.Conn) {
+		req := expect(t, conn, protocol.TypeEdit)
+		var e protocol.Edit
+		_ = json.Unmarshal(req.Data, &e)
+		if e.EditHandle != "h1" || e.Column != "title" || e.Value != "x" {
+			t.Errorf("edit = %+v", e)
+		}
+		_ = conn.WriteJSON(protocol.Message{Type: protocol.TypeAck, RequestID: req.RequestID,
+			Data: protocol.EditAck{XID: 7, LSN: "0/1", Rows: 1}})
+
+		req = expect(t, conn, protocol.TypeEdit)
+		_ = conn.WriteJSON(protocol.Message{Type: protocol.TypeError, RequestID: req.RequestID,
+			Data: &protocol.Error{Code: protocol.CodeConflict, Message: "locked",
+				Lock: &protocol.Lock{EditHandle: "h1", Column: "title", User: "bob"}}})
+		_ = conn.ReadJSON(&req)
+	})
+	ctx := context.Background()
+	c, err := Dial(ctx, url, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	res, err := c.Edit(ctx, "h1", "title", "x")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.RequestID == "" || !reflect.DeepEqual(res.EditAck, EditAck{XID: 7, LSN: "0/1", Rows: 1}) {
+		t.Errorf("result = %+v", res)
+	}
+
+	_, err = c.Edit(ctx, "h1", "title", "y")
+	var perr *Error
+	if !errors.As(err, &perr) || perr.Code != CodeConflict || perr.Lock == nil || perr.Lock.User != "bob" {
+		t.Errorf("err = %#v, want conflict held by bob", err)
+	}
+}
+
+func TestReconnectResumes(t *testing.T) {
+	url := fakeServer(t, func(n int, conn *websocket.Conn) {
+		req := expect(t, conn, protocol.TypeSubscribe)
+		var sub protocol.Subscribe
+		_ = json.Unmarshal(req.Data, &sub)
+		switch n {
+		case 1:
+			_ = conn.WriteJSON(protocol.Message{Type: protocol.TypeSubscribed, RequestID: req.RequestID, Sub: req.Sub,
+				Data: protocol.Subscribed{Snapshot: protocol.Snapshot{ID: "lq", Seq: 3,
+					Keys: []string{"1"}, Rows: []Row{row("h1", "title", "a")}}}})
+			// drop the connection
+		case 2:
+			if sub.Since == nil || *sub.Since != (protocol.Position{ID: "lq", Seq: 3}) {
+				t.Errorf("resubscribe since = %+v, want lq:3", sub.Since)
+			}
+			_ = conn.WriteJSON(protocol.Message{Type: protocol.TypeSubscribed, RequestID: req.RequestID, Sub: req.Sub,
+				Data: protocol.Subscribed{Snapshot: protocol.Snapshot{ID: "lq", Seq: 3}, Resumed: true}})
+			_ = conn.WriteJSON(protocol.Message{Type: protocol.TypeUpdate, Sub: req.Sub,
+				Data: protocol.Update{ID: "lq", Seq: 4, Ops: []RowOp{{Op: OpInsert, Key: "2", Index: idx(1), Row: row("h2", "title", "b")}}}})
+			_ = conn.ReadJSON(&req)
+		}
+	})
+
+	ctx := context.Background()
+	reconnected := make(chan struct{}, 1)
+	c, err := Dial(ctx, url, Options{MinBackoff: 10 * time.Millisecond, OnReconnect: func() { reconnected <- struct{}{} }})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	s, err := c.Subscribe(ctx, "SELECT title FROM film", SubscribeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ch := nextChange(t, s); ch.Kind != ChangeReset {
+		t.Errorf("first change = %v, want reset", ch.Kind)
+	}
+	// the resumed subscription keeps its rows and applies the missed update
+	if ch := nextChange(t, s); ch.Kind != ChangeUpdate || ch.Update.Seq != 4 {
+		t.Errorf("change after reconnect = %+v, want update 4", ch)
+	}
+	select {
+	case <-reconnected:
+	default:
+		t.Error("OnReconnect not called")
+	}
+	if got := titles(s.Rows()); !reflect.DeepEqual(got, []any{"a", "b"}) {
+		t.Errorf("rows = %v", got)
+	}
+}
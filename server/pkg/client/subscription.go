@@ -0,0 +1,468 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"sync"
+
+	"github.com/google/uuid"
+
+	"github.com/zoravur/postgres-spreadsheet-view/server/internal/protocol"
+)
+
+// Row ops in an Update; see RowOp.
+const (
+	OpInsert = "insert"
+	OpUpdate = "update"
+	OpDelete = "delete"
+	OpMove   = "move"
+)
+
+// ChangeKind says what happened to a subscription's result.
+type ChangeKind string
+
+const (
+	// ChangeReset: the whole result was replaced, by the initial snapshot, a
+	// reload, or a resubscribe the server could not resume.
+	ChangeReset ChangeKind = "reset"
+	// ChangeUpdate: Update's row ops were applied.
+	ChangeUpdate ChangeKind = "update"
+	// ChangeSchema: the query was re-analyzed after a schema change; a reset
+	// follows.
+	ChangeSchema ChangeKind = "schema"
+	// ChangeError: the live query failed. It stays subscribed in case a later
+	// schema change fixes it, unless the subscription is closed.
+	ChangeError ChangeKind = "error"
+)
+
+// Change is one event of a subscription, reported after the materialized
+// result reflects it.
+type Change struct {
+	Kind   ChangeKind
+	Update *Update // ChangeUpdate only
+	Err    error   // ChangeError only
+}
+
+type SubscribeOptions struct {
+	// Strategy overrides the server's refresh strategy: pk_pushdown |
+	// full_requery | incremental_aggregate | poll. Only the server's pick or a
+	// more conservative one (full_requery, poll) is accepted.
+	Strategy string
+	// OnChange, if set, is called on the client's read goroutine for every
+	// change; it should return quickly.
+	OnChange func(Change)
+	// Buffer sizes the Changes channel; default 64.
+	Buffer int
+}
+
+// Subscription is a live query's result, kept current by the server's
+// updates. Its methods are safe for concurrent use.
+type Subscription struct {
+	ID  string // the subscription's ID on the connection
+	SQL string
+
+	c        *Client
+	strategy string
+	onChange func(Change)
+	changes  chan Change
+
+	ready   chan struct{} // closed on the first snapshot or failure
+	readyMu sync.Once
+	err     error // why the first subscribe failed
+
+	mu       sync.RWMutex
+	pos      protocol.Position // live query and seq applied, for resuming
+	keys     []string
+	rows     map[string]Row
+	byHandle map[string]map[string]struct{} // edit handle -> keys of rows with a cell it edits
+	closed   bool
+}
+
+// Subscribe starts a live query and waits for its initial result.
+func (c *Client) Subscribe(ctx context.Context, sql string, opt SubscribeOptions) (*Subscription, error) {
+	if opt.Buffer <= 0 {
+		opt.Buffer = 64
+	}
+	s := &Subscription{
+		ID:       uuid.NewString(),
+		SQL:      sql,
+		c:        c,
+		strategy: opt.Strategy,
+		onChange: opt.OnChange,
+		changes:  make(chan Change, opt.Buffer),
+		ready:    make(chan struct{}),
+	}
+	s.reset(nil)
+
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil, ErrClosed
+	}
+	c.subs[s.ID] = s
+	c.mu.Unlock()
+
+	// a dropped connection is fine: the reconnect resubscribes
+	if err := c.write(s.subscribeRequest(c.newRequestID())); err != nil && !errors.Is(err, ErrDisconnected) {
+		c.drop(s)
+		return nil, err
+	}
+	select {
+	case <-s.ready:
+		if s.err != nil {
+			return nil, s.err
+		}
+		return s, nil
+	case <-ctx.Done():
+		// don't wait for the server to confirm, the caller has given up
+		if c.drop(s) {
+			_ = c.write(protocol.Request{Type: protocol.TypeUnsubscribe, Sub: s.ID})
+		}
+		return nil, ctx.Err()
+	case <-c.done:
+		return nil, ErrClosed
+	}
+}
+
+// Close unsubscribes. It doesn't wait for the server when disconnected.
+func (s *Subscription) Close(ctx context.Context) error {
+	if !s.c.drop(s) {
+		return nil
+	}
+	_, err := s.c.request(ctx, protocol.Request{
+		Type:      protocol.TypeUnsubscribe,
+		RequestID: s.c.newRequestID(),
+		Sub:       s.ID,
+	})
+	if errors.Is(err, ErrDisconnected) || errors.Is(err, ErrClosed) {
+		return nil // the subscription ended with the connection
+	}
+	return err
+}
+
+// drop forgets s; false if it already was.
+func (c *Client) drop(s *Subscription) bool {
+	c.mu.Lock()
+	_, ok := c.subs[s.ID]
+	delete(c.subs, s.ID)
+	c.mu.Unlock()
+	if ok {
+		s.end()
+	}
+	return ok
+}
+
+// Changes reports every change to the result. Notifications that don't fit
+// the buffer are dropped, but the rows are always current: a slow reader can
+// read them afresh. The channel is closed when the subscription ends.
+func (s *Subscription) Changes() <-chan Change { return s.changes }
+
+// Rows returns the result rows in order.
+func (s *Subscription) Rows() []Row {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	out := make([]Row, len(s.keys))
+	for i, k := range s.keys {
+		out[i] = s.rows[k]
+	}
+	return out
+}
+
+// Keys returns the row identities, parallel to Rows.
+func (s *Subscription) Keys() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return append([]string(nil), s.keys...)
+}
+
+// Row returns the row with the given key.
+func (s *Subscription) Row(key string) (Row, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	r, ok := s.rows[key]
+	return r, ok
+}
+
+// RowsByHandle returns the rows with a cell the edit handle edits, in result
+// order. A join can show one base row in several result rows.
+func (s *Subscription) RowsByHandle(editHandle string) []Row {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	keys := s.byHandle[editHandle]
+	var out []Row
+	for _, k := range s.keys {
+		if _, ok := keys[k]; ok {
+			out = append(out, s.rows[k])
+		}
+	}
+	return out
+}
+
+// Cell returns the column of a row the edit handle edits.
+func (s *Subscription) Cell(editHandle, column string) (Cell, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for k := range s.byHandle[editHandle] {
+		if c, ok := s.rows[k][column]; ok && c.EditHandle == editHandle {
+			return c, true
+		}
+	}
+	return Cell{}, false
+}
+
+// Position is the live query and seq the result is current as of.
+func (s *Subscription) Position() (liveQueryID string, seq uint64) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.pos.ID, s.pos.Seq
+}
+
+// subscribeRequest (re)subscribes, resuming from the last position applied.
+func (s *Subscription) subscribeRequest(requestID string) protocol.Request {
+	sub := protocol.Subscribe{SQL: s.SQL, Strategy: s.strategy}
+	s.mu.RLock()
+	if s.pos.ID != "" {
+		pos := s.pos
+		sub.Since = &pos
+	}
+	s.mu.RUnlock()
+	return protocol.Request{
+		Type:      protocol.TypeSubscribe,
+		RequestID: requestID,
+		Sub:       s.ID,
+		Data:      mustJSON(sub),
+	}
+}
+
+// handle applies one message for s. An error means a subscribe was refused
+// and s is over.
+func (s *Subscription) handle(m inbound) error {
+	switch m.Type {
+	case protocol.TypeSubscribed:
+		var d protocol.Subscribed
+		if err := json.Unmarshal(m.Data, &d); err != nil {
+			return s.fail(err)
+		}
+		if d.Resumed {
+			// the updates after our position follow
+			s.mu.Lock()
+			s.pos = protocol.Position{ID: d.ID, Seq: d.Seq}
+			s.mu.Unlock()
+		} else {
+			s.reset(&d.Snapshot)
+			s.notify(Change{Kind: ChangeReset})
+		}
+		s.readyMu.Do(func() { close(s.ready) })
+
+	case protocol.TypeReload:
+		var d protocol.Snapshot
+		if err := json.Unmarshal(m.Data, &d); err != nil {
+			return s.fail(err)
+		}
+		s.reset(&d)
+		s.notify(Change{Kind: ChangeReset})
+
+	case protocol.TypeUpdate:
+		var u Update
+		if err := json.Unmarshal(m.Data, &u); err != nil {
+			return s.fail(err)
+		}
+		s.mu.Lock()
+		if s.pos.ID != "" && u.ID != s.pos.ID {
+			s.mu.Unlock()
+			return nil // from a live query we have left
+		}
+		s.apply(u.Ops)
+		s.pos = protocol.Position{ID: u.ID, Seq: u.Seq}
+		s.mu.Unlock()
+		s.notify(Change{Kind: ChangeUpdate, Update: &u})
+
+	case protocol.TypeSchemaChanged:
+		s.notify(Change{Kind: ChangeSchema})
+
+	case protocol.TypeError:
+		err := decodeError(m.Data)
+		if m.RequestID != "" {
+			// our subscribe was refused
+			return s.fail(err)
+		}
+		s.notify(Change{Kind: ChangeError, Err: err})
+	}
+	return nil
+}
+
+// fail ends a subscription whose subscribe was refused; a failed first
+// subscribe is returned by Subscribe instead of reported.
+func (s *Subscription) fail(err error) error {
+	first := false
+	s.readyMu.Do(func() {
+		first = true
+		s.err = err
+		close(s.ready)
+	})
+	if !first {
+		s.notify(Change{Kind: ChangeError, Err: err})
+	}
+	return err
+}
+
+// reset replaces the result with snap, or empties it.
+func (s *Subscription) reset(snap *protocol.Snapshot) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys = nil
+	s.rows = map[string]Row{}
+	s.byHandle = map[string]map[string]struct{}{}
+	if snap == nil {
+		return
+	}
+	s.pos = protocol.Position{ID: snap.ID, Seq: snap.Seq}
+	for i, row := range snap.Rows {
+		if i >= len(snap.Keys) {
+			break
+		}
+		k := snap.Keys[i]
+		s.keys = append(s.keys, k)
+		s.rows[k] = row
+		s.index(k, row)
+	}
+}
+
+// apply applies one update's row ops, in the order RowOp prescribes. The
+// caller holds s.mu.
+func (s *Subscription) apply(ops []RowOp) {
+	gone := map[string]bool{}
+	var placed []RowOp
+	for _, op := range ops {
+		switch op.Op {
+		case OpDelete:
+			gone[op.Key] = true
+			s.unindex(op.Key)
+			delete(s.rows, op.Key)
+		case OpUpdate:
+			row, ok := s.rows[op.Key]
+			if !ok {
+				continue
+			}
+			s.unindex(op.Key)
+			merged := make(Row, len(row)+len(op.Row))
+			for col, c := range row {
+				merged[col] = c
+			}
+			for col, c := range op.Row {
+				merged[col] = c
+			}
+			s.rows[op.Key] = merged
+			s.index(op.Key, merged)
+		case OpInsert, OpMove:
+			if op.Op == OpMove {
+				gone[op.Key] = true // detached, placed again below
+			}
+			placed = append(placed, op)
+		}
+	}
+	if len(gone) > 0 {
+		kept := s.keys[:0]
+		for _, k := range s.keys {
+			if !gone[k] {
+				kept = append(kept, k)
+			}
+		}
+		s.keys = kept
+	}
+
+	sort.SliceStable(placed, func(i, j int) bool { return opIndex(placed[i]) < opIndex(placed[j]) })
+	for _, op := range placed {
+		if op.Op == OpInsert {
+			if _, ok := s.rows[op.Key]; ok {
+				s.unindex(op.Key)
+				s.keys = remove(s.keys, op.Key)
+			}
+			s.rows[op.Key] = op.Row
+			s.index(op.Key, op.Row)
+		} else if _, ok := s.rows[op.Key]; !ok {
+			continue
+		}
+		i := opIndex(op)
+		if i < 0 || i > len(s.keys) {
+			i = len(s.keys)
+		}
+		s.keys = append(s.keys, "")
+		copy(s.keys[i+1:], s.keys[i:])
+		s.keys[i] = op.Key
+	}
+}
+
+// opIndex is where an insert or move goes; the end when the op doesn't say.
+func opIndex(op RowOp) int {
+	if op.Index == nil {
+		return int(^uint(0) >> 1)
+	}
+	return *op.Index
+}
+
+func remove(keys []string, key string) []string {
+	for i, k := range keys {
+		if k == key {
+			return append(keys[:i], keys[i+1:]...)
+		}
+	}
+	return keys
+}
+
+func (s *Subscription) index(key string, row Row) {
+	for _, c := range row {
+		if c.EditHandle == "" {
+			continue
+		}
+		keys := s.byHandle[c.EditHandle]
+		if keys == nil {
+			keys = map[string]struct{}{}
+			s.byHandle[c.EditHandle] = keys
+		}
+		keys[key] = struct{}{}
+	}
+}
+
+func (s *Subscription) unindex(key string) {
+	for _, c := range s.rows[key] {
+		if keys := s.byHandle[c.EditHandle]; keys != nil {
+			delete(keys, key)
+			if len(keys) == 0 {
+				delete(s.byHandle, c.EditHandle)
+			}
+		}
+	}
+}
+
+// notify reports ch to the callback and, if there is room, the channel.
+func (s *Subscription) notify(ch Change) {
+	if s.onChange != nil {
+		s.onChange(ch)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	select {
+	case s.changes <- ch:
+	default:
+	}
+}
+
+// end closes the Changes channel, once.
+func (s *Subscription) end() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.closed {
+		s.closed = true
+		close(s.changes)
+	}
+	s.readyMu.Do(func() {
+		s.err = ErrClosed
+		close(s.ready)
+	})
+}
@@ -0,0 +1,560 @@
+// Package ddl turns structured schema changes (add/rename/drop column, change type,
+// create/rename/drop table, add FK) into PostgreSQL DDL, validating each change
+// against a richcatalog snapshot first.
+//
+// Usage
+//
+//	stmts, err := ddl.Plan(rc.Snapshot(), []ddl.Change{
+//		{Op: ddl.OpAddColumn, Table: "film", Column: "notes", Type: "text"},
+//	})
+//	// dry run: show stmts; otherwise execute them in one transaction
+package ddl
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/lib/pq"
+	rc "github.com/zoravur/postgres-spreadsheet-view/server/pkg/richcatalog"
+)
+
+type Op string
+
+const (
+	OpAddColumn     Op = "add_column"
+	OpRenameColumn  Op = "rename_column"
+	OpDropColumn    Op = "drop_column"
+	OpAlterType     Op = "alter_column_type"
+	OpCreateTable   Op = "create_table"
+	OpRenameTable   Op = "rename_table"
+	OpDropTable     Op = "drop_table"
+	OpAddForeignKey Op = "add_foreign_key"
+)
+
+// Change is one structured schema edit. Which fields are used depends on Op.
+type Change struct {
+	Op     Op     `json:"op"`
+	Schema string `json:"schema,omitempty"` // defaults to "public"
+	Table  string `json:"table"`
+
+	// add_column / rename_column / drop_column / alter_column_type
+	Column  string  `json:"column,omitempty"`
+	Type    string  `json:"type,omitempty"`
+	NotNull bool    `json:"notNull,omitempty"`
+	Default *string `json:"default,omitempty"` // literal value, always quoted
+
+	// rename_column / rename_table
+	NewName string `json:"newName,omitempty"`
+
+	// create_table
+	Columns    []ColumnDef `json:"columns,omitempty"`
+	PrimaryKey []string    `json:"primaryKey,omitempty"`
+
+	// add_foreign_key
+	ForeignKey *ForeignKeyDef `json:"foreignKey,omitempty"`
+
+	// drop_column / drop_table: also drop dependent objects (FKs, views)
+	Cascade bool `json:"cascade,omitempty"`
+}
+
+// Destructive reports whether applying ch can lose data: dropping a table or
+// column, or cascading to the objects that depend on one.
+func (ch Change) Destructive() bool {
+	return ch.Op == OpDropTable || ch.Op == OpDropColumn || ch.Cascade
+}
+
+type ColumnDef struct {
+	Name    string  `json:"name"`
+	Type    string  `json:"type"`
+	NotNull bool    `json:"notNull,omitempty"`
+	Default *string `json:"default,omitempty"`
+}
+
+type ForeignKeyDef struct {
+	Name       string   `json:"name,omitempty"` // generated when empty
+	Columns    []string `json:"columns"`
+	RefSchema  string   `json:"refSchema,omitempty"` // defaults to the change's schema
+	RefTable   string   `json:"refTable"`
+	RefColumns []string `json:"refColumns"`
+	OnDelete   string   `json:"onDelete,omitempty"` // NO ACTION | RESTRICT | CASCADE | SET NULL | SET DEFAULT
+}
+
+// ValidationError reports which change in the batch was rejected and why.
+type ValidationError struct {
+	Index int
+	Op    Op
+	Msg   string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("change %d (%s): %s", e.Index, e.Op, e.Msg)
+}
+
+// Plan validates changes in order against snap and returns the DDL statements to run.
+// Later changes see the effect of earlier ones, so a batch may create a table and
+// then reference it from a foreign key.
+func Plan(snap rc.Snapshot, changes []Change) ([]string, error) {
+	m := newModel(snap)
+	stmts := make([]string, 0, len(changes))
+	for i, ch := range changes {
+		if ch.Schema == "" {
+			ch.Schema = "public"
+		}
+		stmt, err := m.apply(ch)
+		if err != nil {
+			return nil, &ValidationError{Index: i, Op: ch.Op, Msg: err.Error()}
+		}
+		stmts = append(stmts, stmt)
+	}
+	return stmts, nil
+}
+
+// --- working model ---
+
+type table struct {
+	schema, name string
+	cols         []string
+	pk           []string
+	uniques      [][]string // unique index column lists (incl. PK)
+}
+
+func (t *table) hasCol(c string) bool { return indexOf(t.cols, c) >= 0 }
+
+type fkRef struct {
+	name    string
+	from    string // qualified source table
+	cols    []string
+	to      string // qualified referenced table
+	refCols []string
+}
+
+type model struct {
+	tables map[string]*table
+	fks    []fkRef
+}
+
+func newModel(snap rc.Snapshot) *model {
+	m := &model{tables: map[string]*table{}}
+	for _, sc := range snap.Schemas {
+		for _, t := range sc.Tables {
+			mt := &table{schema: t.Schema, name: t.Name, pk: append([]string(nil), t.PK...)}
+			for _, c := range t.Columns {
+				mt.cols = append(mt.cols, c.Name)
+			}
+			for _, ix := range t.Indexes {
+				if ix.IsUnique || ix.IsPrimary {
+					mt.uniques = append(mt.uniques, append([]string(nil), ix.Columns...))
+				}
+			}
+			if len(mt.pk) > 0 {
+				mt.uniques = append(mt.uniques, mt.pk)
+			}
+			m.tables[t.Schema+"."+t.Name] = mt
+			for _, fk := range t.FKs {
+				m.fks = append(m.fks, fkRef{
+					name:    fk.Name,
+					from:    t.Schema + "." + t.Name,
+					cols:    fk.Columns,
+					to:      fk.RefSchema + "." + fk.RefTable,
+					refCols: fk.RefColumns,
+				})
+			}
+		}
+	}
+	return m
+}
+
+func (m *model) apply(ch Change) (string, error) {
+	if err := checkIdent("schema", ch.Schema); err != nil {
+		return "", err
+	}
+	if err := checkIdent("table", ch.Table); err != nil {
+		return "", err
+	}
+	key := ch.Schema + "." + ch.Table
+	rel := qualified(ch.Schema, ch.Table)
+
+	if ch.Op == OpCreateTable {
+		return m.createTable(ch, key, rel)
+	}
+
+	t, ok := m.tables[key]
+	if !ok {
+		return "", fmt.Errorf("table %s does not exist", key)
+	}
+
+	switch ch.Op {
+	case OpAddColumn:
+		def := ColumnDef{Name: ch.Column, Type: ch.Type, NotNull: ch.NotNull, Default: ch.Default}
+		colSQL, err := columnSQL(def)
+		if err != nil {
+			return "", err
+		}
+		if t.hasCol(ch.Column) {
+			return "", fmt.Errorf("column %s already exists", ch.Column)
+		}
+		if ch.NotNull && ch.Default == nil {
+			// would fail on any non-empty table; make the user say what existing rows get
+			return "", fmt.Errorf("NOT NULL column %s needs a default", ch.Column)
+		}
+		t.cols = append(t.cols, ch.Column)
+		return fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s", rel, colSQL), nil
+
+	case OpRenameColumn:
+		if err := m.requireCol(t, ch.Column); err != nil {
+			return "", err
+		}
+		if err := checkIdent("new name", ch.NewName); err != nil {
+			return "", err
+		}
+		if t.hasCol(ch.NewName) {
+			return "", fmt.Errorf("column %s already exists", ch.NewName)
+		}
+		m.renameCol(t, key, ch.Column, ch.NewName)
+		return fmt.Sprintf("ALTER TABLE %s RENAME COLUMN %s TO %s",
+			rel, pq.QuoteIdentifier(ch.Column), pq.QuoteIdentifier(ch.NewName)), nil
+
+	case OpDropColumn:
+		if err := m.requireCol(t, ch.Column); err != nil {
+			return "", err
+		}
+		if indexOf(t.pk, ch.Column) >= 0 {
+			return "", fmt.Errorf("column %s is part of the primary key", ch.Column)
+		}
+		if refs := m.referencing(key, ch.Column); len(refs) > 0 && !ch.Cascade {
+			return "", fmt.Errorf("column %s is referenced by foreign key %s (set cascade to drop it)", ch.Column, refs[0])
+		}
+		t.cols = removeStr(t.cols, ch.Column)
+		uniques := t.uniques[:0]
+		for _, u := range t.uniques {
+			if indexOf(u, ch.Column) < 0 {
+				uniques = append(uniques, u)
+			}
+		}
+		t.uniques = uniques
+		kept := m.fks[:0]
+		for _, fk := range m.fks {
+			if !(fk.from == key && indexOf(fk.cols, ch.Column) >= 0) && !(fk.to == key && indexOf(fk.refCols, ch.Column) >= 0) {
+				kept = append(kept, fk)
+			}
+		}
+		m.fks = kept
+		return fmt.Sprintf("ALTER TABLE %s DROP COLUMN %s%s", rel, pq.QuoteIdentifier(ch.Column), cascadeSQL(ch.Cascade)), nil
+
+	case OpAlterType:
+		if err := m.requireCol(t, ch.Column); err != nil {
+			return "", err
+		}
+		if err := checkType(ch.Type); err != nil {
+			return "", err
+		}
+		col := pq.QuoteIdentifier(ch.Column)
+		return fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s TYPE %s USING %s::%s", rel, col, ch.Type, col, ch.Type), nil
+
+	case OpRenameTable:
+		if err := checkIdent("new name", ch.NewName); err != nil {
+			return "", err
+		}
+		newKey := ch.Schema + "." + ch.NewName
+		if _, exists := m.tables[newKey]; exists {
+			return "", fmt.Errorf("table %s already exists", newKey)
+		}
+		delete(m.tables, key)
+		t.name = ch.NewName
+		m.tables[newKey] = t
+		for i := range m.fks {
+			if m.fks[i].from == key {
+				m.fks[i].from = newKey
+			}
+			if m.fks[i].to == key {
+				m.fks[i].to = newKey
+			}
+		}
+		return fmt.Sprintf("ALTER TABLE %s RENAME TO %s", rel, pq.QuoteIdentifier(ch.NewName)), nil
+
+	case OpDropTable:
+		if refs := m.referencing(key, ""); len(refs) > 0 && !ch.Cascade {
+			return "", fmt.Errorf("table is referenced by foreign key %s (set cascade to drop it)", refs[0])
+		}
+		delete(m.tables, key)
+		kept := m.fks[:0]
+		for _, fk := range m.fks {
+			if fk.from != key && fk.to != key {
+				kept = append(kept, fk)
+			}
+		}
+		m.fks = kept
+		return fmt.Sprintf("DROP TABLE %s%s", rel, cascadeSQL(ch.Cascade)), nil
+
+	case OpAddForeignKey:
+		return m.addForeignKey(ch, key, rel, t)
+
+	default:
+		return "", fmt.Errorf("unknown op %q", ch.Op)
+	}
+}
+
+func (m *model) createTable(ch Change, key, rel string) (string, error) {
+	if _, exists := m.tables[key]; exists {
+		return "", fmt.Errorf("table %s already exists", key)
+	}
+	if len(ch.Columns) == 0 {
+		return "", fmt.Errorf("create_table needs at least one column")
+	}
+	if len(ch.PrimaryKey) == 0 {
+		// every table edited through the grid needs row identity for edit handles
+		return "", fmt.Errorf("create_table needs a primary key")
+	}
+
+	t := &table{schema: ch.Schema, name: ch.Table}
+	parts := make([]string, 0, len(ch.Columns)+1)
+	for _, c := range ch.Columns {
+		colSQL, err := columnSQL(c)
+		if err != nil {
+			return "", err
+		}
+		if t.hasCol(c.Name) {
+			return "", fmt.Errorf("duplicate column %s", c.Name)
+		}
+		t.cols = append(t.cols, c.Name)
+		parts = append(parts, colSQL)
+	}
+	for _, pk := range ch.PrimaryKey {
+		if !t.hasCol(pk) {
+			return "", fmt.Errorf("primary key column %s is not defined", pk)
+		}
+	}
+	t.pk = append([]string(nil), ch.PrimaryKey...)
+	t.uniques = [][]string{t.pk}
+	parts = append(parts, "PRIMARY KEY ("+quoteList(ch.PrimaryKey)+")")
+
+	m.tables[key] = t
+	return fmt.Sprintf("CREATE TABLE %s (%s)", rel, strings.Join(parts, ", ")), nil
+}
+
+func (m *model) addForeignKey(ch Change, key, rel string, t *table) (string, error) {
+	fk := ch.ForeignKey
+	if fk == nil {
+		return "", fmt.Errorf("add_foreign_key needs foreignKey")
+	}
+	if len(fk.Columns) == 0 || len(fk.Columns) != len(fk.RefColumns) {
+		return "", fmt.Errorf("foreign key needs matching columns and refColumns")
+	}
+	for _, c := range fk.Columns {
+		if err := m.requireCol(t, c); err != nil {
+			return "", err
+		}
+	}
+	refSchema := fk.RefSchema
+	if refSchema == "" {
+		refSchema = ch.Schema
+	}
+	if err := checkIdent("refSchema", refSchema); err != nil {
+		return "", err
+	}
+	refKey := refSchema + "." + fk.RefTable
+	ref, ok := m.tables[refKey]
+	if !ok {
+		return "", fmt.Errorf("referenced table %s does not exist", refKey)
+	}
+	for _, c := range fk.RefColumns {
+		if !ref.hasCol(c) {
+			return "", fmt.Errorf("referenced column %s.%s does not exist", refKey, c)
+		}
+	}
+	if !hasUniqueOn(ref, fk.RefColumns) {
+		return "", fmt.Errorf("referenced columns (%s) are not a primary key or unique index", strings.Join(fk.RefColumns, ", "))
+	}
+
+	name := fk.Name
+	if name == "" {
+		name = fmt.Sprintf("%s_%s_fkey", ch.Table, strings.Join(fk.Columns, "_"))
+	}
+	if err := checkIdent("constraint name", name); err != nil {
+		return "", err
+	}
+
+	onDelete := ""
+	if fk.OnDelete != "" {
+		action := strings.ToUpper(strings.TrimSpace(fk.OnDelete))
+		switch action {
+		case "NO ACTION", "RESTRICT", "CASCADE", "SET NULL", "SET DEFAULT":
+			onDelete = " ON DELETE " + action
+		default:
+			return "", fmt.Errorf("invalid onDelete %q", fk.OnDelete)
+		}
+	}
+
+	m.fks = append(m.fks, fkRef{name: name, from: key, cols: fk.Columns, to: refKey, refCols: fk.RefColumns})
+	return fmt.Sprintf("ALTER TABLE %s ADD CONSTRAINT %s FOREIGN KEY (%s) REFERENCES %s (%s)%s",
+		rel, pq.QuoteIdentifier(name), quoteList(fk.Columns),
+		qualified(refSchema, fk.RefTable), quoteList(fk.RefColumns), onDelete), nil
+}
+
+func (m *model) requireCol(t *table, col string) error {
+	if err := checkIdent("column", col); err != nil {
+		return err
+	}
+	if !t.hasCol(col) {
+		return fmt.Errorf("column %s does not exist on %s.%s", col, t.schema, t.name)
+	}
+	return nil
+}
+
+// referencing lists FKs from other tables pointing at key (only those covering
+// col, when col is set). FKs defined on the table itself are dropped along with
+// the column by Postgres, so they don't block anything.
+func (m *model) referencing(key, col string) []string {
+	var out []string
+	for _, fk := range m.fks {
+		if fk.to != key || fk.from == key {
+			continue
+		}
+		if col == "" || indexOf(fk.refCols, col) >= 0 {
+			out = append(out, fk.name)
+		}
+	}
+	return out
+}
+
+func (m *model) renameCol(t *table, key, from, to string) {
+	replace := func(xs []string) {
+		for i, x := range xs {
+			if x == from {
+				xs[i] = to
+			}
+		}
+	}
+	replace(t.cols)
+	replace(t.pk)
+	for _, u := range t.uniques {
+		replace(u)
+	}
+	for i := range m.fks {
+		if m.fks[i].from == key {
+			replace(m.fks[i].cols)
+		}
+		if m.fks[i].to == key {
+			replace(m.fks[i].refCols)
+		}
+	}
+}
+
+// --- SQL helpers ---
+
+var (
+	identRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_$]*$`)
+	// type names like "text", "varchar(40)", "numeric(10, 2)", "public.mood", "int[]",
+	// or one of the SQL-standard names that take several words. Only those are
+	// let through with spaces, so constraints ("int references film",
+	// "text not null") can't ride along in the type.
+	typeRe = regexp.MustCompile(`^(` +
+		`[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?` + typeMod +
+		`|(?i:double precision|character varying|char varying|bit varying|national character varying|national character|national char)` + typeMod +
+		`|(?i:timestamp|time)(\(\d+\))?(?i: with time zone| without time zone)` +
+		`)(\[\])*$`)
+)
+
+// typeMod is an optional type modifier, e.g. "(40)" or "(10, 2)".
+const typeMod = `(\(\d+(,\s*\d+)?\))?`
+
+// checkIdent keeps identifiers to plain names; they're quoted anyway, but
+// rejecting oddities early gives better errors than Postgres would.
+func checkIdent(what, s string) error {
+	if s == "" {
+		return fmt.Errorf("%s is required", what)
+	}
+	if len(s) > 63 || !identRe.MatchString(s) {
+		return fmt.Errorf("invalid %s %q", what, s)
+	}
+	return nil
+}
+
+// checkType validates a type name. Types are spliced into SQL unquoted, so this
+// is what stands between the request body and injection.
+func checkType(s string) error {
+	if s == "" {
+		return fmt.Errorf("type is required")
+	}
+	if !typeRe.MatchString(s) {
+		return fmt.Errorf("invalid type %q", s)
+	}
+	return nil
+}
+
+func columnSQL(c ColumnDef) (string, error) {
+	if err := checkIdent("column", c.Name); err != nil {
+		return "", err
+	}
+	if err := checkType(c.Type); err != nil {
+		return "", err
+	}
+	out := pq.QuoteIdentifier(c.Name) + " " + c.Type
+	if c.NotNull {
+		out += " NOT NULL"
+	}
+	if c.Default != nil {
+		out += " DEFAULT " + pq.QuoteLiteral(*c.Default)
+	}
+	return out, nil
+}
+
+func qualified(schema, table string) string {
+	return pq.QuoteIdentifier(schema) + "." + pq.QuoteIdentifier(table)
+}
+
+func quoteList(cols []string) string {
+	q := make([]string, len(cols))
+	for i, c := range cols {
+		q[i] = pq.QuoteIdentifier(c)
+	}
+	return strings.Join(q, ", ")
+}
+
+func cascadeSQL(cascade bool) string {
+	if cascade {
+		return " CASCADE"
+	}
+	return ""
+}
+
+func hasUniqueOn(t *table, cols []string) bool {
+	for _, u := range t.uniques {
+		if sameSet(u, cols) {
+			return true
+		}
+	}
+	return false
+}
+
+func sameSet(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for _, x := range a {
+		if indexOf(b, x) < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func indexOf(xs []string, s string) int {
+	for i, x := range xs {
+		if x == s {
+			return i
+		}
+	}
+	return -1
+}
+
+func removeStr(xs []string, s string) []string {
+	out := xs[:0]
+	for _, x := range xs {
+		if x != s {
+			out = append(out, x)
+		}
+	}
+	return out
+}
@@ -0,0 +1,218 @@
+package ddl
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	rc "github.com/zoravur/postgres-spreadsheet-view/server/pkg/richcatalog"
+)
+
+func strp(s string) *string { return &s }
+
+func demoSnapshot() rc.Snapshot {
+	return rc.Snapshot{Schemas: []rc.Schema{{
+		Name: "public",
+		Tables: []rc.Table{
+			{
+				Schema: "public", Name: "film",
+				Columns: []rc.Column{{Name: "film_id"}, {Name: "title"}, {Name: "language_id"}},
+				PK:      []string{"film_id"},
+				FKs: []rc.FK{{
+					Name: "film_language_id_fkey", Columns: []string{"language_id"},
+					RefSchema: "public", RefTable: "language", RefColumns: []string{"language_id"},
+				}},
+			},
+			{
+				Schema: "public", Name: "language",
+				Columns: []rc.Column{{Name: "language_id"}, {Name: "name"}},
+				PK:      []string{"language_id"},
+			},
+		},
+	}}}
+}
+
+func TestPlan(t *testing.T) {
+	cases := []struct {
+		name    string
+		changes []Change
+		want    []string
+		wantErr string
+	}{
+		{
+			name:    "add column",
+			changes: []Change{{Op: OpAddColumn, Table: "film", Column: "notes", Type: "text"}},
+			want:    []string{`ALTER TABLE "public"."film" ADD COLUMN "notes" text`},
+		},
+		{
+			name:    "add not null column with default",
+			changes: []Change{{Op: OpAddColumn, Table: "film", Column: "rating", Type: "varchar(5)", NotNull: true, Default: strp("G")}},
+			want:    []string{`ALTER TABLE "public"."film" ADD COLUMN "rating" varchar(5) NOT NULL DEFAULT 'G'`},
+		},
+		{
+			name:    "add not null column without default",
+			changes: []Change{{Op: OpAddColumn, Table: "film", Column: "rating", Type: "text", NotNull: true}},
+			wantErr: "needs a default",
+		},
+		{
+			name:    "add existing column",
+			changes: []Change{{Op: OpAddColumn, Table: "film", Column: "title", Type: "text"}},
+			wantErr: "already exists",
+		},
+		{
+			name:    "type injection",
+			changes: []Change{{Op: OpAddColumn, Table: "film", Column: "x", Type: "text; DROP TABLE film"}},
+			wantErr: "invalid type",
+		},
+		{
+			name:    "constraint in type",
+			changes: []Change{{Op: OpAddColumn, Table: "film", Column: "x", Type: "int references film"}},
+			wantErr: "invalid type",
+		},
+		{
+			name:    "not null in type",
+			changes: []Change{{Op: OpAddColumn, Table: "film", Column: "x", Type: "text not null"}},
+			wantErr: "invalid type",
+		},
+		{
+			name:    "unique in type",
+			changes: []Change{{Op: OpAddColumn, Table: "film", Column: "x", Type: "int unique"}},
+			wantErr: "invalid type",
+		},
+		{
+			name:    "constraint in altered type",
+			changes: []Change{{Op: OpAlterType, Table: "film", Column: "title", Type: "text collate \"C\""}},
+			wantErr: "invalid type",
+		},
+		{
+			name:    "multi-word type",
+			changes: []Change{{Op: OpAddColumn, Table: "film", Column: "x", Type: "double precision"}},
+			want:    []string{`ALTER TABLE "public"."film" ADD COLUMN "x" double precision`},
+		},
+		{
+			name:    "multi-word type with modifier",
+			changes: []Change{{Op: OpAddColumn, Table: "film", Column: "x", Type: "character varying(40)[]"}},
+			want:    []string{`ALTER TABLE "public"."film" ADD COLUMN "x" character varying(40)[]`},
+		},
+		{
+			name:    "timestamp with time zone",
+			changes: []Change{{Op: OpAddColumn, Table: "film", Column: "x", Type: "timestamp(3) with time zone"}},
+			want:    []string{`ALTER TABLE "public"."film" ADD COLUMN "x" timestamp(3) with time zone`},
+		},
+		{
+			name:    "rename column",
+			changes: []Change{{Op: OpRenameColumn, Table: "film", Column: "title", NewName: "name"}},
+			want:    []string{`ALTER TABLE "public"."film" RENAME COLUMN "title" TO "name"`},
+		},
+		{
+			name:    "drop pk column",
+			changes: []Change{{Op: OpDropColumn, Table: "film", Column: "film_id"}},
+			wantErr: "part of the primary key",
+		},
+		{
+			name:    "drop referenced table needs cascade",
+			changes: []Change{{Op: OpDropTable, Table: "language"}},
+			wantErr: "film_language_id_fkey",
+		},
+		{
+			name:    "drop referenced table with cascade",
+			changes: []Change{{Op: OpDropTable, Table: "language", Cascade: true}},
+			want:    []string{`DROP TABLE "public"."language" CASCADE`},
+		},
+		{
+			name:    "alter type",
+			changes: []Change{{Op: OpAlterType, Table: "film", Column: "title", Type: "varchar(255)"}},
+			want:    []string{`ALTER TABLE "public"."film" ALTER COLUMN "title" TYPE varchar(255) USING "title"::varchar(255)`},
+		},
+		{
+			name: "create table then reference it",
+			changes: []Change{
+				{Op: OpCreateTable, Table: "genre", Columns: []ColumnDef{
+					{Name: "genre_id", Type: "integer", NotNull: true},
+					{Name: "label", Type: "text"},
+				}, PrimaryKey: []string{"genre_id"}},
+				{Op: OpAddColumn, Table: "film", Column: "genre_id", Type: "integer"},
+				{Op: OpAddForeignKey, Table: "film", ForeignKey: &ForeignKeyDef{
+					Columns: []string{"genre_id"}, RefTable: "genre", RefColumns: []string{"genre_id"}, OnDelete: "set null",
+				}},
+			},
+			want: []string{
+				`CREATE TABLE "public"."genre" ("genre_id" integer NOT NULL, "label" text, PRIMARY KEY ("genre_id"))`,
+				`ALTER TABLE "public"."film" ADD COLUMN "genre_id" integer`,
+				`ALTER TABLE "public"."film" ADD CONSTRAINT "film_genre_id_fkey" FOREIGN KEY ("genre_id") REFERENCES "public"."genre" ("genre_id") ON DELETE SET NULL`,
+			},
+		},
+		{
+			name: "create table without pk",
+			changes: []Change{
+				{Op: OpCreateTable, Table: "genre", Columns: []ColumnDef{{Name: "label", Type: "text"}}},
+			},
+			wantErr: "needs a primary key",
+		},
+		{
+			name: "fk to non-unique column",
+			changes: []Change{{Op: OpAddForeignKey, Table: "film", ForeignKey: &ForeignKeyDef{
+				Columns: []string{"title"}, RefTable: "language", RefColumns: []string{"name"},
+			}}},
+			wantErr: "not a primary key or unique index",
+		},
+		{
+			name: "rename table then use new name",
+			changes: []Change{
+				{Op: OpRenameTable, Table: "language", NewName: "lang"},
+				{Op: OpAddColumn, Table: "lang", Column: "iso", Type: "char(2)"},
+			},
+			want: []string{
+				`ALTER TABLE "public"."language" RENAME TO "lang"`,
+				`ALTER TABLE "public"."lang" ADD COLUMN "iso" char(2)`,
+			},
+		},
+		{
+			name:    "unknown table",
+			changes: []Change{{Op: OpDropColumn, Table: "nope", Column: "x"}},
+			wantErr: "does not exist",
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := Plan(demoSnapshot(), c.changes)
+			if c.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), c.wantErr) {
+					t.Fatalf("expected error containing %q, got %v", c.wantErr, err)
+				}
+				var ve *ValidationError
+				if !errors.As(err, &ve) {
+					t.Fatalf("expected *ValidationError, got %T", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Fatalf("statements mismatch\nexpected: %q\ngot:      %q", c.want, got)
+			}
+		})
+	}
+}
+
+func TestDestructive(t *testing.T) {
+	cases := []struct {
+		ch   Change
+		want bool
+	}{
+		{Change{Op: OpDropTable, Table: "film"}, true},
+		{Change{Op: OpDropColumn, Table: "film", Column: "title"}, true},
+		{Change{Op: OpDropColumn, Table: "film", Column: "title", Cascade: true}, true},
+		{Change{Op: OpAddColumn, Table: "film", Column: "notes", Type: "text"}, false},
+		{Change{Op: OpRenameTable, Table: "film", NewName: "movie"}, false},
+		{Change{Op: OpAlterType, Table: "film", Column: "title", Type: "text"}, false},
+	}
+	for _, c := range cases {
+		if got := c.ch.Destructive(); got != c.want {
+			t.Errorf("%s %s.%s: Destructive = %v, want %v", c.ch.Op, c.ch.Table, c.ch.Column, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,7 @@
+// Package livequerypb holds the gRPC service for live queries and edits
+// (livequery.proto) and its generated Go code. The server implements it in
+// internal/api; clients dial the server's gRPC address and use
+// NewLiveQueryClient.
+package livequerypb
+
+//go:generate protoc -I . --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative livequery.proto
@@ -0,0 +1,1529 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.10
+// 	protoc        (unknown)
+// source: livequery.proto
+
+// The gRPC API for live queries and edits. It mirrors the WebSocket protocol
+// (internal/protocol) for backend services: the same live queries, row ops and
+// edit handles, without the browser-oriented parts (presence, locks, comments).
+//
+// The Go code is generated; see doc.go.
+
+package livequerypb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	structpb "google.golang.org/protobuf/types/known/structpb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// Cell is one value of a result row and the handle that edits it.
+type Cell struct {
+	state      protoimpl.MessageState `protogen:"open.v1"`
+	EditHandle string                 `protobuf:"bytes,1,opt,name=edit_handle,json=editHandle,proto3" json:"edit_handle,omitempty"`
+	Value      *structpb.Value        `protobuf:"bytes,2,opt,name=value,proto3" json:"value,omitempty"`
+	// comments counts the cell's comment thread; set by Query only.
+	Comments      int32 `protobuf:"varint,3,opt,name=comments,proto3" json:"comments,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Cell) Reset() {
+	*x = Cell{}
+	mi := &file_livequery_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Cell) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Cell) ProtoMessage() {}
+
+func (x *Cell) ProtoReflect() protoreflect.Message {
+	mi := &file_livequery_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Cell.ProtoReflect.Descriptor instead.
+func (*Cell) Descriptor() ([]byte, []int) {
+	return file_livequery_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *Cell) GetEditHandle() string {
+	if x != nil {
+		return x.EditHandle
+	}
+	return ""
+}
+
+func (x *Cell) GetValue() *structpb.Value {
+	if x != nil {
+		return x.Value
+	}
+	return nil
+}
+
+func (x *Cell) GetComments() int32 {
+	if x != nil {
+		return x.Comments
+	}
+	return 0
+}
+
+// Row maps output columns to cells.
+type Row struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Cells         map[string]*Cell       `protobuf:"bytes,1,rep,name=cells,proto3" json:"cells,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Row) Reset() {
+	*x = Row{}
+	mi := &file_livequery_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Row) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Row) ProtoMessage() {}
+
+func (x *Row) ProtoReflect() protoreflect.Message {
+	mi := &file_livequery_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Row.ProtoReflect.Descriptor instead.
+func (*Row) Descriptor() ([]byte, []int) {
+	return file_livequery_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Row) GetCells() map[string]*Cell {
+	if x != nil {
+		return x.Cells
+	}
+	return nil
+}
+
+type QueryRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Sql           string                 `protobuf:"bytes,1,opt,name=sql,proto3" json:"sql,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *QueryRequest) Reset() {
+	*x = QueryRequest{}
+	mi := &file_livequery_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *QueryRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*QueryRequest) ProtoMessage() {}
+
+func (x *QueryRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_livequery_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use QueryRequest.ProtoReflect.Descriptor instead.
+func (*QueryRequest) Descriptor() ([]byte, []int) {
+	return file_livequery_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *QueryRequest) GetSql() string {
+	if x != nil {
+		return x.Sql
+	}
+	return ""
+}
+
+type QueryResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Rows          []*Row                 `protobuf:"bytes,1,rep,name=rows,proto3" json:"rows,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *QueryResponse) Reset() {
+	*x = QueryResponse{}
+	mi := &file_livequery_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *QueryResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*QueryResponse) ProtoMessage() {}
+
+func (x *QueryResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_livequery_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use QueryResponse.ProtoReflect.Descriptor instead.
+func (*QueryResponse) Descriptor() ([]byte, []int) {
+	return file_livequery_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *QueryResponse) GetRows() []*Row {
+	if x != nil {
+		return x.Rows
+	}
+	return nil
+}
+
+// Position is a point in a live query's stream of updates and reloads.
+type Position struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"` // live query
+	Seq           uint64                 `protobuf:"varint,2,opt,name=seq,proto3" json:"seq,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Position) Reset() {
+	*x = Position{}
+	mi := &file_livequery_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Position) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Position) ProtoMessage() {}
+
+func (x *Position) ProtoReflect() protoreflect.Message {
+	mi := &file_livequery_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Position.ProtoReflect.Descriptor instead.
+func (*Position) Descriptor() ([]byte, []int) {
+	return file_livequery_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *Position) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *Position) GetSeq() uint64 {
+	if x != nil {
+		return x.Seq
+	}
+	return 0
+}
+
+type SubscribeRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	Sql   string                 `protobuf:"bytes,1,opt,name=sql,proto3" json:"sql,omitempty"`
+	// strategy overrides the refresh strategy: pk_pushdown | full_requery |
+	// incremental_aggregate | poll. Only the classified strategy or a more
+	// conservative one (full_requery, poll) is accepted.
+	Strategy string `protobuf:"bytes,2,opt,name=strategy,proto3" json:"strategy,omitempty"`
+	// since resumes after a dropped stream: the live query and the seq of the
+	// last Update or reload applied.
+	Since         *Position `protobuf:"bytes,3,opt,name=since,proto3" json:"since,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SubscribeRequest) Reset() {
+	*x = SubscribeRequest{}
+	mi := &file_livequery_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SubscribeRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SubscribeRequest) ProtoMessage() {}
+
+func (x *SubscribeRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_livequery_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SubscribeRequest.ProtoReflect.Descriptor instead.
+func (*SubscribeRequest) Descriptor() ([]byte, []int) {
+	return file_livequery_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *SubscribeRequest) GetSql() string {
+	if x != nil {
+		return x.Sql
+	}
+	return ""
+}
+
+func (x *SubscribeRequest) GetStrategy() string {
+	if x != nil {
+		return x.Strategy
+	}
+	return ""
+}
+
+func (x *SubscribeRequest) GetSince() *Position {
+	if x != nil {
+		return x.Since
+	}
+	return nil
+}
+
+// Snapshot is a live query's whole result, read under one database snapshot
+// at lsn and current as of seq.
+type Snapshot struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Seq           uint64                 `protobuf:"varint,2,opt,name=seq,proto3" json:"seq,omitempty"`
+	Lsn           string                 `protobuf:"bytes,3,opt,name=lsn,proto3" json:"lsn,omitempty"`
+	Rows          []*Row                 `protobuf:"bytes,4,rep,name=rows,proto3" json:"rows,omitempty"`
+	Keys          []string               `protobuf:"bytes,5,rep,name=keys,proto3" json:"keys,omitempty"` // row identities, parallel to rows
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Snapshot) Reset() {
+	*x = Snapshot{}
+	mi := &file_livequery_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Snapshot) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Snapshot) ProtoMessage() {}
+
+func (x *Snapshot) ProtoReflect() protoreflect.Message {
+	mi := &file_livequery_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Snapshot.ProtoReflect.Descriptor instead.
+func (*Snapshot) Descriptor() ([]byte, []int) {
+	return file_livequery_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *Snapshot) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *Snapshot) GetSeq() uint64 {
+	if x != nil {
+		return x.Seq
+	}
+	return 0
+}
+
+func (x *Snapshot) GetLsn() string {
+	if x != nil {
+		return x.Lsn
+	}
+	return ""
+}
+
+func (x *Snapshot) GetRows() []*Row {
+	if x != nil {
+		return x.Rows
+	}
+	return nil
+}
+
+func (x *Snapshot) GetKeys() []string {
+	if x != nil {
+		return x.Keys
+	}
+	return nil
+}
+
+type Columns struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Names         []string               `protobuf:"bytes,1,rep,name=names,proto3" json:"names,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Columns) Reset() {
+	*x = Columns{}
+	mi := &file_livequery_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Columns) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Columns) ProtoMessage() {}
+
+func (x *Columns) ProtoReflect() protoreflect.Message {
+	mi := &file_livequery_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Columns.ProtoReflect.Descriptor instead.
+func (*Columns) Descriptor() ([]byte, []int) {
+	return file_livequery_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *Columns) GetNames() []string {
+	if x != nil {
+		return x.Names
+	}
+	return nil
+}
+
+// Subscribed starts every stream. A resumed stream has no rows and is followed
+// by the updates after since.
+type Subscribed struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Snapshot      *Snapshot              `protobuf:"bytes,1,opt,name=snapshot,proto3" json:"snapshot,omitempty"`
+	Resumed       bool                   `protobuf:"varint,2,opt,name=resumed,proto3" json:"resumed,omitempty"`
+	Tables        []string               `protobuf:"bytes,3,rep,name=tables,proto3" json:"tables,omitempty"`
+	PkCols        map[string]*Columns    `protobuf:"bytes,4,rep,name=pk_cols,json=pkCols,proto3" json:"pk_cols,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	Strategy      string                 `protobuf:"bytes,5,opt,name=strategy,proto3" json:"strategy,omitempty"`
+	Rewrote       string                 `protobuf:"bytes,6,opt,name=rewrote,proto3" json:"rewrote,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Subscribed) Reset() {
+	*x = Subscribed{}
+	mi := &file_livequery_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Subscribed) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Subscribed) ProtoMessage() {}
+
+func (x *Subscribed) ProtoReflect() protoreflect.Message {
+	mi := &file_livequery_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Subscribed.ProtoReflect.Descriptor instead.
+func (*Subscribed) Descriptor() ([]byte, []int) {
+	return file_livequery_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *Subscribed) GetSnapshot() *Snapshot {
+	if x != nil {
+		return x.Snapshot
+	}
+	return nil
+}
+
+func (x *Subscribed) GetResumed() bool {
+	if x != nil {
+		return x.Resumed
+	}
+	return false
+}
+
+func (x *Subscribed) GetTables() []string {
+	if x != nil {
+		return x.Tables
+	}
+	return nil
+}
+
+func (x *Subscribed) GetPkCols() map[string]*Columns {
+	if x != nil {
+		return x.PkCols
+	}
+	return nil
+}
+
+func (x *Subscribed) GetStrategy() string {
+	if x != nil {
+		return x.Strategy
+	}
+	return ""
+}
+
+func (x *Subscribed) GetRewrote() string {
+	if x != nil {
+		return x.Rewrote
+	}
+	return ""
+}
+
+// RowOp is one row-level change; see protocol.RowOp for how to apply them.
+type RowOp struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Op            string                 `protobuf:"bytes,1,opt,name=op,proto3" json:"op,omitempty"` // insert | update | delete | move
+	Key           string                 `protobuf:"bytes,2,opt,name=key,proto3" json:"key,omitempty"`
+	Index         *int32                 `protobuf:"varint,3,opt,name=index,proto3,oneof" json:"index,omitempty"`
+	Row           *Row                   `protobuf:"bytes,4,opt,name=row,proto3" json:"row,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RowOp) Reset() {
+	*x = RowOp{}
+	mi := &file_livequery_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RowOp) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RowOp) ProtoMessage() {}
+
+func (x *RowOp) ProtoReflect() protoreflect.Message {
+	mi := &file_livequery_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RowOp.ProtoReflect.Descriptor instead.
+func (*RowOp) Descriptor() ([]byte, []int) {
+	return file_livequery_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *RowOp) GetOp() string {
+	if x != nil {
+		return x.Op
+	}
+	return ""
+}
+
+func (x *RowOp) GetKey() string {
+	if x != nil {
+		return x.Key
+	}
+	return ""
+}
+
+func (x *RowOp) GetIndex() int32 {
+	if x != nil && x.Index != nil {
+		return *x.Index
+	}
+	return 0
+}
+
+func (x *RowOp) GetRow() *Row {
+	if x != nil {
+		return x.Row
+	}
+	return nil
+}
+
+// Update carries the row ops of one refresh, tagged with the newest commit it
+// reflects and every commit folded into it.
+type Update struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Seq           uint64                 `protobuf:"varint,2,opt,name=seq,proto3" json:"seq,omitempty"`
+	Lsn           string                 `protobuf:"bytes,3,opt,name=lsn,proto3" json:"lsn,omitempty"`
+	Xid           int64                  `protobuf:"varint,4,opt,name=xid,proto3" json:"xid,omitempty"`
+	Xids          []int64                `protobuf:"varint,5,rep,packed,name=xids,proto3" json:"xids,omitempty"`
+	Ops           []*RowOp               `protobuf:"bytes,6,rep,name=ops,proto3" json:"ops,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Update) Reset() {
+	*x = Update{}
+	mi := &file_livequery_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Update) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Update) ProtoMessage() {}
+
+func (x *Update) ProtoReflect() protoreflect.Message {
+	mi := &file_livequery_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Update.ProtoReflect.Descriptor instead.
+func (*Update) Descriptor() ([]byte, []int) {
+	return file_livequery_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *Update) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *Update) GetSeq() uint64 {
+	if x != nil {
+		return x.Seq
+	}
+	return 0
+}
+
+func (x *Update) GetLsn() string {
+	if x != nil {
+		return x.Lsn
+	}
+	return ""
+}
+
+func (x *Update) GetXid() int64 {
+	if x != nil {
+		return x.Xid
+	}
+	return 0
+}
+
+func (x *Update) GetXids() []int64 {
+	if x != nil {
+		return x.Xids
+	}
+	return nil
+}
+
+func (x *Update) GetOps() []*RowOp {
+	if x != nil {
+		return x.Ops
+	}
+	return nil
+}
+
+// SchemaChanged says the query was re-analyzed after a schema change; a reload
+// follows.
+type SchemaChanged struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Tables        []string               `protobuf:"bytes,2,rep,name=tables,proto3" json:"tables,omitempty"`
+	PkCols        map[string]*Columns    `protobuf:"bytes,3,rep,name=pk_cols,json=pkCols,proto3" json:"pk_cols,omitempty" protobuf_key:"bytes,1,opt,name=key" protobuf_val:"bytes,2,opt,name=value"`
+	Changes       []*structpb.Struct     `protobuf:"bytes,4,rep,name=changes,proto3" json:"changes,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SchemaChanged) Reset() {
+	*x = SchemaChanged{}
+	mi := &file_livequery_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SchemaChanged) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SchemaChanged) ProtoMessage() {}
+
+func (x *SchemaChanged) ProtoReflect() protoreflect.Message {
+	mi := &file_livequery_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SchemaChanged.ProtoReflect.Descriptor instead.
+func (*SchemaChanged) Descriptor() ([]byte, []int) {
+	return file_livequery_proto_rawDescGZIP(), []int{11}
+}
+
+func (x *SchemaChanged) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *SchemaChanged) GetTables() []string {
+	if x != nil {
+		return x.Tables
+	}
+	return nil
+}
+
+func (x *SchemaChanged) GetPkCols() map[string]*Columns {
+	if x != nil {
+		return x.PkCols
+	}
+	return nil
+}
+
+func (x *SchemaChanged) GetChanges() []*structpb.Struct {
+	if x != nil {
+		return x.Changes
+	}
+	return nil
+}
+
+// Error is a live query that stopped working; the stream stays open in case a
+// later schema change fixes it.
+type Error struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Code          string                 `protobuf:"bytes,1,opt,name=code,proto3" json:"code,omitempty"` // protocol error code, e.g. query_failed
+	Message       string                 `protobuf:"bytes,2,opt,name=message,proto3" json:"message,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Error) Reset() {
+	*x = Error{}
+	mi := &file_livequery_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Error) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Error) ProtoMessage() {}
+
+func (x *Error) ProtoReflect() protoreflect.Message {
+	mi := &file_livequery_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Error.ProtoReflect.Descriptor instead.
+func (*Error) Descriptor() ([]byte, []int) {
+	return file_livequery_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *Error) GetCode() string {
+	if x != nil {
+		return x.Code
+	}
+	return ""
+}
+
+func (x *Error) GetMessage() string {
+	if x != nil {
+		return x.Message
+	}
+	return ""
+}
+
+type SubscribeEvent struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// Types that are valid to be assigned to Event:
+	//
+	//	*SubscribeEvent_Subscribed
+	//	*SubscribeEvent_Update
+	//	*SubscribeEvent_Reload
+	//	*SubscribeEvent_SchemaChanged
+	//	*SubscribeEvent_Error
+	Event         isSubscribeEvent_Event `protobuf_oneof:"event"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SubscribeEvent) Reset() {
+	*x = SubscribeEvent{}
+	mi := &file_livequery_proto_msgTypes[13]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SubscribeEvent) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SubscribeEvent) ProtoMessage() {}
+
+func (x *SubscribeEvent) ProtoReflect() protoreflect.Message {
+	mi := &file_livequery_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SubscribeEvent.ProtoReflect.Descriptor instead.
+func (*SubscribeEvent) Descriptor() ([]byte, []int) {
+	return file_livequery_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *SubscribeEvent) GetEvent() isSubscribeEvent_Event {
+	if x != nil {
+		return x.Event
+	}
+	return nil
+}
+
+func (x *SubscribeEvent) GetSubscribed() *Subscribed {
+	if x != nil {
+		if x, ok := x.Event.(*SubscribeEvent_Subscribed); ok {
+			return x.Subscribed
+		}
+	}
+	return nil
+}
+
+func (x *SubscribeEvent) GetUpdate() *Update {
+	if x != nil {
+		if x, ok := x.Event.(*SubscribeEvent_Update); ok {
+			return x.Update
+		}
+	}
+	return nil
+}
+
+func (x *SubscribeEvent) GetReload() *Snapshot {
+	if x != nil {
+		if x, ok := x.Event.(*SubscribeEvent_Reload); ok {
+			return x.Reload
+		}
+	}
+	return nil
+}
+
+func (x *SubscribeEvent) GetSchemaChanged() *SchemaChanged {
+	if x != nil {
+		if x, ok := x.Event.(*SubscribeEvent_SchemaChanged); ok {
+			return x.SchemaChanged
+		}
+	}
+	return nil
+}
+
+func (x *SubscribeEvent) GetError() *Error {
+	if x != nil {
+		if x, ok := x.Event.(*SubscribeEvent_Error); ok {
+			return x.Error
+		}
+	}
+	return nil
+}
+
+type isSubscribeEvent_Event interface {
+	isSubscribeEvent_Event()
+}
+
+type SubscribeEvent_Subscribed struct {
+	Subscribed *Subscribed `protobuf:"bytes,1,opt,name=subscribed,proto3,oneof"`
+}
+
+type SubscribeEvent_Update struct {
+	Update *Update `protobuf:"bytes,2,opt,name=update,proto3,oneof"`
+}
+
+type SubscribeEvent_Reload struct {
+	Reload *Snapshot `protobuf:"bytes,3,opt,name=reload,proto3,oneof"`
+}
+
+type SubscribeEvent_SchemaChanged struct {
+	SchemaChanged *SchemaChanged `protobuf:"bytes,4,opt,name=schema_changed,json=schemaChanged,proto3,oneof"`
+}
+
+type SubscribeEvent_Error struct {
+	Error *Error `protobuf:"bytes,5,opt,name=error,proto3,oneof"`
+}
+
+func (*SubscribeEvent_Subscribed) isSubscribeEvent_Event() {}
+
+func (*SubscribeEvent_Update) isSubscribeEvent_Event() {}
+
+func (*SubscribeEvent_Reload) isSubscribeEvent_Event() {}
+
+func (*SubscribeEvent_SchemaChanged) isSubscribeEvent_Event() {}
+
+func (*SubscribeEvent_Error) isSubscribeEvent_Event() {}
+
+type EditRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	EditHandle    string                 `protobuf:"bytes,1,opt,name=edit_handle,json=editHandle,proto3" json:"edit_handle,omitempty"`
+	Column        string                 `protobuf:"bytes,2,opt,name=column,proto3" json:"column,omitempty"`
+	Value         *structpb.Value        `protobuf:"bytes,3,opt,name=value,proto3" json:"value,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *EditRequest) Reset() {
+	*x = EditRequest{}
+	mi := &file_livequery_proto_msgTypes[14]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *EditRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*EditRequest) ProtoMessage() {}
+
+func (x *EditRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_livequery_proto_msgTypes[14]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use EditRequest.ProtoReflect.Descriptor instead.
+func (*EditRequest) Descriptor() ([]byte, []int) {
+	return file_livequery_proto_rawDescGZIP(), []int{14}
+}
+
+func (x *EditRequest) GetEditHandle() string {
+	if x != nil {
+		return x.EditHandle
+	}
+	return ""
+}
+
+func (x *EditRequest) GetColumn() string {
+	if x != nil {
+		return x.Column
+	}
+	return ""
+}
+
+func (x *EditRequest) GetValue() *structpb.Value {
+	if x != nil {
+		return x.Value
+	}
+	return nil
+}
+
+// EditResponse identifies the transaction an edit committed in; xid matches
+// the xids of the updates it causes.
+type EditResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Xid           int64                  `protobuf:"varint,1,opt,name=xid,proto3" json:"xid,omitempty"`
+	Lsn           string                 `protobuf:"bytes,2,opt,name=lsn,proto3" json:"lsn,omitempty"`
+	Rows          int64                  `protobuf:"varint,3,opt,name=rows,proto3" json:"rows,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *EditResponse) Reset() {
+	*x = EditResponse{}
+	mi := &file_livequery_proto_msgTypes[15]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *EditResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*EditResponse) ProtoMessage() {}
+
+func (x *EditResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_livequery_proto_msgTypes[15]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use EditResponse.ProtoReflect.Descriptor instead.
+func (*EditResponse) Descriptor() ([]byte, []int) {
+	return file_livequery_proto_rawDescGZIP(), []int{15}
+}
+
+func (x *EditResponse) GetXid() int64 {
+	if x != nil {
+		return x.Xid
+	}
+	return 0
+}
+
+func (x *EditResponse) GetLsn() string {
+	if x != nil {
+		return x.Lsn
+	}
+	return ""
+}
+
+func (x *EditResponse) GetRows() int64 {
+	if x != nil {
+		return x.Rows
+	}
+	return 0
+}
+
+type BatchEditRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Edits         []*EditRequest         `protobuf:"bytes,1,rep,name=edits,proto3" json:"edits,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BatchEditRequest) Reset() {
+	*x = BatchEditRequest{}
+	mi := &file_livequery_proto_msgTypes[16]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BatchEditRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BatchEditRequest) ProtoMessage() {}
+
+func (x *BatchEditRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_livequery_proto_msgTypes[16]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BatchEditRequest.ProtoReflect.Descriptor instead.
+func (*BatchEditRequest) Descriptor() ([]byte, []int) {
+	return file_livequery_proto_rawDescGZIP(), []int{16}
+}
+
+func (x *BatchEditRequest) GetEdits() []*EditRequest {
+	if x != nil {
+		return x.Edits
+	}
+	return nil
+}
+
+type BatchEditResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Xid           int64                  `protobuf:"varint,1,opt,name=xid,proto3" json:"xid,omitempty"`
+	Lsn           string                 `protobuf:"bytes,2,opt,name=lsn,proto3" json:"lsn,omitempty"`
+	Rows          []int64                `protobuf:"varint,3,rep,packed,name=rows,proto3" json:"rows,omitempty"` // matched rows, per edit
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BatchEditResponse) Reset() {
+	*x = BatchEditResponse{}
+	mi := &file_livequery_proto_msgTypes[17]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BatchEditResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BatchEditResponse) ProtoMessage() {}
+
+func (x *BatchEditResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_livequery_proto_msgTypes[17]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BatchEditResponse.ProtoReflect.Descriptor instead.
+func (*BatchEditResponse) Descriptor() ([]byte, []int) {
+	return file_livequery_proto_rawDescGZIP(), []int{17}
+}
+
+func (x *BatchEditResponse) GetXid() int64 {
+	if x != nil {
+		return x.Xid
+	}
+	return 0
+}
+
+func (x *BatchEditResponse) GetLsn() string {
+	if x != nil {
+		return x.Lsn
+	}
+	return ""
+}
+
+func (x *BatchEditResponse) GetRows() []int64 {
+	if x != nil {
+		return x.Rows
+	}
+	return nil
+}
+
+type GetCatalogRequest struct {
+	state   protoimpl.MessageState `protogen:"open.v1"`
+	Schemas []string               `protobuf:"bytes,1,rep,name=schemas,proto3" json:"schemas,omitempty"`
+	Tables  []string               `protobuf:"bytes,2,rep,name=tables,proto3" json:"tables,omitempty"`
+	// if_none_match is a checksum the caller has; when it is current the
+	// response has not_modified set and no catalog.
+	IfNoneMatch   string `protobuf:"bytes,3,opt,name=if_none_match,json=ifNoneMatch,proto3" json:"if_none_match,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetCatalogRequest) Reset() {
+	*x = GetCatalogRequest{}
+	mi := &file_livequery_proto_msgTypes[18]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetCatalogRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetCatalogRequest) ProtoMessage() {}
+
+func (x *GetCatalogRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_livequery_proto_msgTypes[18]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetCatalogRequest.ProtoReflect.Descriptor instead.
+func (*GetCatalogRequest) Descriptor() ([]byte, []int) {
+	return file_livequery_proto_rawDescGZIP(), []int{18}
+}
+
+func (x *GetCatalogRequest) GetSchemas() []string {
+	if x != nil {
+		return x.Schemas
+	}
+	return nil
+}
+
+func (x *GetCatalogRequest) GetTables() []string {
+	if x != nil {
+		return x.Tables
+	}
+	return nil
+}
+
+func (x *GetCatalogRequest) GetIfNoneMatch() string {
+	if x != nil {
+		return x.IfNoneMatch
+	}
+	return ""
+}
+
+type GetCatalogResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Checksum      string                 `protobuf:"bytes,1,opt,name=checksum,proto3" json:"checksum,omitempty"`
+	NotModified   bool                   `protobuf:"varint,2,opt,name=not_modified,json=notModified,proto3" json:"not_modified,omitempty"`
+	Catalog       *structpb.Struct       `protobuf:"bytes,3,opt,name=catalog,proto3" json:"catalog,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetCatalogResponse) Reset() {
+	*x = GetCatalogResponse{}
+	mi := &file_livequery_proto_msgTypes[19]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetCatalogResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetCatalogResponse) ProtoMessage() {}
+
+func (x *GetCatalogResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_livequery_proto_msgTypes[19]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetCatalogResponse.ProtoReflect.Descriptor instead.
+func (*GetCatalogResponse) Descriptor() ([]byte, []int) {
+	return file_livequery_proto_rawDescGZIP(), []int{19}
+}
+
+func (x *GetCatalogResponse) GetChecksum() string {
+	if x != nil {
+		return x.Checksum
+	}
+	return ""
+}
+
+func (x *GetCatalogResponse) GetNotModified() bool {
+	if x != nil {
+		return x.NotModified
+	}
+	return false
+}
+
+func (x *GetCatalogResponse) GetCatalog() *structpb.Struct {
+	if x != nil {
+		return x.Catalog
+	}
+	return nil
+}
+
+var File_livequery_proto protoreflect.FileDescriptor
+
+const file_livequery_proto_rawDesc = "" +
+	"\n" +
+	"\x0flivequery.proto\x12\x10psv.livequery.v1\x1a\x1cgoogle/protobuf/struct.proto\"q\n" +
+	"\x04Cell\x12\x1f\n" +
+	"\vedit_handle\x18\x01 \x01(\tR\n" +
+	"editHandle\x12,\n" +
+	"\x05value\x18\x02 \x01(\v2\x16.google.protobuf.ValueR\x05value\x12\x1a\n" +
+	"\bcomments\x18\x03 \x01(\x05R\bcomments\"\x8f\x01\n" +
+	"\x03Row\x126\n" +
+	"\x05cells\x18\x01 \x03(\v2 .psv.livequery.v1.Row.CellsEntryR\x05cells\x1aP\n" +
+	"\n" +
+	"CellsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12,\n" +
+	"\x05value\x18\x02 \x01(\v2\x16.psv.livequery.v1.CellR\x05value:\x028\x01\" \n" +
+	"\fQueryRequest\x12\x10\n" +
+	"\x03sql\x18\x01 \x01(\tR\x03sql\":\n" +
+	"\rQueryResponse\x12)\n" +
+	"\x04rows\x18\x01 \x03(\v2\x15.psv.livequery.v1.RowR\x04rows\",\n" +
+	"\bPosition\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x10\n" +
+	"\x03seq\x18\x02 \x01(\x04R\x03seq\"r\n" +
+	"\x10SubscribeRequest\x12\x10\n" +
+	"\x03sql\x18\x01 \x01(\tR\x03sql\x12\x1a\n" +
+	"\bstrategy\x18\x02 \x01(\tR\bstrategy\x120\n" +
+	"\x05since\x18\x03 \x01(\v2\x1a.psv.livequery.v1.PositionR\x05since\"}\n" +
+	"\bSnapshot\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x10\n" +
+	"\x03seq\x18\x02 \x01(\x04R\x03seq\x12\x10\n" +
+	"\x03lsn\x18\x03 \x01(\tR\x03lsn\x12)\n" +
+	"\x04rows\x18\x04 \x03(\v2\x15.psv.livequery.v1.RowR\x04rows\x12\x12\n" +
+	"\x04keys\x18\x05 \x03(\tR\x04keys\"\x1f\n" +
+	"\aColumns\x12\x14\n" +
+	"\x05names\x18\x01 \x03(\tR\x05names\"\xc5\x02\n" +
+	"\n" +
+	"Subscribed\x126\n" +
+	"\bsnapshot\x18\x01 \x01(\v2\x1a.psv.livequery.v1.SnapshotR\bsnapshot\x12\x18\n" +
+	"\aresumed\x18\x02 \x01(\bR\aresumed\x12\x16\n" +
+	"\x06tables\x18\x03 \x03(\tR\x06tables\x12A\n" +
+	"\apk_cols\x18\x04 \x03(\v2(.psv.livequery.v1.Subscribed.PkColsEntryR\x06pkCols\x12\x1a\n" +
+	"\bstrategy\x18\x05 \x01(\tR\bstrategy\x12\x18\n" +
+	"\arewrote\x18\x06 \x01(\tR\arewrote\x1aT\n" +
+	"\vPkColsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12/\n" +
+	"\x05value\x18\x02 \x01(\v2\x19.psv.livequery.v1.ColumnsR\x05value:\x028\x01\"w\n" +
+	"\x05RowOp\x12\x0e\n" +
+	"\x02op\x18\x01 \x01(\tR\x02op\x12\x10\n" +
+	"\x03key\x18\x02 \x01(\tR\x03key\x12\x19\n" +
+	"\x05index\x18\x03 \x01(\x05H\x00R\x05index\x88\x01\x01\x12'\n" +
+	"\x03row\x18\x04 \x01(\v2\x15.psv.livequery.v1.RowR\x03rowB\b\n" +
+	"\x06_index\"\x8d\x01\n" +
+	"\x06Update\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x10\n" +
+	"\x03seq\x18\x02 \x01(\x04R\x03seq\x12\x10\n" +
+	"\x03lsn\x18\x03 \x01(\tR\x03lsn\x12\x10\n" +
+	"\x03xid\x18\x04 \x01(\x03R\x03xid\x12\x12\n" +
+	"\x04xids\x18\x05 \x03(\x03R\x04xids\x12)\n" +
+	"\x03ops\x18\x06 \x03(\v2\x17.psv.livequery.v1.RowOpR\x03ops\"\x86\x02\n" +
+	"\rSchemaChanged\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x16\n" +
+	"\x06tables\x18\x02 \x03(\tR\x06tables\x12D\n" +
+	"\apk_cols\x18\x03 \x03(\v2+.psv.livequery.v1.SchemaChanged.PkColsEntryR\x06pkCols\x121\n" +
+	"\achanges\x18\x04 \x03(\v2\x17.google.protobuf.StructR\achanges\x1aT\n" +
+	"\vPkColsEntry\x12\x10\n" +
+	"\x03key\x18\x01 \x01(\tR\x03key\x12/\n" +
+	"\x05value\x18\x02 \x01(\v2\x19.psv.livequery.v1.ColumnsR\x05value:\x028\x01\"5\n" +
+	"\x05Error\x12\x12\n" +
+	"\x04code\x18\x01 \x01(\tR\x04code\x12\x18\n" +
+	"\amessage\x18\x02 \x01(\tR\amessage\"\xbe\x02\n" +
+	"\x0eSubscribeEvent\x12>\n" +
+	"\n" +
+	"subscribed\x18\x01 \x01(\v2\x1c.psv.livequery.v1.SubscribedH\x00R\n" +
+	"subscribed\x122\n" +
+	"\x06update\x18\x02 \x01(\v2\x18.psv.livequery.v1.UpdateH\x00R\x06update\x124\n" +
+	"\x06reload\x18\x03 \x01(\v2\x1a.psv.livequery.v1.SnapshotH\x00R\x06reload\x12H\n" +
+	"\x0eschema_changed\x18\x04 \x01(\v2\x1f.psv.livequery.v1.SchemaChangedH\x00R\rschemaChanged\x12/\n" +
+	"\x05error\x18\x05 \x01(\v2\x17.psv.livequery.v1.ErrorH\x00R\x05errorB\a\n" +
+	"\x05event\"t\n" +
+	"\vEditRequest\x12\x1f\n" +
+	"\vedit_handle\x18\x01 \x01(\tR\n" +
+	"editHandle\x12\x16\n" +
+	"\x06column\x18\x02 \x01(\tR\x06column\x12,\n" +
+	"\x05value\x18\x03 \x01(\v2\x16.google.protobuf.ValueR\x05value\"F\n" +
+	"\fEditResponse\x12\x10\n" +
+	"\x03xid\x18\x01 \x01(\x03R\x03xid\x12\x10\n" +
+	"\x03lsn\x18\x02 \x01(\tR\x03lsn\x12\x12\n" +
+	"\x04rows\x18\x03 \x01(\x03R\x04rows\"G\n" +
+	"\x10BatchEditRequest\x123\n" +
+	"\x05edits\x18\x01 \x03(\v2\x1d.psv.livequery.v1.EditRequestR\x05edits\"K\n" +
+	"\x11BatchEditResponse\x12\x10\n" +
+	"\x03xid\x18\x01 \x01(\x03R\x03xid\x12\x10\n" +
+	"\x03lsn\x18\x02 \x01(\tR\x03lsn\x12\x12\n" +
+	"\x04rows\x18\x03 \x03(\x03R\x04rows\"i\n" +
+	"\x11GetCatalogRequest\x12\x18\n" +
+	"\aschemas\x18\x01 \x03(\tR\aschemas\x12\x16\n" +
+	"\x06tables\x18\x02 \x03(\tR\x06tables\x12\"\n" +
+	"\rif_none_match\x18\x03 \x01(\tR\vifNoneMatch\"\x86\x01\n" +
+	"\x12GetCatalogResponse\x12\x1a\n" +
+	"\bchecksum\x18\x01 \x01(\tR\bchecksum\x12!\n" +
+	"\fnot_modified\x18\x02 \x01(\bR\vnotModified\x121\n" +
+	"\acatalog\x18\x03 \x01(\v2\x17.google.protobuf.StructR\acatalog2\xa0\x03\n" +
+	"\tLiveQuery\x12H\n" +
+	"\x05Query\x12\x1e.psv.livequery.v1.QueryRequest\x1a\x1f.psv.livequery.v1.QueryResponse\x12S\n" +
+	"\tSubscribe\x12\".psv.livequery.v1.SubscribeRequest\x1a .psv.livequery.v1.SubscribeEvent0\x01\x12E\n" +
+	"\x04Edit\x12\x1d.psv.livequery.v1.EditRequest\x1a\x1e.psv.livequery.v1.EditResponse\x12T\n" +
+	"\tBatchEdit\x12\".psv.livequery.v1.BatchEditRequest\x1a#.psv.livequery.v1.BatchEditResponse\x12W\n" +
+	"\n" +
+	"GetCatalog\x12#.psv.livequery.v1.GetCatalogRequest\x1a$.psv.livequery.v1.GetCatalogResponseBEZCgithub.com/zoravur/postgres-spreadsheet-view/server/pkg/livequerypbb\x06proto3"
+
+var (
+	file_livequery_proto_rawDescOnce sync.Once
+	file_livequery_proto_rawDescData []byte
+)
+
+func file_livequery_proto_rawDescGZIP() []byte {
+	file_livequery_proto_rawDescOnce.Do(func() {
+		file_livequery_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_livequery_proto_rawDesc), len(file_livequery_proto_rawDesc)))
+	})
+	return file_livequery_proto_rawDescData
+}
+
+var file_livequery_proto_msgTypes = make([]protoimpl.MessageInfo, 23)
+var file_livequery_proto_goTypes = []any{
+	(*Cell)(nil),               // 0: psv.livequery.v1.Cell
+	(*Row)(nil),                // 1: psv.livequery.v1.Row
+	(*QueryRequest)(nil),       // 2: psv.livequery.v1.QueryRequest
+	(*QueryResponse)(nil),      // 3: psv.livequery.v1.QueryResponse
+	(*Position)(nil),           // 4: psv.livequery.v1.Position
+	(*SubscribeRequest)(nil),   // 5: psv.livequery.v1.SubscribeRequest
+	(*Snapshot)(nil),           // 6: psv.livequery.v1.Snapshot
+	(*Columns)(nil),            // 7: psv.livequery.v1.Columns
+	(*Subscribed)(nil),         // 8: psv.livequery.v1.Subscribed
+	(*RowOp)(nil),              // 9: psv.livequery.v1.RowOp
+	(*Update)(nil),             // 10: psv.livequery.v1.Update
+	(*SchemaChanged)(nil),      // 11: psv.livequery.v1.SchemaChanged
+	(*Error)(nil),              // 12: psv.livequery.v1.Error
+	(*SubscribeEvent)(nil),     // 13: psv.livequery.v1.SubscribeEvent
+	(*EditRequest)(nil),        // 14: psv.livequery.v1.EditRequest
+	(*EditResponse)(nil),       // 15: psv.livequery.v1.EditResponse
+	(*BatchEditRequest)(nil),   // 16: psv.livequery.v1.BatchEditRequest
+	(*BatchEditResponse)(nil),  // 17: psv.livequery.v1.BatchEditResponse
+	(*GetCatalogRequest)(nil),  // 18: psv.livequery.v1.GetCatalogRequest
+	(*GetCatalogResponse)(nil), // 19: psv.livequery.v1.GetCatalogResponse
+	nil,                        // 20: psv.livequery.v1.Row.CellsEntry
+	nil,                        // 21: psv.livequery.v1.Subscribed.PkColsEntry
+	nil,                        // 22: psv.livequery.v1.SchemaChanged.PkColsEntry
+	(*structpb.Value)(nil),     // 23: google.protobuf.Value
+	(*structpb.Struct)(nil),    // 24: google.protobuf.Struct
+}
+var file_livequery_proto_depIdxs = []int32{
+	23, // 0: psv.livequery.v1.Cell.value:type_name -> google.protobuf.Value
+	20, // 1: psv.livequery.v1.Row.cells:type_name -> psv.livequery.v1.Row.CellsEntry
+	1,  // 2: psv.livequery.v1.QueryResponse.rows:type_name -> psv.livequery.v1.Row
+	4,  // 3: psv.livequery.v1.SubscribeRequest.since:type_name -> psv.livequery.v1.Position
+	1,  // 4: psv.livequery.v1.Snapshot.rows:type_name -> psv.livequery.v1.Row
+	6,  // 5: psv.livequery.v1.Subscribed.snapshot:type_name -> psv.livequery.v1.Snapshot
+	21, // 6: psv.livequery.v1.Subscribed.pk_cols:type_name -> psv.livequery.v1.Subscribed.PkColsEntry
+	1,  // 7: psv.livequery.v1.RowOp.row:type_name -> psv.livequery.v1.Row
+	9,  // 8: psv.livequery.v1.Update.ops:type_name -> psv.livequery.v1.RowOp
+	22, // 9: psv.livequery.v1.SchemaChanged.pk_cols:type_name -> psv.livequery.v1.SchemaChanged.PkColsEntry
+	24, // 10: psv.livequery.v1.SchemaChanged.changes:type_name -> google.protobuf.Struct
+	8,  // 11: psv.livequery.v1.SubscribeEvent.subscribed:type_name -> psv.livequery.v1.Subscribed
+	10, // 12: psv.livequery.v1.SubscribeEvent.update:type_name -> psv.livequery.v1.Update
+	6,  // 13: psv.livequery.v1.SubscribeEvent.reload:type_name -> psv.livequery.v1.Snapshot
+	11, // 14: psv.livequery.v1.SubscribeEvent.schema_changed:type_name -> psv.livequery.v1.SchemaChanged
+	12, // 15: psv.livequery.v1.SubscribeEvent.error:type_name -> psv.livequery.v1.Error
+	23, // 16: psv.livequery.v1.EditRequest.value:type_name -> google.protobuf.Value
+	14, // 17: psv.livequery.v1.BatchEditRequest.edits:type_name -> psv.livequery.v1.EditRequest
+	24, // 18: psv.livequery.v1.GetCatalogResponse.catalog:type_name -> google.protobuf.Struct
+	0,  // 19: psv.livequery.v1.Row.CellsEntry.value:type_name -> psv.livequery.v1.Cell
+	7,  // 20: psv.livequery.v1.Subscribed.PkColsEntry.value:type_name -> psv.livequery.v1.Columns
+	7,  // 21: psv.livequery.v1.SchemaChanged.PkColsEntry.value:type_name -> psv.livequery.v1.Columns
+	2,  // 22: psv.livequery.v1.LiveQuery.Query:input_type -> psv.livequery.v1.QueryRequest
+	5,  // 23: psv.livequery.v1.LiveQuery.Subscribe:input_type -> psv.livequery.v1.SubscribeRequest
+	14, // 24: psv.livequery.v1.LiveQuery.Edit:input_type -> psv.livequery.v1.EditRequest
+	16, // 25: psv.livequery.v1.LiveQuery.BatchEdit:input_type -> psv.livequery.v1.BatchEditRequest
+	18, // 26: psv.livequery.v1.LiveQuery.GetCatalog:input_type -> psv.livequery.v1.GetCatalogRequest
+	3,  // 27: psv.livequery.v1.LiveQuery.Query:output_type -> psv.livequery.v1.QueryResponse
+	13, // 28: psv.livequery.v1.LiveQuery.Subscribe:output_type -> psv.livequery.v1.SubscribeEvent
+	15, // 29: psv.livequery.v1.LiveQuery.Edit:output_type -> psv.livequery.v1.EditResponse
+	17, // 30: psv.livequery.v1.LiveQuery.BatchEdit:output_type -> psv.livequery.v1.BatchEditResponse
+	19, // 31: psv.livequery.v1.LiveQuery.GetCatalog:output_type -> psv.livequery.v1.GetCatalogResponse
+	27, // [27:32] is the sub-list for method output_type
+	22, // [22:27] is the sub-list for method input_type
+	22, // [22:22] is the sub-list for extension type_name
+	22, // [22:22] is the sub-list for extension extendee
+	0,  // [0:22] is the sub-list for field type_name
+}
+
+func init() { file_livequery_proto_init() }
+func file_livequery_proto_init() {
+	if File_livequery_proto != nil {
+		return
+	}
+	file_livequery_proto_msgTypes[9].OneofWrappers = []any{}
+	file_livequery_proto_msgTypes[13].OneofWrappers = []any{
+		(*SubscribeEvent_Subscribed)(nil),
+		(*SubscribeEvent_Update)(nil),
+		(*SubscribeEvent_Reload)(nil),
+		(*SubscribeEvent_SchemaChanged)(nil),
+		(*SubscribeEvent_Error)(nil),
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_livequery_proto_rawDesc), len(file_livequery_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   23,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_livequery_proto_goTypes,
+		DependencyIndexes: file_livequery_proto_depIdxs,
+		MessageInfos:      file_livequery_proto_msgTypes,
+	}.Build()
+	File_livequery_proto = out.File
+	file_livequery_proto_goTypes = nil
+	file_livequery_proto_depIdxs = nil
+}
@@ -0,0 +1,299 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             (unknown)
+// source: livequery.proto
+
+// The gRPC API for live queries and edits. It mirrors the WebSocket protocol
+// (internal/protocol) for backend services: the same live queries, row ops and
+// edit handles, without the browser-oriented parts (presence, locks, comments).
+//
+// The Go code is generated; see doc.go.
+
+package livequerypb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	LiveQuery_Query_FullMethodName      = "/psv.livequery.v1.LiveQuery/Query"
+	LiveQuery_Subscribe_FullMethodName  = "/psv.livequery.v1.LiveQuery/Subscribe"
+	LiveQuery_Edit_FullMethodName       = "/psv.livequery.v1.LiveQuery/Edit"
+	LiveQuery_BatchEdit_FullMethodName  = "/psv.livequery.v1.LiveQuery/BatchEdit"
+	LiveQuery_GetCatalog_FullMethodName = "/psv.livequery.v1.LiveQuery/GetCatalog"
+)
+
+// LiveQueryClient is the client API for LiveQuery service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type LiveQueryClient interface {
+	// Query runs a query once and returns its rows with edit handles, like
+	// POST /api/query.
+	Query(ctx context.Context, in *QueryRequest, opts ...grpc.CallOption) (*QueryResponse, error)
+	// Subscribe streams a live query: a Subscribed event with the initial
+	// result, then row diffs. Queries are shared with WebSocket and SSE
+	// subscribers of the same SQL.
+	Subscribe(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[SubscribeEvent], error)
+	// Edit writes one cell in its own transaction.
+	Edit(ctx context.Context, in *EditRequest, opts ...grpc.CallOption) (*EditResponse, error)
+	// BatchEdit writes several cells in one transaction: all or none.
+	BatchEdit(ctx context.Context, in *BatchEditRequest, opts ...grpc.CallOption) (*BatchEditResponse, error)
+	// GetCatalog returns the schema catalog, like GET /api/catalog.
+	GetCatalog(ctx context.Context, in *GetCatalogRequest, opts ...grpc.CallOption) (*GetCatalogResponse, error)
+}
+
+type liveQueryClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewLiveQueryClient(cc grpc.ClientConnInterface) LiveQueryClient {
+	return &liveQueryClient{cc}
+}
+
+func (c *liveQueryClient) Query(ctx context.Context, in *QueryRequest, opts ...grpc.CallOption) (*QueryResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(QueryResponse)
+	err := c.cc.Invoke(ctx, LiveQuery_Query_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *liveQueryClient) Subscribe(ctx context.Context, in *SubscribeRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[SubscribeEvent], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &LiveQuery_ServiceDesc.Streams[0], LiveQuery_Subscribe_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[SubscribeRequest, SubscribeEvent]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type LiveQuery_SubscribeClient = grpc.ServerStreamingClient[SubscribeEvent]
+
+func (c *liveQueryClient) Edit(ctx context.Context, in *EditRequest, opts ...grpc.CallOption) (*EditResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(EditResponse)
+	err := c.cc.Invoke(ctx, LiveQuery_Edit_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *liveQueryClient) BatchEdit(ctx context.Context, in *BatchEditRequest, opts ...grpc.CallOption) (*BatchEditResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(BatchEditResponse)
+	err := c.cc.Invoke(ctx, LiveQuery_BatchEdit_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *liveQueryClient) GetCatalog(ctx context.Context, in *GetCatalogRequest, opts ...grpc.CallOption) (*GetCatalogResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetCatalogResponse)
+	err := c.cc.Invoke(ctx, LiveQuery_GetCatalog_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// LiveQueryServer is the server API for LiveQuery service.
+// All implementations must embed UnimplementedLiveQueryServer
+// for forward compatibility.
+type LiveQueryServer interface {
+	// Query runs a query once and returns its rows with edit handles, like
+	// POST /api/query.
+	Query(context.Context, *QueryRequest) (*QueryResponse, error)
+	// Subscribe streams a live query: a Subscribed event with the initial
+	// result, then row diffs. Queries are shared with WebSocket and SSE
+	// subscribers of the same SQL.
+	Subscribe(*SubscribeRequest, grpc.ServerStreamingServer[SubscribeEvent]) error
+	// Edit writes one cell in its own transaction.
+	Edit(context.Context, *EditRequest) (*EditResponse, error)
+	// BatchEdit writes several cells in one transaction: all or none.
+	BatchEdit(context.Context, *BatchEditRequest) (*BatchEditResponse, error)
+	// GetCatalog returns the schema catalog, like GET /api/catalog.
+	GetCatalog(context.Context, *GetCatalogRequest) (*GetCatalogResponse, error)
+	mustEmbedUnimplementedLiveQueryServer()
+}
+
+// UnimplementedLiveQueryServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedLiveQueryServer struct{}
+
+func (UnimplementedLiveQueryServer) Query(context.Context, *QueryRequest) (*QueryResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Query not implemented")
+}
+func (UnimplementedLiveQueryServer) Subscribe(*SubscribeRequest, grpc.ServerStreamingServer[SubscribeEvent]) error {
+	return status.Errorf(codes.Unimplemented, "method Subscribe not implemented")
+}
+func (UnimplementedLiveQueryServer) Edit(context.Context, *EditRequest) (*EditResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Edit not implemented")
+}
+func (UnimplementedLiveQueryServer) BatchEdit(context.Context, *BatchEditRequest) (*BatchEditResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method BatchEdit not implemented")
+}
+func (UnimplementedLiveQueryServer) GetCatalog(context.Context, *GetCatalogRequest) (*GetCatalogResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetCatalog not implemented")
+}
+func (UnimplementedLiveQueryServer) mustEmbedUnimplementedLiveQueryServer() {}
+func (UnimplementedLiveQueryServer) testEmbeddedByValue()                   {}
+
+// UnsafeLiveQueryServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to LiveQueryServer will
+// result in compilation errors.
+type UnsafeLiveQueryServer interface {
+	mustEmbedUnimplementedLiveQueryServer()
+}
+
+func RegisterLiveQueryServer(s grpc.ServiceRegistrar, srv LiveQueryServer) {
+	// If the following call pancis, it indicates UnimplementedLiveQueryServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&LiveQuery_ServiceDesc, srv)
+}
+
+func _LiveQuery_Query_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(QueryRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LiveQueryServer).Query(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: LiveQuery_Query_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LiveQueryServer).Query(ctx, req.(*QueryRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LiveQuery_Subscribe_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(SubscribeRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(LiveQueryServer).Subscribe(m, &grpc.GenericServerStream[SubscribeRequest, SubscribeEvent]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type LiveQuery_SubscribeServer = grpc.ServerStreamingServer[SubscribeEvent]
+
+func _LiveQuery_Edit_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(EditRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LiveQueryServer).Edit(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: LiveQuery_Edit_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LiveQueryServer).Edit(ctx, req.(*EditRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LiveQuery_BatchEdit_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BatchEditRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LiveQueryServer).BatchEdit(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: LiveQuery_BatchEdit_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LiveQueryServer).BatchEdit(ctx, req.(*BatchEditRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _LiveQuery_GetCatalog_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetCatalogRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(LiveQueryServer).GetCatalog(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: LiveQuery_GetCatalog_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(LiveQueryServer).GetCatalog(ctx, req.(*GetCatalogRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// LiveQuery_ServiceDesc is the grpc.ServiceDesc for LiveQuery service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var LiveQuery_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "psv.livequery.v1.LiveQuery",
+	HandlerType: (*LiveQueryServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Query",
+			Handler:    _LiveQuery_Query_Handler,
+		},
+		{
+			MethodName: "Edit",
+			Handler:    _LiveQuery_Edit_Handler,
+		},
+		{
+			MethodName: "BatchEdit",
+			Handler:    _LiveQuery_BatchEdit_Handler,
+		},
+		{
+			MethodName: "GetCatalog",
+			Handler:    _LiveQuery_GetCatalog_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Subscribe",
+			Handler:       _LiveQuery_Subscribe_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "livequery.proto",
+}
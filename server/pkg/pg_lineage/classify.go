@@ -0,0 +1,342 @@
+package pg_lineage
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	pg_query "github.com/pganalyze/pg_query_go/v6"
+	rc "github.com/zoravur/postgres-spreadsheet-view/server/pkg/richcatalog"
+)
+
+// Strategy is how a live query is kept current as its tables change.
+type Strategy string
+
+const (
+	// StrategyPKPushdown re-runs the query filtered to the changed primary keys.
+	StrategyPKPushdown Strategy = "pk_pushdown"
+	// StrategyFullRequery re-runs the whole query and diffs the result.
+	StrategyFullRequery Strategy = "full_requery"
+	// StrategyIncrementalAggregate folds row changes into per-group aggregates.
+	StrategyIncrementalAggregate Strategy = "incremental_aggregate"
+	// StrategyPoll re-runs on a timer, for results that change with no row change.
+	StrategyPoll Strategy = "poll"
+)
+
+// ParseStrategy accepts a strategy name; "" means no preference.
+func ParseStrategy(s string) (Strategy, error) {
+	switch st := Strategy(strings.ToLower(strings.TrimSpace(s))); st {
+	case "", StrategyPKPushdown, StrategyFullRequery, StrategyIncrementalAggregate, StrategyPoll:
+		return st, nil
+	default:
+		return "", fmt.Errorf("unknown strategy %q", s)
+	}
+}
+
+// Classification is the strategy picked for a query and why.
+type Classification struct {
+	Strategy Strategy `json:"strategy"`
+	// Reasons lists what rules out the cheaper strategies, e.g. "outer join".
+	Reasons []string `json:"reasons,omitempty"`
+	// Aggregate describes the query when it qualifies for incremental aggregation.
+	Aggregate *AggregateShape `json:"aggregate,omitempty"`
+}
+
+// Override checks a subscriber's strategy override against the classification.
+// Only the picked strategy or a more conservative one is allowed: full_requery
+// for anything but a poll query, and poll for anything. pk_pushdown and
+// incremental_aggregate give wrong results on queries not classified for them.
+func (c Classification) Override(s Strategy) (Strategy, error) {
+	switch {
+	case s == "" || s == c.Strategy:
+		return c.Strategy, nil
+	case s == StrategyPoll:
+		return s, nil
+	case s == StrategyFullRequery && c.Strategy != StrategyPoll:
+		return s, nil
+	}
+	why := strings.Join(c.Reasons, ", ")
+	if why == "" {
+		why = "no reasons"
+	}
+	return "", fmt.Errorf("query does not qualify for %s: classified %s (%s)", s, c.Strategy, why)
+}
+
+// AggregateShape is a single-table GROUP BY query whose outputs are group keys
+// and count/sum/avg/min/max over plain columns.
+type AggregateShape struct {
+	Table   string      `json:"table"`   // "public.film"
+	GroupBy []string    `json:"groupBy"` // base columns
+	Columns []AggColumn `json:"columns"` // in output order
+	// Filtered is set when the query has a WHERE clause. Row changes can't be
+	// tested against it outside Postgres, so their groups are re-queried.
+	Filtered bool `json:"filtered,omitempty"`
+}
+
+// AggColumn is one output of an AggregateShape. Func is "" for group keys.
+type AggColumn struct {
+	Output string `json:"output"`
+	Func   string `json:"func,omitempty"` // count, sum, avg, min, max
+	Arg    string `json:"arg,omitempty"`  // base column; "" for count(*)
+}
+
+var volatileFuncs = map[string]bool{
+	"now": true, "random": true, "clock_timestamp": true, "statement_timestamp": true,
+	"transaction_timestamp": true, "timeofday": true, "gen_random_uuid": true,
+	"uuid_generate_v4": true, "nextval": true, "currval": true, "txid_current": true,
+}
+
+var aggregateFuncs = map[string]bool{
+	"count": true, "sum": true, "avg": true, "min": true, "max": true,
+	"array_agg": true, "string_agg": true, "bool_and": true, "bool_or": true, "every": true,
+	"json_agg": true, "jsonb_agg": true, "json_object_agg": true, "jsonb_object_agg": true,
+	"stddev": true, "stddev_pop": true, "stddev_samp": true, "variance": true,
+	"var_pop": true, "var_samp": true, "bit_and": true, "bit_or": true, "xmlagg": true,
+	"percentile_cont": true, "percentile_disc": true, "mode": true,
+}
+
+var incrementalFuncs = map[string]bool{"count": true, "sum": true, "avg": true, "min": true, "max": true}
+
+// ClassifyQuery picks the refresh strategy for a live query from its shape.
+//
+//   - poll: volatile functions (now(), random(), CURRENT_TIMESTAMP, ...) or
+//     set-returning functions in FROM; WAL changes alone can't keep it current.
+//   - full_requery: anything where a changed row can affect result rows that
+//     don't carry its primary key: set operations, DISTINCT, window functions,
+//     ORDER BY/LIMIT/OFFSET, outer joins, subqueries, CTEs, tables without a
+//     primary key, and aggregates that don't qualify for incremental updates.
+//   - incremental_aggregate: see AggregateShape.
+//   - pk_pushdown: everything else (plain selects and inner joins).
+func ClassifyQuery(sql string, cat rc.Catalog) (Classification, error) {
+	raw, err := pg_query.ParseToJSON(sql)
+	if err != nil {
+		return Classification{}, fmt.Errorf("parse error: %w", err)
+	}
+	var tree map[string]any
+	if err := json.Unmarshal([]byte(raw), &tree); err != nil {
+		return Classification{}, fmt.Errorf("invalid json ast: %w", err)
+	}
+	stmts, _ := tree["stmts"].([]any)
+	if len(stmts) == 0 {
+		return Classification{}, fmt.Errorf("no statements")
+	}
+	stmt, _ := stmts[0].(map[string]any)["stmt"].(map[string]any)
+	sel, ok := stmt["SelectStmt"].(map[string]any)
+	if !ok {
+		return Classification{}, fmt.Errorf("only SELECT supported")
+	}
+
+	var poll, full []string
+	addOnce := func(list *[]string, reason string) {
+		for _, r := range *list {
+			if r == reason {
+				return
+			}
+		}
+		*list = append(*list, reason)
+	}
+
+	ctes := map[string]bool{}
+	if wc, ok := sel["withClause"].(map[string]any); ok {
+		list, _ := wc["ctes"].([]any)
+		for _, c := range list {
+			if cte, ok := c.(map[string]any)["CommonTableExpr"].(map[string]any); ok {
+				name, _ := cte["ctename"].(string)
+				ctes[name] = true
+			}
+		}
+	}
+
+	hasAggregate := false
+	walkNodes(sel, func(kind string, n map[string]any) {
+		switch kind {
+		case "SQLValueFunction":
+			addOnce(&poll, "current date/time")
+		case "RangeFunction":
+			addOnce(&poll, "function in FROM")
+		case "FuncCall":
+			name := strings.ToLower(funcName(n))
+			switch {
+			case volatileFuncs[name]:
+				addOnce(&poll, "volatile function "+name+"()")
+			case n["over"] != nil:
+				addOnce(&full, "window function")
+			case aggregateFuncs[name]:
+				hasAggregate = true
+			}
+		case "SubLink":
+			addOnce(&full, "subquery")
+		case "RangeSubselect":
+			addOnce(&full, "subquery in FROM")
+		case "JoinExpr":
+			if jt, _ := n["jointype"].(string); jt != "" && jt != "JOIN_INNER" {
+				addOnce(&full, "outer join")
+			}
+		case "RangeVar":
+			if name, _ := n["relname"].(string); ctes[name] {
+				break
+			}
+			if rel := qualifiedRel(n); rel != "" {
+				if pks, ok := cat.PrimaryKeys(rel); !ok || len(pks) == 0 {
+					addOnce(&full, "no primary key on "+rel)
+				}
+			}
+		}
+	})
+
+	if op, _ := sel["op"].(string); op != "" && op != "SETOP_NONE" {
+		addOnce(&full, "set operation")
+	}
+	if sel["withClause"] != nil {
+		addOnce(&full, "common table expression")
+	}
+	if sel["distinctClause"] != nil {
+		addOnce(&full, "DISTINCT")
+	}
+	if sel["sortClause"] != nil || sel["limitCount"] != nil || sel["limitOffset"] != nil {
+		addOnce(&full, "ORDER BY/LIMIT/OFFSET")
+	}
+
+	aggregating := hasAggregate || sel["groupClause"] != nil || sel["havingClause"] != nil
+	var shape *AggregateShape
+	if aggregating {
+		shape = aggregateShape(sel)
+		if shape == nil {
+			addOnce(&full, "aggregate")
+		}
+	}
+
+	// the AST walk visits map keys in random order
+	sort.Strings(poll)
+	sort.Strings(full)
+
+	switch {
+	case len(poll) > 0:
+		return Classification{Strategy: StrategyPoll, Reasons: append(poll, full...)}, nil
+	case len(full) > 0:
+		return Classification{Strategy: StrategyFullRequery, Reasons: full}, nil
+	case shape != nil:
+		return Classification{Strategy: StrategyIncrementalAggregate, Reasons: []string{"aggregate"}, Aggregate: shape}, nil
+	default:
+		return Classification{Strategy: StrategyPKPushdown}, nil
+	}
+}
+
+// aggregateShape returns the query's AggregateShape, or nil if it doesn't
+// qualify: exactly one table, no HAVING, GROUP BY on plain columns, and every
+// output either a grouped column or count/sum/avg/min/max of a plain column
+// without DISTINCT, FILTER or ORDER BY.
+func aggregateShape(sel map[string]any) *AggregateShape {
+	from, _ := sel["fromClause"].([]any)
+	if len(from) != 1 || sel["havingClause"] != nil {
+		return nil
+	}
+	rv, ok := from[0].(map[string]any)["RangeVar"].(map[string]any)
+	if !ok {
+		return nil
+	}
+	shape := &AggregateShape{Table: qualifiedRel(rv), Filtered: sel["whereClause"] != nil}
+
+	grouped := map[string]bool{}
+	groups, _ := sel["groupClause"].([]any)
+	for _, g := range groups {
+		cr, ok := g.(map[string]any)["ColumnRef"].(map[string]any)
+		if !ok {
+			return nil
+		}
+		col := lastField(cr)
+		if col == "" {
+			return nil
+		}
+		grouped[col] = true
+		shape.GroupBy = append(shape.GroupBy, col)
+	}
+
+	targets, _ := sel["targetList"].([]any)
+	for _, t := range targets {
+		rt, _ := t.(map[string]any)["ResTarget"].(map[string]any)
+		val, _ := rt["val"].(map[string]any)
+		out := targetOutputKey(rt)
+
+		if cr, ok := val["ColumnRef"].(map[string]any); ok {
+			col := lastField(cr)
+			if col == "" || !grouped[col] {
+				return nil
+			}
+			if out == "" {
+				out = col
+			}
+			shape.Columns = append(shape.Columns, AggColumn{Output: out, Arg: col})
+			continue
+		}
+
+		fn, ok := val["FuncCall"].(map[string]any)
+		if !ok {
+			return nil
+		}
+		name := strings.ToLower(funcName(fn))
+		if !incrementalFuncs[name] || fn["agg_distinct"] != nil || fn["agg_filter"] != nil ||
+			fn["agg_order"] != nil || fn["over"] != nil {
+			return nil
+		}
+		col := AggColumn{Output: out, Func: name}
+		if col.Output == "" {
+			col.Output = name // Postgres names unaliased aggregates after the function
+		}
+		args, _ := fn["args"].([]any)
+		switch {
+		case fn["agg_star"] == true && name == "count" && len(args) == 0:
+		case len(args) == 1:
+			cr, ok := args[0].(map[string]any)["ColumnRef"].(map[string]any)
+			if !ok || lastField(cr) == "" {
+				return nil
+			}
+			col.Arg = lastField(cr)
+		default:
+			return nil
+		}
+		shape.Columns = append(shape.Columns, col)
+	}
+	return shape
+}
+
+// walkNodes calls fn for every node in the JSON AST, keyed by node type.
+func walkNodes(v any, fn func(kind string, n map[string]any)) {
+	switch t := v.(type) {
+	case map[string]any:
+		for k, child := range t {
+			if m, ok := child.(map[string]any); ok && k != "" && k[0] >= 'A' && k[0] <= 'Z' {
+				fn(k, m)
+			}
+			walkNodes(child, fn)
+		}
+	case []any:
+		for _, child := range t {
+			walkNodes(child, fn)
+		}
+	}
+}
+
+// qualifiedRel returns "schema.table" for a RangeVar, defaulting to public.
+func qualifiedRel(rv map[string]any) string {
+	rel, _ := rv["relname"].(string)
+	if rel == "" {
+		return ""
+	}
+	if sch, ok := rv["schemaname"].(string); ok && sch != "" {
+		return sch + "." + rel
+	}
+	return "public." + rel
+}
+
+func lastField(colref map[string]any) string {
+	if isStar(colref) {
+		return ""
+	}
+	fields := extractFields(colref)
+	if len(fields) == 0 {
+		return ""
+	}
+	return fields[len(fields)-1]
+}
@@ -0,0 +1,85 @@
+package pg_lineage
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+type ClassifyCase struct {
+	ID                string              `json:"id"`
+	Query             string              `json:"query"`
+	PrimaryKeys       map[string][]string `json:"primary_keys"` // table → pk columns
+	ExpectedStrategy  Strategy            `json:"expected_strategy"`
+	ExpectedReasons   []string            `json:"expected_reasons"`
+	ExpectedAggregate *AggregateShape     `json:"expected_aggregate"`
+	ExpectedError     string              `json:"expected_error"`
+	// AllowedOverrides lists the strategies a subscriber may force on the
+	// query; every other strategy must be refused.
+	AllowedOverrides []Strategy `json:"allowed_overrides"`
+}
+
+func loadClassifyCases(t *testing.T) []ClassifyCase {
+	t.Helper()
+	path := filepath.Join("testdata", "classify_test_cases.json")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read testdata: %v", err)
+	}
+	var cases []ClassifyCase
+	if err := json.Unmarshal(data, &cases); err != nil {
+		t.Fatalf("failed to unmarshal testdata: %v", err)
+	}
+	return cases
+}
+
+func TestClassifyQuery(t *testing.T) {
+	for _, c := range loadClassifyCases(t) {
+		t.Run(c.ID, func(t *testing.T) {
+			cat := &DemoPKCatalog{cols: demoCols, pks: c.PrimaryKeys}
+
+			got, err := ClassifyQuery(c.Query, cat)
+
+			if c.ExpectedError != "" {
+				if err == nil || err.Error() != c.ExpectedError {
+					t.Fatalf("expected error %q, got %v", c.ExpectedError, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if got.Strategy != c.ExpectedStrategy {
+				t.Fatalf("strategy: expected %s, got %s (reasons %v)", c.ExpectedStrategy, got.Strategy, got.Reasons)
+			}
+			if !reflect.DeepEqual(got.Reasons, c.ExpectedReasons) {
+				t.Fatalf("reasons: expected %#v, got %#v", c.ExpectedReasons, got.Reasons)
+			}
+			if !reflect.DeepEqual(got.Aggregate, c.ExpectedAggregate) {
+				gotJSON, _ := json.Marshal(got.Aggregate)
+				wantJSON, _ := json.Marshal(c.ExpectedAggregate)
+				t.Fatalf("aggregate: expected %s, got %s", wantJSON, gotJSON)
+			}
+
+			allowed := map[Strategy]bool{}
+			for _, s := range c.AllowedOverrides {
+				allowed[s] = true
+			}
+			for _, s := range []Strategy{StrategyPKPushdown, StrategyIncrementalAggregate, StrategyFullRequery, StrategyPoll} {
+				picked, err := got.Override(s)
+				if allowed[s] && (err != nil || picked != s) {
+					t.Errorf("override %s: expected allowed, got %q, %v", s, picked, err)
+				}
+				if !allowed[s] && err == nil {
+					t.Errorf("override %s: expected refusal, got %q", s, picked)
+				}
+			}
+			if picked, err := got.Override(""); err != nil || picked != got.Strategy {
+				t.Errorf("no override: expected %s, got %q, %v", got.Strategy, picked, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,209 @@
+package richcatalog
+
+import (
+	"sort"
+	"strings"
+)
+
+type ChangeKind string
+
+const (
+	TableAdded        ChangeKind = "table_added"
+	TableDropped      ChangeKind = "table_dropped"
+	ColumnAdded       ChangeKind = "column_added"
+	ColumnDropped     ChangeKind = "column_dropped"
+	ColumnRetyped     ChangeKind = "column_retyped"
+	ColumnAltered     ChangeKind = "column_altered" // nullability or default changed
+	PrimaryKeyChanged ChangeKind = "primary_key_changed"
+	IndexAdded        ChangeKind = "index_added"
+	IndexDropped      ChangeKind = "index_dropped"
+	ForeignKeyAdded   ChangeKind = "foreign_key_added"
+	ForeignKeyDropped ChangeKind = "foreign_key_dropped"
+)
+
+// Change is one structural difference between two snapshots.
+type Change struct {
+	Kind   ChangeKind `json:"kind"`
+	Schema string     `json:"schema"`
+	Table  string     `json:"table"`
+	// Name is the column, index or FK name (empty for table-level changes).
+	Name string `json:"name,omitempty"`
+	// From/To hold the old/new column type for column_retyped.
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+	// OldPK/NewPK hold primary key columns for primary_key_changed.
+	OldPK []string `json:"oldPrimaryKey,omitempty"`
+	NewPK []string `json:"newPrimaryKey,omitempty"`
+}
+
+// Qualified returns "schema.table" for the table the change belongs to.
+func (c Change) Qualified() string { return c.Schema + "." + c.Table }
+
+// Diff compares two snapshots and returns the structural changes from old to
+// next. Tables come in order of qualified name. Within a table: columns in
+// next's order (retyped, altered or added), then dropped columns in old's
+// order, the primary key, dropped then added indexes, and dropped then added
+// FKs. Renames can't be told apart from drop+add (the snapshot carries no
+// stable ids), so they show up as such. An index or FK that keeps its name
+// but changes definition is reported as dropped and re-added.
+func Diff(old, next Snapshot) []Change {
+	oldT := tablesByName(old)
+	newT := tablesByName(next)
+
+	names := make([]string, 0, len(oldT)+len(newT))
+	for k := range oldT {
+		names = append(names, k)
+	}
+	for k := range newT {
+		if _, ok := oldT[k]; !ok {
+			names = append(names, k)
+		}
+	}
+	sort.Strings(names)
+
+	var out []Change
+	for _, name := range names {
+		o, inOld := oldT[name]
+		n, inNew := newT[name]
+		switch {
+		case !inOld:
+			out = append(out, Change{Kind: TableAdded, Schema: n.Schema, Table: n.Name})
+		case !inNew:
+			out = append(out, Change{Kind: TableDropped, Schema: o.Schema, Table: o.Name})
+		default:
+			out = append(out, diffTable(o, n)...)
+		}
+	}
+	return out
+}
+
+// AffectedTables returns the sorted, de-duplicated qualified tables touched by changes.
+func AffectedTables(changes []Change) []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, c := range changes {
+		q := c.Qualified()
+		if !seen[q] {
+			seen[q] = true
+			out = append(out, q)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+func diffTable(o, n Table) []Change {
+	var out []Change
+	base := Change{Schema: n.Schema, Table: n.Name}
+	with := func(kind ChangeKind, name string) Change {
+		c := base
+		c.Kind, c.Name = kind, name
+		return c
+	}
+
+	// columns
+	oldCols := map[string]Column{}
+	for _, c := range o.Columns {
+		oldCols[c.Name] = c
+	}
+	newCols := map[string]Column{}
+	for _, c := range n.Columns {
+		newCols[c.Name] = c
+		oc, ok := oldCols[c.Name]
+		if !ok {
+			out = append(out, with(ColumnAdded, c.Name))
+			continue
+		}
+		if oc.Type != c.Type {
+			ch := with(ColumnRetyped, c.Name)
+			ch.From, ch.To = oc.Type, c.Type
+			out = append(out, ch)
+		}
+		if oc.NotNull != c.NotNull || !sameDefault(oc.DefaultSQL, c.DefaultSQL) {
+			out = append(out, with(ColumnAltered, c.Name))
+		}
+	}
+	for _, c := range o.Columns {
+		if _, ok := newCols[c.Name]; !ok {
+			out = append(out, with(ColumnDropped, c.Name))
+		}
+	}
+
+	// primary key (order matters: it drives edit handle encoding)
+	if strings.Join(o.PK, ",") != strings.Join(n.PK, ",") {
+		ch := with(PrimaryKeyChanged, "")
+		ch.OldPK = append([]string(nil), o.PK...)
+		ch.NewPK = append([]string(nil), n.PK...)
+		out = append(out, ch)
+	}
+
+	// indexes
+	oldIdx := map[string]Index{}
+	for _, ix := range o.Indexes {
+		oldIdx[ix.Name] = ix
+	}
+	newIdx := map[string]Index{}
+	for _, ix := range n.Indexes {
+		newIdx[ix.Name] = ix
+	}
+	for _, ix := range o.Indexes {
+		if nx, ok := newIdx[ix.Name]; !ok || !sameIndex(ix, nx) {
+			out = append(out, with(IndexDropped, ix.Name))
+		}
+	}
+	for _, ix := range n.Indexes {
+		if ox, ok := oldIdx[ix.Name]; !ok || !sameIndex(ox, ix) {
+			out = append(out, with(IndexAdded, ix.Name))
+		}
+	}
+
+	// foreign keys
+	oldFK := map[string]FK{}
+	for _, fk := range o.FKs {
+		oldFK[fk.Name] = fk
+	}
+	newFK := map[string]FK{}
+	for _, fk := range n.FKs {
+		newFK[fk.Name] = fk
+	}
+	for _, fk := range o.FKs {
+		if nf, ok := newFK[fk.Name]; !ok || !sameFK(fk, nf) {
+			out = append(out, with(ForeignKeyDropped, fk.Name))
+		}
+	}
+	for _, fk := range n.FKs {
+		if of, ok := oldFK[fk.Name]; !ok || !sameFK(of, fk) {
+			out = append(out, with(ForeignKeyAdded, fk.Name))
+		}
+	}
+	return out
+}
+
+func tablesByName(s Snapshot) map[string]Table {
+	m := map[string]Table{}
+	for _, sc := range s.Schemas {
+		for _, t := range sc.Tables {
+			m[t.Schema+"."+t.Name] = t
+		}
+	}
+	return m
+}
+
+func sameDefault(a, b *string) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+func sameIndex(a, b Index) bool {
+	return a.IsUnique == b.IsUnique && a.IsPrimary == b.IsPrimary &&
+		strings.Join(a.Columns, ",") == strings.Join(b.Columns, ",")
+}
+
+func sameFK(a, b FK) bool {
+	return strings.Join(a.Columns, ",") == strings.Join(b.Columns, ",") &&
+		a.RefSchema == b.RefSchema && a.RefTable == b.RefTable &&
+		strings.Join(a.RefColumns, ",") == strings.Join(b.RefColumns, ",") &&
+		a.OnUpdate == b.OnUpdate && a.OnDelete == b.OnDelete
+}
@@ -55,6 +55,8 @@ type Options struct {
 	// When true, introspection includes indexes and FKs (slower but richer UI data).
 	IncludeIndexes bool
 	IncludeFKs     bool
+	// KeepHistory retains this many superseded snapshots for SnapshotAt (0 = none).
+	KeepHistory int
 }
 
 type AutoRefresh struct {
@@ -135,6 +137,10 @@ type DBCatalog struct {
 	cond *sync.Cond
 	// notifyCancel cancels the LISTEN loop (if any)
 	notifyCancel context.CancelFunc
+	// listeners are invoked (outside mu) whenever Refresh swaps in a new checksum
+	listeners []func(prev, next Snapshot)
+	// history holds superseded snapshots, oldest first (bounded by opt.KeepHistory)
+	history []Snapshot
 }
 
 func New(db *sql.DB, opt Options) (*DBCatalog, error) {
@@ -154,6 +160,26 @@ func (c *DBCatalog) Snapshot() Snapshot {
 	return out
 }
 
+// Pin returns the current snapshot without copying it. Refresh swaps in new
+// snapshots rather than mutating old ones, so a pinned snapshot is a stable
+// schema version for as long as the caller holds it. Treat it as read-only.
+func (c *DBCatalog) Pin() *Snapshot {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	s := c.snap
+	return &s
+}
+
+// Load is Pin, refreshing first if no snapshot has been loaded yet.
+func (c *DBCatalog) Load(ctx context.Context) (*Snapshot, error) {
+	if c.Checksum() == "" {
+		if err := c.Refresh(ctx); err != nil {
+			return nil, err
+		}
+	}
+	return c.Pin(), nil
+}
+
 // Columns implements the minimal Catalog interface.
 func (c *DBCatalog) Columns(qualified string) ([]string, bool) {
 	t, ok := c.lookupTable(qualified)
@@ -194,14 +220,62 @@ func (c *DBCatalog) Refresh(ctx context.Context) error {
 	}
 
 	c.mu.Lock()
-	defer c.mu.Unlock()
-	if newSnap.Checksum != c.snap.Checksum {
+	prev := c.snap
+	changed := newSnap.Checksum != prev.Checksum
+	if changed {
+		if c.opt.KeepHistory > 0 && prev.Checksum != "" {
+			c.history = append(c.history, prev)
+			if len(c.history) > c.opt.KeepHistory {
+				c.history = c.history[len(c.history)-c.opt.KeepHistory:]
+			}
+		}
 		c.snap = newSnap
 		c.cond.Broadcast()
 	}
+	listeners := c.listeners // OnChange only appends, so the header is a stable copy
+	c.mu.Unlock()
+
+	if changed {
+		for _, fn := range listeners {
+			fn(prev, newSnap)
+		}
+	}
 	return nil
 }
 
+// OnChange registers fn to be called after every refresh that changes the checksum
+// (including the first successful load, where prev is the zero Snapshot).
+// Snapshots are shared, not copied: listeners must treat them as read-only.
+func (c *DBCatalog) OnChange(fn func(prev, next Snapshot)) {
+	c.mu.Lock()
+	c.listeners = append(c.listeners, fn)
+	c.mu.Unlock()
+}
+
+// SnapshotAt returns the snapshot with the given checksum if it is the current one
+// or still retained in history (see Options.KeepHistory). Like OnChange, the
+// result is shared and must be treated as read-only.
+func (c *DBCatalog) SnapshotAt(checksum string) (Snapshot, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.snap.Checksum == checksum {
+		return c.snap, true
+	}
+	for i := len(c.history) - 1; i >= 0; i-- {
+		if c.history[i].Checksum == checksum {
+			return c.history[i], true
+		}
+	}
+	return Snapshot{}, false
+}
+
+// Checksum returns the checksum of the current snapshot without copying it.
+func (c *DBCatalog) Checksum() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.snap.Checksum
+}
+
 // StartAutoRefresh starts background refresh. Returns a stop func.
 func (c *DBCatalog) StartAutoRefresh(ctx context.Context, ar AutoRefresh) func() {
 	ctx, cancel := context.WithCancel(ctx)
@@ -248,7 +322,19 @@ func (c *DBCatalog) WaitUntilRefreshed(prevChecksum string) {
 
 // --- Introspection SQL ---
 
+// Querier is what introspection reads the catalog through: the *sql.DB, or a
+// *sql.Tx to see the schema as that transaction does.
+type Querier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
 func (c *DBCatalog) introspect(ctx context.Context) (Snapshot, error) {
+	return c.Introspect(ctx, c.db)
+}
+
+// Introspect reads a snapshot through q without caching it, so a caller
+// holding a transaction can check the schema it is about to change.
+func (c *DBCatalog) Introspect(ctx context.Context, q Querier) (Snapshot, error) {
 	schemas := c.opt.Schemas
 	filter := ""
 	if len(schemas) > 0 {
@@ -262,7 +348,7 @@ func (c *DBCatalog) introspect(ctx context.Context) (Snapshot, error) {
 	}
 
 	// One round-trip using CTEs. Keep deterministic ordering for stable checksum.
-	q := fmt.Sprintf(`
+	query := fmt.Sprintf(`
 WITH schemas AS (
   SELECT n.oid AS nspoid, n.nspname
   FROM pg_catalog.pg_namespace n
@@ -346,7 +432,7 @@ SELECT 'FK', src_schema, src_table, NULL, NULL, NULL, NULL, NULL,
   FROM fk
 ORDER BY 2,3,1,4 NULLS LAST,5 NULLS LAST`, filter)
 
-	rows, err := c.db.QueryContext(ctx, q)
+	rows, err := q.QueryContext(ctx, query)
 	if err != nil {
 		fmt.Println()
 		return Snapshot{}, err
@@ -582,6 +668,88 @@ func (c *DBCatalog) ForceRefreshIf(ctx context.Context, knownChecksum string) (c
 	return c.snap.Checksum != knownChecksum, nil
 }
 
+// Filter returns a copy of s restricted to the given schemas and tables. Table names
+// may be bare ("film") or schema-qualified ("public.film"). Empty lists don't filter.
+// Types are kept for every schema that survives the filter.
+func (s Snapshot) Filter(schemas, tables []string) Snapshot {
+	if len(schemas) == 0 && len(tables) == 0 {
+		return s
+	}
+	wantSchema := toSet(schemas)
+	wantTable := toSet(tables)
+
+	out := Snapshot{Checksum: s.Checksum, GeneratedAt: s.GeneratedAt}
+	for _, sc := range s.Schemas {
+		if len(wantSchema) > 0 && !wantSchema[sc.Name] {
+			continue
+		}
+		kept := Schema{Name: sc.Name, Types: sc.Types, Tables: []Table{}}
+		for _, t := range sc.Tables {
+			if len(wantTable) > 0 && !wantTable[t.Name] && !wantTable[t.Schema+"."+t.Name] {
+				continue
+			}
+			kept.Tables = append(kept.Tables, t)
+		}
+		if len(wantTable) > 0 && len(kept.Tables) == 0 {
+			continue
+		}
+		out.Schemas = append(out.Schemas, kept)
+	}
+	if out.Schemas == nil {
+		out.Schemas = []Schema{}
+	}
+	return out
+}
+
+// Table looks up a table by qualified ("public.film") or bare ("film", assumed public) name.
+func (s Snapshot) Table(qualified string) (Table, bool) {
+	q := qual(qualified)
+	if s.byTable != nil {
+		if t, ok := s.byTable[q]; ok {
+			return *t, true
+		}
+		return Table{}, false
+	}
+	for _, sc := range s.Schemas {
+		for _, t := range sc.Tables {
+			if t.Schema+"."+t.Name == q {
+				return t, true
+			}
+		}
+	}
+	return Table{}, false
+}
+
+// Columns implements the minimal Catalog interface against this snapshot.
+func (s Snapshot) Columns(qualified string) ([]string, bool) {
+	t, ok := s.Table(qualified)
+	if !ok {
+		return nil, false
+	}
+	cols := make([]string, len(t.Columns))
+	for i, col := range t.Columns {
+		cols[i] = col.Name
+	}
+	return cols, true
+}
+
+// PrimaryKeys implements the minimal Catalog interface against this snapshot.
+func (s Snapshot) PrimaryKeys(qualified string) ([]string, bool) {
+	t, ok := s.Table(qualified)
+	if !ok {
+		return nil, false
+	}
+	return append([]string(nil), t.PK...), true
+}
+
+func toSet(xs []string) map[string]bool {
+	m := make(map[string]bool, len(xs))
+	for _, x := range xs {
+		m[x] = true
+	}
+	return m
+}
+
 // --- BONUS: tiny JSON API payload helpers ---
 
 type Summary struct {
@@ -1 +1,123 @@
 package richcatalog
+
+import (
+	"reflect"
+	"testing"
+)
+
+func demoSnapshot() Snapshot {
+	return Snapshot{
+		Checksum: "abc",
+		Schemas: []Schema{
+			{Name: "public", Tables: []Table{
+				{Schema: "public", Name: "actor", PK: []string{"actor_id"}},
+				{Schema: "public", Name: "film", PK: []string{"film_id"}},
+			}},
+			{Name: "sales", Tables: []Table{
+				{Schema: "sales", Name: "film"},
+			}},
+		},
+	}
+}
+
+func tableNames(s Snapshot) []string {
+	var out []string
+	for _, sc := range s.Schemas {
+		for _, t := range sc.Tables {
+			out = append(out, t.Schema+"."+t.Name)
+		}
+	}
+	return out
+}
+
+func TestSnapshotFilter(t *testing.T) {
+	cases := []struct {
+		name    string
+		schemas []string
+		tables  []string
+		want    []string
+	}{
+		{"no filter", nil, nil, []string{"public.actor", "public.film", "sales.film"}},
+		{"by schema", []string{"sales"}, nil, []string{"sales.film"}},
+		{"bare table", nil, []string{"film"}, []string{"public.film", "sales.film"}},
+		{"qualified table", nil, []string{"public.film"}, []string{"public.film"}},
+		{"schema and table", []string{"public"}, []string{"actor"}, []string{"public.actor"}},
+		{"no match", nil, []string{"nope"}, nil},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := demoSnapshot().Filter(c.schemas, c.tables)
+			if got.Checksum != "abc" {
+				t.Fatalf("checksum not preserved: %q", got.Checksum)
+			}
+			if names := tableNames(got); !reflect.DeepEqual(names, c.want) {
+				t.Fatalf("want %v, got %v", c.want, names)
+			}
+		})
+	}
+}
+
+func TestDiff(t *testing.T) {
+	def := "0"
+	old := Snapshot{Schemas: []Schema{{Name: "public", Tables: []Table{
+		{
+			Schema: "public", Name: "film",
+			Columns: []Column{{Name: "film_id", Type: "integer"}, {Name: "title", Type: "text"}, {Name: "length", Type: "smallint"}},
+			PK:      []string{"film_id"},
+			Indexes: []Index{{Name: "film_pkey", IsPrimary: true, IsUnique: true, Columns: []string{"film_id"}}},
+		},
+		{Schema: "public", Name: "staff", Columns: []Column{{Name: "staff_id", Type: "integer"}}},
+	}}}}
+	next := Snapshot{Schemas: []Schema{{Name: "public", Tables: []Table{
+		{
+			Schema: "public", Name: "film",
+			Columns: []Column{{Name: "film_id", Type: "integer"}, {Name: "title", Type: "varchar(255)", NotNull: true}, {Name: "rating", Type: "text", DefaultSQL: &def}},
+			PK:      []string{"film_id", "title"},
+			Indexes: []Index{
+				{Name: "film_pkey", IsPrimary: true, IsUnique: true, Columns: []string{"film_id", "title"}},
+				{Name: "film_title_idx", Columns: []string{"title"}},
+			},
+			FKs: []FK{{Name: "film_rating_fkey", Columns: []string{"rating"}, RefSchema: "public", RefTable: "rating", RefColumns: []string{"code"}}},
+		},
+		{Schema: "public", Name: "rating", Columns: []Column{{Name: "code", Type: "text"}}},
+	}}}}
+
+	got := Diff(old, next)
+	want := []Change{
+		{Kind: ColumnRetyped, Schema: "public", Table: "film", Name: "title", From: "text", To: "varchar(255)"},
+		{Kind: ColumnAltered, Schema: "public", Table: "film", Name: "title"},
+		{Kind: ColumnAdded, Schema: "public", Table: "film", Name: "rating"},
+		{Kind: ColumnDropped, Schema: "public", Table: "film", Name: "length"},
+		{Kind: PrimaryKeyChanged, Schema: "public", Table: "film", OldPK: []string{"film_id"}, NewPK: []string{"film_id", "title"}},
+		{Kind: IndexDropped, Schema: "public", Table: "film", Name: "film_pkey"},
+		{Kind: IndexAdded, Schema: "public", Table: "film", Name: "film_pkey"},
+		{Kind: IndexAdded, Schema: "public", Table: "film", Name: "film_title_idx"},
+		{Kind: ForeignKeyAdded, Schema: "public", Table: "film", Name: "film_rating_fkey"},
+		{Kind: TableAdded, Schema: "public", Table: "rating"},
+		{Kind: TableDropped, Schema: "public", Table: "staff"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("diff mismatch\nexpected: %+v\ngot:      %+v", want, got)
+	}
+
+	if tables := AffectedTables(got); !reflect.DeepEqual(tables, []string{"public.film", "public.rating", "public.staff"}) {
+		t.Fatalf("unexpected affected tables %v", tables)
+	}
+	if d := Diff(next, next); len(d) != 0 {
+		t.Fatalf("expected no changes diffing a snapshot with itself, got %+v", d)
+	}
+}
+
+func TestSnapshotCatalog(t *testing.T) {
+	var cat Catalog = demoSnapshot()
+
+	if pk, ok := cat.PrimaryKeys("actor"); !ok || !reflect.DeepEqual(pk, []string{"actor_id"}) {
+		t.Errorf("PrimaryKeys(actor) = %v, %v", pk, ok)
+	}
+	if _, ok := cat.PrimaryKeys("sales.film"); !ok {
+		t.Errorf("PrimaryKeys(sales.film) not found")
+	}
+	if _, ok := cat.Columns("public.missing"); ok {
+		t.Errorf("Columns(public.missing) found")
+	}
+}